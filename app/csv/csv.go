@@ -0,0 +1,655 @@
+// package csv provides an application for geotagging the rows of a CSV file using point-in-polygon queries.
+package csv
+
+import (
+	"context"
+	encoding_csv "encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/sfomuseum/go-flags/flagset"
+	"github.com/whosonfirst/go-whosonfirst-spatial-pip"
+	"github.com/whosonfirst/go-whosonfirst-spatial-pip/hierarchy"
+	spatial_app "github.com/whosonfirst/go-whosonfirst-spatial/app"
+	"github.com/whosonfirst/go-whosonfirst-spatial/flags"
+	"github.com/whosonfirst/go-whosonfirst-spr/v2"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// STDIO is the value of the -input and -output flags used to signal that the CSV file should be read from STDIN or
+// written to STDOUT.
+const STDIO string = "-"
+
+// Run invokes the CSV application using the default flag set.
+func Run(ctx context.Context, logger *log.Logger) error {
+
+	fs, err := DefaultFlagSet(ctx)
+
+	if err != nil {
+		return fmt.Errorf("Failed to create application flag set, %w", err)
+	}
+
+	return RunWithFlagSet(ctx, fs, logger)
+}
+
+// RunWithFlagSet invokes the CSV application using 'fs'. The spatial database is populated from the URIs passed as
+// arguments after which a point-in-polygon query is performed for each row of the -input CSV file and the row, with
+// the ID, name and placetype of the most specific place containing it (or of the most specific place for each -placetype
+// flag) appended, is written to the -output CSV file. Rows are written in the same order they are read.
+func RunWithFlagSet(ctx context.Context, fs *flag.FlagSet, logger *log.Logger) error {
+
+	flagset.Parse(fs)
+
+	err := flagset.SetFlagsFromEnvVars(fs, "PIP")
+
+	if err != nil {
+		return fmt.Errorf("Failed to set flags from environment variables, %w", err)
+	}
+
+	err = flags.ValidateCommonFlags(fs)
+
+	if err != nil {
+		return fmt.Errorf("Failed to validate common flags, %w", err)
+	}
+
+	err = flags.ValidateIndexingFlags(fs)
+
+	if err != nil {
+		return fmt.Errorf("Failed to validate indexing flags, %w", err)
+	}
+
+	switch on_error {
+	case ON_ERROR_COLUMN, ON_ERROR_SKIP, ON_ERROR_FAIL:
+		// pass
+	default:
+		return fmt.Errorf("Invalid -on-error value '%s'", on_error)
+	}
+
+	// The filtering, sorting and properties criteria shared by every row
+
+	filter_req, err := pip.NewPointInPolygonRequestFromFlagSetWithCoordinate(fs, 0.0, 0.0)
+
+	if err != nil {
+		return fmt.Errorf("Failed to create point in polygon request, %w", err)
+	}
+
+	app, err := spatial_app.NewSpatialApplicationWithFlagSet(ctx, fs)
+
+	if err != nil {
+		return fmt.Errorf("Failed to create new spatial application, %w", err)
+	}
+
+	uris := fs.Args()
+
+	if len(uris) > 0 {
+
+		t1 := time.Now()
+
+		err = app.Iterator.IterateURIs(ctx, uris...)
+
+		if err != nil {
+			return fmt.Errorf("Failed to index paths, %w", err)
+		}
+
+		logger.Printf("Indexed %d records in %v", app.Iterator.Seen, time.Since(t1))
+	}
+
+	var r io.Reader
+	var wr io.Writer
+
+	switch input {
+	case STDIO:
+		r = os.Stdin
+	default:
+
+		fh, err := os.Open(input)
+
+		if err != nil {
+			return fmt.Errorf("Failed to open %s for reading, %w", input, err)
+		}
+
+		defer fh.Close()
+		r = fh
+	}
+
+	switch output {
+	case STDIO:
+		wr = os.Stdout
+	default:
+
+		fh, err := os.Create(output)
+
+		if err != nil {
+			return fmt.Errorf("Failed to open %s for writing, %w", output, err)
+		}
+
+		defer fh.Close()
+		wr = fh
+	}
+
+	opts := &GeotagOptions{
+		LatitudeColumn:  latitude_column,
+		LongitudeColumn: longitude_column,
+		ColumnPrefix:    column_prefix,
+		Filter:          filter_req,
+		MaxWorkers:      max_workers,
+		OnError:         on_error,
+		Logger:          logger,
+	}
+
+	return Geotag(ctx, app, r, wr, opts)
+}
+
+// GeotagOptions defines options for geotagging a CSV file.
+type GeotagOptions struct {
+	// The name of the column containing each row's latitude.
+	LatitudeColumn string
+	// The name of the column containing each row's longitude.
+	LongitudeColumn string
+	// The prefix for the names of the columns appended to each row.
+	ColumnPrefix string
+	// The filtering, sorting and properties criteria applied to each row's point-in-polygon query. If it defines any
+	// placetypes then columns are appended for the most specific place of each placetype, otherwise for the most
+	// specific place. If it defines any properties then a column is appended for each one.
+	Filter *pip.PointInPolygonRequest
+	// The maximum number of point-in-polygon queries to perform concurrently.
+	MaxWorkers int
+	// How to handle rows that can not be geotagged. One of ON_ERROR_COLUMN, ON_ERROR_SKIP or ON_ERROR_FAIL.
+	OnError string
+	Logger  *log.Logger
+}
+
+// geotagRow is a single row of a CSV file and the outcome of geotagging it.
+type geotagRow struct {
+	// The line number of the row in the CSV file.
+	Line int
+	// The row read from the CSV file.
+	Record []string
+	// The columns to append to the row.
+	Columns []string
+	// The error, if any, geotagging the row.
+	Error error
+	// Closed when the row has been geotagged.
+	done chan bool
+}
+
+// Geotag reads a CSV file, whose first row is a header, from 'r' and writes each row with the outcome of its
+// point-in-polygon query appended to 'wr' as CSV. Queries are performed concurrently but rows are written in the same
+// order they are read.
+func Geotag(ctx context.Context, app *spatial_app.SpatialApplication, r io.Reader, wr io.Writer, opts *GeotagOptions) error {
+
+	max_workers := opts.MaxWorkers
+
+	if max_workers < 1 {
+		max_workers = pip.DEFAULT_BATCH_WORKERS
+	}
+
+	filter_req := opts.Filter
+
+	if filter_req == nil {
+		filter_req = &pip.PointInPolygonRequest{}
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	csv_r := encoding_csv.NewReader(r)
+	csv_r.FieldsPerRecord = -1
+
+	csv_wr := encoding_csv.NewWriter(wr)
+
+	header, err := csv_r.Read()
+
+	if err != nil {
+		return fmt.Errorf("Failed to read header, %w", err)
+	}
+
+	g, err := newGeotagger(header, filter_req, opts)
+
+	if err != nil {
+		return err
+	}
+
+	err = csv_wr.Write(g.Header())
+
+	if err != nil {
+		return fmt.Errorf("Failed to write header, %w", err)
+	}
+
+	// Rows are dispatched to 'rows_ch' in the order they are read and written once they
+	// are done. Its capacity limits the number of rows held in memory waiting for a slow
+	// query to complete.
+
+	rows_ch := make(chan *geotagRow, max_workers*2)
+	read_err_ch := make(chan error, 1)
+
+	go func() {
+
+		defer close(rows_ch)
+
+		throttle := make(chan bool, max_workers)
+
+		for {
+
+			record, err := csv_r.Read()
+
+			if errors.Is(err, io.EOF) {
+				return
+			}
+
+			if err != nil {
+				read_err_ch <- fmt.Errorf("Failed to read row, %w", err)
+				return
+			}
+
+			line, _ := csv_r.FieldPos(0)
+
+			row := &geotagRow{
+				Line:   line,
+				Record: record,
+				done:   make(chan bool),
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case throttle <- true:
+				// pass
+			}
+
+			go func(row *geotagRow) {
+
+				defer func() {
+					<-throttle
+					close(row.done)
+				}()
+
+				row.Columns, row.Error = g.Geotag(ctx, app, row.Record)
+			}(row)
+
+			select {
+			case <-ctx.Done():
+				return
+			case rows_ch <- row:
+				// pass
+			}
+		}
+	}()
+
+	count := 0
+	errors_count := 0
+
+	for row := range rows_ch {
+
+		<-row.done
+
+		if row.Error != nil {
+
+			errors_count += 1
+
+			switch opts.OnError {
+			case ON_ERROR_FAIL:
+				return fmt.Errorf("Failed to geotag row at line %d, %w", row.Line, row.Error)
+			case ON_ERROR_SKIP:
+				opts.Logger.Printf("Failed to geotag row at line %d, %v", row.Line, row.Error)
+				continue
+			default:
+				row.Columns = g.ErrorColumns(row.Error)
+			}
+		}
+
+		err := csv_wr.Write(g.Row(row.Record, row.Columns))
+
+		if err != nil {
+			return fmt.Errorf("Failed to write row at line %d, %w", row.Line, err)
+		}
+
+		count += 1
+	}
+
+	select {
+	case err := <-read_err_ch:
+		return err
+	default:
+		// pass
+	}
+
+	csv_wr.Flush()
+
+	err = csv_wr.Error()
+
+	if err != nil {
+		return fmt.Errorf("Failed to flush CSV writer, %w", err)
+	}
+
+	opts.Logger.Printf("Wrote %d rows, %d rows could not be geotagged", count, errors_count)
+	return nil
+}
+
+// geotagger derives the columns to append to each row of a CSV file.
+type geotagger struct {
+	header        []string
+	latitude_idx  int
+	longitude_idx int
+	prefix        string
+	filter        *pip.PointInPolygonRequest
+	placetypes    []string
+	error_columns bool
+}
+
+func newGeotagger(header []string, filter_req *pip.PointInPolygonRequest, opts *GeotagOptions) (*geotagger, error) {
+
+	latitude_idx := -1
+	longitude_idx := -1
+
+	for idx, col := range header {
+
+		switch col {
+		case opts.LatitudeColumn:
+			latitude_idx = idx
+		case opts.LongitudeColumn:
+			longitude_idx = idx
+		}
+	}
+
+	if latitude_idx == -1 {
+		return nil, fmt.Errorf("Header is missing latitude column '%s'", opts.LatitudeColumn)
+	}
+
+	if longitude_idx == -1 {
+		return nil, fmt.Errorf("Header is missing longitude column '%s'", opts.LongitudeColumn)
+	}
+
+	placetypes := make([]string, 0)
+
+	for _, raw := range filter_req.Placetypes {
+
+		for _, pt := range strings.Split(raw, ",") {
+
+			pt = strings.TrimSpace(pt)
+
+			if pt != "" {
+				placetypes = append(placetypes, pt)
+			}
+		}
+	}
+
+	g := &geotagger{
+		header:        header,
+		latitude_idx:  latitude_idx,
+		longitude_idx: longitude_idx,
+		prefix:        opts.ColumnPrefix,
+		filter:        filter_req,
+		placetypes:    placetypes,
+		error_columns: opts.OnError == ON_ERROR_COLUMN,
+	}
+
+	return g, nil
+}
+
+// Header returns the header row for the geotagged CSV file.
+func (g *geotagger) Header() []string {
+
+	header := make([]string, len(g.header))
+	copy(header, g.header)
+
+	if len(g.placetypes) == 0 {
+		header = append(header, g.placeColumns("")...)
+	} else {
+
+		for _, pt := range g.placetypes {
+			header = append(header, g.placeColumns(pt)...)
+		}
+	}
+
+	if g.error_columns {
+		header = append(header, g.prefix+"error", g.prefix+"error_code")
+	}
+
+	return header
+}
+
+// Row returns 'record' padded to the length of the header row with 'columns' appended.
+func (g *geotagger) Row(record []string, columns []string) []string {
+
+	row := make([]string, len(g.header), len(g.header)+len(columns))
+	copy(row, record)
+
+	return append(row, columns...)
+}
+
+// ErrorColumns returns the columns to append to a row that could not be geotagged because of 'err'.
+func (g *geotagger) ErrorColumns(err error) []string {
+
+	columns := make([]string, len(g.Header())-len(g.header))
+
+	if g.error_columns {
+
+		code, _ := pip.ErrorCodeWithError(err)
+
+		columns[len(columns)-2] = err.Error()
+		columns[len(columns)-1] = string(code)
+	}
+
+	return columns
+}
+
+// Geotag performs a point-in-polygon query for the coordinate in 'record' and returns the columns to append to it.
+func (g *geotagger) Geotag(ctx context.Context, app *spatial_app.SpatialApplication, record []string) ([]string, error) {
+
+	latitude, err := g.coordinate(record, g.latitude_idx)
+
+	if err != nil {
+		return nil, pip.NewError(pip.INVALID_COORDINATE, fmt.Errorf("Invalid latitude, %w", err))
+	}
+
+	longitude, err := g.coordinate(record, g.longitude_idx)
+
+	if err != nil {
+		return nil, pip.NewError(pip.INVALID_COORDINATE, fmt.Errorf("Invalid longitude, %w", err))
+	}
+
+	req := new(pip.PointInPolygonRequest)
+	*req = *g.filter
+
+	req.Latitude = latitude
+	req.Longitude = longitude
+
+	err = req.Validate()
+
+	if err != nil {
+		return nil, err
+	}
+
+	// Use a per-row application so that timings are not accumulated by 'app'
+
+	req_app, _ := pip.NewRequestApplication(app)
+
+	rsp, err := pip.QueryPointInPolygon(ctx, req_app, req)
+
+	if err != nil {
+		return nil, err
+	}
+
+	places := hierarchy.MostSpecificResults(rsp.Results())
+
+	var selected []spr.StandardPlacesResult
+
+	if len(g.placetypes) == 0 {
+
+		selected = make([]spr.StandardPlacesResult, 1)
+
+		if len(places) > 0 {
+			selected[0] = places[0]
+		}
+
+	} else {
+
+		by_placetype := make(map[string]spr.StandardPlacesResult)
+
+		for _, p := range places {
+			by_placetype[p.Placetype()] = p
+		}
+
+		selected = make([]spr.StandardPlacesResult, len(g.placetypes))
+
+		for idx, pt := range g.placetypes {
+			selected[idx] = by_placetype[pt]
+		}
+	}
+
+	props, err := g.properties(ctx, app, selected)
+
+	if err != nil {
+		return nil, err
+	}
+
+	columns := make([]string, 0)
+
+	for idx, p := range selected {
+
+		if p == nil {
+
+			pt := ""
+
+			if len(g.placetypes) > 0 {
+				pt = g.placetypes[idx]
+			}
+
+			columns = append(columns, make([]string, len(g.placeColumns(pt)))...)
+			continue
+		}
+
+		columns = append(columns, p.Id(), p.Name())
+
+		if len(g.placetypes) == 0 {
+			columns = append(columns, p.Placetype())
+		}
+
+		for _, k := range g.filter.Properties {
+			columns = append(columns, propertyValue(props[idx], k))
+		}
+	}
+
+	if g.error_columns {
+		columns = append(columns, "", "")
+	}
+
+	return columns, nil
+}
+
+// placeColumns returns the names of the columns for a place of placetype 'pt'. If 'pt' is empty the columns are for
+// the most specific place, in which case they include its placetype.
+func (g *geotagger) placeColumns(pt string) []string {
+
+	prefix := g.prefix
+
+	if pt != "" {
+		prefix = fmt.Sprintf("%s%s_", prefix, pt)
+	}
+
+	columns := []string{
+		prefix + "id",
+		prefix + "name",
+	}
+
+	if pt == "" {
+		columns = append(columns, prefix+"placetype")
+	}
+
+	for _, k := range g.filter.Properties {
+		columns = append(columns, prefix+k)
+	}
+
+	return columns
+}
+
+// coordinate returns the value of column 'idx' in 'record' parsed as a float.
+func (g *geotagger) coordinate(record []string, idx int) (float64, error) {
+
+	if idx >= len(record) {
+		return 0.0, fmt.Errorf("Missing column '%s'", g.header[idx])
+	}
+
+	str := strings.TrimSpace(record[idx])
+
+	if str == "" {
+		return 0.0, fmt.Errorf("Empty column '%s'", g.header[idx])
+	}
+
+	return strconv.ParseFloat(str, 64)
+}
+
+// properties returns the properties defined by the filter for each of 'places', read from the application's
+// properties reader. Places that are nil have nil properties.
+func (g *geotagger) properties(ctx context.Context, app *spatial_app.SpatialApplication, places []spr.StandardPlacesResult) ([]map[string]interface{}, error) {
+
+	props := make([]map[string]interface{}, len(places))
+
+	if len(g.filter.Properties) == 0 {
+		return props, nil
+	}
+
+	found := make([]spr.StandardPlacesResult, 0)
+
+	for _, p := range places {
+
+		if p != nil {
+			found = append(found, p)
+		}
+	}
+
+	if len(found) == 0 {
+		return props, nil
+	}
+
+	props_rsp, err := pip.PropertiesResponseResultsWithStandardPlacesResults(ctx, app.PropertiesReader, g.filter.Properties, pip.NewPointInPolygonResults(found))
+
+	if err != nil {
+		return nil, err
+	}
+
+	i := 0
+
+	for idx, p := range places {
+
+		if p == nil {
+			continue
+		}
+
+		props[idx] = *props_rsp.Properties[i]
+		i += 1
+	}
+
+	return props, nil
+}
+
+// propertyValue returns the value of property 'k' in 'props' as a string. Values that are not strings are JSON-encoded.
+func propertyValue(props map[string]interface{}, k string) string {
+
+	v, ok := props[k]
+
+	if !ok || v == nil {
+		return ""
+	}
+
+	str_v, ok := v.(string)
+
+	if ok {
+		return str_v
+	}
+
+	enc_v, err := json.Marshal(v)
+
+	if err != nil {
+		return ""
+	}
+
+	return string(enc_v)
+}
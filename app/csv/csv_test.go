@@ -0,0 +1,396 @@
+package csv
+
+import (
+	_ "github.com/whosonfirst/go-whosonfirst-spatial-rtree"
+)
+
+import (
+	"bytes"
+	"context"
+	encoding_csv "encoding/csv"
+	"fmt"
+	"github.com/whosonfirst/go-whosonfirst-iterate/v2/iterator"
+	"github.com/whosonfirst/go-whosonfirst-spatial-pip"
+	spatial_app "github.com/whosonfirst/go-whosonfirst-spatial/app"
+	"github.com/whosonfirst/go-whosonfirst-spatial/database"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const fixturesPath string = "../../fixtures/data"
+
+// newTestApplication returns a new spatial_app.SpatialApplication instance whose spatial database is a rtree://
+// database in which the records in fixturesPath have been indexed.
+func newTestApplication(t *testing.T) *spatial_app.SpatialApplication {
+
+	t.Helper()
+
+	ctx := context.Background()
+
+	db, err := database.NewSpatialDatabase(ctx, "rtree://")
+
+	if err != nil {
+		t.Fatalf("Failed to create spatial database, %v", err)
+	}
+
+	iter_cb := func(ctx context.Context, path string, r io.ReadSeeker, args ...interface{}) error {
+
+		body, err := io.ReadAll(r)
+
+		if err != nil {
+			return err
+		}
+
+		return db.IndexFeature(ctx, body)
+	}
+
+	iter, err := iterator.NewIterator(ctx, "directory://", iter_cb)
+
+	if err != nil {
+		t.Fatalf("Failed to create iterator, %v", err)
+	}
+
+	iter.Logger = log.New(io.Discard, "", 0)
+
+	err = iter.IterateURIs(ctx, fixturesPath)
+
+	if err != nil {
+		t.Fatalf("Failed to index fixtures, %v", err)
+	}
+
+	app := &spatial_app.SpatialApplication{
+		SpatialDatabase:  db,
+		PropertiesReader: db,
+		Iterator:         iter,
+		Logger:           log.New(io.Discard, "", 0),
+		Monitor:          pip.NewRequestMonitor(),
+	}
+
+	return app
+}
+
+// readCSV returns the rows of the CSV file in 'body'.
+func readCSV(t *testing.T, body []byte) [][]string {
+
+	t.Helper()
+
+	csv_r := encoding_csv.NewReader(bytes.NewReader(body))
+	csv_r.FieldsPerRecord = -1
+
+	rows, err := csv_r.ReadAll()
+
+	if err != nil {
+		t.Fatalf("Failed to read CSV output, %v", err)
+	}
+
+	return rows
+}
+
+func TestGeotag(t *testing.T) {
+
+	ctx := context.Background()
+	app := newTestApplication(t)
+
+	input := "name,latitude,longitude\ncorner,0.25,0.25\nbox,0.75,0.75\nisland,5.5,5.5\nnowhere,-10,-10\n"
+
+	tests := []struct {
+		name     string
+		input    string
+		filter   *pip.PointInPolygonRequest
+		on_error string
+		expected [][]string
+	}{
+		{
+			name:     "most specific place",
+			input:    input,
+			filter:   &pip.PointInPolygonRequest{IsCurrent: []int64{1}},
+			on_error: ON_ERROR_COLUMN,
+			expected: [][]string{
+				{"name", "latitude", "longitude", "pip_id", "pip_name", "pip_placetype", "pip_error", "pip_error_code"},
+				{"corner", "0.25", "0.25", "102000001", "Corner", "neighbourhood", "", ""},
+				{"box", "0.75", "0.75", "101000001", "Box", "locality", "", ""},
+				{"island", "5.5", "5.5", "101000003", "Island", "locality", "", ""},
+				{"nowhere", "-10", "-10", "", "", "", "", ""},
+			},
+		},
+		{
+			name:     "placetypes",
+			input:    input,
+			filter:   &pip.PointInPolygonRequest{IsCurrent: []int64{1}, Placetypes: []string{"locality,region"}},
+			on_error: ON_ERROR_SKIP,
+			expected: [][]string{
+				{"name", "latitude", "longitude", "pip_locality_id", "pip_locality_name", "pip_region_id", "pip_region_name"},
+				{"corner", "0.25", "0.25", "101000001", "Box", "85000002", "Midbox"},
+				{"box", "0.75", "0.75", "101000001", "Box", "85000002", "Midbox"},
+				{"island", "5.5", "5.5", "101000003", "Island", "", ""},
+				{"nowhere", "-10", "-10", "", "", "", ""},
+			},
+		},
+		{
+			name:     "properties",
+			input:    "name,latitude,longitude\nbox,0.75,0.75\nnowhere,-10,-10\n",
+			filter:   &pip.PointInPolygonRequest{IsCurrent: []int64{1}, Properties: []string{"wof:placetype", "wof:parent_id"}},
+			on_error: ON_ERROR_SKIP,
+			expected: [][]string{
+				{"name", "latitude", "longitude", "pip_id", "pip_name", "pip_placetype", "pip_wof:placetype", "pip_wof:parent_id"},
+				{"box", "0.75", "0.75", "101000001", "Box", "locality", "locality", "85000002"},
+				{"nowhere", "-10", "-10", "", "", "", "", ""},
+			},
+		},
+		{
+			name:     "error columns",
+			input:    "name,latitude,longitude\nnorth,north,1\nempty,,1\nout of range,91,1\nshort,0.75\n",
+			on_error: ON_ERROR_COLUMN,
+			expected: [][]string{
+				{"name", "latitude", "longitude", "pip_id", "pip_name", "pip_placetype", "pip_error", "pip_error_code"},
+				{"north", "north", "1", "", "", "", "Invalid latitude, strconv.ParseFloat: parsing \"north\": invalid syntax", string(pip.INVALID_COORDINATE)},
+				{"empty", "", "1", "", "", "", "Invalid latitude, Empty column 'latitude'", string(pip.INVALID_COORDINATE)},
+				{"out of range", "91", "1", "", "", "", "Invalid request, latitude: Latitude must be between -90 and 90", string(pip.INVALID_COORDINATE)},
+				{"short", "0.75", "", "", "", "", "Invalid longitude, Missing column 'longitude'", string(pip.INVALID_COORDINATE)},
+			},
+		},
+		{
+			name:     "skip errors",
+			input:    "name,latitude,longitude\nnorth,north,1\nbox,0.75,0.75\n",
+			filter:   &pip.PointInPolygonRequest{IsCurrent: []int64{1}},
+			on_error: ON_ERROR_SKIP,
+			expected: [][]string{
+				{"name", "latitude", "longitude", "pip_id", "pip_name", "pip_placetype"},
+				{"box", "0.75", "0.75", "101000001", "Box", "locality"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+
+		t.Run(tt.name, func(t *testing.T) {
+
+			var buf bytes.Buffer
+
+			opts := &GeotagOptions{
+				LatitudeColumn:  "latitude",
+				LongitudeColumn: "longitude",
+				ColumnPrefix:    "pip_",
+				Filter:          tt.filter,
+				MaxWorkers:      2,
+				OnError:         tt.on_error,
+				Logger:          log.New(io.Discard, "", 0),
+			}
+
+			err := Geotag(ctx, app, strings.NewReader(tt.input), &buf, opts)
+
+			if err != nil {
+				t.Fatalf("Failed to geotag CSV, %v", err)
+			}
+
+			rows := readCSV(t, buf.Bytes())
+
+			if !reflect.DeepEqual(rows, tt.expected) {
+				t.Fatalf("Expected %v, got %v", tt.expected, rows)
+			}
+		})
+	}
+}
+
+func TestGeotagOrder(t *testing.T) {
+
+	ctx := context.Background()
+	app := newTestApplication(t)
+
+	// Rows are written in the order they are read regardless of how many queries are performed concurrently
+
+	var input strings.Builder
+	input.WriteString("id,lat,lon\n")
+
+	expected := make([]string, 0)
+
+	for i := 0; i < 100; i++ {
+
+		coord := "0.75,0.75"
+		id := "101000001"
+
+		if i%3 == 0 {
+			coord = "5.5,5.5"
+			id = "101000003"
+		}
+
+		fmt.Fprintf(&input, "%d,%s\n", i, coord)
+		expected = append(expected, id)
+	}
+
+	var buf bytes.Buffer
+
+	opts := &GeotagOptions{
+		LatitudeColumn:  "lat",
+		LongitudeColumn: "lon",
+		Filter:          &pip.PointInPolygonRequest{IsCurrent: []int64{1}},
+		MaxWorkers:      8,
+		OnError:         ON_ERROR_FAIL,
+		Logger:          log.New(io.Discard, "", 0),
+	}
+
+	err := Geotag(ctx, app, strings.NewReader(input.String()), &buf, opts)
+
+	if err != nil {
+		t.Fatalf("Failed to geotag CSV, %v", err)
+	}
+
+	rows := readCSV(t, buf.Bytes())
+
+	if len(rows) != 101 {
+		t.Fatalf("Expected 101 rows, got %d", len(rows))
+	}
+
+	for idx, row := range rows[1:] {
+
+		if row[0] != fmt.Sprintf("%d", idx) || row[3] != expected[idx] {
+			t.Fatalf("Expected row %d to be %s, got %v", idx, expected[idx], row)
+		}
+	}
+}
+
+func TestGeotagErrors(t *testing.T) {
+
+	ctx := context.Background()
+	app := newTestApplication(t)
+
+	tests := []struct {
+		name     string
+		input    string
+		on_error string
+		code     pip.ErrorCode
+	}{
+		{name: "empty", input: ""},
+		{name: "missing latitude column", input: "name,lat,longitude\nbox,0.75,0.75\n"},
+		{name: "missing longitude column", input: "name,latitude,lon\nbox,0.75,0.75\n"},
+		{name: "fail", input: "name,latitude,longitude\nbox,0.75,0.75\nnorth,north,1\n", on_error: ON_ERROR_FAIL, code: pip.INVALID_COORDINATE},
+		{name: "malformed", input: "name,latitude,longitude\n\"box,0.75,0.75\n", on_error: ON_ERROR_COLUMN},
+	}
+
+	for _, tt := range tests {
+
+		t.Run(tt.name, func(t *testing.T) {
+
+			opts := &GeotagOptions{
+				LatitudeColumn:  "latitude",
+				LongitudeColumn: "longitude",
+				OnError:         tt.on_error,
+				Logger:          log.New(io.Discard, "", 0),
+			}
+
+			err := Geotag(ctx, app, strings.NewReader(tt.input), io.Discard, opts)
+
+			if err == nil {
+				t.Fatalf("Expected an error")
+			}
+
+			if tt.code != "" {
+
+				code, _ := pip.ErrorCodeWithError(err)
+
+				if code != tt.code {
+					t.Fatalf("Expected %s, got %v", tt.code, err)
+				}
+			}
+		})
+	}
+}
+
+func TestRunWithFlagSet(t *testing.T) {
+
+	ctx := context.Background()
+
+	tests := []struct {
+		name     string
+		args     []string
+		expected [][]string
+		ok       bool
+	}{
+		{
+			name: "geotag",
+			args: []string{"-is-current", "1", "-placetype", "neighbourhood", "-column-prefix", "wof_"},
+			expected: [][]string{
+				{"name", "latitude", "longitude", "wof_neighbourhood_id", "wof_neighbourhood_name", "wof_error", "wof_error_code"},
+				{"corner", "0.25", "0.25", "102000001", "Corner", "", ""},
+				{"island", "5.5", "5.5", "", "", "", ""},
+			},
+			ok: true,
+		},
+		{
+			name: "invalid on error",
+			args: []string{"-on-error", "ignore"},
+		},
+	}
+
+	for _, tt := range tests {
+
+		t.Run(tt.name, func(t *testing.T) {
+
+			root := t.TempDir()
+
+			input := filepath.Join(root, "input.csv")
+			output := filepath.Join(root, "output.csv")
+
+			err := os.WriteFile(input, []byte("name,latitude,longitude\ncorner,0.25,0.25\nisland,5.5,5.5\n"), 0644)
+
+			if err != nil {
+				t.Fatalf("Failed to write input, %v", err)
+			}
+
+			fs, err := DefaultFlagSet(ctx)
+
+			if err != nil {
+				t.Fatalf("Failed to create flag set, %v", err)
+			}
+
+			args_orig := os.Args
+
+			defer func() {
+				os.Args = args_orig
+			}()
+
+			args := []string{
+				"csv",
+				"-spatial-database-uri", "rtree://",
+				"-iterator-uri", "directory://",
+				"-input", input,
+				"-output", output,
+			}
+
+			args = append(args, tt.args...)
+			os.Args = append(args, fixturesPath)
+
+			err = RunWithFlagSet(ctx, fs, log.New(io.Discard, "", 0))
+
+			if !tt.ok {
+
+				if err == nil {
+					t.Fatalf("Expected an error")
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Failed to run application, %v", err)
+			}
+
+			body, err := os.ReadFile(output)
+
+			if err != nil {
+				t.Fatalf("Failed to read output, %v", err)
+			}
+
+			rows := readCSV(t, body)
+
+			if !reflect.DeepEqual(rows, tt.expected) {
+				t.Fatalf("Expected %v, got %v", tt.expected, rows)
+			}
+		})
+	}
+}
@@ -0,0 +1,80 @@
+package csv
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/whosonfirst/go-whosonfirst-spatial-pip"
+	"github.com/whosonfirst/go-whosonfirst-spatial/flags"
+	"os"
+)
+
+// ON_ERROR_COLUMN signals that the error for a row that can not be geotagged should be recorded in its error columns.
+const ON_ERROR_COLUMN string = "column"
+
+// ON_ERROR_SKIP signals that a row that can not be geotagged should be logged and omitted from the output.
+const ON_ERROR_SKIP string = "skip"
+
+// ON_ERROR_FAIL signals that a row that can not be geotagged should stop the application.
+const ON_ERROR_FAIL string = "fail"
+
+var input string
+
+var output string
+
+var latitude_column string
+
+var longitude_column string
+
+var column_prefix string
+
+var max_workers int
+
+var on_error string
+
+func DefaultFlagSet(ctx context.Context) (*flag.FlagSet, error) {
+
+	fs, err := flags.CommonFlags()
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to derive common spatial flags, %w", err)
+	}
+
+	err = flags.AppendQueryFlags(fs)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to append query flags, %w", err)
+	}
+
+	err = flags.AppendIndexingFlags(fs)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to append indexing flags, %w", err)
+	}
+
+	err = pip.AppendFallbackFlags(fs)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to append fallback flags, %w", err)
+	}
+
+	fs.StringVar(&input, "input", STDIO, "The path to the CSV file to geotag. If '-' the CSV file is read from STDIN.")
+	fs.StringVar(&output, "output", STDIO, "The path to write the geotagged CSV file to. If '-' the CSV file is written to STDOUT.")
+
+	fs.StringVar(&latitude_column, "latitude-column", "latitude", "The name of the column containing each row's latitude.")
+	fs.StringVar(&longitude_column, "longitude-column", "longitude", "The name of the column containing each row's longitude.")
+	fs.StringVar(&column_prefix, "column-prefix", "pip_", "The prefix for the names of the columns appended to each row.")
+
+	fs.IntVar(&max_workers, "max-workers", pip.DEFAULT_BATCH_WORKERS, "The maximum number of concurrent point-in-polygon queries to perform.")
+	fs.StringVar(&on_error, "on-error", ON_ERROR_COLUMN, "How to handle rows that can not be geotagged. Valid options are: column (record the error in the row's error columns), skip (log the error and omit the row), fail (stop processing).")
+
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Append the IDs, names and placetypes of the places containing each row of a CSV file to that row.\n")
+		fmt.Fprintf(os.Stderr, "Usage:\n\t %s [options] uri(N) uri(N)\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Where uri(N) are the URIs of the records to index in the spatial database. The -latitude and -longitude flags are ignored.\n\n")
+		fmt.Fprintf(os.Stderr, "Valid options are:\n\n")
+		fs.PrintDefaults()
+	}
+
+	return fs, nil
+}
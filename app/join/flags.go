@@ -0,0 +1,74 @@
+package join
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/whosonfirst/go-whosonfirst-spatial-pip"
+	"github.com/whosonfirst/go-whosonfirst-spatial/flags"
+	"os"
+)
+
+// ON_ERROR_PROPERTY signals that the error for a feature that can not be joined should be recorded in its properties.
+const ON_ERROR_PROPERTY string = "property"
+
+// ON_ERROR_SKIP signals that a feature that can not be joined should be logged and omitted from the output.
+const ON_ERROR_SKIP string = "skip"
+
+// ON_ERROR_FAIL signals that a feature that can not be joined should stop the application.
+const ON_ERROR_FAIL string = "fail"
+
+var input string
+
+var output string
+
+var property_prefix string
+
+var max_workers int
+
+var on_error string
+
+func DefaultFlagSet(ctx context.Context) (*flag.FlagSet, error) {
+
+	fs, err := flags.CommonFlags()
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to derive common spatial flags, %w", err)
+	}
+
+	err = flags.AppendQueryFlags(fs)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to append query flags, %w", err)
+	}
+
+	err = flags.AppendIndexingFlags(fs)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to append indexing flags, %w", err)
+	}
+
+	err = pip.AppendFallbackFlags(fs)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to append fallback flags, %w", err)
+	}
+
+	fs.StringVar(&input, "input", STDIO, "The path to the GeoJSON FeatureCollection or newline-delimited GeoJSON features to join. If '-' features are read from STDIN.")
+	fs.StringVar(&output, "output", STDIO, "The path to write the joined newline-delimited GeoJSON features to. If '-' features are written to STDOUT.")
+
+	fs.StringVar(&property_prefix, "property-prefix", "pip:", "The prefix for the names of the properties added to each feature.")
+
+	fs.IntVar(&max_workers, "max-workers", pip.DEFAULT_BATCH_WORKERS, "The maximum number of concurrent point-in-polygon queries to perform.")
+	fs.StringVar(&on_error, "on-error", ON_ERROR_PROPERTY, "How to handle features that can not be joined. Valid options are: property (record the error in the feature's properties), skip (log the error and omit the feature), fail (stop processing).")
+
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Annotate the properties of GeoJSON features with the places that contain them.\n")
+		fmt.Fprintf(os.Stderr, "Usage:\n\t %s [options] uri(N) uri(N)\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Where uri(N) are the URIs of the records to index in the spatial database. The -latitude and -longitude flags are ignored.\n\n")
+		fmt.Fprintf(os.Stderr, "Valid options are:\n\n")
+		fs.PrintDefaults()
+	}
+
+	return fs, nil
+}
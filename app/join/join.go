@@ -0,0 +1,618 @@
+// package join provides an application for annotating GeoJSON features with the places that contain them using
+// point-in-polygon queries.
+package join
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geojson"
+	"github.com/paulmach/orb/planar"
+	"github.com/sfomuseum/go-flags/flagset"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+	"github.com/whosonfirst/go-whosonfirst-spatial"
+	"github.com/whosonfirst/go-whosonfirst-spatial-pip"
+	"github.com/whosonfirst/go-whosonfirst-spatial-pip/hierarchy"
+	spatial_app "github.com/whosonfirst/go-whosonfirst-spatial/app"
+	"github.com/whosonfirst/go-whosonfirst-spatial/flags"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+// STDIO is the value of the -input and -output flags used to signal that features should be read from STDIN or
+// written to STDOUT.
+const STDIO string = "-"
+
+// Run invokes the join application using the default flag set.
+func Run(ctx context.Context, logger *log.Logger) error {
+
+	fs, err := DefaultFlagSet(ctx)
+
+	if err != nil {
+		return fmt.Errorf("Failed to create application flag set, %w", err)
+	}
+
+	return RunWithFlagSet(ctx, fs, logger)
+}
+
+// RunWithFlagSet invokes the join application using 'fs'. The spatial database is populated from the URIs passed as
+// arguments after which a point-in-polygon query is performed for each feature read from -input and the feature, with
+// the places containing it added to its properties, is written to -output as newline-delimited GeoJSON. Features are
+// written in the same order they are read.
+func RunWithFlagSet(ctx context.Context, fs *flag.FlagSet, logger *log.Logger) error {
+
+	flagset.Parse(fs)
+
+	err := flagset.SetFlagsFromEnvVars(fs, "PIP")
+
+	if err != nil {
+		return fmt.Errorf("Failed to set flags from environment variables, %w", err)
+	}
+
+	err = flags.ValidateCommonFlags(fs)
+
+	if err != nil {
+		return fmt.Errorf("Failed to validate common flags, %w", err)
+	}
+
+	err = flags.ValidateIndexingFlags(fs)
+
+	if err != nil {
+		return fmt.Errorf("Failed to validate indexing flags, %w", err)
+	}
+
+	switch on_error {
+	case ON_ERROR_PROPERTY, ON_ERROR_SKIP, ON_ERROR_FAIL:
+		// pass
+	default:
+		return fmt.Errorf("Invalid -on-error value '%s'", on_error)
+	}
+
+	// The filtering, sorting and properties criteria shared by every feature
+
+	filter_req, err := pip.NewPointInPolygonRequestFromFlagSetWithCoordinate(fs, 0.0, 0.0)
+
+	if err != nil {
+		return fmt.Errorf("Failed to create point in polygon request, %w", err)
+	}
+
+	app, err := spatial_app.NewSpatialApplicationWithFlagSet(ctx, fs)
+
+	if err != nil {
+		return fmt.Errorf("Failed to create new spatial application, %w", err)
+	}
+
+	uris := fs.Args()
+
+	if len(uris) > 0 {
+
+		t1 := time.Now()
+
+		err = app.Iterator.IterateURIs(ctx, uris...)
+
+		if err != nil {
+			return fmt.Errorf("Failed to index paths, %w", err)
+		}
+
+		logger.Printf("Indexed %d records in %v", app.Iterator.Seen, time.Since(t1))
+	}
+
+	var r io.Reader
+	var wr io.Writer
+
+	switch input {
+	case STDIO:
+		r = os.Stdin
+	default:
+
+		fh, err := os.Open(input)
+
+		if err != nil {
+			return fmt.Errorf("Failed to open %s for reading, %w", input, err)
+		}
+
+		defer fh.Close()
+		r = fh
+	}
+
+	switch output {
+	case STDIO:
+		wr = os.Stdout
+	default:
+
+		fh, err := os.Create(output)
+
+		if err != nil {
+			return fmt.Errorf("Failed to open %s for writing, %w", output, err)
+		}
+
+		defer fh.Close()
+		wr = fh
+	}
+
+	props_opts := &spatial.PropertiesResponseOptions{
+		Reader:       app.PropertiesReader,
+		Keys:         filter_req.Properties,
+		SourcePrefix: "properties",
+	}
+
+	opts := &JoinOptions{
+		Filter:             filter_req,
+		PropertiesResponse: props_opts,
+		PropertyPrefix:     property_prefix,
+		MaxWorkers:         max_workers,
+		OnError:            on_error,
+		Logger:             logger,
+	}
+
+	return Join(ctx, app, r, wr, opts)
+}
+
+// JoinOptions defines options for annotating GeoJSON features with the places that contain them.
+type JoinOptions struct {
+	// The filtering and sorting criteria applied to each feature's point-in-polygon query.
+	Filter *pip.PointInPolygonRequest
+	// If not nil and it defines one or more keys then each place is a properties response, containing those properties
+	// read from its reader, rather than a standard places response.
+	PropertiesResponse *spatial.PropertiesResponseOptions
+	// The prefix for the names of the properties added to each feature.
+	PropertyPrefix string
+	// The maximum number of point-in-polygon queries to perform concurrently.
+	MaxWorkers int
+	// How to handle features that can not be joined. One of ON_ERROR_PROPERTY, ON_ERROR_SKIP or ON_ERROR_FAIL.
+	OnError string
+	Logger  *log.Logger
+}
+
+// joinFeature is a single GeoJSON feature and the outcome of joining it.
+type joinFeature struct {
+	// The position of the feature in the input, starting at 1.
+	Number int
+	// The feature read from the input.
+	Body []byte
+	// The feature with the places that contain it added to its properties.
+	Joined []byte
+	// The error, if any, joining the feature.
+	Error error
+	// Closed when the feature has been joined.
+	done chan bool
+}
+
+// Join reads a GeoJSON FeatureCollection, or newline-delimited GeoJSON features, from 'r' and writes each feature with
+// the places containing its coordinate (or the centroid of its geometry if it is not a point) added to its properties
+// to 'wr' as newline-delimited GeoJSON. The following properties are added, each prefixed by opts.PropertyPrefix:
+// "parent_id", the ID of the most specific place; "hierarchy", the hierarchies of that place; "places", the most
+// specific place for each placetype ordered from most to least specific. Queries are performed concurrently but
+// features are written in the same order they are read.
+func Join(ctx context.Context, app *spatial_app.SpatialApplication, r io.Reader, wr io.Writer, opts *JoinOptions) error {
+
+	max_workers := opts.MaxWorkers
+
+	if max_workers < 1 {
+		max_workers = pip.DEFAULT_BATCH_WORKERS
+	}
+
+	filter_req := opts.Filter
+
+	if filter_req == nil {
+		filter_req = &pip.PointInPolygonRequest{}
+	}
+
+	j := &joiner{
+		filter:     filter_req,
+		props_opts: opts.PropertiesResponse,
+		prefix:     opts.PropertyPrefix,
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// Features are dispatched to 'features_ch' in the order they are read and written
+	// once they are done. Its capacity limits the number of features held in memory
+	// waiting for a slow query to complete.
+
+	features_ch := make(chan *joinFeature, max_workers*2)
+	read_err_ch := make(chan error, 1)
+
+	go func() {
+
+		defer close(features_ch)
+
+		throttle := make(chan bool, max_workers)
+		count := 0
+
+		read_cb := func(body []byte) error {
+
+			count += 1
+
+			f := &joinFeature{
+				Number: count,
+				Body:   body,
+				done:   make(chan bool),
+			}
+
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case throttle <- true:
+				// pass
+			}
+
+			go func(f *joinFeature) {
+
+				defer func() {
+					<-throttle
+					close(f.done)
+				}()
+
+				f.Joined, f.Error = j.Join(ctx, app, f.Body)
+			}(f)
+
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case features_ch <- f:
+				// pass
+			}
+
+			return nil
+		}
+
+		err := readFeatures(r, read_cb)
+
+		if err != nil && !errors.Is(err, context.Canceled) {
+			read_err_ch <- err
+		}
+	}()
+
+	count := 0
+	errors_count := 0
+
+	for f := range features_ch {
+
+		<-f.done
+
+		if f.Error != nil {
+
+			errors_count += 1
+
+			switch opts.OnError {
+			case ON_ERROR_FAIL:
+				return fmt.Errorf("Failed to join feature %d, %w", f.Number, f.Error)
+			case ON_ERROR_SKIP:
+				opts.Logger.Printf("Failed to join feature %d, %v", f.Number, f.Error)
+				continue
+			default:
+
+				joined, err := j.JoinError(f.Body, f.Error)
+
+				if err != nil {
+					return fmt.Errorf("Failed to record error for feature %d, %w", f.Number, err)
+				}
+
+				f.Joined = joined
+			}
+		}
+
+		_, err := wr.Write(append(f.Joined, '\n'))
+
+		if err != nil {
+			return fmt.Errorf("Failed to write feature %d, %w", f.Number, err)
+		}
+
+		count += 1
+	}
+
+	select {
+	case err := <-read_err_ch:
+		return err
+	default:
+		// pass
+	}
+
+	opts.Logger.Printf("Wrote %d features, %d features could not be joined", count, errors_count)
+	return nil
+}
+
+// joiner adds the places that contain a GeoJSON feature to its properties.
+type joiner struct {
+	filter     *pip.PointInPolygonRequest
+	props_opts *spatial.PropertiesResponseOptions
+	prefix     string
+}
+
+// Join returns a copy of the GeoJSON feature 'body', encoded on a single line, with the places containing it added
+// to its properties.
+func (j *joiner) Join(ctx context.Context, app *spatial_app.SpatialApplication, body []byte) ([]byte, error) {
+
+	if gjson.GetBytes(body, "type").String() != "Feature" {
+		return nil, pip.NewError(pip.INVALID_REQUEST, fmt.Errorf("Not a GeoJSON Feature"))
+	}
+
+	pt, err := featureCoordinate(body)
+
+	if err != nil {
+		return nil, err
+	}
+
+	req := new(pip.PointInPolygonRequest)
+	*req = *j.filter
+
+	req.Latitude = pt.Y()
+	req.Longitude = pt.X()
+
+	err = req.Validate()
+
+	if err != nil {
+		return nil, err
+	}
+
+	// Use a per-feature application so that timings are not accumulated by 'app'
+
+	req_app, _ := pip.NewRequestApplication(app)
+
+	h_rsp, err := hierarchy.ResolveHierarchy(ctx, req_app, req)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var places interface{}
+	places = h_rsp.Places
+
+	if j.props_opts != nil && len(j.props_opts.Keys) > 0 {
+
+		props_rsp, err := spatial.PropertiesResponseResultsWithStandardPlacesResults(ctx, j.props_opts, pip.NewPointInPolygonResults(h_rsp.Places))
+
+		if err != nil {
+			return nil, pip.NewError(pip.BACKEND_ERROR, fmt.Errorf("Failed to derive properties, %w", err))
+		}
+
+		places = props_rsp.Properties
+	}
+
+	props := map[string]interface{}{
+		"parent_id": h_rsp.ParentId,
+		"hierarchy": h_rsp.Hierarchy,
+		"places":    places,
+	}
+
+	return j.setProperties(body, props)
+}
+
+// JoinError returns a copy of the GeoJSON feature 'body', encoded on a single line, with 'err' and its code added to
+// its properties.
+func (j *joiner) JoinError(body []byte, err error) ([]byte, error) {
+
+	code, _ := pip.ErrorCodeWithError(err)
+
+	props := map[string]interface{}{
+		"error":      err.Error(),
+		"error_code": code,
+	}
+
+	if !gjson.ValidBytes(body) || !gjson.ParseBytes(body).IsObject() {
+		return nil, err
+	}
+
+	return j.setProperties(body, props)
+}
+
+// setProperties returns a copy of the GeoJSON feature 'body', encoded on a single line, with each of 'props' assigned
+// to its properties using the joiner's prefix.
+func (j *joiner) setProperties(body []byte, props map[string]interface{}) ([]byte, error) {
+
+	var err error
+
+	if !gjson.GetBytes(body, "properties").IsObject() {
+
+		body, err = sjson.SetBytes(body, "properties", map[string]interface{}{})
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to assign properties, %w", err)
+		}
+	}
+
+	for _, k := range []string{"parent_id", "hierarchy", "places", "error", "error_code"} {
+
+		v, ok := props[k]
+
+		if !ok {
+			continue
+		}
+
+		path := fmt.Sprintf("properties.%s", escapePath(j.prefix+k))
+
+		body, err = sjson.SetBytes(body, path, v)
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to assign %s property, %w", k, err)
+		}
+	}
+
+	var buf bytes.Buffer
+
+	err = json.Compact(&buf, body)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to encode feature, %w", err)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// featureCoordinate returns the coordinate of the GeoJSON feature 'body' if its geometry is a point, otherwise the
+// centroid of its geometry.
+func featureCoordinate(body []byte) (orb.Point, error) {
+
+	geom_rsp := gjson.GetBytes(body, "geometry")
+
+	if !geom_rsp.IsObject() {
+		return orb.Point{}, pip.NewError(pip.INVALID_COORDINATE, fmt.Errorf("Missing geometry"))
+	}
+
+	geom, err := geojson.UnmarshalGeometry([]byte(geom_rsp.Raw))
+
+	if err != nil {
+		return orb.Point{}, pip.NewError(pip.INVALID_COORDINATE, fmt.Errorf("Failed to parse geometry, %w", err))
+	}
+
+	orb_geom := geom.Geometry()
+
+	if orb_geom == nil {
+		return orb.Point{}, pip.NewError(pip.INVALID_COORDINATE, fmt.Errorf("Missing geometry"))
+	}
+
+	pt, ok := orb_geom.(orb.Point)
+
+	if ok {
+		return pt, nil
+	}
+
+	centroid, _ := planar.CentroidArea(orb_geom)
+	return centroid, nil
+}
+
+// readFeatures reads one or more GeoJSON objects from 'r' invoking 'cb' for each feature. If an object has a "features"
+// member, as a FeatureCollection does, 'cb' is invoked for each of its elements as they are decoded rather than reading
+// the entire collection in to memory first. Otherwise 'cb' is invoked for the object itself, as is the case for
+// newline-delimited GeoJSON features.
+func readFeatures(r io.Reader, cb func([]byte) error) error {
+
+	dec := json.NewDecoder(r)
+	count := 0
+
+	expectDelim := func(want json.Delim) error {
+
+		tok, err := dec.Token()
+
+		if err != nil {
+			return err
+		}
+
+		d, ok := tok.(json.Delim)
+
+		if !ok || d != want {
+			return fmt.Errorf("Unexpected token '%v', expected '%v'", tok, want)
+		}
+
+		return nil
+	}
+
+	for {
+
+		err := expectDelim('{')
+
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+
+		if err != nil {
+			return fmt.Errorf("Failed to read object %d, %w", count+1, err)
+		}
+
+		count += 1
+
+		members := make(map[string]json.RawMessage)
+		is_collection := false
+
+		for dec.More() {
+
+			tok, err := dec.Token()
+
+			if err != nil {
+				return fmt.Errorf("Failed to read object %d, %w", count, err)
+			}
+
+			k, ok := tok.(string)
+
+			if !ok {
+				return fmt.Errorf("Failed to read object %d, unexpected token '%v'", count, tok)
+			}
+
+			if k == "features" {
+
+				is_collection = true
+
+				err := expectDelim('[')
+
+				if err != nil {
+					return fmt.Errorf("Failed to read features for object %d, %w", count, err)
+				}
+
+				for dec.More() {
+
+					var raw json.RawMessage
+					err := dec.Decode(&raw)
+
+					if err != nil {
+						return fmt.Errorf("Failed to read feature for object %d, %w", count, err)
+					}
+
+					err = cb(raw)
+
+					if err != nil {
+						return err
+					}
+				}
+
+				err = expectDelim(']')
+
+				if err != nil {
+					return fmt.Errorf("Failed to read features for object %d, %w", count, err)
+				}
+
+				continue
+			}
+
+			var raw json.RawMessage
+			err = dec.Decode(&raw)
+
+			if err != nil {
+				return fmt.Errorf("Failed to read %s member for object %d, %w", k, count, err)
+			}
+
+			members[k] = raw
+		}
+
+		err = expectDelim('}')
+
+		if err != nil {
+			return fmt.Errorf("Failed to read object %d, %w", count, err)
+		}
+
+		if is_collection {
+			continue
+		}
+
+		body, err := json.Marshal(members)
+
+		if err != nil {
+			return fmt.Errorf("Failed to encode object %d, %w", count, err)
+		}
+
+		err = cb(body)
+
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// escapePath escapes the characters in 'k' that have a special meaning in gjson and sjson paths.
+func escapePath(k string) string {
+
+	for _, c := range []string{`\`, ".", "*", "?"} {
+		k = strings.ReplaceAll(k, c, `\`+c)
+	}
+
+	return k
+}
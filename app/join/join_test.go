@@ -0,0 +1,633 @@
+package join
+
+import (
+	_ "github.com/whosonfirst/go-whosonfirst-spatial-rtree"
+)
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/paulmach/orb"
+	"github.com/whosonfirst/go-whosonfirst-iterate/v2/iterator"
+	"github.com/whosonfirst/go-whosonfirst-spatial"
+	"github.com/whosonfirst/go-whosonfirst-spatial-pip"
+	spatial_app "github.com/whosonfirst/go-whosonfirst-spatial/app"
+	"github.com/whosonfirst/go-whosonfirst-spatial/database"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const fixturesPath string = "../../fixtures/data"
+
+// newTestApplication returns a new spatial_app.SpatialApplication instance whose spatial database is a rtree://
+// database in which the records in fixturesPath have been indexed.
+func newTestApplication(t *testing.T) *spatial_app.SpatialApplication {
+
+	t.Helper()
+
+	ctx := context.Background()
+
+	db, err := database.NewSpatialDatabase(ctx, "rtree://")
+
+	if err != nil {
+		t.Fatalf("Failed to create spatial database, %v", err)
+	}
+
+	iter_cb := func(ctx context.Context, path string, r io.ReadSeeker, args ...interface{}) error {
+
+		body, err := io.ReadAll(r)
+
+		if err != nil {
+			return err
+		}
+
+		return db.IndexFeature(ctx, body)
+	}
+
+	iter, err := iterator.NewIterator(ctx, "directory://", iter_cb)
+
+	if err != nil {
+		t.Fatalf("Failed to create iterator, %v", err)
+	}
+
+	iter.Logger = log.New(io.Discard, "", 0)
+
+	err = iter.IterateURIs(ctx, fixturesPath)
+
+	if err != nil {
+		t.Fatalf("Failed to index fixtures, %v", err)
+	}
+
+	app := &spatial_app.SpatialApplication{
+		SpatialDatabase:  db,
+		PropertiesReader: db,
+		Iterator:         iter,
+		Logger:           log.New(io.Discard, "", 0),
+		Monitor:          pip.NewRequestMonitor(),
+	}
+
+	return app
+}
+
+// joinedFeature is the subset of a joined GeoJSON feature examined by tests.
+type joinedFeature struct {
+	Id         string                 `json:"id"`
+	Properties map[string]interface{} `json:"properties"`
+}
+
+// readJoined returns the newline-delimited GeoJSON features in 'body', checking that each is encoded on a single line.
+// Numbers are decoded as json.Number so that IDs are not formatted as floats.
+func readJoined(t *testing.T, body []byte) []*joinedFeature {
+
+	t.Helper()
+
+	features := make([]*joinedFeature, 0)
+
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+
+	for scanner.Scan() {
+
+		var f *joinedFeature
+
+		dec := json.NewDecoder(bytes.NewReader(scanner.Bytes()))
+		dec.UseNumber()
+
+		err := dec.Decode(&f)
+
+		if err != nil {
+			t.Fatalf("Failed to decode joined feature '%s', %v", scanner.Text(), err)
+		}
+
+		features = append(features, f)
+	}
+
+	return features
+}
+
+// placeIds returns the "wof:id" property of each place in the joined property 'v'.
+func placeIds(v interface{}) []string {
+
+	ids := make([]string, 0)
+
+	places, _ := v.([]interface{})
+
+	for _, p := range places {
+
+		id := p.(map[string]interface{})["wof:id"]
+		ids = append(ids, fmt.Sprintf("%v", id))
+	}
+
+	return ids
+}
+
+// pointFeature returns a GeoJSON point feature with id 'id' at 'lon', 'lat'.
+func pointFeature(id string, lon float64, lat float64) string {
+	return fmt.Sprintf(`{"type":"Feature","id":"%s","properties":{"name":"%s"},"geometry":{"type":"Point","coordinates":[%v,%v]}}`, id, id, lon, lat)
+}
+
+func TestReadFeatures(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		body     string
+		expected []string
+		ok       bool
+	}{
+		{
+			name:     "feature collection",
+			body:     `{"type":"FeatureCollection","features":[{"id":"a"},{"id":"b"}],"name":"test"}`,
+			expected: []string{`{"id":"a"}`, `{"id":"b"}`},
+			ok:       true,
+		},
+		{
+			name:     "newline-delimited",
+			body:     "{\"id\":\"a\"}\n{\"id\":\"b\"}\n\n{\"id\":\"c\"}",
+			expected: []string{`{"id":"a"}`, `{"id":"b"}`, `{"id":"c"}`},
+			ok:       true,
+		},
+		{
+			name:     "collections",
+			body:     `{"features":[{"id":"a"}]} {"features":[]} {"id":"b"}`,
+			expected: []string{`{"id":"a"}`, `{"id":"b"}`},
+			ok:       true,
+		},
+		{
+			name:     "empty",
+			body:     "",
+			expected: []string{},
+			ok:       true,
+		},
+		{
+			name: "array",
+			body: `[{"id":"a"}]`,
+		},
+		{
+			name: "features not an array",
+			body: `{"features":{"id":"a"}}`,
+		},
+		{
+			name:     "truncated",
+			body:     `{"id":"a"} {"id":`,
+			expected: []string{`{"id":"a"}`},
+		},
+	}
+
+	for _, tt := range tests {
+
+		t.Run(tt.name, func(t *testing.T) {
+
+			features := make([]string, 0)
+
+			cb := func(body []byte) error {
+				features = append(features, string(body))
+				return nil
+			}
+
+			err := readFeatures(strings.NewReader(tt.body), cb)
+
+			if tt.ok && err != nil {
+				t.Fatalf("Failed to read features, %v", err)
+			}
+
+			if !tt.ok && err == nil {
+				t.Fatalf("Expected an error")
+			}
+
+			if tt.expected != nil && !reflect.DeepEqual(features, tt.expected) {
+				t.Fatalf("Expected %v, got %v", tt.expected, features)
+			}
+		})
+	}
+}
+
+func TestFeatureCoordinate(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		body     string
+		expected orb.Point
+		ok       bool
+	}{
+		{name: "point", body: pointFeature("a", 1.5, 2.5), expected: orb.Point{1.5, 2.5}, ok: true},
+		{name: "polygon", body: `{"type":"Feature","geometry":{"type":"Polygon","coordinates":[[[0,0],[2,0],[2,2],[0,2],[0,0]]]}}`, expected: orb.Point{1, 1}, ok: true},
+		{name: "missing geometry", body: `{"type":"Feature","properties":{}}`},
+		{name: "null geometry", body: `{"type":"Feature","geometry":null}`},
+		{name: "invalid geometry", body: `{"type":"Feature","geometry":{"type":"Blob","coordinates":[1,2]}}`},
+	}
+
+	for _, tt := range tests {
+
+		t.Run(tt.name, func(t *testing.T) {
+
+			pt, err := featureCoordinate([]byte(tt.body))
+
+			if !tt.ok {
+
+				if err == nil {
+					t.Fatalf("Expected an error, got %v", pt)
+				}
+
+				code, _ := pip.ErrorCodeWithError(err)
+
+				if code != pip.INVALID_COORDINATE {
+					t.Fatalf("Expected %s, got %v", pip.INVALID_COORDINATE, err)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Failed to derive coordinate, %v", err)
+			}
+
+			if !pt.Equal(tt.expected) {
+				t.Fatalf("Expected %v, got %v", tt.expected, pt)
+			}
+		})
+	}
+}
+
+func TestEscapePath(t *testing.T) {
+
+	tests := map[string]string{
+		"pip:parent_id": "pip:parent_id",
+		"pip.parent_id": `pip\.parent_id`,
+		"pip*?":         `pip\*\?`,
+		`pip\parent_id`: `pip\\parent_id`,
+	}
+
+	for k, expected := range tests {
+
+		v := escapePath(k)
+
+		if v != expected {
+			t.Fatalf("Expected '%s' for '%s', got '%s'", expected, k, v)
+		}
+	}
+}
+
+func TestJoin(t *testing.T) {
+
+	ctx := context.Background()
+	app := newTestApplication(t)
+
+	// Features which can not be joined are recorded, skipped or stop processing depending on -on-error
+
+	input := strings.Join([]string{
+		pointFeature("corner", 0.25, 0.25),
+		`{"type":"Feature","id":"polygon","properties":null,"geometry":{"type":"Polygon","coordinates":[[[5.25,5.25],[5.75,5.25],[5.75,5.75],[5.25,5.75],[5.25,5.25]]]}}`,
+		`{"type":"Feature","id":"missing"}`,
+		pointFeature("nowhere", -10, -10),
+	}, "\n")
+
+	tests := []struct {
+		name     string
+		input    string
+		on_error string
+		prefix   string
+		ids      []string
+		places   [][]string
+		errors   []string
+	}{
+		{
+			name:     "property",
+			input:    input,
+			on_error: ON_ERROR_PROPERTY,
+			prefix:   "pip:",
+			ids:      []string{"corner", "polygon", "missing", "nowhere"},
+			places:   [][]string{{"102000001", "101000001", "85000002", "85000001"}, {"101000003"}, nil, {}},
+			errors:   []string{"", "", string(pip.INVALID_COORDINATE), ""},
+		},
+		{
+			name:     "skip",
+			input:    input,
+			on_error: ON_ERROR_SKIP,
+			prefix:   "wof.",
+			ids:      []string{"corner", "polygon", "nowhere"},
+			places:   [][]string{{"102000001", "101000001", "85000002", "85000001"}, {"101000003"}, {}},
+			errors:   []string{"", "", ""},
+		},
+		{
+			name:     "feature collection",
+			input:    fmt.Sprintf(`{"type":"FeatureCollection","features":[%s,{"type":"Point","coordinates":[0,0]}]}`, pointFeature("box", 0.75, 0.75)),
+			on_error: ON_ERROR_PROPERTY,
+			prefix:   "pip:",
+			ids:      []string{"box", ""},
+			places:   [][]string{{"101000001", "85000002", "85000001"}, nil},
+			errors:   []string{"", string(pip.INVALID_REQUEST)},
+		},
+	}
+
+	for _, tt := range tests {
+
+		t.Run(tt.name, func(t *testing.T) {
+
+			var buf bytes.Buffer
+
+			opts := &JoinOptions{
+				Filter:         &pip.PointInPolygonRequest{IsCurrent: []int64{1}},
+				PropertyPrefix: tt.prefix,
+				MaxWorkers:     2,
+				OnError:        tt.on_error,
+				Logger:         log.New(io.Discard, "", 0),
+			}
+
+			err := Join(ctx, app, strings.NewReader(tt.input), &buf, opts)
+
+			if err != nil {
+				t.Fatalf("Failed to join features, %v", err)
+			}
+
+			features := readJoined(t, buf.Bytes())
+
+			if len(features) != len(tt.ids) {
+				t.Fatalf("Expected %d features, got %d", len(tt.ids), len(features))
+			}
+
+			for idx, f := range features {
+
+				if f.Id != tt.ids[idx] {
+					t.Fatalf("Expected feature %d to be '%s', got '%s'", idx, tt.ids[idx], f.Id)
+				}
+
+				code, _ := f.Properties[tt.prefix+"error_code"].(string)
+
+				if code != tt.errors[idx] {
+					t.Fatalf("Expected error code '%s' for feature %d, got '%s'", tt.errors[idx], idx, code)
+				}
+
+				if tt.places[idx] == nil {
+					continue
+				}
+
+				ids := placeIds(f.Properties[tt.prefix+"places"])
+
+				if !reflect.DeepEqual(ids, tt.places[idx]) {
+					t.Fatalf("Expected places %v for feature %d, got %v", tt.places[idx], idx, ids)
+				}
+
+				if len(ids) > 0 && fmt.Sprintf("%v", f.Properties[tt.prefix+"parent_id"]) != ids[0] {
+					t.Fatalf("Expected parent ID %s for feature %d, got %v", ids[0], idx, f.Properties[tt.prefix+"parent_id"])
+				}
+
+				if _, ok := f.Properties[tt.prefix+"hierarchy"]; !ok {
+					t.Fatalf("Expected hierarchy for feature %d", idx)
+				}
+			}
+		})
+	}
+}
+
+func TestJoinProperties(t *testing.T) {
+
+	ctx := context.Background()
+	app := newTestApplication(t)
+
+	var buf bytes.Buffer
+
+	opts := &JoinOptions{
+		Filter: &pip.PointInPolygonRequest{IsCurrent: []int64{1}},
+		PropertiesResponse: &spatial.PropertiesResponseOptions{
+			Reader:       app.PropertiesReader,
+			Keys:         []string{"wof:placetype"},
+			SourcePrefix: "properties",
+		},
+		PropertyPrefix: "pip:",
+		OnError:        ON_ERROR_FAIL,
+		Logger:         log.New(io.Discard, "", 0),
+	}
+
+	err := Join(ctx, app, strings.NewReader(pointFeature("box", 0.75, 0.75)), &buf, opts)
+
+	if err != nil {
+		t.Fatalf("Failed to join features, %v", err)
+	}
+
+	features := readJoined(t, buf.Bytes())
+
+	if len(features) != 1 {
+		t.Fatalf("Expected 1 feature, got %d", len(features))
+	}
+
+	places, _ := features[0].Properties["pip:places"].([]interface{})
+
+	placetypes := make([]string, len(places))
+
+	for idx, p := range places {
+		placetypes[idx], _ = p.(map[string]interface{})["wof:placetype"].(string)
+	}
+
+	expected := []string{"locality", "region", "country"}
+
+	if !reflect.DeepEqual(placetypes, expected) {
+		t.Fatalf("Expected %v, got %v", expected, placetypes)
+	}
+
+	if features[0].Properties["name"] != "box" {
+		t.Fatalf("Expected existing properties to be preserved, %v", features[0].Properties)
+	}
+}
+
+func TestJoinOrder(t *testing.T) {
+
+	ctx := context.Background()
+	app := newTestApplication(t)
+
+	// Features are written in the order they are read regardless of how many queries are performed concurrently
+
+	features := make([]string, 0)
+
+	for i := 0; i < 100; i++ {
+
+		lon := 0.75
+
+		if i%3 == 0 {
+			lon = 5.5
+		}
+
+		features = append(features, pointFeature(fmt.Sprintf("%d", i), lon, lon))
+	}
+
+	var buf bytes.Buffer
+
+	opts := &JoinOptions{
+		Filter:     &pip.PointInPolygonRequest{IsCurrent: []int64{1}},
+		MaxWorkers: 8,
+		OnError:    ON_ERROR_FAIL,
+		Logger:     log.New(io.Discard, "", 0),
+	}
+
+	err := Join(ctx, app, strings.NewReader(strings.Join(features, "\n")), &buf, opts)
+
+	if err != nil {
+		t.Fatalf("Failed to join features, %v", err)
+	}
+
+	joined := readJoined(t, buf.Bytes())
+
+	if len(joined) != 100 {
+		t.Fatalf("Expected 100 features, got %d", len(joined))
+	}
+
+	for idx, f := range joined {
+
+		expected := "101000001"
+
+		if idx%3 == 0 {
+			expected = "101000003"
+		}
+
+		if f.Id != fmt.Sprintf("%d", idx) || fmt.Sprintf("%v", f.Properties["parent_id"]) != expected {
+			t.Fatalf("Expected feature %d to have parent %s, got %s %v", idx, expected, f.Id, f.Properties["parent_id"])
+		}
+	}
+}
+
+func TestJoinErrors(t *testing.T) {
+
+	ctx := context.Background()
+	app := newTestApplication(t)
+
+	tests := []struct {
+		name     string
+		input    string
+		on_error string
+		code     pip.ErrorCode
+	}{
+		{name: "fail", input: pointFeature("box", 0.75, 0.75) + "\n" + pointFeature("out of range", 0, 91), on_error: ON_ERROR_FAIL, code: pip.INVALID_COORDINATE},
+		{name: "not an object", input: `{"features":["box"]}`, on_error: ON_ERROR_PROPERTY},
+		{name: "malformed", input: pointFeature("box", 0.75, 0.75) + "\n{", on_error: ON_ERROR_PROPERTY},
+	}
+
+	for _, tt := range tests {
+
+		t.Run(tt.name, func(t *testing.T) {
+
+			opts := &JoinOptions{
+				OnError: tt.on_error,
+				Logger:  log.New(io.Discard, "", 0),
+			}
+
+			err := Join(ctx, app, strings.NewReader(tt.input), io.Discard, opts)
+
+			if err == nil {
+				t.Fatalf("Expected an error")
+			}
+
+			if tt.code != "" {
+
+				code, _ := pip.ErrorCodeWithError(err)
+
+				if code != tt.code {
+					t.Fatalf("Expected %s, got %v", tt.code, err)
+				}
+			}
+		})
+	}
+}
+
+func TestRunWithFlagSet(t *testing.T) {
+
+	ctx := context.Background()
+
+	tests := []struct {
+		name   string
+		args   []string
+		parent []string
+		ok     bool
+	}{
+		{
+			name:   "join",
+			args:   []string{"-is-current", "1", "-placetype", "locality"},
+			parent: []string{"101000001", "101000003"},
+			ok:     true,
+		},
+		{
+			name: "invalid on error",
+			args: []string{"-on-error", "ignore"},
+		},
+	}
+
+	for _, tt := range tests {
+
+		t.Run(tt.name, func(t *testing.T) {
+
+			root := t.TempDir()
+
+			input := filepath.Join(root, "input.geojson")
+			output := filepath.Join(root, "output.geojsonl")
+
+			body := fmt.Sprintf(`{"type":"FeatureCollection","features":[%s,%s]}`, pointFeature("corner", 0.25, 0.25), pointFeature("island", 5.5, 5.5))
+
+			err := os.WriteFile(input, []byte(body), 0644)
+
+			if err != nil {
+				t.Fatalf("Failed to write input, %v", err)
+			}
+
+			fs, err := DefaultFlagSet(ctx)
+
+			if err != nil {
+				t.Fatalf("Failed to create flag set, %v", err)
+			}
+
+			args_orig := os.Args
+
+			defer func() {
+				os.Args = args_orig
+			}()
+
+			args := []string{
+				"join",
+				"-spatial-database-uri", "rtree://",
+				"-iterator-uri", "directory://",
+				"-input", input,
+				"-output", output,
+			}
+
+			args = append(args, tt.args...)
+			os.Args = append(args, fixturesPath)
+
+			err = RunWithFlagSet(ctx, fs, log.New(io.Discard, "", 0))
+
+			if !tt.ok {
+
+				if err == nil {
+					t.Fatalf("Expected an error")
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Failed to run application, %v", err)
+			}
+
+			out, err := os.ReadFile(output)
+
+			if err != nil {
+				t.Fatalf("Failed to read output, %v", err)
+			}
+
+			features := readJoined(t, out)
+
+			parent := make([]string, len(features))
+
+			for idx, f := range features {
+				parent[idx] = fmt.Sprintf("%v", f.Properties["pip:parent_id"])
+			}
+
+			if !reflect.DeepEqual(parent, tt.parent) {
+				t.Fatalf("Expected parent IDs %v, got %v", tt.parent, parent)
+			}
+		})
+	}
+}
@@ -0,0 +1,102 @@
+package query
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// STDIN is the positional argument used to signal that coordinates should be read from STDIN.
+const STDIN string = "-"
+
+// re_coord matches a "latitude,longitude" positional argument.
+var re_coord = regexp.MustCompile(`^\s*[-+]?(\d+(\.\d*)?|\.\d+)\s*,\s*[-+]?(\d+(\.\d*)?|\.\d+)\s*$`)
+
+// queryCoordinate is a latitude, longitude pair to query.
+type queryCoordinate struct {
+	Latitude  float64
+	Longitude float64
+}
+
+// splitArgs splits 'args' in to "latitude,longitude" coordinates to query and URIs to index. It also returns
+// true if 'args' contains the STDIN argument.
+func splitArgs(args []string) ([]string, []string, bool) {
+
+	coords := make([]string, 0)
+	uris := make([]string, 0)
+	read_stdin := false
+
+	for _, a := range args {
+
+		switch {
+		case a == STDIN:
+			read_stdin = true
+		case re_coord.MatchString(a):
+			coords = append(coords, a)
+		default:
+			uris = append(uris, a)
+		}
+	}
+
+	return coords, uris, read_stdin
+}
+
+// readCoordinates returns the "latitude,longitude" coordinates, one per line, read from 'r'. Empty lines and lines
+// starting with "#" are ignored.
+func readCoordinates(r io.Reader) ([]string, error) {
+
+	coords := make([]string, 0)
+
+	scanner := bufio.NewScanner(r)
+
+	for scanner.Scan() {
+
+		ln := strings.TrimSpace(scanner.Text())
+
+		if ln == "" || strings.HasPrefix(ln, "#") {
+			continue
+		}
+
+		coords = append(coords, ln)
+	}
+
+	err := scanner.Err()
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read coordinates, %w", err)
+	}
+
+	return coords, nil
+}
+
+// parseCoordinate parses a "latitude,longitude" string.
+func parseCoordinate(str string) (*queryCoordinate, error) {
+
+	parts := strings.Split(str, ",")
+
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("Invalid coordinate '%s', expected latitude,longitude", str)
+	}
+
+	lat, err := strconv.ParseFloat(strings.TrimSpace(parts[0]), 64)
+
+	if err != nil {
+		return nil, fmt.Errorf("Invalid latitude in coordinate '%s', %w", str, err)
+	}
+
+	lon, err := strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
+
+	if err != nil {
+		return nil, fmt.Errorf("Invalid longitude in coordinate '%s', %w", str, err)
+	}
+
+	c := &queryCoordinate{
+		Latitude:  lat,
+		Longitude: lon,
+	}
+
+	return c, nil
+}
@@ -0,0 +1,255 @@
+package query
+
+import (
+	"context"
+	"os"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestSplitArgs(t *testing.T) {
+
+	tests := []struct {
+		name       string
+		args       []string
+		coords     []string
+		uris       []string
+		read_stdin bool
+	}{
+		{
+			name:   "coordinates and uris",
+			args:   []string{"37.6,-122.4", "/usr/local/data", "-1.5, .5", "+2.,3"},
+			coords: []string{"37.6,-122.4", "-1.5, .5", "+2.,3"},
+			uris:   []string{"/usr/local/data"},
+		},
+		{
+			name:       "stdin",
+			args:       []string{"-", "/usr/local/data"},
+			coords:     []string{},
+			uris:       []string{"/usr/local/data"},
+			read_stdin: true,
+		},
+		{
+			name:   "not coordinates",
+			args:   []string{"1,2,3", "a,b", "1.2.3,4", "data,1"},
+			coords: []string{},
+			uris:   []string{"1,2,3", "a,b", "1.2.3,4", "data,1"},
+		},
+		{
+			name:   "empty",
+			args:   []string{},
+			coords: []string{},
+			uris:   []string{},
+		},
+	}
+
+	for _, tt := range tests {
+
+		t.Run(tt.name, func(t *testing.T) {
+
+			coords, uris, read_stdin := splitArgs(tt.args)
+
+			if !reflect.DeepEqual(coords, tt.coords) {
+				t.Fatalf("Expected coordinates %v, got %v", tt.coords, coords)
+			}
+
+			if !reflect.DeepEqual(uris, tt.uris) {
+				t.Fatalf("Expected URIs %v, got %v", tt.uris, uris)
+			}
+
+			if read_stdin != tt.read_stdin {
+				t.Fatalf("Expected read STDIN to be %t, got %t", tt.read_stdin, read_stdin)
+			}
+		})
+	}
+}
+
+func TestReadCoordinates(t *testing.T) {
+
+	body := "# A comment\n37.6,-122.4\n\n  1,2  \n# Another comment\n5.5,5.5"
+
+	coords, err := readCoordinates(strings.NewReader(body))
+
+	if err != nil {
+		t.Fatalf("Failed to read coordinates, %v", err)
+	}
+
+	expected := []string{"37.6,-122.4", "1,2", "5.5,5.5"}
+
+	if !slices.Equal(coords, expected) {
+		t.Fatalf("Expected %v, got %v", expected, coords)
+	}
+}
+
+func TestParseCoordinate(t *testing.T) {
+
+	tests := []struct {
+		str      string
+		expected *queryCoordinate
+	}{
+		{str: "37.6,-122.4", expected: &queryCoordinate{Latitude: 37.6, Longitude: -122.4}},
+		{str: " 1 , 2 ", expected: &queryCoordinate{Latitude: 1, Longitude: 2}},
+		{str: "1"},
+		{str: "1,2,3"},
+		{str: "north,2"},
+		{str: "1,east"},
+	}
+
+	for _, tt := range tests {
+
+		t.Run(tt.str, func(t *testing.T) {
+
+			c, err := parseCoordinate(tt.str)
+
+			if tt.expected == nil {
+
+				if err == nil {
+					t.Fatalf("Expected an error, got %v", c)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Failed to parse coordinate, %v", err)
+			}
+
+			if *c != *tt.expected {
+				t.Fatalf("Expected %v, got %v", tt.expected, c)
+			}
+		})
+	}
+}
+
+func TestCLIRequests(t *testing.T) {
+
+	ctx := context.Background()
+
+	tests := []struct {
+		name     string
+		args     []string
+		coords   []string
+		expected [][2]float64
+		ok       bool
+	}{
+		{
+			name:     "flags",
+			args:     []string{"-latitude", "1", "-longitude", "2"},
+			expected: [][2]float64{{1, 2}},
+			ok:       true,
+		},
+		{
+			name:     "null island",
+			args:     []string{"-latitude", "0", "-longitude", "0"},
+			expected: [][2]float64{{0, 0}},
+			ok:       true,
+		},
+		{
+			name:     "coordinates",
+			args:     []string{"-placetype", "locality"},
+			coords:   []string{"1,2", "3,4"},
+			expected: [][2]float64{{1, 2}, {3, 4}},
+			ok:       true,
+		},
+		{
+			name: "missing coordinates",
+			args: []string{"-placetype", "locality"},
+		},
+		{
+			name: "missing longitude",
+			args: []string{"-latitude", "1"},
+		},
+		{
+			name:   "flags and coordinates",
+			args:   []string{"-latitude", "1", "-longitude", "2"},
+			coords: []string{"3,4"},
+		},
+		{
+			name:   "invalid coordinate",
+			coords: []string{"3,4", "north,4"},
+		},
+	}
+
+	for _, tt := range tests {
+
+		t.Run(tt.name, func(t *testing.T) {
+
+			fs, err := DefaultFlagSet(ctx)
+
+			if err != nil {
+				t.Fatalf("Failed to create flag set, %v", err)
+			}
+
+			err = fs.Parse(tt.args)
+
+			if err != nil {
+				t.Fatalf("Failed to parse flags, %v", err)
+			}
+
+			reqs, err := cliRequests(fs, tt.coords)
+
+			if !tt.ok {
+
+				if err == nil {
+					t.Fatalf("Expected an error")
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Failed to derive requests, %v", err)
+			}
+
+			if len(reqs) != len(tt.expected) {
+				t.Fatalf("Expected %d requests, got %d", len(tt.expected), len(reqs))
+			}
+
+			for idx, req := range reqs {
+
+				if req.Latitude != tt.expected[idx][0] || req.Longitude != tt.expected[idx][1] {
+					t.Fatalf("Expected %v for request %d, got %f,%f", tt.expected[idx], idx, req.Latitude, req.Longitude)
+				}
+			}
+		})
+	}
+}
+
+func TestRunWithFlagSetSTDIN(t *testing.T) {
+
+	stdin_orig := os.Stdin
+
+	r, wr, err := os.Pipe()
+
+	if err != nil {
+		t.Fatalf("Failed to create pipe, %v", err)
+	}
+
+	os.Stdin = r
+
+	defer func() {
+		os.Stdin = stdin_orig
+	}()
+
+	_, err = wr.WriteString("# Coordinates\n5.5,5.5\n0.75,0.75\n")
+
+	if err != nil {
+		t.Fatalf("Failed to write coordinates, %v", err)
+	}
+
+	wr.Close()
+
+	ids, err := runCLI(t, "-is-current", "1", STDIN, fixturesPath)
+
+	if err != nil {
+		t.Fatalf("Failed to run application, %v", err)
+	}
+
+	expected := [][]int64{{101000003}, {85000001, 85000002, 101000001}}
+
+	if !reflect.DeepEqual(ids, expected) {
+		t.Fatalf("Expected %v, got %v", expected, ids)
+	}
+}
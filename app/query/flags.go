@@ -0,0 +1,127 @@
+package query
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/whosonfirst/go-whosonfirst-spatial-pip"
+	"github.com/whosonfirst/go-whosonfirst-spatial/flags"
+)
+
+var mode string
+
+var server_uri string
+
+var enable_geojson bool
+
+var log_timings bool
+
+var batch_max_workers int
+
+var batch_max_requests int
+
+var stream_max_workers int
+
+var enable_metrics bool
+
+var health_minimum_records int64
+
+var strict_requests bool
+
+var index_geometries bool
+
+var cache_size int
+
+var cache_ttl int
+
+var cache_precision int
+
+var data_release string
+
+var enable_admin bool
+
+var admin_token string
+
+var reindex_interval int
+
+var serve_while_indexing bool
+
+var expected_records int64
+
+func DefaultFlagSet(ctx context.Context) (*flag.FlagSet, error) {
+
+	fs, err := flags.CommonFlags()
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to derive common spatial flags, %w", err)
+	}
+
+	err = flags.AppendQueryFlags(fs)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to append query flags, %w", err)
+	}
+
+	err = flags.AppendIndexingFlags(fs)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to append indexing flags, %w", err)
+	}
+
+	err = pip.AppendPaginationFlags(fs)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to append pagination flags, %w", err)
+	}
+
+	err = pip.AppendFallbackFlags(fs)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to append fallback flags, %w", err)
+	}
+
+	err = pip.AppendExplainFlags(fs)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to append explain flags, %w", err)
+	}
+
+	err = pip.AppendTimingsFlags(fs)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to append timings flags, %w", err)
+	}
+
+	fs.StringVar(&mode, "mode", "cli", "Valid options are: cli, lambda, server.")
+	fs.StringVar(&server_uri, "server-uri", "http://localhost:8080", "A valid aaronland/go-http-server URI. Only used when -mode is 'server'.")
+	fs.BoolVar(&enable_geojson, "enable-geojson", false, "Enable GeoJSON output for point-in-polygon responses. Only used when -mode is 'server'.")
+	fs.BoolVar(&log_timings, "log-timings", false, "Log timings for point-in-polygon requests. Only used when -mode is 'server'.")
+
+	fs.IntVar(&batch_max_workers, "batch-max-workers", pip.DEFAULT_BATCH_WORKERS, "The maximum number of concurrent point-in-polygon queries to perform for a batch request. Only used when -mode is 'server'.")
+	fs.IntVar(&batch_max_requests, "batch-max-requests", 10000, "The maximum number of point-in-polygon queries allowed in a batch request. If 0 there is no limit. Only used when -mode is 'server'.")
+	fs.IntVar(&stream_max_workers, "stream-max-workers", pip.DEFAULT_BATCH_WORKERS, "The maximum number of concurrent point-in-polygon queries to perform for a streaming request. Only used when -mode is 'server'.")
+
+	fs.BoolVar(&enable_metrics, "enable-metrics", false, "Enable a /metrics endpoint exporting request counts, stage latencies, result counts, errors and indexing progress in the Prometheus text format. Only used when -mode is 'server'.")
+
+	fs.Int64Var(&health_minimum_records, "health-minimum-records", 0, "The minimum number of records that must be indexed before the /health/ready endpoint reports that the server is ready. Only used when -mode is 'server'.")
+
+	fs.BoolVar(&strict_requests, "strict-requests", false, "Reject JSON-encoded requests that contain unknown fields. Only used when -mode is 'server'.")
+
+	fs.BoolVar(&index_geometries, "index-geometries", false, "Maintain a secondary index of the geometries being indexed, which is required for intersects, geometry and nearest-polygon fallback queries and for explaining point-in-polygon candidates. The secondary index keeps a second copy of every geometry in memory so it roughly doubles the memory used by in-memory spatial databases.")
+
+	fs.IntVar(&cache_size, "cache-size", 0, "The maximum number of point-in-polygon results to cache. If 0 results are not cached.")
+	fs.IntVar(&cache_ttl, "cache-ttl", 300, "The maximum number of seconds to cache point-in-polygon results for. If 0 results do not expire. Only used when -cache-size is greater than 0.")
+	fs.IntVar(&cache_precision, "cache-precision", pip.DEFAULT_CACHE_PRECISION, "The number of decimal places that coordinates are rounded to when caching point-in-polygon results. Queries are performed using the rounded coordinates. If 0 the default precision is used. Only used when -cache-size is greater than 0.")
+
+	fs.StringVar(&data_release, "data-release", "", "An optional label identifying the data release being indexed. It is included in point-in-polygon entity tags and the X-Index-Release response header.")
+
+	fs.BoolVar(&enable_admin, "enable-admin", false, "Enable the /features endpoint for indexing and removing records while the server is running. Requires the -admin-token flag. Only used when -mode is 'server'.")
+	fs.StringVar(&admin_token, "admin-token", "", "The bearer token that requests to the /features endpoint must present. Only used when -mode is 'server'.")
+
+	fs.BoolVar(&serve_while_indexing, "serve-while-indexing", false, "Perform point-in-polygon, batch, stream, hierarchy, intersects and geometry queries while records are still being indexed, marking responses with an 'X-Index-Complete: false' header (and, where the response format allows, an 'index_progress' property) rather than returning 503 Service Unavailable responses. The /health/ready endpoint reports that the server is ready once -health-minimum-records have been indexed. Only used when -mode is 'server'.")
+	fs.Int64Var(&expected_records, "expected-records", 0, "The number of records expected to be indexed, used to report indexing progress. If 0 the number of expected records is not reported. Only used when -mode is 'server'.")
+
+	fs.IntVar(&reindex_interval, "reindex-interval", 0, "The number of seconds between re-crawls of the URIs being indexed, during which records whose files have been modified are re-indexed and records whose files have been deleted are removed. Deleted alternate geometry files are not removed until the spatial database is rebuilt. If 0 records are not re-crawled. Sending the server a SIGHUP signal rebuilds the spatial database regardless. Only used when -mode is 'server'.")
+
+	return fs, nil
+}
@@ -0,0 +1,623 @@
+package query
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/aaronland/go-http-server"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/sfomuseum/go-flags/flagset"
+	"github.com/sfomuseum/go-flags/lookup"
+	"github.com/whosonfirst/go-reader"
+	"github.com/whosonfirst/go-whosonfirst-spatial-pip"
+	"github.com/whosonfirst/go-whosonfirst-spatial-pip/http/api"
+	spatial_app "github.com/whosonfirst/go-whosonfirst-spatial/app"
+	"github.com/whosonfirst/go-whosonfirst-spatial/flags"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"runtime/debug"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// Run invokes the query application using the default flag set.
+func Run(ctx context.Context, logger *log.Logger) error {
+
+	fs, err := DefaultFlagSet(ctx)
+
+	if err != nil {
+		return fmt.Errorf("Failed to create application flag set, %w", err)
+	}
+
+	return RunWithFlagSet(ctx, fs, logger)
+}
+
+// RunWithFlagSet invokes the query application using 'fs' in one of the "cli", "lambda" or "server" modes.
+func RunWithFlagSet(ctx context.Context, fs *flag.FlagSet, logger *log.Logger) error {
+
+	flagset.Parse(fs)
+
+	err := flagset.SetFlagsFromEnvVars(fs, "PIP")
+
+	if err != nil {
+		return fmt.Errorf("Failed to set flags from environment variables, %w", err)
+	}
+
+	err = flags.ValidateCommonFlags(fs)
+
+	if err != nil {
+		return fmt.Errorf("Failed to validate common flags, %w", err)
+	}
+
+	err = flags.ValidateIndexingFlags(fs)
+
+	if err != nil {
+		return fmt.Errorf("Failed to validate indexing flags, %w", err)
+	}
+
+	if mode == "cli" {
+
+		err = flags.ValidateQueryFlags(fs)
+
+		if err != nil {
+			return fmt.Errorf("Failed to validate query flags, %w", err)
+		}
+	}
+
+	app, err := newSpatialApplication(ctx, fs)
+
+	if err != nil {
+		return fmt.Errorf("Failed to create new spatial application, %w", err)
+	}
+
+	uris := fs.Args()
+
+	switch mode {
+
+	case "cli":
+
+		// Coordinates may be passed as "latitude,longitude" arguments, or read from STDIN,
+		// in which case the remaining arguments are the URIs to index.
+
+		coords, index_uris, read_stdin := splitArgs(uris)
+
+		if read_stdin {
+
+			stdin_coords, err := readCoordinates(os.Stdin)
+
+			if err != nil {
+				return err
+			}
+
+			coords = append(coords, stdin_coords...)
+		}
+
+		reqs, err := cliRequests(fs, coords)
+
+		if err != nil {
+			return fmt.Errorf("Failed to create point in polygon request, %w", err)
+		}
+
+		// Index synchronously since the queries can't be performed until
+		// indexing is complete.
+
+		if len(index_uris) > 0 {
+
+			err = app.Iterator.IterateURIs(ctx, index_uris...)
+
+			if err != nil {
+				return fmt.Errorf("Failed to index paths, %w", err)
+			}
+		}
+
+		enc := json.NewEncoder(os.Stdout)
+
+		for _, req := range reqs {
+
+			rsp, err := query(ctx, app, req)
+
+			if err != nil {
+				return fmt.Errorf("Failed to query point in polygon for %f,%f, %w", req.Latitude, req.Longitude, err)
+			}
+
+			err = enc.Encode(rsp)
+
+			if err != nil {
+				return fmt.Errorf("Failed to encode results, %w", err)
+			}
+		}
+
+		return nil
+
+	case "lambda":
+
+		err = indexPaths(ctx, app, nil, uris...)
+
+		if err != nil {
+			return err
+		}
+
+		handler := func(ctx context.Context, req *pip.PointInPolygonRequest) (interface{}, error) {
+			return query(ctx, app, req)
+		}
+
+		lambda.Start(handler)
+		return nil
+
+	case "server":
+
+		// Records are indexed using an iterator which records the modification times and IDs
+		// of their files so that they can be compared when the URIs are re-crawled.
+
+		files := newIndexedFiles()
+		var indexed int64
+
+		iter, err := newIndexingIterator(ctx, fs, app.SpatialDatabase, files, false, &indexed)
+
+		if err != nil {
+			return fmt.Errorf("Failed to create iterator, %w", err)
+		}
+
+		app.Iterator = iter
+
+		indexing_started := time.Now()
+
+		// The iterator only reports that it is indexing once it has started so readiness
+		// is derived from a flag which is set once the initial indexing has finished.
+
+		indexing_done := new(atomic.Bool)
+
+		err = indexPaths(ctx, app, indexing_done, uris...)
+
+		if err != nil {
+			return err
+		}
+
+		if len(uris) > 0 {
+
+			r := &reindexer{
+				app:      app,
+				fs:       fs,
+				uris:     uris,
+				interval: time.Duration(reindex_interval) * time.Second,
+				files:    files,
+				logger:   logger,
+			}
+
+			go r.Run(ctx)
+		}
+
+		var metrics *api.Metrics
+
+		if enable_metrics {
+			metrics = api.NewMetrics()
+		}
+
+		indexing_opts := api.IndexingOptions{
+			ServeWhileIndexing: serve_while_indexing,
+			ExpectedRecords:    expected_records,
+		}
+
+		pip_opts := &api.PointInPolygonHandlerOptions{
+			EnableGeoJSON:   enable_geojson,
+			Logger:          logger,
+			LogTimings:      log_timings,
+			Metrics:         metrics,
+			StrictDecoding:  strict_requests,
+			IndexingOptions: indexing_opts,
+		}
+
+		pip_handler, err := api.PointInPolygonHandler(app, pip_opts)
+
+		if err != nil {
+			return fmt.Errorf("Failed to create point in polygon handler, %w", err)
+		}
+
+		batch_opts := &api.BatchPointInPolygonHandlerOptions{
+			MaxWorkers:      batch_max_workers,
+			MaxRequests:     batch_max_requests,
+			Logger:          logger,
+			Metrics:         metrics,
+			StrictDecoding:  strict_requests,
+			IndexingOptions: indexing_opts,
+		}
+
+		batch_handler, err := api.BatchPointInPolygonHandler(app, batch_opts)
+
+		if err != nil {
+			return fmt.Errorf("Failed to create batch point in polygon handler, %w", err)
+		}
+
+		stream_opts := &api.StreamPointInPolygonHandlerOptions{
+			MaxWorkers:      stream_max_workers,
+			Logger:          logger,
+			Metrics:         metrics,
+			StrictDecoding:  strict_requests,
+			IndexingOptions: indexing_opts,
+		}
+
+		stream_handler, err := api.StreamPointInPolygonHandler(app, stream_opts)
+
+		if err != nil {
+			return fmt.Errorf("Failed to create streaming point in polygon handler, %w", err)
+		}
+
+		hierarchy_opts := &api.HierarchyHandlerOptions{
+			Logger:          logger,
+			Metrics:         metrics,
+			StrictDecoding:  strict_requests,
+			IndexingOptions: indexing_opts,
+		}
+
+		hierarchy_handler, err := api.HierarchyHandler(app, hierarchy_opts)
+
+		if err != nil {
+			return fmt.Errorf("Failed to create hierarchy handler, %w", err)
+		}
+
+		intersects_opts := &api.IntersectsHandlerOptions{
+			EnableGeoJSON:   enable_geojson,
+			Logger:          logger,
+			LogTimings:      log_timings,
+			Metrics:         metrics,
+			StrictDecoding:  strict_requests,
+			IndexingOptions: indexing_opts,
+		}
+
+		intersects_handler, err := api.IntersectsHandler(app, intersects_opts)
+
+		if err != nil {
+			return fmt.Errorf("Failed to create intersects handler, %w", err)
+		}
+
+		geom_opts := &api.GeometryHandlerOptions{
+			EnableGeoJSON:   enable_geojson,
+			Logger:          logger,
+			LogTimings:      log_timings,
+			Metrics:         metrics,
+			StrictDecoding:  strict_requests,
+			IndexingOptions: indexing_opts,
+		}
+
+		geom_handler, err := api.GeometryHandler(app, geom_opts)
+
+		if err != nil {
+			return fmt.Errorf("Failed to create geometry handler, %w", err)
+		}
+
+		mux := http.NewServeMux()
+		mux.Handle("/", api.WithMetrics(api.METRICS_POINT_IN_POLYGON, pip_handler, metrics))
+		mux.Handle("/batch", api.WithMetrics(api.METRICS_BATCH, batch_handler, metrics))
+		mux.Handle("/stream", api.WithMetrics(api.METRICS_STREAM, stream_handler, metrics))
+		mux.Handle("/hierarchy", api.WithMetrics(api.METRICS_HIERARCHY, hierarchy_handler, metrics))
+		mux.Handle("/intersects", api.WithMetrics(api.METRICS_INTERSECTS, intersects_handler, metrics))
+		mux.Handle("/geometry", api.WithMetrics(api.METRICS_GEOMETRY, geom_handler, metrics))
+
+		health_opts := &api.HealthHandlerOptions{
+			MinimumRecords:     health_minimum_records,
+			ExpectedRecords:    expected_records,
+			ServeWhileIndexing: serve_while_indexing,
+			IndexingStarted:    indexing_started,
+			Indexed:            indexing_done,
+			Logger:             logger,
+		}
+
+		live_handler, err := api.LiveHandler(app, health_opts)
+
+		if err != nil {
+			return fmt.Errorf("Failed to create liveness handler, %w", err)
+		}
+
+		ready_handler, err := api.ReadyHandler(app, health_opts)
+
+		if err != nil {
+			return fmt.Errorf("Failed to create readiness handler, %w", err)
+		}
+
+		mux.Handle("/health/live", live_handler)
+		mux.Handle("/health/ready", ready_handler)
+
+		if enable_admin {
+
+			features_opts := &api.FeaturesHandlerOptions{
+				Token:  admin_token,
+				Logger: logger,
+			}
+
+			features_handler, err := api.FeaturesHandler(app, features_opts)
+
+			if err != nil {
+				return fmt.Errorf("Failed to create features handler, %w", err)
+			}
+
+			features_handler = api.WithMetrics(api.METRICS_FEATURES, features_handler, metrics)
+
+			mux.Handle("/features", features_handler)
+			mux.Handle("/features/{id}", features_handler)
+		}
+
+		if enable_metrics {
+
+			metrics_opts := &api.MetricsHandlerOptions{
+				Metrics: metrics,
+			}
+
+			metrics_handler, err := api.MetricsHandler(app, metrics_opts)
+
+			if err != nil {
+				return fmt.Errorf("Failed to create metrics handler, %w", err)
+			}
+
+			mux.Handle("/metrics", metrics_handler)
+		}
+
+		s, err := server.NewServer(ctx, server_uri)
+
+		if err != nil {
+			return fmt.Errorf("Failed to create new server for '%s', %w", server_uri, err)
+		}
+
+		logger.Printf("Listening on %s", s.Address())
+
+		err = s.ListenAndServe(ctx, mux)
+
+		if err != nil {
+			return fmt.Errorf("Failed to start server, %w", err)
+		}
+
+		return nil
+
+	default:
+		return fmt.Errorf("Invalid or unsupported mode '%s'", mode)
+	}
+}
+
+// cliRequests returns the point-in-polygon requests to perform in "cli" mode. If 'coords' is empty a single request
+// is derived from the -latitude and -longitude flags, which must both be set. Otherwise a request is returned for each
+// "latitude,longitude" string in 'coords', in which case the -latitude and -longitude flags must not be set. In both
+// cases the remaining criteria are derived from 'fs'.
+func cliRequests(fs *flag.FlagSet, coords []string) ([]*pip.PointInPolygonRequest, error) {
+
+	if len(coords) == 0 {
+
+		req, err := pip.NewPointInPolygonRequestFromFlagSet(fs)
+
+		if err != nil {
+			return nil, fmt.Errorf("%w. Coordinates must be specified using the -latitude and -longitude flags, as latitude,longitude arguments or read from STDIN using the '%s' argument", err, STDIN)
+		}
+
+		return []*pip.PointInPolygonRequest{req}, nil
+	}
+
+	if pip.IsFlagSet(fs, flags.LATITUDE) || pip.IsFlagSet(fs, flags.LONGITUDE) {
+		return nil, fmt.Errorf("Coordinates can not be specified using both the -latitude and -longitude flags and latitude,longitude arguments")
+	}
+
+	reqs := make([]*pip.PointInPolygonRequest, len(coords))
+
+	for idx, str_coord := range coords {
+
+		c, err := parseCoordinate(str_coord)
+
+		if err != nil {
+			return nil, err
+		}
+
+		req, err := pip.NewPointInPolygonRequestFromFlagSetWithCoordinate(fs, c.Latitude, c.Longitude)
+
+		if err != nil {
+			return nil, err
+		}
+
+		reqs[idx] = req
+	}
+
+	return reqs, nil
+}
+
+// newSpatialApplication returns a new spatial_app.SpatialApplication instance derived from 'fs' whose spatial database
+// is wrapped by a pip.SpatialDatabase instance so that, if the -index-geometries flag is set, queries other than
+// point-in-polygon can be performed, the spatial database can be rebuilt and, if the -cache-size flag is set, point-in-polygon results can be cached.
+func newSpatialApplication(ctx context.Context, fs *flag.FlagSet) (*spatial_app.SpatialApplication, error) {
+
+	app, err := spatial_app.NewSpatialApplicationWithFlagSet(ctx, fs)
+
+	if err != nil {
+		return nil, err
+	}
+
+	index_alt_files, err := indexAltFiles(fs)
+
+	if err != nil {
+		return nil, err
+	}
+
+	spatial_db_opts := &pip.SpatialDatabaseOptions{
+		IndexGeometries: index_geometries,
+		IndexAltFiles:   index_alt_files,
+	}
+
+	spatial_db, err := pip.NewSpatialDatabase(ctx, app.SpatialDatabase, spatial_db_opts)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create spatial database, %w", err)
+	}
+
+	// The application's iterator indexes records in the database it was created
+	// with so it needs to be replaced by one that indexes the wrapped database.
+
+	iter, err := spatial_app.NewIteratorWithFlagSet(ctx, fs, spatial_db)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create iterator, %w", err)
+	}
+
+	if cache_size > 0 {
+
+		cache_opts := &pip.QueryCacheOptions{
+			Size:      cache_size,
+			TTL:       time.Duration(cache_ttl) * time.Second,
+			Precision: cache_precision,
+		}
+
+		cache, err := pip.NewQueryCache(cache_opts)
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to create query cache, %w", err)
+		}
+
+		spatial_db.SetQueryCache(cache)
+	}
+
+	spatial_db.SetRelease(data_release)
+
+	// If records are read from the spatial database they need to be read from the
+	// wrapped database so that they are read from the new database after a rebuild.
+
+	if app.PropertiesReader == reader.Reader(app.SpatialDatabase) {
+		app.PropertiesReader = spatial_db
+	}
+
+	app.SpatialDatabase = spatial_db
+	app.Iterator = iter
+
+	return app, nil
+}
+
+// indexPaths indexes 'uris' in the background, if present. If the application's spatial database implements the
+// pip.BulkIndex interface the records are indexed in bulk. If 'indexed' is not nil it is set to true once the records
+// have been indexed, or immediately if there are none.
+func indexPaths(ctx context.Context, app *spatial_app.SpatialApplication, indexed *atomic.Bool, uris ...string) error {
+
+	if len(uris) == 0 {
+
+		if indexed != nil {
+			indexed.Store(true)
+		}
+
+		return nil
+	}
+
+	bulk_idx, is_bulk := app.SpatialDatabase.(pip.BulkIndex)
+
+	if is_bulk {
+		bulk_idx.StartBulkIndexing()
+	}
+
+	done_ch := make(chan bool)
+
+	go func() {
+
+		defer close(done_ch)
+
+		t1 := time.Now()
+
+		err := app.Iterator.IterateURIs(ctx, uris...)
+
+		if is_bulk {
+			bulk_idx.StopBulkIndexing()
+		}
+
+		if err != nil {
+			app.Logger.Fatalf("Failed to index paths, %v", err)
+		}
+
+		if indexed != nil {
+			indexed.Store(true)
+		}
+
+		app.Logger.Printf("Finished indexing in %v", time.Since(t1))
+		debug.FreeOSMemory()
+	}()
+
+	go func() {
+
+		ticker := time.NewTicker(1 * time.Second)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-done_ch:
+				return
+			case <-ticker.C:
+
+				if app.Iterator.IsIndexing() {
+					app.Logger.Printf("Indexing, %d records indexed", atomic.LoadInt64(&app.Iterator.Seen))
+				}
+			}
+		}
+	}()
+
+	return nil
+}
+
+// query performs a point in polygon query for 'req' returning either the SPR results or, if
+// 'req' defines one or more properties, a properties response. If 'req' asks for timings they
+// are included in the response.
+func query(ctx context.Context, app *spatial_app.SpatialApplication, req *pip.PointInPolygonRequest) (interface{}, error) {
+
+	err := req.Validate()
+
+	if err != nil {
+		return nil, err
+	}
+
+	req_app, req_monitor := pip.NewRequestApplication(app)
+
+	rsp, err := pip.QueryPointInPolygon(ctx, req_app, req)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if req.Timings {
+		rsp = pip.AppendTimingsToResults(rsp, req_monitor.Timings())
+	}
+
+	if len(req.Properties) == 0 {
+		return rsp, nil
+	}
+
+	props_rsp, err := pip.PropertiesResponseResultsWithStandardPlacesResults(ctx, app.PropertiesReader, req.Properties, rsp)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to derive properties response, %w", err)
+	}
+
+	return props_rsp, nil
+}
+
+// indexAltFiles returns true if the -spatial-database-uri flag in 'fs' enables indexing alternate geometry files, using
+// the "index_alt_files" query parameter supported by the rtree:// database.
+func indexAltFiles(fs *flag.FlagSet) (bool, error) {
+
+	spatial_database_uri, err := lookup.StringVar(fs, flags.SPATIAL_DATABASE_URI)
+
+	if err != nil {
+		return false, fmt.Errorf("Failed to derive spatial database URI, %w", err)
+	}
+
+	u, err := url.Parse(spatial_database_uri)
+
+	if err != nil {
+		return false, fmt.Errorf("Failed to parse spatial database URI, %w", err)
+	}
+
+	str_index_alt := u.Query().Get("index_alt_files")
+
+	if str_index_alt == "" {
+		return false, nil
+	}
+
+	index_alt, err := strconv.ParseBool(str_index_alt)
+
+	if err != nil {
+		return false, fmt.Errorf("Invalid index_alt_files parameter, %w", err)
+	}
+
+	return index_alt, nil
+}
@@ -0,0 +1,161 @@
+package query
+
+import (
+	_ "github.com/whosonfirst/go-whosonfirst-spatial-rtree"
+)
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"os"
+	"slices"
+	"testing"
+)
+
+const fixturesPath string = "../../fixtures/data"
+
+// runCLI runs the query application in "cli" mode with 'args' and returns the SPR results written to STDOUT.
+func runCLI(t *testing.T, args ...string) ([][]int64, error) {
+
+	t.Helper()
+
+	ctx := context.Background()
+
+	fs, err := DefaultFlagSet(ctx)
+
+	if err != nil {
+		t.Fatalf("Failed to create flag set, %v", err)
+	}
+
+	common := []string{
+		"query",
+		"-mode", "cli",
+		"-spatial-database-uri", "rtree://",
+		"-iterator-uri", "directory://",
+	}
+
+	args_orig := os.Args
+	stdout_orig := os.Stdout
+
+	r, wr, err := os.Pipe()
+
+	if err != nil {
+		t.Fatalf("Failed to create pipe, %v", err)
+	}
+
+	os.Args = append(common, args...)
+	os.Stdout = wr
+
+	defer func() {
+		os.Args = args_orig
+		os.Stdout = stdout_orig
+	}()
+
+	out_ch := make(chan []byte)
+
+	go func() {
+		body, _ := io.ReadAll(r)
+		out_ch <- body
+	}()
+
+	logger := log.New(io.Discard, "", 0)
+
+	run_err := RunWithFlagSet(ctx, fs, logger)
+
+	wr.Close()
+	out := <-out_ch
+
+	if run_err != nil {
+		return nil, run_err
+	}
+
+	ids := make([][]int64, 0)
+
+	dec := json.NewDecoder(bytes.NewReader(out))
+
+	for dec.More() {
+
+		var rsp struct {
+			Places []struct {
+				Id int64 `json:"wof:id"`
+			} `json:"places"`
+		}
+
+		err := dec.Decode(&rsp)
+
+		if err != nil {
+			t.Fatalf("Failed to decode output, %v", err)
+		}
+
+		results := make([]int64, len(rsp.Places))
+
+		for idx, p := range rsp.Places {
+			results[idx] = p.Id
+		}
+
+		slices.Sort(results)
+		ids = append(ids, results)
+	}
+
+	return ids, nil
+}
+
+func TestRunWithFlagSetCLI(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		args     []string
+		expected [][]int64
+	}{
+		{
+			name:     "flags",
+			args:     []string{"-latitude", "0.75", "-longitude", "0.75", "-is-current", "1", fixturesPath},
+			expected: [][]int64{{85000001, 85000002, 101000001}},
+		},
+		{
+			name:     "placetype",
+			args:     []string{"-latitude", "0.25", "-longitude", "0.25", "-placetype", "neighbourhood", fixturesPath},
+			expected: [][]int64{{102000001}},
+		},
+		{
+			name:     "arguments",
+			args:     []string{"-is-current", "1", "0.25,0.25", "5.5,5.5", "-1.5,-1.5", fixturesPath},
+			expected: [][]int64{{85000001, 85000002, 101000001, 102000001}, {101000003}, {85000001}},
+		},
+	}
+
+	for _, tt := range tests {
+
+		t.Run(tt.name, func(t *testing.T) {
+
+			ids, err := runCLI(t, tt.args...)
+
+			if err != nil {
+				t.Fatalf("Failed to run application, %v", err)
+			}
+
+			if len(ids) != len(tt.expected) {
+				t.Fatalf("Expected %d responses, got %d", len(tt.expected), len(ids))
+			}
+
+			for idx, expected := range tt.expected {
+
+				if !slices.Equal(ids[idx], expected) {
+					t.Fatalf("Expected %v for response %d, got %v", expected, idx, ids[idx])
+				}
+			}
+		})
+	}
+}
+
+func TestRunWithFlagSetInvalidMode(t *testing.T) {
+
+	_, err := runCLI(t, "-mode", "invalid", fixturesPath)
+
+	if err == nil {
+		t.Fatalf("Expected an error for an invalid mode")
+	}
+}
@@ -0,0 +1,379 @@
+package query
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/sfomuseum/go-flags/lookup"
+	"github.com/whosonfirst/go-whosonfirst-feature/alt"
+	"github.com/whosonfirst/go-whosonfirst-feature/geometry"
+	"github.com/whosonfirst/go-whosonfirst-feature/properties"
+	"github.com/whosonfirst/go-whosonfirst-iterate/v2/iterator"
+	"github.com/whosonfirst/go-whosonfirst-spatial-pip"
+	spatial_app "github.com/whosonfirst/go-whosonfirst-spatial/app"
+	"github.com/whosonfirst/go-whosonfirst-spatial/database"
+	"github.com/whosonfirst/go-whosonfirst-spatial/flags"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// indexedFile is a file which has been indexed.
+type indexedFile struct {
+	path   string
+	mtime  time.Time
+	id     int64
+	is_alt bool
+	// Whether the file's record was skipped, rather than indexed, because it does not have a geometry which can be
+	// indexed.
+	skipped bool
+}
+
+// indexedFiles records the modification times and IDs of the files that have been indexed, and which of them have been
+// seen during a re-crawl.
+type indexedFiles struct {
+	files map[string]*indexedFile
+	seen  map[string]bool
+	mu    *sync.Mutex
+}
+
+// newIndexedFiles returns a new, empty indexedFiles instance.
+func newIndexedFiles() *indexedFiles {
+
+	f := &indexedFiles{
+		files: make(map[string]*indexedFile),
+		seen:  make(map[string]bool),
+		mu:    new(sync.Mutex),
+	}
+
+	return f
+}
+
+// Changed returns true if 't' is not the modification time recorded for 'path'.
+func (f *indexedFiles) Changed(path string, t time.Time) bool {
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	last, ok := f.files[path]
+	return !ok || !last.mtime.Equal(t)
+}
+
+// Set records 't' as the modification time for 'path' whose record has the ID 'id'. If 'skipped' is true the record
+// was not indexed so it is not removed if 'path' is deleted.
+func (f *indexedFiles) Set(path string, t time.Time, id int64, is_alt bool, skipped bool) {
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.files[path] = &indexedFile{
+		path:    path,
+		mtime:   t,
+		id:      id,
+		is_alt:  is_alt,
+		skipped: skipped,
+	}
+}
+
+// StartCrawl forgets which files have been seen.
+func (f *indexedFiles) StartCrawl() {
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.seen = make(map[string]bool)
+}
+
+// Seen records that 'path' has been seen since StartCrawl was called.
+func (f *indexedFiles) Seen(path string) {
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.seen[path] = true
+}
+
+// Deleted returns, and forgets, the files that have not been seen since StartCrawl was called. Files for principal
+// geometries are not returned if another file, which has been seen, has the same ID since the record has been moved
+// rather than deleted. Files whose records were skipped are forgotten but not returned.
+func (f *indexedFiles) Deleted() []*indexedFile {
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	seen_ids := make(map[int64]bool)
+
+	for path, _ := range f.seen {
+
+		file, ok := f.files[path]
+
+		if ok && !file.is_alt && !file.skipped {
+			seen_ids[file.id] = true
+		}
+	}
+
+	deleted := make([]*indexedFile, 0)
+
+	for path, file := range f.files {
+
+		if f.seen[path] {
+			continue
+		}
+
+		delete(f.files, path)
+
+		if file.skipped {
+			continue
+		}
+
+		if !file.is_alt && seen_ids[file.id] {
+			continue
+		}
+
+		deleted = append(deleted, file)
+	}
+
+	return deleted
+}
+
+// reindexer rebuilds the spatial database of a server-mode application when it receives a SIGHUP signal and, if an
+// interval is set, periodically re-indexes the files whose modification times have changed and removes the records
+// whose files have been deleted.
+type reindexer struct {
+	app      *spatial_app.SpatialApplication
+	fs       *flag.FlagSet
+	uris     []string
+	interval time.Duration
+	files    *indexedFiles
+	logger   *log.Logger
+}
+
+// Run waits for SIGHUP signals and, if an interval is set, ticks until 'ctx' is cancelled. Rebuilds and re-crawls are
+// performed one at a time and are skipped while the initial indexing is still in progress.
+func (r *reindexer) Run(ctx context.Context) {
+
+	signal_ch := make(chan os.Signal, 1)
+	signal.Notify(signal_ch, syscall.SIGHUP)
+
+	defer signal.Stop(signal_ch)
+
+	var tick_ch <-chan time.Time
+
+	if r.interval > 0 {
+		ticker := time.NewTicker(r.interval)
+		defer ticker.Stop()
+		tick_ch = ticker.C
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-signal_ch:
+
+			if r.app.Iterator.IsIndexing() {
+				r.logger.Printf("Received SIGHUP while indexing, skipping rebuild")
+				continue
+			}
+
+			err := r.Rebuild(ctx)
+
+			if err != nil {
+				r.logger.Printf("Failed to rebuild spatial database, %v", err)
+			}
+
+		case <-tick_ch:
+
+			if r.app.Iterator.IsIndexing() {
+				continue
+			}
+
+			err := r.Update(ctx)
+
+			if err != nil {
+				r.logger.Printf("Failed to re-index changed records, %v", err)
+			}
+		}
+	}
+}
+
+// Rebuild indexes all the records in a new spatial database which replaces the application's spatial database once
+// it is complete. Queries continue to use the existing spatial database until then. Records indexed or removed using
+// the admin API are not carried over, so removed records whose files are still present are indexed again.
+func (r *reindexer) Rebuild(ctx context.Context) error {
+
+	spatial_db, ok := r.app.SpatialDatabase.(*pip.SpatialDatabase)
+
+	if !ok {
+		return fmt.Errorf("Spatial database does not support rebuilding")
+	}
+
+	fresh, err := spatial_app.NewSpatialDatabaseWithFlagSet(ctx, r.fs)
+
+	if err != nil {
+		return fmt.Errorf("Failed to create spatial database, %w", err)
+	}
+
+	files := newIndexedFiles()
+	var indexed int64
+
+	index_func := func(ctx context.Context, staging *pip.SpatialDatabase) error {
+
+		iter, err := newIndexingIterator(ctx, r.fs, staging, files, false, &indexed)
+
+		if err != nil {
+			return fmt.Errorf("Failed to create iterator, %w", err)
+		}
+
+		return iter.IterateURIs(ctx, r.uris...)
+	}
+
+	r.logger.Printf("Rebuilding spatial database")
+
+	t1 := time.Now()
+
+	err = spatial_db.Rebuild(ctx, fresh, index_func)
+
+	if err != nil {
+		return err
+	}
+
+	r.files = files
+
+	r.logger.Printf("Rebuilt spatial database with %d records in %v", atomic.LoadInt64(&indexed), time.Since(t1))
+	return nil
+}
+
+// Update re-indexes the records whose files have been modified since they were last indexed and removes the records
+// whose files have been deleted. Records are only removed if the URIs are re-crawled without any errors. Deleted
+// alternate geometry files are not removed, since the spatial database can only remove entire records, until the
+// spatial database is rebuilt.
+func (r *reindexer) Update(ctx context.Context) error {
+
+	var indexed int64
+
+	iter, err := newIndexingIterator(ctx, r.fs, r.app.SpatialDatabase, r.files, true, &indexed)
+
+	if err != nil {
+		return fmt.Errorf("Failed to create iterator, %w", err)
+	}
+
+	t1 := time.Now()
+
+	r.files.StartCrawl()
+
+	err = iter.IterateURIs(ctx, r.uris...)
+
+	if err != nil {
+		return err
+	}
+
+	removed := 0
+
+	for _, f := range r.files.Deleted() {
+
+		if f.is_alt {
+			r.logger.Printf("Alternate geometry file %s has been deleted but will not be removed until the spatial database is rebuilt", f.path)
+			continue
+		}
+
+		err := r.app.SpatialDatabase.RemoveFeature(ctx, strconv.FormatInt(f.id, 10))
+
+		if err != nil {
+			return fmt.Errorf("Failed to remove record %d for deleted file %s, %w", f.id, f.path, err)
+		}
+
+		removed += 1
+	}
+
+	count := atomic.LoadInt64(&indexed)
+
+	if count > 0 || removed > 0 {
+		r.logger.Printf("Re-indexed %d changed records and removed %d deleted records in %v", count, removed, time.Since(t1))
+	}
+
+	return nil
+}
+
+// newIndexingIterator returns a new iterator, derived from the -iterator-uri flag in 'fs', that indexes records in
+// 'spatial_db' and records the modification times and IDs of their files in 'files'. If 'changed_only' is true every
+// file is recorded as seen in 'files', records are only indexed if their files have been modified since they were last
+// indexed, replacing the existing records, and records which are not files are skipped. The number of records indexed
+// is added to 'indexed'.
+func newIndexingIterator(ctx context.Context, fs *flag.FlagSet, spatial_db database.SpatialDatabase, files *indexedFiles, changed_only bool, indexed *int64) (*iterator.Iterator, error) {
+
+	iterator_uri, err := lookup.StringVar(fs, flags.ITERATOR_URI)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to derive iterator URI, %w", err)
+	}
+
+	index_cb := func(ctx context.Context, path string, r io.ReadSeeker, args ...interface{}) error {
+
+		info, stat_err := os.Stat(path)
+
+		if changed_only {
+
+			if stat_err != nil {
+				return nil
+			}
+
+			files.Seen(path)
+
+			if !files.Changed(path, info.ModTime()) {
+				return nil
+			}
+		}
+
+		body, err := io.ReadAll(r)
+
+		if err != nil {
+			return fmt.Errorf("Failed to read '%s', %w", path, err)
+		}
+
+		geom_type, err := geometry.Type(body)
+
+		if err != nil {
+			return fmt.Errorf("Failed to derive geometry type for %s, %w", path, err)
+		}
+
+		id, err := properties.Id(body)
+
+		if err != nil {
+			return fmt.Errorf("Failed to derive ID for %s, %w", path, err)
+		}
+
+		// Records with point geometries are not indexed but their files are still recorded
+		// so that they are not read again during a re-crawl unless they change.
+
+		if geom_type == "Point" {
+
+			if stat_err == nil {
+				files.Set(path, info.ModTime(), id, alt.IsAlt(body), true)
+			}
+
+			return nil
+		}
+
+		err = spatial_db.IndexFeature(ctx, body)
+
+		if err != nil {
+			return fmt.Errorf("Failed to index %s, %w", path, err)
+		}
+
+		if stat_err == nil {
+			files.Set(path, info.ModTime(), id, alt.IsAlt(body), false)
+		}
+
+		atomic.AddInt64(indexed, 1)
+		return nil
+	}
+
+	return iterator.NewIterator(ctx, iterator_uri, index_cb)
+}
@@ -0,0 +1,313 @@
+package query
+
+import (
+	"context"
+	"github.com/whosonfirst/go-whosonfirst-spatial-pip"
+	spatial_app "github.com/whosonfirst/go-whosonfirst-spatial/app"
+	"github.com/whosonfirst/go-whosonfirst-spatial/database"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestIndexedFiles(t *testing.T) {
+
+	t1 := time.Unix(1, 0)
+	t2 := time.Unix(2, 0)
+
+	files := newIndexedFiles()
+
+	if !files.Changed("a.geojson", t1) {
+		t.Fatalf("Expected a file which has not been indexed to have changed")
+	}
+
+	files.Set("a.geojson", t1, 1, false, false)
+	files.Set("a-alt-example.geojson", t1, 1, true, false)
+	files.Set("b.geojson", t1, 2, false, false)
+	files.Set("c.geojson", t1, 3, false, false)
+	files.Set("d.geojson", t1, 2, false, true)
+	files.Set("e.geojson", t1, 5, false, true)
+
+	if files.Changed("a.geojson", t1) {
+		t.Fatalf("Expected a.geojson not to have changed")
+	}
+
+	if !files.Changed("a.geojson", t2) {
+		t.Fatalf("Expected a.geojson to have changed")
+	}
+
+	if files.Changed("d.geojson", t1) {
+		t.Fatalf("Expected skipped d.geojson not to have changed")
+	}
+
+	// c.geojson has been moved to moved/c.geojson and the alternate geometry and b.geojson have been deleted. The
+	// skipped d.geojson, whose ID is the same as b.geojson, does not mean that b.geojson has been moved and the
+	// skipped e.geojson has been deleted but there is no record to remove.
+
+	files.StartCrawl()
+	files.Seen("a.geojson")
+	files.Seen("c.geojson")
+
+	files.StartCrawl()
+	files.Seen("a.geojson")
+	files.Seen("d.geojson")
+	files.Set("moved/c.geojson", t2, 3, false, false)
+	files.Seen("moved/c.geojson")
+
+	deleted := make([]string, 0)
+
+	for _, f := range files.Deleted() {
+		deleted = append(deleted, f.path)
+	}
+
+	slices.Sort(deleted)
+
+	expected := []string{"a-alt-example.geojson", "b.geojson"}
+
+	if !reflect.DeepEqual(deleted, expected) {
+		t.Fatalf("Expected %v to be deleted, got %v", expected, deleted)
+	}
+
+	// Deleted files are forgotten
+
+	if len(files.Deleted()) != 0 {
+		t.Fatalf("Expected deleted files to be forgotten")
+	}
+
+	if !files.Changed("c.geojson", t1) || !files.Changed("e.geojson", t1) || files.Changed("moved/c.geojson", t2) {
+		t.Fatalf("Expected only the moved file to be recorded")
+	}
+}
+
+// copyFixtures copies the records in fixturesPath, other than alternate geometry files, to a new temporary directory
+// which is returned.
+func copyFixtures(t *testing.T) string {
+
+	t.Helper()
+
+	root := t.TempDir()
+
+	walk_func := func(path string, d fs.DirEntry, err error) error {
+
+		if err != nil {
+			return err
+		}
+
+		if d.IsDir() || strings.Contains(path, "-alt-") {
+			return nil
+		}
+
+		rel_path, err := filepath.Rel(fixturesPath, path)
+
+		if err != nil {
+			return err
+		}
+
+		body, err := os.ReadFile(path)
+
+		if err != nil {
+			return err
+		}
+
+		copy_path := filepath.Join(root, rel_path)
+
+		err = os.MkdirAll(filepath.Dir(copy_path), 0755)
+
+		if err != nil {
+			return err
+		}
+
+		return os.WriteFile(copy_path, body, 0644)
+	}
+
+	err := filepath.WalkDir(fixturesPath, walk_func)
+
+	if err != nil {
+		t.Fatalf("Failed to copy fixtures, %v", err)
+	}
+
+	return root
+}
+
+func TestReindexer(t *testing.T) {
+
+	ctx := context.Background()
+
+	root := copyFixtures(t)
+
+	fs_flags, err := DefaultFlagSet(ctx)
+
+	if err != nil {
+		t.Fatalf("Failed to create flag set, %v", err)
+	}
+
+	err = fs_flags.Parse([]string{"-spatial-database-uri", "rtree://", "-iterator-uri", "directory://"})
+
+	if err != nil {
+		t.Fatalf("Failed to parse flags, %v", err)
+	}
+
+	spatial_db, err := database.NewSpatialDatabase(ctx, "rtree://")
+
+	if err != nil {
+		t.Fatalf("Failed to create spatial database, %v", err)
+	}
+
+	db, err := pip.NewSpatialDatabase(ctx, spatial_db, nil)
+
+	if err != nil {
+		t.Fatalf("Failed to create spatial database, %v", err)
+	}
+
+	app := &spatial_app.SpatialApplication{
+		SpatialDatabase:  db,
+		PropertiesReader: db,
+		Logger:           log.New(io.Discard, "", 0),
+		Monitor:          pip.NewRequestMonitor(),
+	}
+
+	r := &reindexer{
+		app:    app,
+		fs:     fs_flags,
+		uris:   []string{root},
+		files:  newIndexedFiles(),
+		logger: log.New(io.Discard, "", 0),
+	}
+
+	// A record with a point geometry is not indexed but its file is still recorded
+
+	point_path := filepath.Join(root, "point.geojson")
+	point_body := `{"type":"Feature","properties":{"wof:id":103000001,"wof:name":"Point","wof:placetype":"venue","wof:parent_id":-1,"wof:repo":"whosonfirst-data-test","wof:lastmodified":1},"geometry":{"type":"Point","coordinates":[0.75,0.75]}}`
+
+	err = os.WriteFile(point_path, []byte(point_body), 0644)
+
+	if err != nil {
+		t.Fatalf("Failed to write point record, %v", err)
+	}
+
+	// The changes made to the copied records before they are re-crawled
+
+	island_path := filepath.Join(root, "101/000/003/101000003.geojson")
+	moved_path := filepath.Join(root, "moved/101000003.geojson")
+
+	changes := map[string]func() error{
+		"modify": func() error {
+
+			body, err := os.ReadFile(island_path)
+
+			if err != nil {
+				return err
+			}
+
+			body = []byte(strings.Replace(string(body), `"Island"`, `"Atoll"`, 1))
+
+			err = os.WriteFile(island_path, body, 0644)
+
+			if err != nil {
+				return err
+			}
+
+			mtime := time.Now().Add(time.Hour)
+			return os.Chtimes(island_path, mtime, mtime)
+		},
+		"delete": func() error {
+			return os.Remove(filepath.Join(root, "101/000/001/101000001.geojson"))
+		},
+		"move": func() error {
+
+			err := os.MkdirAll(filepath.Dir(moved_path), 0755)
+
+			if err != nil {
+				return err
+			}
+
+			return os.Rename(island_path, moved_path)
+		},
+	}
+
+	tests := []struct {
+		name    string
+		change  string
+		rebuild bool
+		// The names of the places containing 0.75,0.75 and 5.5,5.5
+		expected []string
+		// Whether the spatial database should have changed
+		modified bool
+	}{
+		{name: "rebuild", rebuild: true, expected: []string{"Bigbox", "Box", "Midbox", "Oldbox", "Island"}, modified: true},
+		{name: "unchanged", expected: []string{"Bigbox", "Box", "Midbox", "Oldbox", "Island"}},
+		{name: "modify", change: "modify", expected: []string{"Bigbox", "Box", "Midbox", "Oldbox", "Atoll"}, modified: true},
+		{name: "delete", change: "delete", expected: []string{"Bigbox", "Midbox", "Oldbox", "Atoll"}, modified: true},
+		{name: "move", change: "move", expected: []string{"Bigbox", "Midbox", "Oldbox", "Atoll"}, modified: true},
+		{name: "rebuild again", rebuild: true, expected: []string{"Bigbox", "Midbox", "Oldbox", "Atoll"}, modified: true},
+	}
+
+	for _, tt := range tests {
+
+		generation := db.Generation()
+
+		if tt.change != "" {
+
+			err := changes[tt.change]()
+
+			if err != nil {
+				t.Fatalf("Failed to %s file, %v", tt.change, err)
+			}
+		}
+
+		if tt.rebuild {
+			err = r.Rebuild(ctx)
+		} else {
+			err = r.Update(ctx)
+		}
+
+		if err != nil {
+			t.Fatalf("Failed to re-index records for %s, %v", tt.name, err)
+		}
+
+		if (db.Generation() != generation) != tt.modified {
+			t.Fatalf("Expected database to have been modified to be %t for %s", tt.modified, tt.name)
+		}
+
+		names := make([]string, 0)
+
+		for _, coord := range []float64{0.75, 5.5} {
+
+			rsp, err := pip.QueryPointInPolygon(ctx, app, &pip.PointInPolygonRequest{Latitude: coord, Longitude: coord})
+
+			if err != nil {
+				t.Fatalf("Failed to query %f for %s, %v", coord, tt.name, err)
+			}
+
+			coord_names := make([]string, 0)
+
+			for _, p := range rsp.Results() {
+				coord_names = append(coord_names, p.Name())
+			}
+
+			slices.Sort(coord_names)
+			names = append(names, coord_names...)
+		}
+
+		if !reflect.DeepEqual(names, tt.expected) {
+			t.Fatalf("Expected %v for %s, got %v", tt.expected, tt.name, names)
+		}
+
+		point_info, err := os.Stat(point_path)
+
+		if err != nil {
+			t.Fatalf("Failed to stat point record, %v", err)
+		}
+
+		if r.files.Changed(point_path, point_info.ModTime()) {
+			t.Fatalf("Expected point record to have been recorded for %s", tt.name)
+		}
+	}
+}
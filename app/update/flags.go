@@ -0,0 +1,73 @@
+package update
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/sfomuseum/go-flags/flagset"
+	"github.com/sfomuseum/go-flags/multi"
+	"github.com/whosonfirst/go-whosonfirst-iterate/v2/emitter"
+	"github.com/whosonfirst/go-whosonfirst-spatial/flags"
+	"github.com/whosonfirst/go-writer/v3"
+	"os"
+	"sort"
+	"strings"
+)
+
+var iterator_uri string
+
+var spatial_iterator_uri string
+
+var spatial_sources multi.MultiString
+
+var writer_uri string
+
+var is_current multi.MultiInt64
+
+var is_ceased multi.MultiInt64
+
+var is_deprecated multi.MultiInt64
+
+var is_superseded multi.MultiInt64
+
+var is_superseding multi.MultiInt64
+
+func DefaultFlagSet(ctx context.Context) (*flag.FlagSet, error) {
+
+	fs := flagset.NewFlagSet("update")
+
+	err := flags.AppendCommonFlags(fs)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to append common flags, %w", err)
+	}
+
+	emitter_schemes := emitter.Schemes()
+	sort.Strings(emitter_schemes)
+
+	desc_emitters := fmt.Sprintf("Supported schemes are: %s.", strings.Join(emitter_schemes, ", "))
+
+	fs.StringVar(&iterator_uri, "iterator-uri", "repo://", fmt.Sprintf("A valid whosonfirst/go-whosonfirst-iterate/v2 URI. This is used to identify WOF records to be PIP-ed. %s", desc_emitters))
+	fs.StringVar(&spatial_iterator_uri, "spatial-iterator-uri", "repo://", fmt.Sprintf("A valid whosonfirst/go-whosonfirst-iterate/v2 URI. This is used to identify WOF records to be indexed in the spatial database. %s", desc_emitters))
+	fs.Var(&spatial_sources, "spatial-source", "One or more URIs to be indexed in the spatial database (used for PIP-ing).")
+
+	writer_schemes := writer.Schemes()
+	sort.Strings(writer_schemes)
+
+	fs.StringVar(&writer_uri, "writer-uri", "null://", fmt.Sprintf("A valid whosonfirst/go-writer URI. This is where updated records will be written to. Supported schemes are: %s.", strings.Join(writer_schemes, ", ")))
+
+	fs.Var(&is_current, "is-current", "One or more existential flags (-1, 0, 1) to filter PIP results.")
+	fs.Var(&is_ceased, "is-ceased", "One or more existential flags (-1, 0, 1) to filter PIP results.")
+	fs.Var(&is_deprecated, "is-deprecated", "One or more existential flags (-1, 0, 1) to filter PIP results.")
+	fs.Var(&is_superseded, "is-superseded", "One or more existential flags (-1, 0, 1) to filter PIP results.")
+	fs.Var(&is_superseding, "is-superseding", "One or more existential flags (-1, 0, 1) to filter PIP results.")
+
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Perform point-in-polygon (PIP), and related update, operations on a set of Who's on First records.\n")
+		fmt.Fprintf(os.Stderr, "Usage:\n\t %s [options] uri(N) uri(N)\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Valid options are:\n\n")
+		fs.PrintDefaults()
+	}
+
+	return fs, nil
+}
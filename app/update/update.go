@@ -0,0 +1,247 @@
+package update
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"github.com/sfomuseum/go-flags/flagset"
+	"github.com/sfomuseum/go-timings"
+	"github.com/whosonfirst/go-whosonfirst-feature/geometry"
+	"github.com/whosonfirst/go-whosonfirst-feature/properties"
+	"github.com/whosonfirst/go-whosonfirst-iterate/v2/iterator"
+	"github.com/whosonfirst/go-whosonfirst-spatial-pip"
+	"github.com/whosonfirst/go-whosonfirst-spatial-pip/hierarchy"
+	spatial_app "github.com/whosonfirst/go-whosonfirst-spatial/app"
+	"github.com/whosonfirst/go-whosonfirst-spatial/flags"
+	"github.com/whosonfirst/go-whosonfirst-uri"
+	"github.com/whosonfirst/go-writer/v3"
+	"io"
+	"log"
+	"sync/atomic"
+	"time"
+)
+
+// Run invokes the update application using the default flag set.
+func Run(ctx context.Context, logger *log.Logger) error {
+
+	fs, err := DefaultFlagSet(ctx)
+
+	if err != nil {
+		return fmt.Errorf("Failed to create application flag set, %w", err)
+	}
+
+	return RunWithFlagSet(ctx, fs, logger)
+}
+
+// RunWithFlagSet invokes the update application using 'fs'. The spatial database is populated from any
+// -spatial-source URIs, after which each record emitted by the URIs passed as arguments has its parent ID,
+// hierarchies and "belongs to" properties assigned. Records whose properties have changed are written to
+// the -writer-uri writer.
+func RunWithFlagSet(ctx context.Context, fs *flag.FlagSet, logger *log.Logger) error {
+
+	flagset.Parse(fs)
+
+	err := flagset.SetFlagsFromEnvVars(fs, "PIP")
+
+	if err != nil {
+		return fmt.Errorf("Failed to set flags from environment variables, %w", err)
+	}
+
+	err = flags.ValidateCommonFlags(fs)
+
+	if err != nil {
+		return fmt.Errorf("Failed to validate common flags, %w", err)
+	}
+
+	uris := fs.Args()
+
+	if len(uris) == 0 {
+		return fmt.Errorf("Nothing to update")
+	}
+
+	app, err := newSpatialApplication(ctx, fs, logger)
+
+	if err != nil {
+		return fmt.Errorf("Failed to create spatial application, %w", err)
+	}
+
+	if len(spatial_sources) > 0 {
+
+		t1 := time.Now()
+
+		err = app.Iterator.IterateURIs(ctx, spatial_sources...)
+
+		if err != nil {
+			return fmt.Errorf("Failed to index spatial sources, %w", err)
+		}
+
+		logger.Printf("Indexed %d spatial records in %v", app.Iterator.Seen, time.Since(t1))
+	}
+
+	wr, err := writer.NewWriter(ctx, writer_uri)
+
+	if err != nil {
+		return fmt.Errorf("Failed to create writer for '%s', %w", writer_uri, err)
+	}
+
+	filter_req := &pip.PointInPolygonRequest{
+		IsCurrent:     is_current,
+		IsCeased:      is_ceased,
+		IsDeprecated:  is_deprecated,
+		IsSuperseded:  is_superseded,
+		IsSuperseding: is_superseding,
+	}
+
+	updated := int64(0)
+
+	iter_cb := func(ctx context.Context, path string, r io.ReadSeeker, args ...interface{}) error {
+
+		body, err := io.ReadAll(r)
+
+		if err != nil {
+			return fmt.Errorf("Failed to read %s, %w", path, err)
+		}
+
+		alt_label, _ := properties.AltLabel(body)
+
+		if alt_label != "" {
+			return nil
+		}
+
+		id, err := properties.Id(body)
+
+		if err != nil {
+			return fmt.Errorf("Failed to derive ID for %s, %w", path, err)
+		}
+
+		changed, new_body, err := hierarchy.UpdateFeature(ctx, app, body, filter_req)
+
+		if err != nil {
+			return fmt.Errorf("Failed to update %s, %w", path, err)
+		}
+
+		if !changed {
+			return nil
+		}
+
+		rel_path, err := uri.Id2RelPath(id)
+
+		if err != nil {
+			return fmt.Errorf("Failed to derive path for %d, %w", id, err)
+		}
+
+		_, err = wr.Write(ctx, rel_path, bytes.NewReader(new_body))
+
+		if err != nil {
+			return fmt.Errorf("Failed to write %s, %w", rel_path, err)
+		}
+
+		atomic.AddInt64(&updated, 1)
+		return nil
+	}
+
+	iter, err := iterator.NewIterator(ctx, iterator_uri, iter_cb)
+
+	if err != nil {
+		return fmt.Errorf("Failed to create iterator, %w", err)
+	}
+
+	err = iter.IterateURIs(ctx, uris...)
+
+	if err != nil {
+		return fmt.Errorf("Failed to iterate URIs, %w", err)
+	}
+
+	err = wr.Close(ctx)
+
+	if err != nil {
+		return fmt.Errorf("Failed to close writer, %w", err)
+	}
+
+	logger.Printf("Updated %d of %d records", atomic.LoadInt64(&updated), iter.Seen)
+	return nil
+}
+
+// newSpatialApplication returns a new spatial_app.SpatialApplication instance. This can't be done using
+// NewSpatialApplicationWithFlagSet because the -iterator-uri flag is used for the records being updated
+// rather than the records being indexed in the spatial database.
+func newSpatialApplication(ctx context.Context, fs *flag.FlagSet, logger *log.Logger) (*spatial_app.SpatialApplication, error) {
+
+	spatial_db, err := spatial_app.NewSpatialDatabaseWithFlagSet(ctx, fs)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed instantiate spatial database, %w", err)
+	}
+
+	properties_r, err := spatial_app.NewPropertiesReaderWithFlagsSet(ctx, fs)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create properties reader, %w", err)
+	}
+
+	if properties_r == nil {
+		properties_r = spatial_db
+	}
+
+	err = spatial_app.AppendCustomPlacetypesWithFlagSet(ctx, fs)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to append custom placetypes, %w", err)
+	}
+
+	index_cb := func(ctx context.Context, path string, r io.ReadSeeker, args ...interface{}) error {
+
+		body, err := io.ReadAll(r)
+
+		if err != nil {
+			return fmt.Errorf("Failed to read '%s', %w", path, err)
+		}
+
+		geom_type, err := geometry.Type(body)
+
+		if err != nil {
+			return fmt.Errorf("Failed to derive geometry type for %s, %w", path, err)
+		}
+
+		if geom_type == "Point" {
+			return nil
+		}
+
+		err = spatial_db.IndexFeature(ctx, body)
+
+		if err != nil {
+			return fmt.Errorf("Failed to index %s, %w", path, err)
+		}
+
+		return nil
+	}
+
+	iter, err := iterator.NewIterator(ctx, spatial_iterator_uri, index_cb)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create spatial iterator, %w", err)
+	}
+
+	m, err := timings.NewMonitor(ctx, "since://")
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create timings monitor, %w", err)
+	}
+
+	err = m.Start(ctx, io.Discard)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to start timings monitor, %w", err)
+	}
+
+	app := &spatial_app.SpatialApplication{
+		SpatialDatabase:  spatial_db,
+		PropertiesReader: properties_r,
+		Iterator:         iter,
+		Logger:           logger,
+		Monitor:          m,
+	}
+
+	return app, nil
+}
@@ -0,0 +1,115 @@
+package update
+
+import (
+	_ "github.com/whosonfirst/go-whosonfirst-spatial-rtree"
+)
+
+import (
+	"context"
+	"github.com/whosonfirst/go-whosonfirst-feature/properties"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const fixturesPath string = "../../fixtures/data"
+
+func TestRunWithFlagSet(t *testing.T) {
+
+	ctx := context.Background()
+
+	input := t.TempDir()
+	output := t.TempDir()
+
+	// The neighbourhood's hierarchy is already correct so only the venue should be written
+
+	venue := `{"type":"Feature","id":1,"properties":{"wof:id":1,"wof:name":"Venue","wof:placetype":"venue","wof:parent_id":-1,"wof:repo":"whosonfirst-data-test","wof:lastmodified":1,"geom:latitude":0.1,"geom:longitude":0.1},"geometry":{"type":"Point","coordinates":[0.1,0.1]}}`
+
+	err := os.WriteFile(filepath.Join(input, "1.geojson"), []byte(venue), 0644)
+
+	if err != nil {
+		t.Fatalf("Failed to write venue, %v", err)
+	}
+
+	neighbourhood, err := os.ReadFile(filepath.Join(fixturesPath, "102/000/001/102000001.geojson"))
+
+	if err != nil {
+		t.Fatalf("Failed to read fixture, %v", err)
+	}
+
+	err = os.WriteFile(filepath.Join(input, "102000001.geojson"), neighbourhood, 0644)
+
+	if err != nil {
+		t.Fatalf("Failed to write neighbourhood, %v", err)
+	}
+
+	fs_flags, err := DefaultFlagSet(ctx)
+
+	if err != nil {
+		t.Fatalf("Failed to create flag set, %v", err)
+	}
+
+	args_orig := os.Args
+
+	defer func() {
+		os.Args = args_orig
+	}()
+
+	os.Args = []string{
+		"update",
+		"-spatial-database-uri", "rtree://",
+		"-spatial-iterator-uri", "directory://",
+		"-spatial-source", fixturesPath,
+		"-iterator-uri", "directory://",
+		"-writer-uri", "fs://" + output,
+		"-is-current", "1",
+		input,
+	}
+
+	logger := log.New(io.Discard, "", 0)
+
+	err = RunWithFlagSet(ctx, fs_flags, logger)
+
+	if err != nil {
+		t.Fatalf("Failed to run application, %v", err)
+	}
+
+	written := make([]string, 0)
+
+	err = filepath.WalkDir(output, func(path string, d fs.DirEntry, err error) error {
+
+		if err != nil {
+			return err
+		}
+
+		if !d.IsDir() {
+			written = append(written, path)
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		t.Fatalf("Failed to walk output, %v", err)
+	}
+
+	if len(written) != 1 {
+		t.Fatalf("Expected 1 record to be written, got %v", written)
+	}
+
+	body, err := os.ReadFile(written[0])
+
+	if err != nil {
+		t.Fatalf("Failed to read updated record, %v", err)
+	}
+
+	id, _ := properties.Id(body)
+	parent_id, _ := properties.ParentId(body)
+
+	if id != 1 || parent_id != 102000001 {
+		t.Fatalf("Expected record 1 with parent 102000001, got %d with parent %d", id, parent_id)
+	}
+}
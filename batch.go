@@ -0,0 +1,132 @@
+package pip
+
+import (
+	"context"
+	"fmt"
+	spatial_app "github.com/whosonfirst/go-whosonfirst-spatial/app"
+	"github.com/whosonfirst/go-whosonfirst-spr/v2"
+	"sync"
+)
+
+// DEFAULT_BATCH_WORKERS is the default number of concurrent lookups performed by QueryPointInPolygonBatch.
+const DEFAULT_BATCH_WORKERS int = 10
+
+// BatchCoordinate is a single latitude, longitude pair to be queried using a shared filter.
+type BatchCoordinate struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+}
+
+// BatchPointInPolygonRequest is a shared point-in-polygon filter (and sorting, properties, etc.)
+// criteria to be applied to a list of coordinates.
+type BatchPointInPolygonRequest struct {
+	Filter      *PointInPolygonRequest `json:"filter,omitempty"`
+	Coordinates []*BatchCoordinate     `json:"coordinates"`
+}
+
+// BatchPointInPolygonResult is the outcome of a single point-in-polygon query in a batch.
+type BatchPointInPolygonResult struct {
+	Request *PointInPolygonRequest
+	Results spr.StandardPlacesResults
+	Error   error
+	// The per-lookup application used to perform the query whose Monitor records the timings for this lookup only.
+	Application *spatial_app.SpatialApplication
+}
+
+// Requests returns a new PointInPolygonRequest for each coordinate in 'b' using the criteria in 'b.Filter'.
+func (b *BatchPointInPolygonRequest) Requests() []*PointInPolygonRequest {
+
+	reqs := make([]*PointInPolygonRequest, len(b.Coordinates))
+
+	for idx, c := range b.Coordinates {
+
+		req := &PointInPolygonRequest{}
+
+		if b.Filter != nil {
+			*req = *b.Filter
+		}
+
+		if c != nil {
+			req.Latitude = c.Latitude
+			req.Longitude = c.Longitude
+		}
+
+		reqs[idx] = req
+	}
+
+	return reqs
+}
+
+// QueryPointInPolygonBatch performs a point-in-polygon query for each request in 'reqs' running at most 'max_workers'
+// queries concurrently. Results are returned in the same order as 'reqs' and a failed query is reported in its
+// own result rather than failing the entire batch. Each query is performed using its own request application, derived
+// from 'app', so that the timings for concurrent lookups are not mixed together.
+func QueryPointInPolygonBatch(ctx context.Context, app *spatial_app.SpatialApplication, reqs []*PointInPolygonRequest, max_workers int) []*BatchPointInPolygonResult {
+	return QueryPointInPolygonBatchWithObserver(ctx, app, reqs, max_workers, nil)
+}
+
+// QueryPointInPolygonBatchWithObserver is identical to QueryPointInPolygonBatch except that the RequestMonitor instance
+// for each lookup notifies 'o', if not nil, as each stage is completed.
+func QueryPointInPolygonBatchWithObserver(ctx context.Context, app *spatial_app.SpatialApplication, reqs []*PointInPolygonRequest, max_workers int, o StageObserver) []*BatchPointInPolygonResult {
+
+	if max_workers < 1 {
+		max_workers = DEFAULT_BATCH_WORKERS
+	}
+
+	results := make([]*BatchPointInPolygonResult, len(reqs))
+
+	throttle := make(chan bool, max_workers)
+	wg := new(sync.WaitGroup)
+
+	for idx, req := range reqs {
+
+		results[idx] = &BatchPointInPolygonResult{
+			Request: req,
+		}
+
+		if req == nil {
+			results[idx].Error = NewError(INVALID_REQUEST, fmt.Errorf("Empty request"))
+			continue
+		}
+
+		err := req.Validate()
+
+		if err != nil {
+			results[idx].Error = err
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			results[idx].Error = ctx.Err()
+			continue
+		case throttle <- true:
+			// pass
+		}
+
+		wg.Add(1)
+
+		go func(r *BatchPointInPolygonResult) {
+
+			defer func() {
+				<-throttle
+				wg.Done()
+			}()
+
+			req_app, _ := NewRequestApplicationWithObserver(app, o)
+			r.Application = req_app
+
+			rsp, err := QueryPointInPolygon(ctx, req_app, r.Request)
+
+			if err != nil {
+				r.Error = err
+				return
+			}
+
+			r.Results = rsp
+		}(results[idx])
+	}
+
+	wg.Wait()
+	return results
+}
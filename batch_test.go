@@ -0,0 +1,141 @@
+package pip
+
+import (
+	"context"
+	"slices"
+	"testing"
+)
+
+func TestBatchPointInPolygonRequestRequests(t *testing.T) {
+
+	b := &BatchPointInPolygonRequest{
+		Filter: &PointInPolygonRequest{
+			Placetypes: []string{"locality"},
+			IsCurrent:  []int64{1},
+		},
+		Coordinates: []*BatchCoordinate{
+			{Latitude: 0.5, Longitude: 0.5},
+			{Latitude: 5.5, Longitude: 5.5},
+		},
+	}
+
+	reqs := b.Requests()
+
+	if len(reqs) != 2 {
+		t.Fatalf("Expected 2 requests, got %d", len(reqs))
+	}
+
+	for idx, req := range reqs {
+
+		c := b.Coordinates[idx]
+
+		if req.Latitude != c.Latitude || req.Longitude != c.Longitude {
+			t.Fatalf("Unexpected coordinate for request %d, %f,%f", idx, req.Latitude, req.Longitude)
+		}
+
+		if !slices.Equal(req.Placetypes, []string{"locality"}) || !slices.Equal(req.IsCurrent, []int64{1}) {
+			t.Fatalf("Request %d does not use the shared filter", idx)
+		}
+	}
+
+	if reqs[0] == b.Filter {
+		t.Fatalf("Requests must not share the filter instance")
+	}
+}
+
+func TestQueryPointInPolygonBatch(t *testing.T) {
+
+	ctx := context.Background()
+	app := newTestApplication(t, nil)
+
+	tests := []struct {
+		name     string
+		req      *PointInPolygonRequest
+		expected []string
+		code     ErrorCode
+	}{
+		{
+			name:     "neighbourhood",
+			req:      &PointInPolygonRequest{Latitude: 0.25, Longitude: 0.25, Placetypes: []string{"neighbourhood"}},
+			expected: []string{"102000001"},
+		},
+		{
+			name: "invalid coordinate",
+			req:  &PointInPolygonRequest{Latitude: 91, Longitude: 0.25},
+			code: INVALID_COORDINATE,
+		},
+		{
+			name:     "current localities",
+			req:      &PointInPolygonRequest{Latitude: 0.75, Longitude: 0.75, Placetypes: []string{"locality"}, IsCurrent: []int64{1}},
+			expected: []string{"101000001"},
+		},
+		{
+			name: "empty",
+			code: INVALID_REQUEST,
+		},
+		{
+			name:     "island",
+			req:      &PointInPolygonRequest{Latitude: 5.5, Longitude: 5.5},
+			expected: []string{"101000003"},
+		},
+		{
+			name: "invalid placetype",
+			req:  &PointInPolygonRequest{Latitude: 0.25, Longitude: 0.25, Placetypes: []string{"planet-x"}},
+			code: INVALID_PLACETYPE,
+		},
+		{
+			name:     "no results",
+			req:      &PointInPolygonRequest{Latitude: -50, Longitude: -50},
+			expected: []string{},
+		},
+	}
+
+	reqs := make([]*PointInPolygonRequest, len(tests))
+
+	for idx, tt := range tests {
+		reqs[idx] = tt.req
+	}
+
+	for _, max_workers := range []int{0, 1, 3} {
+
+		results := QueryPointInPolygonBatch(ctx, app, reqs, max_workers)
+
+		if len(results) != len(tests) {
+			t.Fatalf("Expected %d results, got %d", len(tests), len(results))
+		}
+
+		for idx, tt := range tests {
+
+			r := results[idx]
+
+			if r.Request != tt.req {
+				t.Fatalf("Result %d (%s) is out of order", idx, tt.name)
+			}
+
+			if tt.code != "" {
+
+				code, ok := ErrorCodeWithError(r.Error)
+
+				if !ok || code != tt.code {
+					t.Fatalf("Expected %s error for %s, got %v", tt.code, tt.name, r.Error)
+				}
+
+				continue
+			}
+
+			if r.Error != nil {
+				t.Fatalf("Unexpected error for %s, %v", tt.name, r.Error)
+			}
+
+			ids := resultIds(r.Results)
+
+			if !slices.Equal(ids, tt.expected) {
+				t.Fatalf("Expected %v for %s, got %v", tt.expected, tt.name, ids)
+			}
+
+			if r.Application == nil || r.Application == app {
+				t.Fatalf("Expected %s to be queried using its own request application", tt.name)
+			}
+		}
+	}
+}
@@ -0,0 +1,318 @@
+package pip
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	spatial_app "github.com/whosonfirst/go-whosonfirst-spatial/app"
+	"github.com/whosonfirst/go-whosonfirst-spr/v2"
+	"math"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// DEFAULT_CACHE_PRECISION is the default number of decimal places that coordinates are rounded to by a QueryCache.
+const DEFAULT_CACHE_PRECISION int = 6
+
+// CachingIndex is implemented by spatial databases that have a QueryCache for point-in-polygon results.
+type CachingIndex interface {
+	// QueryCache returns the database's QueryCache or nil if it does not have one.
+	QueryCache() *QueryCache
+}
+
+// QueryCacheOptions defines options for a QueryCache.
+type QueryCacheOptions struct {
+	// The maximum number of results to cache. The least recently used results are evicted when it is exceeded.
+	Size int
+	// The maximum amount of time that results are cached for. If zero results do not expire.
+	TTL time.Duration
+	// The number of decimal places that coordinates are rounded to. Requests whose coordinates are the same after
+	// rounding share a single cache entry and QueryPointInPolygon queries the rounded coordinate, so that every request
+	// sharing an entry gets the same results. If 0 then DEFAULT_CACHE_PRECISION is used.
+	Precision int
+}
+
+// QueryCacheStats reports the activity of a QueryCache.
+type QueryCacheStats struct {
+	// The number of lookups that returned cached results.
+	Hits int64
+	// The number of lookups that did not return cached results.
+	Misses int64
+	// The number of results removed because the cache was full or they had expired.
+	Evictions int64
+	// The number of times the cache has been emptied because the spatial database has changed.
+	Invalidations int64
+	// The number of results currently cached.
+	Entries int
+}
+
+// QueryCache is a bounded, least recently used cache of point-in-polygon results keyed on a normalised request.
+// A QueryCache is used by QueryPointInPolygon when it is assigned to a SpatialDatabase using SetQueryCache, in
+// which case it is emptied whenever a record is indexed or removed. Note that queries are then performed using the
+// coordinates returned by NormaliseRequest rather than those in the original request.
+type QueryCache struct {
+	size          int
+	ttl           time.Duration
+	precision     int
+	mu            *sync.Mutex
+	entries       map[string]*list.Element
+	lru           *list.List
+	generation    uint64
+	hits          int64
+	misses        int64
+	evictions     int64
+	invalidations int64
+}
+
+// queryCacheEntry is a cached point-in-polygon result.
+type queryCacheEntry struct {
+	key     string
+	results spr.StandardPlacesResults
+	expires time.Time
+}
+
+// NewQueryCache returns a new QueryCache instance configured by 'opts'.
+func NewQueryCache(opts *QueryCacheOptions) (*QueryCache, error) {
+
+	if opts.Size < 1 {
+		return nil, fmt.Errorf("Invalid cache size, must be greater than zero")
+	}
+
+	if opts.TTL < 0 {
+		return nil, fmt.Errorf("Invalid cache TTL, must not be negative")
+	}
+
+	if opts.Precision < 0 {
+		return nil, fmt.Errorf("Invalid cache precision, must not be negative")
+	}
+
+	precision := opts.Precision
+
+	if precision == 0 {
+		precision = DEFAULT_CACHE_PRECISION
+	}
+
+	c := &QueryCache{
+		size:      opts.Size,
+		ttl:       opts.TTL,
+		precision: precision,
+		mu:        new(sync.Mutex),
+		entries:   make(map[string]*list.Element),
+		lru:       list.New(),
+	}
+
+	return c, nil
+}
+
+// QueryCacheWithApplication returns the QueryCache for the spatial database of 'app' and true, or nil and false if
+// the spatial database does not have one.
+func QueryCacheWithApplication(app *spatial_app.SpatialApplication) (*QueryCache, bool) {
+
+	idx, ok := app.SpatialDatabase.(CachingIndex)
+
+	if !ok {
+		return nil, false
+	}
+
+	c := idx.QueryCache()
+	return c, c != nil
+}
+
+// NormaliseRequest returns a copy of 'req' whose coordinates are rounded to the cache's precision.
+func (c *QueryCache) NormaliseRequest(req *PointInPolygonRequest) *PointInPolygonRequest {
+
+	norm_req := new(PointInPolygonRequest)
+	*norm_req = *req
+
+	scale := math.Pow(10, float64(c.precision))
+
+	norm_req.Latitude = math.Round(req.Latitude*scale) / scale
+	norm_req.Longitude = math.Round(req.Longitude*scale) / scale
+
+	return norm_req
+}
+
+// Key returns the cache key for 'req'. The key is derived from its coordinates, rounded to the cache's precision, and
+// a hash of its filtering, sorting, properties, pagination and fallback criteria. The order of lists whose order does
+// not affect the results, such as placetypes, is ignored.
+func (c *QueryCache) Key(req *PointInPolygonRequest) (string, error) {
+
+	norm_req := c.NormaliseRequest(req)
+
+	criteria := new(PointInPolygonRequest)
+	*criteria = *norm_req
+
+	criteria.Id = ""
+	criteria.Latitude = 0.0
+	criteria.Longitude = 0.0
+	criteria.Explain = false
+	criteria.Timings = false
+
+	criteria.Placetypes = sortedStrings(criteria.Placetypes)
+	criteria.AlternateGeometries = sortedStrings(criteria.AlternateGeometries)
+	criteria.Properties = sortedStrings(criteria.Properties)
+	criteria.IsCurrent = sortedInt64s(criteria.IsCurrent)
+	criteria.IsCeased = sortedInt64s(criteria.IsCeased)
+	criteria.IsDeprecated = sortedInt64s(criteria.IsDeprecated)
+	criteria.IsSuperseded = sortedInt64s(criteria.IsSuperseded)
+	criteria.IsSuperseding = sortedInt64s(criteria.IsSuperseding)
+
+	enc_criteria, err := json.Marshal(criteria)
+
+	if err != nil {
+		return "", fmt.Errorf("Failed to encode cache key, %w", err)
+	}
+
+	hash := sha256.Sum256(enc_criteria)
+
+	str_lat := strconv.FormatFloat(norm_req.Latitude, 'f', c.precision, 64)
+	str_lon := strconv.FormatFloat(norm_req.Longitude, 'f', c.precision, 64)
+
+	return fmt.Sprintf("%s,%s#%s", str_lat, str_lon, hex.EncodeToString(hash[:])), nil
+}
+
+// Get returns the results cached for 'key' and true, or nil and false if there are none. It also returns the cache's
+// current generation which must be passed to Set when caching the results of a query performed after a miss.
+func (c *QueryCache) Get(key string) (spr.StandardPlacesResults, uint64, bool) {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.entries[key]
+
+	if !ok {
+		c.misses += 1
+		return nil, c.generation, false
+	}
+
+	e := el.Value.(*queryCacheEntry)
+
+	if !e.expires.IsZero() && time.Now().After(e.expires) {
+		c.remove(el)
+		c.evictions += 1
+		c.misses += 1
+		return nil, c.generation, false
+	}
+
+	c.lru.MoveToFront(el)
+	c.hits += 1
+
+	return e.results, c.generation, true
+}
+
+// Set caches 'results' for 'key'. 'generation' is the value returned by the Get call which preceded the query for
+// 'results'; if the cache has been purged since then 'results' may be stale and are not cached. Nil results are
+// never cached.
+func (c *QueryCache) Set(key string, results spr.StandardPlacesResults, generation uint64) {
+
+	if results == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if generation != c.generation {
+		return
+	}
+
+	e := &queryCacheEntry{
+		key:     key,
+		results: results,
+	}
+
+	if c.ttl > 0 {
+		e.expires = time.Now().Add(c.ttl)
+	}
+
+	el, ok := c.entries[key]
+
+	if ok {
+		el.Value = e
+		c.lru.MoveToFront(el)
+		return
+	}
+
+	c.entries[key] = c.lru.PushFront(e)
+
+	for c.lru.Len() > c.size {
+		c.remove(c.lru.Back())
+		c.evictions += 1
+	}
+}
+
+// Purge removes all the cached results. It is safe to call on a nil instance, in which case it does nothing.
+func (c *QueryCache) Purge() {
+
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.generation += 1
+
+	if c.lru.Len() == 0 {
+		return
+	}
+
+	c.entries = make(map[string]*list.Element)
+	c.lru.Init()
+	c.invalidations += 1
+}
+
+// Stats returns the activity of the cache.
+func (c *QueryCache) Stats() *QueryCacheStats {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stats := &QueryCacheStats{
+		Hits:          c.hits,
+		Misses:        c.misses,
+		Evictions:     c.evictions,
+		Invalidations: c.invalidations,
+		Entries:       c.lru.Len(),
+	}
+
+	return stats
+}
+
+func (c *QueryCache) remove(el *list.Element) {
+	e := c.lru.Remove(el).(*queryCacheEntry)
+	delete(c.entries, e.key)
+}
+
+func sortedStrings(values []string) []string {
+
+	if len(values) == 0 {
+		return nil
+	}
+
+	sorted := make([]string, len(values))
+	copy(sorted, values)
+
+	sort.Strings(sorted)
+	return sorted
+}
+
+func sortedInt64s(values []int64) []int64 {
+
+	if len(values) == 0 {
+		return nil
+	}
+
+	sorted := make([]int64, len(values))
+	copy(sorted, values)
+
+	sort.Slice(sorted, func(i int, j int) bool {
+		return sorted[i] < sorted[j]
+	})
+
+	return sorted
+}
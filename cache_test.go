@@ -0,0 +1,545 @@
+package pip
+
+import (
+	"context"
+	"fmt"
+	"github.com/whosonfirst/go-whosonfirst-spr/v2"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewQueryCache(t *testing.T) {
+
+	tests := []struct {
+		name string
+		opts *QueryCacheOptions
+		ok   bool
+	}{
+		{name: "valid", opts: &QueryCacheOptions{Size: 10, TTL: time.Minute, Precision: DEFAULT_CACHE_PRECISION}, ok: true},
+		{name: "no ttl", opts: &QueryCacheOptions{Size: 1}, ok: true},
+		{name: "size", opts: &QueryCacheOptions{Size: 0}},
+		{name: "ttl", opts: &QueryCacheOptions{Size: 10, TTL: -1 * time.Second}},
+		{name: "precision", opts: &QueryCacheOptions{Size: 10, Precision: -1}},
+	}
+
+	for _, tt := range tests {
+
+		t.Run(tt.name, func(t *testing.T) {
+
+			_, err := NewQueryCache(tt.opts)
+
+			if tt.ok && err != nil {
+				t.Fatalf("Failed to create query cache, %v", err)
+			}
+
+			if !tt.ok && err == nil {
+				t.Fatalf("Expected an error")
+			}
+		})
+	}
+}
+
+func TestQueryCacheKey(t *testing.T) {
+
+	c, err := NewQueryCache(&QueryCacheOptions{Size: 10, Precision: 3})
+
+	if err != nil {
+		t.Fatalf("Failed to create query cache, %v", err)
+	}
+
+	base := &PointInPolygonRequest{
+		Latitude:   37.6181,
+		Longitude:  -122.3841,
+		Placetypes: []string{"locality", "neighbourhood"},
+		IsCurrent:  []int64{1, 0},
+		Properties: []string{"wof:name", "wof:placetype"},
+	}
+
+	tests := []struct {
+		name  string
+		req   *PointInPolygonRequest
+		equal bool
+	}{
+		{
+			name:  "rounded coordinates",
+			req:   &PointInPolygonRequest{Latitude: 37.61809, Longitude: -122.38412, Placetypes: base.Placetypes, IsCurrent: base.IsCurrent, Properties: base.Properties},
+			equal: true,
+		},
+		{
+			name:  "list order",
+			req:   &PointInPolygonRequest{Latitude: base.Latitude, Longitude: base.Longitude, Placetypes: []string{"neighbourhood", "locality"}, IsCurrent: []int64{0, 1}, Properties: []string{"wof:placetype", "wof:name"}},
+			equal: true,
+		},
+		{
+			name:  "request options",
+			req:   &PointInPolygonRequest{Id: "abc", Explain: true, Timings: true, Latitude: base.Latitude, Longitude: base.Longitude, Placetypes: base.Placetypes, IsCurrent: base.IsCurrent, Properties: base.Properties},
+			equal: true,
+		},
+		{
+			name: "coordinates",
+			req:  &PointInPolygonRequest{Latitude: 37.619, Longitude: base.Longitude, Placetypes: base.Placetypes, IsCurrent: base.IsCurrent, Properties: base.Properties},
+		},
+		{
+			name: "placetypes",
+			req:  &PointInPolygonRequest{Latitude: base.Latitude, Longitude: base.Longitude, Placetypes: []string{"locality"}, IsCurrent: base.IsCurrent, Properties: base.Properties},
+		},
+		{
+			name: "existential",
+			req:  &PointInPolygonRequest{Latitude: base.Latitude, Longitude: base.Longitude, Placetypes: base.Placetypes, IsCurrent: []int64{1}, Properties: base.Properties},
+		},
+		{
+			name: "properties",
+			req:  &PointInPolygonRequest{Latitude: base.Latitude, Longitude: base.Longitude, Placetypes: base.Placetypes, IsCurrent: base.IsCurrent},
+		},
+		{
+			name: "sort",
+			req:  &PointInPolygonRequest{Latitude: base.Latitude, Longitude: base.Longitude, Placetypes: base.Placetypes, IsCurrent: base.IsCurrent, Properties: base.Properties, Sort: []string{"name://"}},
+		},
+		{
+			name: "pagination",
+			req:  &PointInPolygonRequest{Latitude: base.Latitude, Longitude: base.Longitude, Placetypes: base.Placetypes, IsCurrent: base.IsCurrent, Properties: base.Properties, Page: 2},
+		},
+		{
+			name: "fallback",
+			req:  &PointInPolygonRequest{Latitude: base.Latitude, Longitude: base.Longitude, Placetypes: base.Placetypes, IsCurrent: base.IsCurrent, Properties: base.Properties, FallbackMaxDistance: 100},
+		},
+	}
+
+	base_key, err := c.Key(base)
+
+	if err != nil {
+		t.Fatalf("Failed to derive key, %v", err)
+	}
+
+	for _, tt := range tests {
+
+		t.Run(tt.name, func(t *testing.T) {
+
+			key, err := c.Key(tt.req)
+
+			if err != nil {
+				t.Fatalf("Failed to derive key, %v", err)
+			}
+
+			if (key == base_key) != tt.equal {
+				t.Fatalf("Expected keys to be equal to be %t, got %s and %s", tt.equal, base_key, key)
+			}
+		})
+	}
+
+	// Deriving a key does not modify the request
+
+	if base.Latitude != 37.6181 || !reflect.DeepEqual(base.IsCurrent, []int64{1, 0}) {
+		t.Fatalf("Expected request to be unchanged, %+v", base)
+	}
+}
+
+func TestQueryCacheEviction(t *testing.T) {
+
+	c, err := NewQueryCache(&QueryCacheOptions{Size: 2})
+
+	if err != nil {
+		t.Fatalf("Failed to create query cache, %v", err)
+	}
+
+	results := NewPointInPolygonResults([]spr.StandardPlacesResult{})
+
+	_, generation, _ := c.Get("a")
+
+	c.Set("a", results, generation)
+	c.Set("b", results, generation)
+
+	// "a" is now the most recently used so "b" is evicted when "c" is added
+
+	_, _, ok := c.Get("a")
+
+	if !ok {
+		t.Fatalf("Expected a to be cached")
+	}
+
+	c.Set("c", results, generation)
+
+	tests := map[string]bool{
+		"a": true,
+		"b": false,
+		"c": true,
+	}
+
+	for key, expected := range tests {
+
+		_, _, ok := c.Get(key)
+
+		if ok != expected {
+			t.Fatalf("Expected %s to be cached to be %t", key, expected)
+		}
+	}
+
+	expected := &QueryCacheStats{
+		Hits:      3,
+		Misses:    2,
+		Evictions: 1,
+		Entries:   2,
+	}
+
+	stats := c.Stats()
+
+	if !reflect.DeepEqual(stats, expected) {
+		t.Fatalf("Expected %+v, got %+v", expected, stats)
+	}
+}
+
+func TestQueryCacheTTL(t *testing.T) {
+
+	c, err := NewQueryCache(&QueryCacheOptions{Size: 10, TTL: 10 * time.Millisecond})
+
+	if err != nil {
+		t.Fatalf("Failed to create query cache, %v", err)
+	}
+
+	_, generation, _ := c.Get("a")
+	c.Set("a", NewPointInPolygonResults([]spr.StandardPlacesResult{}), generation)
+
+	_, _, ok := c.Get("a")
+
+	if !ok {
+		t.Fatalf("Expected a to be cached")
+	}
+
+	time.Sleep(20 * time.Millisecond)
+
+	_, _, ok = c.Get("a")
+
+	if ok {
+		t.Fatalf("Expected a to have expired")
+	}
+
+	stats := c.Stats()
+
+	if stats.Evictions != 1 || stats.Entries != 0 {
+		t.Fatalf("Expected expired results to be evicted, %+v", stats)
+	}
+}
+
+func TestQueryCacheSet(t *testing.T) {
+
+	c, err := NewQueryCache(&QueryCacheOptions{Size: 10})
+
+	if err != nil {
+		t.Fatalf("Failed to create query cache, %v", err)
+	}
+
+	results := NewPointInPolygonResults([]spr.StandardPlacesResult{})
+
+	_, generation, _ := c.Get("a")
+
+	// Nil results are never cached
+
+	c.Set("a", nil, generation)
+
+	_, _, ok := c.Get("a")
+
+	if ok {
+		t.Fatalf("Expected nil results not to be cached")
+	}
+
+	// Results queried before the cache was purged may be stale and are not cached
+
+	c.Purge()
+	c.Set("a", results, generation)
+
+	_, generation, ok = c.Get("a")
+
+	if ok {
+		t.Fatalf("Expected stale results not to be cached")
+	}
+
+	c.Set("a", results, generation)
+
+	_, _, ok = c.Get("a")
+
+	if !ok {
+		t.Fatalf("Expected a to be cached")
+	}
+
+	c.Purge()
+
+	stats := c.Stats()
+
+	if stats.Entries != 0 || stats.Invalidations != 1 {
+		t.Fatalf("Expected purging an empty cache not to be counted as an invalidation, %+v", stats)
+	}
+
+	var nil_cache *QueryCache
+	nil_cache.Purge()
+}
+
+func TestQueryPointInPolygonCache(t *testing.T) {
+
+	ctx := context.Background()
+	app := newTestApplication(t, nil)
+
+	_, ok := QueryCacheWithApplication(app)
+
+	if ok {
+		t.Fatalf("Expected no query cache")
+	}
+
+	c, err := NewQueryCache(&QueryCacheOptions{Size: 10, Precision: 3})
+
+	if err != nil {
+		t.Fatalf("Failed to create query cache, %v", err)
+	}
+
+	db := app.SpatialDatabase.(*SpatialDatabase)
+	db.SetQueryCache(c)
+
+	body, err := os.ReadFile(filepath.Join(fixturesPath, "101/000/003/101000003.geojson"))
+
+	if err != nil {
+		t.Fatalf("Failed to read fixture, %v", err)
+	}
+
+	// Each step is a query, and the change to the database which precedes it, and whether it is answered from the cache
+
+	tests := []struct {
+		name     string
+		change   func() error
+		lat      float64
+		hit      bool
+		expected []string
+	}{
+		{name: "miss", lat: 0.75, expected: []string{"101000001", "85000001", "85000002"}},
+		{name: "hit", lat: 0.75, hit: true, expected: []string{"101000001", "85000001", "85000002"}},
+		{name: "rounded hit", lat: 0.7501, hit: true, expected: []string{"101000001", "85000001", "85000002"}},
+		{
+			name:     "index feature",
+			change:   func() error { return db.IndexFeature(ctx, body) },
+			lat:      0.75,
+			expected: []string{"101000001", "85000001", "85000002"},
+		},
+		{
+			name:     "remove feature",
+			change:   func() error { return db.RemoveFeature(ctx, "101000001") },
+			lat:      0.75,
+			expected: []string{"85000001", "85000002"},
+		},
+		{name: "hit after remove", lat: 0.75, hit: true, expected: []string{"85000001", "85000002"}},
+		{
+			name: "bulk indexing",
+			change: func() error {
+				db.StartBulkIndexing()
+				return db.IndexFeature(ctx, body)
+			},
+			lat:      0.75,
+			expected: []string{"85000001", "85000002"},
+		},
+		{
+			name: "stop bulk indexing",
+			change: func() error {
+				db.StopBulkIndexing()
+				return nil
+			},
+			lat:      0.75,
+			expected: []string{"85000001", "85000002"},
+		},
+	}
+
+	for _, tt := range tests {
+
+		if tt.change != nil {
+
+			err := tt.change()
+
+			if err != nil {
+				t.Fatalf("Failed to change database for %s, %v", tt.name, err)
+			}
+		}
+
+		before := c.Stats()
+
+		req := &PointInPolygonRequest{
+			Latitude:  tt.lat,
+			Longitude: tt.lat,
+			IsCurrent: []int64{1},
+		}
+
+		rsp, err := QueryPointInPolygon(ctx, app, req)
+
+		if err != nil {
+			t.Fatalf("Failed to query %s, %v", tt.name, err)
+		}
+
+		ids := resultIds(rsp)
+
+		if !reflect.DeepEqual(ids, tt.expected) {
+			t.Fatalf("Expected %v for %s, got %v", tt.expected, tt.name, ids)
+		}
+
+		after := c.Stats()
+
+		if (after.Hits > before.Hits) != tt.hit {
+			t.Fatalf("Expected %s to be a cache hit to be %t, %+v", tt.name, tt.hit, after)
+		}
+	}
+
+	stats := c.Stats()
+
+	// Indexing and removing a record, and stopping bulk indexing, each empty the cache. Bulk indexing bypasses the cache.
+
+	if stats.Invalidations != 3 || stats.Hits != 3 || stats.Misses != 4 {
+		t.Fatalf("Unexpected cache stats, %+v", stats)
+	}
+}
+
+func TestQueryPointInPolygonCacheConcurrent(t *testing.T) {
+
+	ctx := context.Background()
+	app := newTestApplication(t, &SpatialDatabaseOptions{IndexGeometries: true})
+
+	c, err := NewQueryCache(&QueryCacheOptions{Size: 10, Precision: DEFAULT_CACHE_PRECISION})
+
+	if err != nil {
+		t.Fatalf("Failed to create query cache, %v", err)
+	}
+
+	db := app.SpatialDatabase.(*SpatialDatabase)
+	db.SetQueryCache(c)
+
+	// A record with a large geometry, so that indexing it takes long enough for the queries to be scheduled while it
+	// is being indexed even if only one CPU is available
+
+	coords := make([]string, 0)
+
+	for i := 0; i <= 5000; i++ {
+		a := 2 * math.Pi * float64(i%5000) / 5000
+		coords = append(coords, fmt.Sprintf("[%f,%f]", 20.5+0.4*math.Cos(a), 20.5+0.4*math.Sin(a)))
+	}
+
+	body := []byte(fmt.Sprintf(`{"type":"Feature","id":1,"properties":{"wof:id":1,"wof:name":"Circle","wof:placetype":"locality","wof:parent_id":-1,"wof:repo":"whosonfirst-data-test","wof:lastmodified":1,"geom:latitude":20.5,"geom:longitude":20.5},"bbox":[20.1,20.1,20.9,20.9],"geometry":{"type":"Polygon","coordinates":[[%s]]}}`, strings.Join(coords, ",")))
+
+	err = db.IndexFeature(ctx, body)
+
+	if err != nil {
+		t.Fatalf("Failed to index feature, %v", err)
+	}
+
+	req := &PointInPolygonRequest{
+		Latitude:  20.5,
+		Longitude: 20.5,
+	}
+
+	// Queries performed while a removed record is being indexed again must never leave results without it in
+	// the cache once IndexFeature has returned
+
+	done := make(chan bool)
+	stopped := make(chan bool)
+
+	go func() {
+
+		defer close(stopped)
+
+		for {
+			select {
+			case <-done:
+				return
+			default:
+				QueryPointInPolygon(ctx, app, req)
+			}
+		}
+	}()
+
+	defer func() {
+		close(done)
+		<-stopped
+	}()
+
+	for i := 0; i < 5; i++ {
+
+		err := db.RemoveFeature(ctx, "1")
+
+		if err != nil {
+			t.Fatalf("Failed to remove feature, %v", err)
+		}
+
+		err = db.IndexFeature(ctx, body)
+
+		if err != nil {
+			t.Fatalf("Failed to index feature, %v", err)
+		}
+
+		rsp, err := QueryPointInPolygon(ctx, app, req)
+
+		if err != nil {
+			t.Fatalf("Failed to query point in polygon, %v", err)
+		}
+
+		ids := resultIds(rsp)
+
+		if !reflect.DeepEqual(ids, []string{"1"}) {
+			t.Fatalf("Expected the indexed record after %d iterations, got %v", i, ids)
+		}
+	}
+}
+
+func TestQueryCachePrecision(t *testing.T) {
+
+	ctx := context.Background()
+
+	tests := []struct {
+		name      string
+		precision int
+		latitude  float64
+		// The latitude after it has been rounded
+		normalised float64
+		expected   []string
+	}{
+		{name: "default", precision: 0, latitude: -1.4, normalised: -1.4, expected: []string{"85000001"}},
+		{name: "explicit", precision: 3, latitude: -1.4004, normalised: -1.4, expected: []string{"85000001"}},
+		// Queries are performed using the rounded coordinate, which is on the boundary of 85000002
+		{name: "rounded", precision: 0, latitude: -1.0000001, normalised: -1, expected: []string{"85000001", "85000002"}},
+	}
+
+	for _, tt := range tests {
+
+		t.Run(tt.name, func(t *testing.T) {
+
+			app := newTestApplication(t, nil)
+
+			c, err := NewQueryCache(&QueryCacheOptions{Size: 10, Precision: tt.precision})
+
+			if err != nil {
+				t.Fatalf("Failed to create query cache, %v", err)
+			}
+
+			app.SpatialDatabase.(*SpatialDatabase).SetQueryCache(c)
+
+			req := &PointInPolygonRequest{
+				Latitude:  tt.latitude,
+				Longitude: tt.latitude,
+			}
+
+			norm_req := c.NormaliseRequest(req)
+
+			if norm_req.Latitude != tt.normalised || norm_req.Longitude != tt.normalised {
+				t.Fatalf("Expected normalised coordinate %v, got %v, %v", tt.normalised, norm_req.Latitude, norm_req.Longitude)
+			}
+
+			rsp, err := QueryPointInPolygon(ctx, app, req)
+
+			if err != nil {
+				t.Fatalf("Failed to query point in polygon, %v", err)
+			}
+
+			ids := resultIds(rsp)
+
+			if !reflect.DeepEqual(ids, tt.expected) {
+				t.Fatalf("Expected %v, got %v", tt.expected, ids)
+			}
+		})
+	}
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	_ "github.com/whosonfirst/go-whosonfirst-spatial-rtree"
+)
+
+import (
+	"context"
+	"github.com/whosonfirst/go-whosonfirst-spatial-pip/app/join"
+	"log"
+)
+
+func main() {
+
+	ctx := context.Background()
+
+	logger := log.Default()
+
+	err := join.Run(ctx, logger)
+
+	if err != nil {
+		logger.Fatalf("Failed to run join application, %v", err)
+	}
+
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	_ "github.com/whosonfirst/go-whosonfirst-spatial-rtree"
+	_ "github.com/whosonfirst/go-writer-featurecollection/v3"
+)
+
+import (
+	"context"
+	"github.com/whosonfirst/go-whosonfirst-spatial-pip/app/update"
+	"log"
+)
+
+func main() {
+
+	ctx := context.Background()
+
+	logger := log.Default()
+
+	err := update.Run(ctx, logger)
+
+	if err != nil {
+		logger.Fatalf("Failed to run update application, %v", err)
+	}
+
+}
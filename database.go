@@ -0,0 +1,849 @@
+package pip
+
+import (
+	"context"
+	"fmt"
+	"github.com/dhconnelly/rtreego"
+	"github.com/paulmach/orb"
+	"github.com/whosonfirst/go-whosonfirst-feature/alt"
+	"github.com/whosonfirst/go-whosonfirst-feature/geometry"
+	"github.com/whosonfirst/go-whosonfirst-feature/properties"
+	"github.com/whosonfirst/go-whosonfirst-spatial"
+	spatial_app "github.com/whosonfirst/go-whosonfirst-spatial/app"
+	"github.com/whosonfirst/go-whosonfirst-spatial/database"
+	"github.com/whosonfirst/go-whosonfirst-spatial/filter"
+	"github.com/whosonfirst/go-whosonfirst-spr/v2"
+	"github.com/whosonfirst/go-whosonfirst-uri"
+	"io"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// IntersectsIndex is implemented by spatial databases that can return the records whose geometries intersect,
+// or are within a distance of, an arbitrary geometry.
+type IntersectsIndex interface {
+	// Intersects returns the records whose geometries intersect 'geom' and match 'filters'.
+	Intersects(context.Context, orb.Geometry, ...spatial.Filter) (spr.StandardPlacesResults, error)
+	// WithinDistance returns the records whose geometries are within 'distance' metres of 'pt' and match 'filters'.
+	WithinDistance(context.Context, *orb.Point, float64, ...spatial.Filter) (spr.StandardPlacesResults, error)
+}
+
+// GeometryIndex is implemented by spatial databases that can return the records whose geometries have a spatial
+// relationship with an arbitrary geometry.
+type GeometryIndex interface {
+	// Relate returns the records whose geometries have the relationship 'relation' with 'geom' and match 'filters'.
+	Relate(context.Context, orb.Geometry, Relation, ...spatial.Filter) (spr.StandardPlacesResults, error)
+}
+
+// NearestIndex is implemented by spatial databases that can return the records nearest to a point.
+type NearestIndex interface {
+	// Nearest returns the records within a distance, in metres, of a point that match 'filters' as NearestPlacesResult
+	// instances ordered by their distance from the point.
+	Nearest(context.Context, *orb.Point, float64, ...spatial.Filter) (spr.StandardPlacesResults, error)
+}
+
+// FeatureIndex is implemented by spatial databases that can return the standard places response and geometry
+// for an indexed record.
+type FeatureIndex interface {
+	// IndexedFeature returns the standard places response and geometry for the record with ID 'id'. Alternate geometries
+	// are identified using a spatial ID of the form "{ID}#{ALT_LABEL}".
+	IndexedFeature(context.Context, string) (spr.StandardPlacesResult, orb.Geometry, error)
+}
+
+// VersionedIndex is implemented by spatial databases that track changes to the records they index.
+type VersionedIndex interface {
+	// Generation returns a counter which is incremented every time a record is indexed or removed.
+	Generation() uint64
+	// LastModified returns the time that a record was last indexed or removed.
+	LastModified() time.Time
+	// Release returns the label of the data release being indexed, if known.
+	Release() string
+}
+
+// BulkIndex is implemented by spatial databases that can defer the work done after each record is indexed, like
+// purging caches, until a large number of records have been indexed.
+type BulkIndex interface {
+	// StartBulkIndexing signals that a large number of records are about to be indexed.
+	StartBulkIndexing()
+	// StopBulkIndexing signals that the records started by StartBulkIndexing have been indexed.
+	StopBulkIndexing()
+}
+
+// IndexVersion identifies the state of a spatial database.
+type IndexVersion struct {
+	// A counter which is incremented every time a record is indexed or removed.
+	Generation uint64 `json:"generation"`
+	// The label of the data release being indexed, if known.
+	Release string `json:"release,omitempty"`
+	// The time that a record was last indexed or removed.
+	LastModified time.Time `json:"last_modified"`
+}
+
+// IndexVersionWithApplication returns the IndexVersion for the spatial database of 'app' and true, or nil and false
+// if the spatial database does not track changes to the records it indexes.
+func IndexVersionWithApplication(app *spatial_app.SpatialApplication) (*IndexVersion, bool) {
+
+	idx, ok := app.SpatialDatabase.(VersionedIndex)
+
+	if !ok {
+		return nil, false
+	}
+
+	v := &IndexVersion{
+		Generation:   idx.Generation(),
+		Release:      idx.Release(),
+		LastModified: idx.LastModified(),
+	}
+
+	return v, true
+}
+
+// SpatialDatabaseOptions defines configuration options for a SpatialDatabase instance.
+type SpatialDatabaseOptions struct {
+	// If true maintain a secondary index of the bounds, geometries and standard places responses of the records being
+	// indexed so that intersects, geometry, nearest and explain queries can be performed. The secondary index keeps
+	// a second copy of every geometry in memory so it roughly doubles the memory used by in-memory databases.
+	IndexGeometries bool
+	// If true include alternate geometry files in the secondary index so that they can be inspected when explaining
+	// queries. This should be true if, and only if, the underlying database indexes alternate geometry files.
+	IndexAltFiles bool
+}
+
+// SpatialDatabase wraps a `database.SpatialDatabase` instance and, if enabled, maintains a secondary index of the
+// bounds, geometries and standard places responses of the records it indexes so that queries other than
+// point-in-polygon can be performed. If the secondary index is not enabled those queries return UNSUPPORTED_QUERY
+// errors. All other methods are handled by the underlying database. Alternate geometry files are only included in
+// the secondary index if enabled, and only so that they can be inspected when explaining queries; they are never
+// returned by queries of the secondary index. If a QueryCache has been assigned it is purged whenever a record is indexed or
+// removed. SpatialDatabase implements the VersionedIndex interface. The underlying database and secondary index can be
+// replaced, without interrupting queries, using the Rebuild method.
+//
+// Records are removed by excluding their IDs from the results of the underlying database rather than by removing them
+// from it, since some databases (like rtree://) can not remove a single record exactly. The entries for a removed or
+// replaced record remain in the underlying database, and in memory, until it is rebuilt.
+type SpatialDatabase struct {
+	index            *databaseIndex
+	mu               *sync.RWMutex
+	cache            *QueryCache
+	index_geometries bool
+	index_alt_files  bool
+	// True if records are being indexed in bulk.
+	bulk atomic.Bool
+	// The number of times a record has been indexed or removed.
+	generation atomic.Uint64
+	// The time, in Unix nanoseconds, that a record was last indexed or removed.
+	last_modified atomic.Int64
+	release       string
+}
+
+// databaseIndex is the underlying database and secondary index of a SpatialDatabase, which are replaced together when
+// it is rebuilt.
+type databaseIndex struct {
+	database.SpatialDatabase
+	rtree *rtreego.Rtree
+	// The records in the secondary index keyed by their IDs and then by their alternate geometry labels, which are
+	// empty for the principal geometry. Only records with principal geometries are added to 'rtree'.
+	features map[string]map[string]*indexedFeature
+	// The IDs of the records which have been removed and are excluded from the results of the underlying database.
+	removed map[string]bool
+}
+
+// indexedFeature is a record in the secondary index of a SpatialDatabase.
+type indexedFeature struct {
+	rect     rtreego.Rect
+	spr      spr.StandardPlacesResult
+	geometry orb.Geometry
+}
+
+// Bounds implements the rtreego.Spatial interface.
+func (f *indexedFeature) Bounds() rtreego.Rect {
+	return f.rect
+}
+
+// NewSpatialDatabase returns a new SpatialDatabase instance wrapping 'db' configured by 'opts'. If 'opts' is nil the
+// secondary index is not enabled.
+func NewSpatialDatabase(ctx context.Context, db database.SpatialDatabase, opts *SpatialDatabaseOptions) (*SpatialDatabase, error) {
+
+	if opts == nil {
+		opts = &SpatialDatabaseOptions{}
+	}
+
+	sp_db := &SpatialDatabase{
+		index:            newDatabaseIndex(db),
+		mu:               new(sync.RWMutex),
+		index_geometries: opts.IndexGeometries,
+		index_alt_files:  opts.IndexAltFiles,
+	}
+
+	sp_db.last_modified.Store(time.Now().UnixNano())
+
+	return sp_db, nil
+}
+
+// IndexFeature indexes 'body' in the underlying database and, if it is enabled, in the secondary index. Alternate
+// geometry files are only added to the secondary index if the IndexAltFiles option was set. If the record has already
+// been indexed it is replaced. If the record has been removed, and 'body' is not an alternate geometry file, it is
+// included in results again. Unless records are being indexed in bulk the query cache is then purged and the
+// generation is incremented.
+func (db *SpatialDatabase) IndexFeature(ctx context.Context, body []byte) error {
+
+	is_alt := alt.IsAlt(body)
+
+	id, err := properties.Id(body)
+
+	if err != nil {
+		return fmt.Errorf("Failed to derive ID, %w", err)
+	}
+
+	str_id := strconv.FormatInt(id, 10)
+	alt_label := ""
+
+	if is_alt {
+		alt_label, _ = properties.AltLabel(body)
+	}
+
+	var f *indexedFeature
+
+	if db.index_geometries && (!is_alt || db.index_alt_files) {
+
+		f, err = newIndexedFeature(body)
+
+		if err != nil {
+			return fmt.Errorf("Failed to create secondary index record for %d, %w", id, err)
+		}
+	}
+
+	idx := db.current()
+
+	err = idx.IndexFeature(ctx, body)
+
+	if err != nil {
+		return err
+	}
+
+	db.mu.Lock()
+
+	if !is_alt {
+		delete(idx.removed, str_id)
+	}
+
+	if f != nil {
+
+		alts, ok := idx.features[str_id]
+
+		if !ok {
+			alts = make(map[string]*indexedFeature)
+			idx.features[str_id] = alts
+		}
+
+		if !is_alt {
+
+			existing, ok := alts[alt_label]
+
+			if ok {
+				idx.rtree.Delete(existing)
+			}
+
+			idx.rtree.Insert(f)
+		}
+
+		alts[alt_label] = f
+	}
+
+	db.mu.Unlock()
+
+	// The cache is only purged, and the generation incremented, once the record can be queried so that queries
+	// performed in the meantime can not be cached, or tagged, as though they included it.
+
+	if !db.bulk.Load() {
+		db.cache.Purge()
+		db.modified()
+	}
+
+	return nil
+}
+
+// RemoveFeature removes the record 'id', and any alternate geometries for it, from the results of the underlying database
+// and from the secondary index.
+func (db *SpatialDatabase) RemoveFeature(ctx context.Context, id string) error {
+
+	idx := db.current()
+
+	db.mu.Lock()
+
+	idx.removed[id] = true
+
+	existing, ok := idx.features[id][""]
+
+	if ok {
+		idx.rtree.Delete(existing)
+	}
+
+	delete(idx.features, id)
+
+	db.mu.Unlock()
+
+	db.cache.Purge()
+	db.modified()
+
+	return nil
+}
+
+// Rebuild replaces the underlying database and secondary index with 'fresh' once the records in it have been indexed
+// by 'index_func'. 'index_func' is passed a new SpatialDatabase instance wrapping 'fresh' which should be used to index
+// records, and queries continue to be performed using the existing database until it returns. If 'index_func' returns
+// an error the existing database is left in place. The replaced database is not disconnected since queries which are
+// still in progress may be using it. Records indexed or removed using the existing database while it is being rebuilt
+// are not carried over.
+func (db *SpatialDatabase) Rebuild(ctx context.Context, fresh database.SpatialDatabase, index_func func(context.Context, *SpatialDatabase) error) error {
+
+	opts := &SpatialDatabaseOptions{
+		IndexGeometries: db.index_geometries,
+		IndexAltFiles:   db.index_alt_files,
+	}
+
+	staging, err := NewSpatialDatabase(ctx, fresh, opts)
+
+	if err != nil {
+		return fmt.Errorf("Failed to create staging database, %w", err)
+	}
+
+	err = index_func(ctx, staging)
+
+	if err != nil {
+		return fmt.Errorf("Failed to index staging database, %w", err)
+	}
+
+	db.mu.Lock()
+	db.index = staging.index
+	db.mu.Unlock()
+
+	db.cache.Purge()
+	db.modified()
+
+	return nil
+}
+
+// PointInPolygon returns the records in the underlying database that contain 'coord' and match 'filters'.
+func (db *SpatialDatabase) PointInPolygon(ctx context.Context, coord *orb.Point, filters ...spatial.Filter) (spr.StandardPlacesResults, error) {
+
+	idx := db.current()
+
+	rsp, err := idx.PointInPolygon(ctx, coord, filters...)
+
+	if err != nil || rsp == nil || !db.hasRemoved(idx) {
+		return rsp, err
+	}
+
+	places := make([]spr.StandardPlacesResult, 0)
+
+	for _, r := range rsp.Results() {
+
+		if !db.isRemoved(idx, r.Id()) {
+			places = append(places, r)
+		}
+	}
+
+	return NewPointInPolygonResults(places), nil
+}
+
+// PointInPolygonCandidates returns the candidates in the underlying database whose bounds contain 'coord'.
+func (db *SpatialDatabase) PointInPolygonCandidates(ctx context.Context, coord *orb.Point, filters ...spatial.Filter) ([]*spatial.PointInPolygonCandidate, error) {
+
+	idx := db.current()
+
+	candidates, err := idx.PointInPolygonCandidates(ctx, coord, filters...)
+
+	if err != nil || candidates == nil || !db.hasRemoved(idx) {
+		return candidates, err
+	}
+
+	possible := make([]*spatial.PointInPolygonCandidate, 0)
+
+	for _, c := range candidates {
+
+		if !db.isRemoved(idx, c.FeatureId) {
+			possible = append(possible, c)
+		}
+	}
+
+	return possible, nil
+}
+
+// PointInPolygonWithChannels dispatches the records in the underlying database that contain 'coord' and match
+// 'filters' to 'rsp_ch'.
+func (db *SpatialDatabase) PointInPolygonWithChannels(ctx context.Context, rsp_ch chan spr.StandardPlacesResult, err_ch chan error, done_ch chan bool, coord *orb.Point, filters ...spatial.Filter) {
+
+	idx := db.current()
+
+	if !db.hasRemoved(idx) {
+		idx.PointInPolygonWithChannels(ctx, rsp_ch, err_ch, done_ch, coord, filters...)
+		return
+	}
+
+	idx_rsp_ch := make(chan spr.StandardPlacesResult)
+	idx_done_ch := make(chan bool)
+
+	go idx.PointInPolygonWithChannels(ctx, idx_rsp_ch, err_ch, idx_done_ch, coord, filters...)
+
+	for {
+		select {
+		case r := <-idx_rsp_ch:
+
+			if !db.isRemoved(idx, r.Id()) {
+				rsp_ch <- r
+			}
+
+		case <-idx_done_ch:
+			done_ch <- true
+			return
+		}
+	}
+}
+
+// PointInPolygonCandidatesWithChannels dispatches the candidates in the underlying database whose bounds contain
+// 'coord' to 'rsp_ch'.
+func (db *SpatialDatabase) PointInPolygonCandidatesWithChannels(ctx context.Context, rsp_ch chan *spatial.PointInPolygonCandidate, err_ch chan error, done_ch chan bool, coord *orb.Point, filters ...spatial.Filter) {
+
+	idx := db.current()
+
+	if !db.hasRemoved(idx) {
+		idx.PointInPolygonCandidatesWithChannels(ctx, rsp_ch, err_ch, done_ch, coord, filters...)
+		return
+	}
+
+	idx_rsp_ch := make(chan *spatial.PointInPolygonCandidate)
+	idx_done_ch := make(chan bool)
+
+	go idx.PointInPolygonCandidatesWithChannels(ctx, idx_rsp_ch, err_ch, idx_done_ch, coord, filters...)
+
+	for {
+		select {
+		case c := <-idx_rsp_ch:
+
+			if !db.isRemoved(idx, c.FeatureId) {
+				rsp_ch <- c
+			}
+
+		case <-idx_done_ch:
+			done_ch <- true
+			return
+		}
+	}
+}
+
+// Disconnect disconnects the underlying database.
+func (db *SpatialDatabase) Disconnect(ctx context.Context) error {
+	return db.current().Disconnect(ctx)
+}
+
+// Read reads the record 'uri' from the underlying database. An error is returned if the record has been removed.
+func (db *SpatialDatabase) Read(ctx context.Context, str_uri string) (io.ReadSeekCloser, error) {
+
+	idx := db.current()
+
+	if db.hasRemoved(idx) {
+
+		id, _, err := uri.ParseURI(str_uri)
+
+		if err == nil && db.isRemoved(idx, strconv.FormatInt(id, 10)) {
+			return nil, fmt.Errorf("Record %d has been removed", id)
+		}
+	}
+
+	return idx.Read(ctx, str_uri)
+}
+
+// ReaderURI returns the URI of the record 'uri' in the underlying database.
+func (db *SpatialDatabase) ReaderURI(ctx context.Context, uri string) string {
+	return db.current().ReaderURI(ctx, uri)
+}
+
+// Write writes 'r' to 'uri' in the underlying database.
+func (db *SpatialDatabase) Write(ctx context.Context, uri string, r io.ReadSeeker) (int64, error) {
+	return db.current().Write(ctx, uri, r)
+}
+
+// WriterURI returns the URI that 'uri' is written to in the underlying database.
+func (db *SpatialDatabase) WriterURI(ctx context.Context, uri string) string {
+	return db.current().WriterURI(ctx, uri)
+}
+
+// Flush flushes the underlying database.
+func (db *SpatialDatabase) Flush(ctx context.Context) error {
+	return db.current().Flush(ctx)
+}
+
+// Close closes the underlying database.
+func (db *SpatialDatabase) Close(ctx context.Context) error {
+	return db.current().Close(ctx)
+}
+
+// SetLogger assigns 'logger' to the underlying database.
+func (db *SpatialDatabase) SetLogger(ctx context.Context, logger *log.Logger) error {
+	return db.current().SetLogger(ctx, logger)
+}
+
+// SetQueryCache assigns 'c' as the cache for point-in-polygon results performed using the database. If 'c' is nil
+// results are not cached.
+func (db *SpatialDatabase) SetQueryCache(c *QueryCache) {
+	db.cache = c
+}
+
+// QueryCache returns the cache for point-in-polygon results performed using the database or nil if there is none.
+// While records are being indexed in bulk nil is returned so that results are not cached.
+func (db *SpatialDatabase) QueryCache() *QueryCache {
+
+	if db.bulk.Load() {
+		return nil
+	}
+
+	return db.cache
+}
+
+// StartBulkIndexing signals that a large number of records, for example all the records in a data release, are about
+// to be indexed. Until StopBulkIndexing is called the query cache is not used and is not purged, and the generation is
+// not incremented, as each record is indexed.
+func (db *SpatialDatabase) StartBulkIndexing() {
+	db.bulk.Store(true)
+}
+
+// StopBulkIndexing signals that the records started by StartBulkIndexing have been indexed, purging the query cache
+// and incrementing the generation once.
+func (db *SpatialDatabase) StopBulkIndexing() {
+
+	db.bulk.Store(false)
+
+	db.cache.Purge()
+	db.modified()
+}
+
+// IndexAltFiles returns true if the IndexAltFiles option was set, which is to say the underlying database is expected
+// to index alternate geometry files.
+func (db *SpatialDatabase) IndexAltFiles() bool {
+	return db.index_alt_files
+}
+
+// SetRelease assigns 'label' as the label of the data release being indexed. It should be called before the database
+// is queried.
+func (db *SpatialDatabase) SetRelease(label string) {
+	db.release = label
+}
+
+// Release returns the label of the data release being indexed, if known.
+func (db *SpatialDatabase) Release() string {
+	return db.release
+}
+
+// Generation returns a counter which is incremented every time a record is indexed or removed, and once for all the
+// records indexed between calls to StartBulkIndexing and StopBulkIndexing.
+func (db *SpatialDatabase) Generation() uint64 {
+	return db.generation.Load()
+}
+
+// LastModified returns the time that a record was last indexed or removed or, if none have been, the time that the
+// database was created.
+func (db *SpatialDatabase) LastModified() time.Time {
+	return time.Unix(0, db.last_modified.Load())
+}
+
+// IndexedFeature returns the standard places response and geometry for the record with ID 'id' from the secondary index.
+// Alternate geometries are identified using a spatial ID of the form "{ID}#{ALT_LABEL}".
+func (db *SpatialDatabase) IndexedFeature(ctx context.Context, id string) (spr.StandardPlacesResult, orb.Geometry, error) {
+
+	if !db.index_geometries {
+		return nil, nil, errGeometriesNotIndexed()
+	}
+
+	str_id, alt_label, _ := strings.Cut(id, "#")
+
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	f, ok := db.index.features[str_id][alt_label]
+
+	if !ok {
+		return nil, nil, fmt.Errorf("Record %s is not indexed", id)
+	}
+
+	return f.spr, f.geometry, nil
+}
+
+// Intersects returns the records whose geometries intersect 'geom' and match 'filters'.
+func (db *SpatialDatabase) Intersects(ctx context.Context, geom orb.Geometry, filters ...spatial.Filter) (spr.StandardPlacesResults, error) {
+	return db.Relate(ctx, geom, INTERSECTS, filters...)
+}
+
+// Relate returns the records whose geometries have the relationship 'relation' with 'geom' and match 'filters'.
+// Candidates are selected by comparing their bounds with those of 'geom' and then tested exactly.
+func (db *SpatialDatabase) Relate(ctx context.Context, geom orb.Geometry, relation Relation, filters ...spatial.Filter) (spr.StandardPlacesResults, error) {
+
+	if !db.index_geometries {
+		return nil, errGeometriesNotIndexed()
+	}
+
+	var test func(orb.Geometry) bool
+
+	switch relation {
+	case INTERSECTS:
+
+		test = func(f_geom orb.Geometry) bool {
+			return geometriesIntersect(f_geom, geom)
+		}
+
+	case CONTAINS:
+
+		test = func(f_geom orb.Geometry) bool {
+			return geometryContains(f_geom, geom)
+		}
+
+	case WITHIN:
+
+		test = func(f_geom orb.Geometry) bool {
+			return geometryContains(geom, f_geom)
+		}
+
+	default:
+		return nil, fmt.Errorf("Invalid or unsupported relation '%s'", relation)
+	}
+
+	return db.search(ctx, geom.Bound(), test, filters...)
+}
+
+// WithinDistance returns the records whose geometries are within 'distance' metres of 'pt' and match 'filters'.
+func (db *SpatialDatabase) WithinDistance(ctx context.Context, pt *orb.Point, distance float64, filters ...spatial.Filter) (spr.StandardPlacesResults, error) {
+
+	if !db.index_geometries {
+		return nil, errGeometriesNotIndexed()
+	}
+
+	test := func(f_geom orb.Geometry) bool {
+		return distanceToGeometry(*pt, f_geom) <= distance
+	}
+
+	return db.search(ctx, boundWithRadius(*pt, distance), test, filters...)
+}
+
+// Nearest returns the records within 'max_distance' metres of 'pt' that match 'filters' as NearestPlacesResult
+// instances ordered by their distance from 'pt'.
+func (db *SpatialDatabase) Nearest(ctx context.Context, pt *orb.Point, max_distance float64, filters ...spatial.Filter) (spr.StandardPlacesResults, error) {
+
+	if !db.index_geometries {
+		return nil, errGeometriesNotIndexed()
+	}
+
+	candidates, err := db.candidates(ctx, boundWithRadius(*pt, max_distance), filters...)
+
+	if err != nil {
+		return nil, err
+	}
+
+	nearest := make([]*NearestPlacesResult, 0)
+
+	for _, f := range candidates {
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		default:
+			// pass
+		}
+
+		d := distanceToGeometry(*pt, f.geometry)
+
+		if d > max_distance {
+			continue
+		}
+
+		r := &NearestPlacesResult{
+			StandardPlacesResult: f.spr,
+			Distance:             d,
+			MatchType:            NEAREST_MATCH,
+		}
+
+		nearest = append(nearest, r)
+	}
+
+	sort.SliceStable(nearest, func(i int, j int) bool {
+		return nearest[i].Distance < nearest[j].Distance
+	})
+
+	places := make([]spr.StandardPlacesResult, len(nearest))
+
+	for idx, r := range nearest {
+		places[idx] = r
+	}
+
+	return NewPointInPolygonResults(places), nil
+}
+
+// current returns the underlying database and secondary index currently in use.
+func (db *SpatialDatabase) current() *databaseIndex {
+
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	return db.index
+}
+
+// hasRemoved returns true if any records have been removed from 'idx'.
+func (db *SpatialDatabase) hasRemoved(idx *databaseIndex) bool {
+
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	return len(idx.removed) > 0
+}
+
+// isRemoved returns true if the record 'id' has been removed from 'idx'.
+func (db *SpatialDatabase) isRemoved(idx *databaseIndex, id string) bool {
+
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	return idx.removed[id]
+}
+
+// modified records that a record has been indexed or removed.
+func (db *SpatialDatabase) modified() {
+	db.last_modified.Store(time.Now().UnixNano())
+	db.generation.Add(1)
+}
+
+// search returns the records whose bounds intersect 'bounds', whose standard places responses match 'filters'
+// and whose geometries satisfy 'test'.
+func (db *SpatialDatabase) search(ctx context.Context, bounds orb.Bound, test func(orb.Geometry) bool, filters ...spatial.Filter) (spr.StandardPlacesResults, error) {
+
+	candidates, err := db.candidates(ctx, bounds, filters...)
+
+	if err != nil {
+		return nil, err
+	}
+
+	places := make([]spr.StandardPlacesResult, 0)
+
+	for _, f := range candidates {
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		default:
+			// pass
+		}
+
+		if !test(f.geometry) {
+			continue
+		}
+
+		places = append(places, f.spr)
+	}
+
+	return NewPointInPolygonResults(places), nil
+}
+
+// candidates returns the records whose bounds intersect 'bounds' and whose standard places responses match 'filters'.
+func (db *SpatialDatabase) candidates(ctx context.Context, bounds orb.Bound, filters ...spatial.Filter) ([]*indexedFeature, error) {
+
+	rect, err := rtreego.NewRectFromPoints(rtreego.Point{bounds.Min.X(), bounds.Min.Y()}, rtreego.Point{bounds.Max.X(), bounds.Max.Y()})
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to derive search bounds, %w", err)
+	}
+
+	db.mu.RLock()
+	possible := db.index.rtree.SearchIntersect(rect)
+	db.mu.RUnlock()
+
+	candidates := make([]*indexedFeature, 0)
+
+	for _, row := range possible {
+
+		f := row.(*indexedFeature)
+
+		if !matchesFilters(f.spr, filters...) {
+			continue
+		}
+
+		candidates = append(candidates, f)
+	}
+
+	return candidates, nil
+}
+
+// newIndexedFeature returns a new indexedFeature instance for the record 'body'.
+func newIndexedFeature(body []byte) (*indexedFeature, error) {
+
+	var s spr.StandardPlacesResult
+	var err error
+
+	if alt.IsAlt(body) {
+		s, err = spr.WhosOnFirstAltSPR(body)
+	} else {
+		s, err = spr.WhosOnFirstSPR(body)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to derive SPR, %w", err)
+	}
+
+	geojson_geom, err := geometry.Geometry(body)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to derive geometry, %w", err)
+	}
+
+	orb_geom := geojson_geom.Geometry()
+	bounds := orb_geom.Bound()
+
+	rect, err := rtreego.NewRectFromPoints(rtreego.Point{bounds.Min.X(), bounds.Min.Y()}, rtreego.Point{bounds.Max.X(), bounds.Max.Y()})
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to derive bounds, %w", err)
+	}
+
+	f := &indexedFeature{
+		rect:     rect,
+		spr:      s,
+		geometry: orb_geom,
+	}
+
+	return f, nil
+}
+
+// errGeometriesNotIndexed returns the error for queries that require the secondary index when it is not enabled.
+func errGeometriesNotIndexed() error {
+	return NewError(UNSUPPORTED_QUERY, fmt.Errorf("Spatial database does not index geometries"))
+}
+
+// newDatabaseIndex returns a new databaseIndex instance for 'db' with an empty secondary index.
+func newDatabaseIndex(db database.SpatialDatabase) *databaseIndex {
+
+	idx := &databaseIndex{
+		SpatialDatabase: db,
+		rtree:           rtreego.NewTree(2, 25, 50),
+		features:        make(map[string]map[string]*indexedFeature),
+		removed:         make(map[string]bool),
+	}
+
+	return idx
+}
+
+// matchesFilters returns true if 's' matches all of 'filters'.
+func matchesFilters(s spr.StandardPlacesResult, filters ...spatial.Filter) bool {
+
+	for _, f := range filters {
+
+		err := filter.FilterSPR(f, s)
+
+		if err != nil {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,182 @@
+package pip
+
+import (
+	"context"
+	"fmt"
+	spatial_app "github.com/whosonfirst/go-whosonfirst-spatial/app"
+	"github.com/whosonfirst/go-whosonfirst-spatial/database"
+	"github.com/whosonfirst/go-whosonfirst-uri"
+	"io"
+	"log"
+	"slices"
+	"strconv"
+	"testing"
+)
+
+// testFeature returns a Who's On First GeoJSON feature for the record 'id', of placetype 'pt', whose geometry is the
+// bounding box 'bbox' (min x, min y, max x, max y).
+func testFeature(id int64, pt string, bbox [4]float64) []byte {
+
+	min_x, min_y, max_x, max_y := bbox[0], bbox[1], bbox[2], bbox[3]
+
+	coords := fmt.Sprintf("[[[%v,%v],[%v,%v],[%v,%v],[%v,%v],[%v,%v]]]", min_x, min_y, max_x, min_y, max_x, max_y, min_x, max_y, min_x, min_y)
+
+	return []byte(fmt.Sprintf(`{"type":"Feature","id":%d,"properties":{"wof:id":%d,"wof:name":"Test %d","wof:placetype":"%s","wof:parent_id":-1,"wof:repo":"whosonfirst-data-test","wof:lastmodified":1,"mz:is_current":1,"geom:latitude":%v,"geom:longitude":%v},"bbox":[%v,%v,%v,%v],"geometry":{"type":"Polygon","coordinates":%s}}`,
+		id, id, id, pt, (min_y+max_y)/2, (min_x+max_x)/2, min_x, min_y, max_x, max_y, coords))
+}
+
+func TestSpatialDatabaseRemoveFeature(t *testing.T) {
+
+	ctx := context.Background()
+
+	spatial_db, err := database.NewSpatialDatabase(ctx, "rtree://")
+
+	if err != nil {
+		t.Fatalf("Failed to create spatial database, %v", err)
+	}
+
+	db, err := NewSpatialDatabase(ctx, spatial_db, &SpatialDatabaseOptions{IndexGeometries: true})
+
+	if err != nil {
+		t.Fatalf("Failed to create spatial database, %v", err)
+	}
+
+	app := &spatial_app.SpatialApplication{
+		SpatialDatabase:  db,
+		PropertiesReader: db,
+		Logger:           log.New(io.Discard, "", 0),
+		Monitor:          NewRequestMonitor(),
+	}
+
+	// More than 50 overlapping records, some of whose IDs are prefixes of the others, so that removing
+	// one record must not remove any record whose ID merely starts with, or contains, the same digits
+
+	ids := []int64{101, 1010, 10155, 101550}
+
+	for id := int64(10100); id < 10160; id++ {
+
+		if id != 10155 {
+			ids = append(ids, id)
+		}
+	}
+
+	for _, id := range ids {
+
+		err := db.IndexFeature(ctx, testFeature(id, "locality", [4]float64{0, 0, 1, 1}))
+
+		if err != nil {
+			t.Fatalf("Failed to index %d, %v", id, err)
+		}
+	}
+
+	expected := make([]string, len(ids))
+
+	for idx, id := range ids {
+		expected[idx] = strconv.FormatInt(id, 10)
+	}
+
+	slices.Sort(expected)
+
+	tests := []struct {
+		name    string
+		remove  []string
+		reindex []int64
+		removed []string
+	}{
+		{name: "indexed"},
+		{name: "remove", remove: []string{"1010"}, removed: []string{"1010"}},
+		{name: "remove again", remove: []string{"10155", "1010"}, removed: []string{"1010", "10155"}},
+		{name: "unknown", remove: []string{"999"}, removed: []string{"1010", "10155"}},
+		{name: "reindex", reindex: []int64{1010}, removed: []string{"10155"}},
+		{name: "reindex all", reindex: []int64{10155}},
+	}
+
+	for _, tt := range tests {
+
+		t.Run(tt.name, func(t *testing.T) {
+
+			generation := db.Generation()
+
+			for _, id := range tt.remove {
+
+				err := db.RemoveFeature(ctx, id)
+
+				if err != nil {
+					t.Fatalf("Failed to remove %s, %v", id, err)
+				}
+			}
+
+			for _, id := range tt.reindex {
+
+				err := db.IndexFeature(ctx, testFeature(id, "locality", [4]float64{0, 0, 1, 1}))
+
+				if err != nil {
+					t.Fatalf("Failed to index %d, %v", id, err)
+				}
+			}
+
+			changes := uint64(len(tt.remove) + len(tt.reindex))
+
+			if db.Generation() != generation+changes {
+				t.Fatalf("Expected generation %d, got %d", generation+changes, db.Generation())
+			}
+
+			remaining := make([]string, 0)
+
+			for _, id := range expected {
+
+				if !slices.Contains(tt.removed, id) {
+					remaining = append(remaining, id)
+				}
+			}
+
+			pip_rsp, err := QueryPointInPolygon(ctx, app, &PointInPolygonRequest{Latitude: 0.5, Longitude: 0.5})
+
+			if err != nil {
+				t.Fatalf("Failed to query point in polygon, %v", err)
+			}
+
+			pip_ids := resultIds(pip_rsp)
+
+			if !slices.Equal(pip_ids, remaining) {
+				t.Fatalf("Expected %d point in polygon results, got %d: %v", len(remaining), len(pip_ids), pip_ids)
+			}
+
+			intersects_rsp, err := QueryIntersects(ctx, app, &IntersectsRequest{BoundingBox: []float64{0.25, 0.25, 0.75, 0.75}})
+
+			if err != nil {
+				t.Fatalf("Failed to query intersects, %v", err)
+			}
+
+			intersects_ids := resultIds(intersects_rsp)
+
+			if !slices.Equal(intersects_ids, remaining) {
+				t.Fatalf("Expected %d intersects results, got %d: %v", len(remaining), len(intersects_ids), intersects_ids)
+			}
+
+			for _, str_id := range expected {
+
+				id, _ := strconv.ParseInt(str_id, 10, 64)
+				rel_path, _ := uri.Id2RelPath(id)
+
+				fh, err := db.Read(ctx, rel_path)
+
+				if slices.Contains(tt.removed, str_id) {
+
+					if err == nil {
+						fh.Close()
+						t.Fatalf("Expected reading removed record %s to fail", str_id)
+					}
+
+					continue
+				}
+
+				if err != nil {
+					t.Fatalf("Failed to read %s, %v", str_id, err)
+				}
+
+				fh.Close()
+			}
+		})
+	}
+}
@@ -0,0 +1,138 @@
+package pip
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/whosonfirst/go-whosonfirst-flags/date"
+	"github.com/whosonfirst/go-whosonfirst-flags/placetypes"
+	"strings"
+)
+
+// ErrorCode is a stable, machine-readable code describing why a request failed.
+type ErrorCode string
+
+// INVALID_COORDINATE is the code for errors caused by a missing, malformed or out of range latitude or longitude.
+const INVALID_COORDINATE ErrorCode = "invalid_coordinate"
+
+// INVALID_PLACETYPE is the code for errors caused by an unknown placetype.
+const INVALID_PLACETYPE ErrorCode = "invalid_placetype"
+
+// INVALID_EDTF is the code for errors caused by an invalid inception or cessation EDTF date.
+const INVALID_EDTF ErrorCode = "invalid_edtf"
+
+// INVALID_PARAMETER is the code for errors caused by any other invalid request parameter.
+const INVALID_PARAMETER ErrorCode = "invalid_parameter"
+
+// INVALID_REQUEST is the code for errors caused by a request that can not be parsed.
+const INVALID_REQUEST ErrorCode = "invalid_request"
+
+// INDEX_NOT_READY is the code for errors caused by a request issued while records are still being indexed.
+const INDEX_NOT_READY ErrorCode = "index_not_ready"
+
+// UNSUPPORTED_QUERY is the code for errors caused by a query that the spatial database does not support.
+const UNSUPPORTED_QUERY ErrorCode = "unsupported_query"
+
+// BACKEND_ERROR is the code for errors raised by the spatial database or properties reader.
+const BACKEND_ERROR ErrorCode = "backend_error"
+
+// Error is an error with an associated ErrorCode.
+type Error struct {
+	// The code describing why the request failed.
+	Code ErrorCode
+	// The underlying error.
+	Err error
+}
+
+// NewError returns a new Error instance wrapping 'err' with the code 'code'.
+func NewError(code ErrorCode, err error) *Error {
+
+	e := &Error{
+		Code: code,
+		Err:  err,
+	}
+
+	return e
+}
+
+// Error returns the message of the underlying error.
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the underlying error.
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// ErrorCodeWithError returns the code for the first Error instance in the chain of errors wrapped by 'err' and true,
+// or an empty string and false if there is no Error instance.
+func ErrorCodeWithError(err error) (ErrorCode, bool) {
+
+	var pip_err *Error
+
+	if !errors.As(err, &pip_err) {
+		return "", false
+	}
+
+	return pip_err.Code, true
+}
+
+// backendError returns a new BACKEND_ERROR Error wrapping 'err' and described by 'msg' unless 'err' already wraps an
+// Error instance, for example because the spatial database does not support a query, in which case its code is kept.
+func backendError(msg string, err error) error {
+
+	code, ok := ErrorCodeWithError(err)
+
+	if !ok {
+		code = BACKEND_ERROR
+	}
+
+	return NewError(code, fmt.Errorf("%s, %w", msg, err))
+}
+
+// noResultsError returns the error for a spatial database query that returned neither results nor an error, which
+// some databases (like rtree://) do when 'ctx' is cancelled.
+func noResultsError(ctx context.Context) error {
+
+	err := ctx.Err()
+
+	if err != nil {
+		return err
+	}
+
+	return NewError(BACKEND_ERROR, fmt.Errorf("Spatial database did not return any results"))
+}
+
+// filterErrorCode returns the code for an error returned when deriving a filter from 'req'. Since the underlying
+// filter package does not distinguish between invalid inputs the placetypes and dates in 'req' are checked to see
+// which caused the error.
+func filterErrorCode(req *PointInPolygonRequest) ErrorCode {
+
+	for _, raw := range req.Placetypes {
+
+		for _, pt := range strings.Split(raw, ",") {
+
+			_, err := placetypes.NewPlacetypeFlag(strings.TrimSpace(pt))
+
+			if err != nil {
+				return INVALID_PLACETYPE
+			}
+		}
+	}
+
+	for _, d := range []string{req.InceptionDate, req.CessationDate} {
+
+		if d == "" {
+			continue
+		}
+
+		_, err := date.NewEDTFDateFlag(d)
+
+		if err != nil {
+			return INVALID_EDTF
+		}
+	}
+
+	return INVALID_PARAMETER
+}
@@ -0,0 +1,127 @@
+package pip
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestErrorCodeWithError(t *testing.T) {
+
+	tests := []struct {
+		name string
+		err  error
+		code ErrorCode
+		ok   bool
+	}{
+		{name: "error", err: NewError(INVALID_PLACETYPE, fmt.Errorf("Unknown placetype")), code: INVALID_PLACETYPE, ok: true},
+		{name: "wrapped", err: fmt.Errorf("Failed to query, %w", NewError(INVALID_EDTF, fmt.Errorf("Invalid date"))), code: INVALID_EDTF, ok: true},
+		{name: "outermost", err: NewError(BACKEND_ERROR, NewError(INVALID_PARAMETER, fmt.Errorf("Invalid value"))), code: BACKEND_ERROR, ok: true},
+		{name: "plain", err: fmt.Errorf("Something went wrong"), ok: false},
+		{name: "nil", err: nil, ok: false},
+	}
+
+	for _, tt := range tests {
+
+		t.Run(tt.name, func(t *testing.T) {
+
+			code, ok := ErrorCodeWithError(tt.err)
+
+			if ok != tt.ok || code != tt.code {
+				t.Fatalf("Expected %s (%t), got %s (%t)", tt.code, tt.ok, code, ok)
+			}
+		})
+	}
+}
+
+func TestError(t *testing.T) {
+
+	cause := fmt.Errorf("Invalid value")
+	err := NewError(INVALID_PARAMETER, cause)
+
+	if err.Error() != "Invalid value" {
+		t.Fatalf("Expected the underlying error message, got '%s'", err.Error())
+	}
+
+	if !errors.Is(err, cause) {
+		t.Fatalf("Expected error to wrap its cause")
+	}
+}
+
+func TestBackendError(t *testing.T) {
+
+	tests := []struct {
+		name string
+		err  error
+		code ErrorCode
+	}{
+		{name: "plain", err: fmt.Errorf("Connection refused"), code: BACKEND_ERROR},
+		{name: "unsupported", err: NewError(UNSUPPORTED_QUERY, fmt.Errorf("Not supported")), code: UNSUPPORTED_QUERY},
+		{name: "wrapped", err: fmt.Errorf("Failed, %w", NewError(INVALID_PARAMETER, fmt.Errorf("Invalid"))), code: INVALID_PARAMETER},
+	}
+
+	for _, tt := range tests {
+
+		t.Run(tt.name, func(t *testing.T) {
+
+			err := backendError("Failed to perform query", tt.err)
+
+			code, _ := ErrorCodeWithError(err)
+
+			if code != tt.code {
+				t.Fatalf("Expected %s, got %s", tt.code, code)
+			}
+
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("Expected backend error to wrap the original error")
+			}
+		})
+	}
+}
+
+func TestNoResultsError(t *testing.T) {
+
+	err := noResultsError(context.Background())
+
+	code, _ := ErrorCodeWithError(err)
+
+	if code != BACKEND_ERROR {
+		t.Fatalf("Expected %s, got %v", BACKEND_ERROR, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err = noResultsError(ctx)
+
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected context.Canceled, got %v", err)
+	}
+}
+
+func TestFilterErrorCode(t *testing.T) {
+
+	tests := []struct {
+		name string
+		req  *PointInPolygonRequest
+		code ErrorCode
+	}{
+		{name: "placetype", req: &PointInPolygonRequest{Placetypes: []string{"locality,planet-x"}}, code: INVALID_PLACETYPE},
+		{name: "inception", req: &PointInPolygonRequest{Placetypes: []string{"locality"}, InceptionDate: "not-a-date"}, code: INVALID_EDTF},
+		{name: "cessation", req: &PointInPolygonRequest{CessationDate: "not-a-date"}, code: INVALID_EDTF},
+		{name: "other", req: &PointInPolygonRequest{InceptionDate: "2000"}, code: INVALID_PARAMETER},
+	}
+
+	for _, tt := range tests {
+
+		t.Run(tt.name, func(t *testing.T) {
+
+			code := filterErrorCode(tt.req)
+
+			if code != tt.code {
+				t.Fatalf("Expected %s, got %s", tt.code, code)
+			}
+		})
+	}
+}
@@ -0,0 +1,241 @@
+package pip
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/sfomuseum/go-timings"
+	"github.com/whosonfirst/go-whosonfirst-flags/date"
+	"github.com/whosonfirst/go-whosonfirst-flags/geometry"
+	"github.com/whosonfirst/go-whosonfirst-flags/placetypes"
+	"github.com/whosonfirst/go-whosonfirst-spatial"
+	spatial_app "github.com/whosonfirst/go-whosonfirst-spatial/app"
+	"github.com/whosonfirst/go-whosonfirst-spatial/geo"
+	"github.com/whosonfirst/go-whosonfirst-spr/v2"
+)
+
+const timingsPIPExplainCandidates string = "PIP explain candidates"
+
+const timingsPIPExplainTests string = "PIP explain tests"
+
+const ExplainFlag string = "explain"
+
+// Explanation describes how the results for a point-in-polygon query were derived.
+type Explanation struct {
+	// Every candidate whose bounding box contains the coordinate.
+	Candidates []*ExplainCandidate `json:"candidates"`
+	// The timings for each stage of the query.
+	Timings []*timings.SinceResponse `json:"timings"`
+}
+
+// ExplainCandidate describes whether, and why, a bounding box candidate for a point-in-polygon query was matched.
+type ExplainCandidate struct {
+	// The spatial ID of the candidate.
+	Id string `json:"id"`
+	// The ID of the record that the candidate belongs to.
+	FeatureId string `json:"feature_id"`
+	// The alternate geometry label of the candidate, if it is an alternate geometry.
+	AltLabel string `json:"alt_label,omitempty"`
+	// The name of the filter check that rejected the candidate, if any.
+	RejectedBy string `json:"rejected_by,omitempty"`
+	// Whether the candidate's polygons contain the coordinate.
+	Contains bool `json:"contains"`
+	// Whether the candidate passed all the filter checks and contains the coordinate.
+	Matched bool `json:"matched"`
+	// Any error that prevented the candidate from being inspected.
+	Error string `json:"error,omitempty"`
+}
+
+// AppendExplainFlags appends flags for explaining point-in-polygon queries to 'fs'.
+func AppendExplainFlags(fs *flag.FlagSet) error {
+
+	fs.Bool(ExplainFlag, false, "If true include an explanation of how results were derived, including every bounding box candidate and the filter check that rejected it, in the response.")
+	return nil
+}
+
+// ExplanationWithResults returns the explanation for 'rsp' or nil if it was not explained.
+func ExplanationWithResults(rsp spr.StandardPlacesResults) *Explanation {
+
+	pip_rsp, ok := rsp.(*PointInPolygonResults)
+
+	if !ok {
+		return nil
+	}
+
+	return pip_rsp.Explain
+}
+
+// explainPointInPolygon performs a point-in-polygon query for 'req' and returns its results along with an explanation
+// of how they were derived. Timings are collected using a RequestMonitor so that they only reflect this request. If the
+// application's Monitor is not already a RequestMonitor instance a new one is used.
+func explainPointInPolygon(ctx context.Context, app *spatial_app.SpatialApplication, req *PointInPolygonRequest) (spr.StandardPlacesResults, error) {
+
+	explain_app := app
+	m, ok := app.Monitor.(*RequestMonitor)
+
+	if !ok {
+		explain_app, m = NewRequestApplication(app)
+	}
+
+	explain_req := *req
+	explain_req.Explain = false
+
+	rsp, err := QueryPointInPolygon(ctx, explain_app, &explain_req)
+
+	if err != nil {
+		return nil, err
+	}
+
+	candidates, err := explainCandidates(ctx, explain_app, req)
+
+	if err != nil {
+		return nil, err
+	}
+
+	explain := &Explanation{
+		Candidates: candidates,
+		Timings:    m.Timings(),
+	}
+
+	explained := &PointInPolygonResults{
+		Places:     rsp.Results(),
+		Pagination: PaginationWithResults(rsp),
+		Explain:    explain,
+	}
+
+	return explained, nil
+}
+
+// explainCandidates returns every bounding box candidate for the coordinate in 'req' along with whether it passed
+// the filter checks and exact polygon test for 'req'. Candidates can only be inspected if the application's spatial
+// database implements the FeatureIndex interface.
+func explainCandidates(ctx context.Context, app *spatial_app.SpatialApplication, req *PointInPolygonRequest) ([]*ExplainCandidate, error) {
+
+	c, err := geo.NewCoordinate(req.Longitude, req.Latitude)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create new coordinate, %w", err)
+	}
+
+	f, err := NewSPRFilterFromPointInPolygonRequest(req)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create point in polygon filter from request, %w", err)
+	}
+
+	app.Monitor.Signal(ctx, timings.SinceStart, timingsPIPExplainCandidates)
+
+	pip_candidates, err := app.SpatialDatabase.PointInPolygonCandidates(ctx, c)
+
+	app.Monitor.Signal(ctx, timings.SinceStop, timingsPIPExplainCandidates)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to derive point in polygon candidates, %w", err)
+	}
+
+	// Some databases (like rtree://) return no candidates, rather than an error, when 'ctx' is cancelled
+
+	if pip_candidates == nil && ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+
+	idx, has_idx := app.SpatialDatabase.(FeatureIndex)
+
+	app.Monitor.Signal(ctx, timings.SinceStart, timingsPIPExplainTests)
+	defer app.Monitor.Signal(ctx, timings.SinceStop, timingsPIPExplainTests)
+
+	candidates := make([]*ExplainCandidate, len(pip_candidates))
+
+	for i, pip_c := range pip_candidates {
+
+		candidate := &ExplainCandidate{
+			Id:        pip_c.Id,
+			FeatureId: pip_c.FeatureId,
+			AltLabel:  pip_c.AltLabel,
+		}
+
+		candidates[i] = candidate
+
+		if !has_idx {
+			candidate.Error = "Spatial database does not support inspecting candidates"
+			continue
+		}
+
+		feature_id := pip_c.FeatureId
+
+		if pip_c.AltLabel != "" {
+			feature_id = fmt.Sprintf("%s#%s", pip_c.FeatureId, pip_c.AltLabel)
+		}
+
+		s, geom, err := idx.IndexedFeature(ctx, feature_id)
+
+		if err != nil {
+			candidate.Error = err.Error()
+			continue
+		}
+
+		candidate.RejectedBy = rejectedBy(f, s)
+		candidate.Contains = geometryContainsPoint(geom, *c)
+		candidate.Matched = candidate.RejectedBy == "" && candidate.Contains
+	}
+
+	return candidates, nil
+}
+
+// rejectedBy returns the name of the first check in 'f' that 's' fails, or an empty string if it passes them all.
+// Checks are performed in the same order as the `filter.FilterSPR` method.
+func rejectedBy(f spatial.Filter, s spr.StandardPlacesResult) string {
+
+	pf, err := placetypes.NewPlacetypeFlag(s.Placetype())
+
+	if err == nil && !f.HasPlacetypes(pf) {
+		return "placetype"
+	}
+
+	inc_fl, err := date.NewEDTFDateFlagWithDate(s.Inception())
+
+	if err != nil || !f.MatchesInception(inc_fl) {
+		return "inception"
+	}
+
+	cessation_fl, err := date.NewEDTFDateFlagWithDate(s.Cessation())
+
+	if err != nil || !f.MatchesCessation(cessation_fl) {
+		return "cessation"
+	}
+
+	if !f.IsCurrent(s.IsCurrent()) {
+		return "is_current"
+	}
+
+	if !f.IsDeprecated(s.IsDeprecated()) {
+		return "is_deprecated"
+	}
+
+	if !f.IsCeased(s.IsCeased()) {
+		return "is_ceased"
+	}
+
+	if !f.IsSuperseded(s.IsSuperseded()) {
+		return "is_superseded"
+	}
+
+	if !f.IsSuperseding(s.IsSuperseding()) {
+		return "is_superseding"
+	}
+
+	af, err := geometry.NewAlternateGeometryFlag(s.Path())
+
+	if err == nil {
+
+		if !f.IsAlternateGeometry(af) {
+			return "is_alternate_geometry"
+		}
+
+		if !f.HasAlternateGeometry(af) {
+			return "has_alternate_geometry"
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,196 @@
+package pip
+
+import (
+	"context"
+	"github.com/paulmach/orb"
+	"github.com/whosonfirst/go-whosonfirst-spatial"
+	"reflect"
+	"testing"
+)
+
+// altCandidatesDatabase is a SpatialDatabase instance which adds the alternate geometry for 101000001 to the candidates
+// for every coordinate, since the rtree:// database can not index alternate geometry files.
+type altCandidatesDatabase struct {
+	*SpatialDatabase
+}
+
+func (db *altCandidatesDatabase) PointInPolygonCandidates(ctx context.Context, coord *orb.Point, filters ...spatial.Filter) ([]*spatial.PointInPolygonCandidate, error) {
+
+	candidates, err := db.SpatialDatabase.PointInPolygonCandidates(ctx, coord, filters...)
+
+	if err != nil {
+		return nil, err
+	}
+
+	alt_candidate := &spatial.PointInPolygonCandidate{
+		Id:        "101000001#example",
+		FeatureId: "101000001",
+		IsAlt:     true,
+		AltLabel:  "example",
+	}
+
+	return append(candidates, alt_candidate), nil
+}
+
+func TestExplainPointInPolygon(t *testing.T) {
+
+	ctx := context.Background()
+
+	app := newTestApplication(t, &SpatialDatabaseOptions{IndexGeometries: true, IndexAltFiles: true})
+
+	app.SpatialDatabase = &altCandidatesDatabase{
+		SpatialDatabase: app.SpatialDatabase.(*SpatialDatabase),
+	}
+
+	tests := []struct {
+		name     string
+		req      *PointInPolygonRequest
+		expected []string
+		// The candidates keyed by their spatial IDs and the name of the check that rejected them, or "matched".
+		candidates map[string]string
+	}{
+		{
+			name:     "filters",
+			req:      &PointInPolygonRequest{Latitude: 0.75, Longitude: 0.75, IsCurrent: []int64{1}, Placetypes: []string{"locality", "region"}},
+			expected: []string{"101000001", "85000002"},
+			candidates: map[string]string{
+				"101000001#:0":      "matched",
+				"101000002#:0":      "is_current",
+				"85000001#:0":       "placetype",
+				"85000002#:0":       "matched",
+				"101000001#example": "is_current",
+			},
+		},
+		{
+			name:     "no filters",
+			req:      &PointInPolygonRequest{Latitude: 1.25, Longitude: 1.25},
+			expected: []string{"85000001", "85000002"},
+			candidates: map[string]string{
+				"85000001#:0":       "matched",
+				"85000002#:0":       "matched",
+				"101000001#example": "matched",
+			},
+		},
+		{
+			name:     "alternate geometries",
+			req:      &PointInPolygonRequest{Latitude: 1.25, Longitude: 1.25, Geometries: "alternate"},
+			expected: []string{},
+			candidates: map[string]string{
+				"85000001#:0":       "is_alternate_geometry",
+				"85000002#:0":       "is_alternate_geometry",
+				"101000001#example": "matched",
+			},
+		},
+		{
+			name:     "alternate geometry label",
+			req:      &PointInPolygonRequest{Latitude: 1.25, Longitude: 1.25, AlternateGeometries: []string{"example"}},
+			expected: []string{},
+			candidates: map[string]string{
+				"85000001#:0":       "has_alternate_geometry",
+				"85000002#:0":       "has_alternate_geometry",
+				"101000001#example": "matched",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+
+		t.Run(tt.name, func(t *testing.T) {
+
+			tt.req.Explain = true
+
+			rsp, err := QueryPointInPolygon(ctx, app, tt.req)
+
+			if err != nil {
+				t.Fatalf("Failed to query point in polygon, %v", err)
+			}
+
+			ids := resultIds(rsp)
+
+			if !reflect.DeepEqual(ids, tt.expected) {
+				t.Fatalf("Expected %v, got %v", tt.expected, ids)
+			}
+
+			explain := ExplanationWithResults(rsp)
+
+			if explain == nil {
+				t.Fatalf("Expected an explanation")
+			}
+
+			if len(explain.Timings) == 0 {
+				t.Fatalf("Expected explanation to include timings")
+			}
+
+			candidates := make(map[string]string)
+
+			for _, c := range explain.Candidates {
+
+				if c.Error != "" {
+					t.Fatalf("Unexpected error for candidate %s, %s", c.Id, c.Error)
+				}
+
+				if !c.Contains {
+					t.Fatalf("Expected candidate %s to contain coordinate", c.Id)
+				}
+
+				if c.Matched != (c.RejectedBy == "") {
+					t.Fatalf("Candidate %s is matched but was rejected by %s", c.Id, c.RejectedBy)
+				}
+
+				status := c.RejectedBy
+
+				if c.Matched {
+					status = "matched"
+				}
+
+				candidates[c.Id] = status
+			}
+
+			if !reflect.DeepEqual(candidates, tt.candidates) {
+				t.Fatalf("Expected candidates %v, got %v", tt.candidates, candidates)
+			}
+		})
+	}
+}
+
+func TestExplainPointInPolygonUnindexed(t *testing.T) {
+
+	ctx := context.Background()
+
+	// Without the secondary index candidates can be listed but not inspected
+
+	app := newTestApplication(t, nil)
+
+	req := &PointInPolygonRequest{
+		Latitude:  0.75,
+		Longitude: 0.75,
+		IsCurrent: []int64{1},
+		Explain:   true,
+	}
+
+	rsp, err := QueryPointInPolygon(ctx, app, req)
+
+	if err != nil {
+		t.Fatalf("Failed to query point in polygon, %v", err)
+	}
+
+	expected := []string{"101000001", "85000001", "85000002"}
+	ids := resultIds(rsp)
+
+	if !reflect.DeepEqual(ids, expected) {
+		t.Fatalf("Expected %v, got %v", expected, ids)
+	}
+
+	explain := ExplanationWithResults(rsp)
+
+	if explain == nil || len(explain.Candidates) != 4 {
+		t.Fatalf("Expected 4 candidates, got %v", explain)
+	}
+
+	for _, c := range explain.Candidates {
+
+		if c.Error == "" || c.Matched {
+			t.Fatalf("Expected candidate %s to have an error", c.Id)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package pip
+
+import (
+	_ "github.com/whosonfirst/go-whosonfirst-spatial-rtree"
+)
+
+import (
+	"context"
+	"github.com/whosonfirst/go-whosonfirst-iterate/v2/iterator"
+	spatial_app "github.com/whosonfirst/go-whosonfirst-spatial/app"
+	"github.com/whosonfirst/go-whosonfirst-spatial/database"
+	"github.com/whosonfirst/go-whosonfirst-spr/v2"
+	"io"
+	"log"
+	"slices"
+	"testing"
+)
+
+// fixturesPath is the directory containing the test records. It contains a country (85000001), a region (85000002),
+// two localities (101000001 and the ceased 101000002) and a neighbourhood (102000001) nested inside one another around
+// 0,0 as well as an unrelated locality (101000003) between 5,5 and 6,6 and an alternate geometry for 101000001.
+const fixturesPath string = "fixtures/data"
+
+// newTestApplication returns a new spatial_app.SpatialApplication instance whose spatial database is a SpatialDatabase
+// instance, configured by 'opts', wrapping a rtree:// database in which the records in fixturesPath have been indexed.
+func newTestApplication(t *testing.T, opts *SpatialDatabaseOptions) *spatial_app.SpatialApplication {
+
+	t.Helper()
+
+	return newTestApplicationWithDatabaseURI(t, "rtree://", opts)
+}
+
+// newTestApplicationWithDatabaseURI returns a new spatial_app.SpatialApplication instance whose spatial database is a
+// SpatialDatabase instance, configured by 'opts', wrapping the database defined by 'uri' in which the records in
+// fixturesPath have been indexed.
+func newTestApplicationWithDatabaseURI(t *testing.T, uri string, opts *SpatialDatabaseOptions) *spatial_app.SpatialApplication {
+
+	t.Helper()
+
+	ctx := context.Background()
+
+	spatial_db, err := database.NewSpatialDatabase(ctx, uri)
+
+	if err != nil {
+		t.Fatalf("Failed to create spatial database for %s, %v", uri, err)
+	}
+
+	db, err := NewSpatialDatabase(ctx, spatial_db, opts)
+
+	if err != nil {
+		t.Fatalf("Failed to create spatial database, %v", err)
+	}
+
+	iter_cb := func(ctx context.Context, path string, r io.ReadSeeker, args ...interface{}) error {
+
+		body, err := io.ReadAll(r)
+
+		if err != nil {
+			return err
+		}
+
+		return db.IndexFeature(ctx, body)
+	}
+
+	iter, err := iterator.NewIterator(ctx, "directory://", iter_cb)
+
+	if err != nil {
+		t.Fatalf("Failed to create iterator, %v", err)
+	}
+
+	iter.Logger = log.New(io.Discard, "", 0)
+
+	err = iter.IterateURIs(ctx, fixturesPath)
+
+	if err != nil {
+		t.Fatalf("Failed to index fixtures, %v", err)
+	}
+
+	app := &spatial_app.SpatialApplication{
+		SpatialDatabase:  db,
+		PropertiesReader: db,
+		Iterator:         iter,
+		Logger:           log.New(io.Discard, "", 0),
+		Monitor:          NewRequestMonitor(),
+	}
+
+	return app
+}
+
+// resultIds returns the sorted IDs of the records in 'rsp'.
+func resultIds(rsp spr.StandardPlacesResults) []string {
+
+	ids := make([]string, 0)
+
+	if rsp == nil {
+		return ids
+	}
+
+	for _, r := range rsp.Results() {
+		ids = append(ids, r.Id())
+	}
+
+	slices.Sort(ids)
+	return ids
+}
@@ -0,0 +1,448 @@
+package pip
+
+import (
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/planar"
+	"math"
+	"slices"
+)
+
+// EARTH_RADIUS is the mean radius of the Earth, in metres, used to convert between degrees and metres.
+const EARTH_RADIUS float64 = 6371008.8
+
+// metresPerDegree is the length of one degree of latitude, in metres.
+var metresPerDegree = EARTH_RADIUS * math.Pi / 180.0
+
+// boundWithRadius returns the bounding box enclosing a circle of 'radius' metres around 'pt'.
+func boundWithRadius(pt orb.Point, radius float64) orb.Bound {
+
+	d_lat := radius / metresPerDegree
+	d_lon := 180.0
+
+	cos_lat := math.Cos(pt.Y() * math.Pi / 180.0)
+
+	if cos_lat > 0 {
+		d_lon = math.Min(d_lon, d_lat/cos_lat)
+	}
+
+	b := orb.Bound{
+		Min: orb.Point{math.Max(pt.X()-d_lon, -180.0), math.Max(pt.Y()-d_lat, -90.0)},
+		Max: orb.Point{math.Min(pt.X()+d_lon, 180.0), math.Min(pt.Y()+d_lat, 90.0)},
+	}
+
+	return b
+}
+
+// geometryPolygons returns the polygons contained by 'g'. Non-polygonal geometries return an empty list.
+func geometryPolygons(g orb.Geometry) []orb.Polygon {
+
+	switch v := g.(type) {
+	case orb.Polygon:
+		return []orb.Polygon{v}
+	case orb.MultiPolygon:
+		return v
+	case orb.Bound:
+		return []orb.Polygon{v.ToPolygon()}
+	case orb.Ring:
+		return []orb.Polygon{orb.Polygon{v}}
+	case orb.Collection:
+
+		polys := make([]orb.Polygon, 0)
+
+		for _, c := range v {
+			polys = append(polys, geometryPolygons(c)...)
+		}
+
+		return polys
+	default:
+		return nil
+	}
+}
+
+// geometryPoints returns all of the vertices in 'g'.
+func geometryPoints(g orb.Geometry) []orb.Point {
+
+	switch v := g.(type) {
+	case orb.Point:
+		return []orb.Point{v}
+	case orb.MultiPoint:
+		return v
+	case orb.LineString:
+		return v
+	case orb.Ring:
+		return v
+	case orb.MultiLineString:
+
+		pts := make([]orb.Point, 0)
+
+		for _, ls := range v {
+			pts = append(pts, ls...)
+		}
+
+		return pts
+	case orb.Polygon:
+
+		pts := make([]orb.Point, 0)
+
+		for _, r := range v {
+			pts = append(pts, r...)
+		}
+
+		return pts
+	case orb.MultiPolygon:
+
+		pts := make([]orb.Point, 0)
+
+		for _, p := range v {
+			pts = append(pts, geometryPoints(p)...)
+		}
+
+		return pts
+	case orb.Bound:
+		return geometryPoints(v.ToPolygon())
+	case orb.Collection:
+
+		pts := make([]orb.Point, 0)
+
+		for _, c := range v {
+			pts = append(pts, geometryPoints(c)...)
+		}
+
+		return pts
+	default:
+		return nil
+	}
+}
+
+// geometrySegments returns all of the line segments in 'g'. Points and multi points return an empty list.
+func geometrySegments(g orb.Geometry) [][2]orb.Point {
+
+	segments := make([][2]orb.Point, 0)
+
+	appendLine := func(pts []orb.Point) {
+
+		for i := 1; i < len(pts); i++ {
+			segments = append(segments, [2]orb.Point{pts[i-1], pts[i]})
+		}
+	}
+
+	switch v := g.(type) {
+	case orb.LineString:
+		appendLine(v)
+	case orb.Ring:
+		appendLine(v)
+	case orb.MultiLineString:
+
+		for _, ls := range v {
+			appendLine(ls)
+		}
+
+	case orb.Polygon:
+
+		for _, r := range v {
+			appendLine(r)
+		}
+
+	case orb.MultiPolygon:
+
+		for _, p := range v {
+
+			for _, r := range p {
+				appendLine(r)
+			}
+		}
+
+	case orb.Bound:
+		appendLine(v.ToRing())
+	case orb.Collection:
+
+		for _, c := range v {
+			segments = append(segments, geometrySegments(c)...)
+		}
+	}
+
+	return segments
+}
+
+// geometryContainsPoint returns true if 'pt' is contained by any of the polygons in 'g'.
+func geometryContainsPoint(g orb.Geometry, pt orb.Point) bool {
+
+	for _, p := range geometryPolygons(g) {
+
+		if planar.PolygonContains(p, pt) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// geometriesIntersect returns true if 'a' and 'b' share any points in common.
+func geometriesIntersect(a orb.Geometry, b orb.Geometry) bool {
+
+	if !a.Bound().Intersects(b.Bound()) {
+		return false
+	}
+
+	for _, pt := range geometryPoints(b) {
+
+		if geometryContainsPoint(a, pt) {
+			return true
+		}
+	}
+
+	for _, pt := range geometryPoints(a) {
+
+		if geometryContainsPoint(b, pt) {
+			return true
+		}
+	}
+
+	b_segments := geometrySegments(b)
+
+	for _, a_seg := range geometrySegments(a) {
+
+		for _, b_seg := range b_segments {
+
+			if segmentsIntersect(a_seg[0], a_seg[1], b_seg[0], b_seg[1]) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// geometryContains returns true if every point of 'b' is contained by the polygons in 'a'. Specifically every vertex of
+// 'b', and every part of its segments between the points where they touch the boundaries of 'a', must be inside or on
+// the boundary of 'a', none of the segments in 'b' may cross the boundaries of 'a' and no interior ring (hole) of 'a'
+// may be inside 'b'. Non-polygonal geometries do not contain anything.
+func geometryContains(a orb.Geometry, b orb.Geometry) bool {
+
+	polys := geometryPolygons(a)
+
+	if len(polys) == 0 {
+		return false
+	}
+
+	a_bounds := a.Bound()
+	b_bounds := b.Bound()
+
+	if !a_bounds.Contains(b_bounds.Min) || !a_bounds.Contains(b_bounds.Max) {
+		return false
+	}
+
+	covers := func(pt orb.Point) bool {
+
+		for _, p := range polys {
+
+			if polygonCoversPoint(p, pt) {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	for _, pt := range geometryPoints(b) {
+
+		if !covers(pt) {
+			return false
+		}
+	}
+
+	a_points := geometryPoints(a)
+	a_segments := geometrySegments(a)
+
+	for _, b_seg := range geometrySegments(b) {
+
+		for _, a_seg := range a_segments {
+
+			if segmentsCross(b_seg[0], b_seg[1], a_seg[0], a_seg[1]) {
+				return false
+			}
+		}
+
+		// A segment whose end points are inside 'a' may still pass through a hole, or outside a concave
+		// boundary, between the vertices of 'a' that it touches. Check the middle of each of those parts.
+
+		for _, pt := range segmentMidpoints(b_seg[0], b_seg[1], a_points) {
+
+			if !covers(pt) {
+				return false
+			}
+		}
+	}
+
+	// A hole which is entirely inside 'b' is not crossed by any of its segments
+
+	b_segments := geometrySegments(b)
+
+	for _, p := range polys {
+
+		for _, hole := range p[1:] {
+
+			for _, seg := range geometrySegments(hole) {
+
+				mid := orb.Point{(seg[0].X() + seg[1].X()) / 2, (seg[0].Y() + seg[1].Y()) / 2}
+
+				for _, pt := range []orb.Point{seg[0], mid} {
+
+					if geometryContainsPoint(b, pt) && !pointOnSegments(pt, b_segments) {
+						return false
+					}
+				}
+			}
+		}
+	}
+
+	return true
+}
+
+// polygonCoversPoint returns true if 'pt' is inside or on the boundary of 'p'. Unlike planar.PolygonContains points on
+// the boundary of an interior ring are considered to be covered.
+func polygonCoversPoint(p orb.Polygon, pt orb.Point) bool {
+
+	if len(p) == 0 || !planar.RingContains(p[0], pt) {
+		return false
+	}
+
+	for _, hole := range p[1:] {
+
+		if planar.RingContains(hole, pt) && !pointOnSegments(pt, geometrySegments(hole)) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// pointOnSegments returns true if 'pt' is on any of the line segments in 'segments'.
+func pointOnSegments(pt orb.Point, segments [][2]orb.Point) bool {
+
+	for _, seg := range segments {
+
+		if orientation(seg[0], seg[1], pt) == 0 && onSegment(seg[0], seg[1], pt) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// segmentMidpoints splits the line segment 'p1'-'p2' at each of 'pts' which are on it and returns the midpoint of
+// each of the resulting parts.
+func segmentMidpoints(p1 orb.Point, p2 orb.Point, pts []orb.Point) []orb.Point {
+
+	dx := p2.X() - p1.X()
+	dy := p2.Y() - p1.Y()
+
+	length := dx*dx + dy*dy
+
+	if length == 0 {
+		return nil
+	}
+
+	positions := []float64{0, 1}
+
+	for _, pt := range pts {
+
+		if orientation(p1, p2, pt) != 0 || !onSegment(p1, p2, pt) {
+			continue
+		}
+
+		positions = append(positions, ((pt.X()-p1.X())*dx+(pt.Y()-p1.Y())*dy)/length)
+	}
+
+	slices.Sort(positions)
+
+	midpoints := make([]orb.Point, 0)
+
+	for i := 1; i < len(positions); i++ {
+
+		if positions[i] == positions[i-1] {
+			continue
+		}
+
+		pos := (positions[i-1] + positions[i]) / 2
+		midpoints = append(midpoints, orb.Point{p1.X() + pos*dx, p1.Y() + pos*dy})
+	}
+
+	return midpoints
+}
+
+// segmentsCross returns true if the line segments 'p1'-'p2' and 'p3'-'p4' properly cross each other, which is to say
+// they intersect at a single point that is not an end point of either segment.
+func segmentsCross(p1 orb.Point, p2 orb.Point, p3 orb.Point, p4 orb.Point) bool {
+
+	d1 := orientation(p3, p4, p1)
+	d2 := orientation(p3, p4, p2)
+	d3 := orientation(p1, p2, p3)
+	d4 := orientation(p1, p2, p4)
+
+	return ((d1 > 0 && d2 < 0) || (d1 < 0 && d2 > 0)) && ((d3 > 0 && d4 < 0) || (d3 < 0 && d4 > 0))
+}
+
+// segmentsIntersect returns true if the line segment 'p1'-'p2' intersects the line segment 'p3'-'p4'.
+func segmentsIntersect(p1 orb.Point, p2 orb.Point, p3 orb.Point, p4 orb.Point) bool {
+
+	if segmentsCross(p1, p2, p3, p4) {
+		return true
+	}
+
+	d1 := orientation(p3, p4, p1)
+	d2 := orientation(p3, p4, p2)
+	d3 := orientation(p1, p2, p3)
+	d4 := orientation(p1, p2, p4)
+
+	switch {
+	case d1 == 0 && onSegment(p3, p4, p1):
+		return true
+	case d2 == 0 && onSegment(p3, p4, p2):
+		return true
+	case d3 == 0 && onSegment(p1, p2, p3):
+		return true
+	case d4 == 0 && onSegment(p1, p2, p4):
+		return true
+	}
+
+	return false
+}
+
+func orientation(a orb.Point, b orb.Point, c orb.Point) float64 {
+	return (b.X()-a.X())*(c.Y()-a.Y()) - (b.Y()-a.Y())*(c.X()-a.X())
+}
+
+func onSegment(a orb.Point, b orb.Point, c orb.Point) bool {
+	return math.Min(a.X(), b.X()) <= c.X() && c.X() <= math.Max(a.X(), b.X()) &&
+		math.Min(a.Y(), b.Y()) <= c.Y() && c.Y() <= math.Max(a.Y(), b.Y())
+}
+
+// distanceToGeometry returns the approximate distance, in metres, between 'pt' and the nearest point of 'g'. If 'pt'
+// is contained by 'g' the distance is 0. Distances are calculated using an equirectangular projection centered on
+// 'pt' which is accurate enough for the short distances used by radius queries.
+func distanceToGeometry(pt orb.Point, g orb.Geometry) float64 {
+
+	if geometryContainsPoint(g, pt) {
+		return 0.0
+	}
+
+	project := func(p orb.Point) orb.Point {
+		x := (p.X() - pt.X()) * metresPerDegree * math.Cos(pt.Y()*math.Pi/180.0)
+		y := (p.Y() - pt.Y()) * metresPerDegree
+		return orb.Point{x, y}
+	}
+
+	origin := orb.Point{0.0, 0.0}
+	d := math.Inf(1)
+
+	for _, seg := range geometrySegments(g) {
+		d = math.Min(d, planar.DistanceFromSegment(project(seg[0]), project(seg[1]), origin))
+	}
+
+	for _, p := range geometryPoints(g) {
+		d = math.Min(d, planar.Distance(project(p), origin))
+	}
+
+	return d
+}
@@ -0,0 +1,400 @@
+package pip
+
+import (
+	"github.com/paulmach/orb"
+	"math"
+	"testing"
+)
+
+// square returns a polygon for the bounding box defined by 'min_x', 'min_y', 'max_x' and 'max_y'.
+func square(min_x float64, min_y float64, max_x float64, max_y float64) orb.Polygon {
+
+	b := orb.Bound{
+		Min: orb.Point{min_x, min_y},
+		Max: orb.Point{max_x, max_y},
+	}
+
+	return b.ToPolygon()
+}
+
+func TestSegmentsCross(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		segments [4]orb.Point
+		cross    bool
+		touch    bool
+	}{
+		{
+			name:     "cross",
+			segments: [4]orb.Point{{0, 0}, {2, 2}, {0, 2}, {2, 0}},
+			cross:    true,
+			touch:    true,
+		},
+		{
+			name:     "parallel",
+			segments: [4]orb.Point{{0, 0}, {2, 0}, {0, 1}, {2, 1}},
+			cross:    false,
+			touch:    false,
+		},
+		{
+			name:     "apart",
+			segments: [4]orb.Point{{0, 0}, {1, 1}, {2, 0}, {3, -1}},
+			cross:    false,
+			touch:    false,
+		},
+		{
+			name:     "shared end point",
+			segments: [4]orb.Point{{0, 0}, {1, 1}, {1, 1}, {2, 0}},
+			cross:    false,
+			touch:    true,
+		},
+		{
+			name:     "end point on segment",
+			segments: [4]orb.Point{{0, 0}, {2, 0}, {1, 0}, {1, 1}},
+			cross:    false,
+			touch:    true,
+		},
+		{
+			name:     "collinear overlap",
+			segments: [4]orb.Point{{0, 0}, {2, 0}, {1, 0}, {3, 0}},
+			cross:    false,
+			touch:    true,
+		},
+		{
+			name:     "collinear apart",
+			segments: [4]orb.Point{{0, 0}, {1, 0}, {2, 0}, {3, 0}},
+			cross:    false,
+			touch:    false,
+		},
+	}
+
+	for _, tt := range tests {
+
+		t.Run(tt.name, func(t *testing.T) {
+
+			s := tt.segments
+
+			cross := segmentsCross(s[0], s[1], s[2], s[3])
+
+			if cross != tt.cross {
+				t.Fatalf("Expected segmentsCross to return %t, got %t", tt.cross, cross)
+			}
+
+			// The order of the segments should not matter
+
+			if segmentsCross(s[2], s[3], s[0], s[1]) != cross {
+				t.Fatalf("Expected segmentsCross to be symmetric")
+			}
+
+			touch := segmentsIntersect(s[0], s[1], s[2], s[3])
+
+			if touch != tt.touch {
+				t.Fatalf("Expected segmentsIntersect to return %t, got %t", tt.touch, touch)
+			}
+		})
+	}
+}
+
+func TestGeometryContains(t *testing.T) {
+
+	// A "U" shaped polygon whose arms are between 0,1 and 2,3 on the x axis
+
+	u_shape := orb.Polygon{
+		orb.Ring{{0, 0}, {3, 0}, {3, 3}, {2, 3}, {2, 1}, {1, 1}, {1, 3}, {0, 3}, {0, 0}},
+	}
+
+	// A square between 0,0 and 10,10 with a hole between 4,4 and 6,6
+
+	with_hole := orb.Polygon{
+		square(0, 0, 10, 10)[0],
+		square(4, 4, 6, 6)[0],
+	}
+
+	tests := []struct {
+		name     string
+		a        orb.Geometry
+		b        orb.Geometry
+		expected bool
+	}{
+		{
+			name:     "inside",
+			a:        square(0, 0, 2, 2),
+			b:        square(0.5, 0.5, 1, 1),
+			expected: true,
+		},
+		{
+			name:     "around hole",
+			a:        with_hole,
+			b:        square(3, 3, 7, 7),
+			expected: false,
+		},
+		{
+			name:     "around hole with the same hole",
+			a:        with_hole,
+			b:        orb.Polygon{square(3, 3, 7, 7)[0], square(4, 4, 6, 6)[0]},
+			expected: true,
+		},
+		{
+			name:     "beside hole",
+			a:        with_hole,
+			b:        square(1, 1, 3, 3),
+			expected: true,
+		},
+		{
+			name:     "touching hole",
+			a:        with_hole,
+			b:        square(6, 4, 8, 6),
+			expected: true,
+		},
+		{
+			name:     "in hole",
+			a:        with_hole,
+			b:        square(4.5, 4.5, 5.5, 5.5),
+			expected: false,
+		},
+		{
+			name:     "line across hole",
+			a:        with_hole,
+			b:        orb.LineString{{4, 4}, {6, 6}},
+			expected: false,
+		},
+		{
+			name:     "line through hole corners",
+			a:        with_hole,
+			b:        orb.LineString{{3, 3}, {7, 7}},
+			expected: false,
+		},
+		{
+			name:     "line along hole",
+			a:        with_hole,
+			b:        orb.LineString{{4, 3}, {4, 7}},
+			expected: true,
+		},
+		{
+			name:     "multi polygon with hole",
+			a:        orb.MultiPolygon{with_hole, square(20, 20, 30, 30)},
+			b:        orb.MultiPolygon{square(1, 1, 2, 2), square(21, 21, 29, 29)},
+			expected: true,
+		},
+		{
+			name:     "multi polygon around hole",
+			a:        orb.MultiPolygon{with_hole, square(20, 20, 30, 30)},
+			b:        orb.MultiPolygon{square(3, 3, 7, 7), square(21, 21, 29, 29)},
+			expected: false,
+		},
+
+		{
+			name:     "same",
+			a:        square(0, 0, 2, 2),
+			b:        square(0, 0, 2, 2),
+			expected: true,
+		},
+		{
+			name:     "shared edges",
+			a:        square(0, 0, 2, 2),
+			b:        square(0, 0, 1, 1),
+			expected: true,
+		},
+		{
+			name:     "overlapping",
+			a:        square(0, 0, 2, 2),
+			b:        square(1, 1, 3, 3),
+			expected: false,
+		},
+		{
+			name:     "larger",
+			a:        square(0.5, 0.5, 1, 1),
+			b:        square(0, 0, 2, 2),
+			expected: false,
+		},
+		{
+			name:     "point",
+			a:        square(0, 0, 2, 2),
+			b:        orb.Point{1, 1},
+			expected: true,
+		},
+		{
+			name:     "line across concave polygon",
+			a:        u_shape,
+			b:        orb.LineString{{0.5, 2}, {2.5, 2}},
+			expected: false,
+		},
+		{
+			name:     "line in concave polygon",
+			a:        u_shape,
+			b:        orb.LineString{{0.5, 2}, {0.5, 0.5}, {2.5, 0.5}, {2.5, 2}},
+			expected: true,
+		},
+		{
+			name:     "not a polygon",
+			a:        orb.LineString{{0, 0}, {2, 2}},
+			b:        orb.Point{1, 1},
+			expected: false,
+		},
+		{
+			name:     "multi polygon",
+			a:        orb.MultiPolygon{square(0, 0, 1, 1), square(2, 2, 3, 3)},
+			b:        orb.MultiPoint{{0.5, 0.5}, {2.5, 2.5}},
+			expected: true,
+		},
+	}
+
+	for _, tt := range tests {
+
+		t.Run(tt.name, func(t *testing.T) {
+
+			contains := geometryContains(tt.a, tt.b)
+
+			if contains != tt.expected {
+				t.Fatalf("Expected %t, got %t", tt.expected, contains)
+			}
+		})
+	}
+}
+
+func TestGeometriesIntersect(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		a        orb.Geometry
+		b        orb.Geometry
+		expected bool
+	}{
+		{
+			name:     "overlapping",
+			a:        square(0, 0, 2, 2),
+			b:        square(1, 1, 3, 3),
+			expected: true,
+		},
+		{
+			name:     "inside",
+			a:        square(0, 0, 2, 2),
+			b:        square(0.5, 0.5, 1, 1),
+			expected: true,
+		},
+		{
+			name:     "shared edge",
+			a:        square(0, 0, 1, 1),
+			b:        square(1, 0, 2, 1),
+			expected: true,
+		},
+		{
+			name:     "apart",
+			a:        square(0, 0, 1, 1),
+			b:        square(2, 2, 3, 3),
+			expected: false,
+		},
+		{
+			name:     "line through",
+			a:        square(0, 0, 2, 2),
+			b:        orb.LineString{{-1, 1}, {3, 1}},
+			expected: true,
+		},
+		{
+			name:     "line with overlapping bounds",
+			a:        square(0, 0, 2, 2),
+			b:        orb.LineString{{1, 4}, {4, 1}},
+			expected: false,
+		},
+		{
+			name:     "point",
+			a:        square(0, 0, 2, 2),
+			b:        orb.Point{1, 1},
+			expected: true,
+		},
+	}
+
+	for _, tt := range tests {
+
+		t.Run(tt.name, func(t *testing.T) {
+
+			if geometriesIntersect(tt.a, tt.b) != tt.expected {
+				t.Fatalf("Expected %t", tt.expected)
+			}
+
+			if geometriesIntersect(tt.b, tt.a) != tt.expected {
+				t.Fatalf("Expected %t with geometries reversed", tt.expected)
+			}
+		})
+	}
+}
+
+func TestGeometryContainsPoint(t *testing.T) {
+
+	with_hole := orb.Polygon{
+		square(0, 0, 4, 4)[0],
+		square(1, 1, 3, 3)[0],
+	}
+
+	tests := []struct {
+		name     string
+		g        orb.Geometry
+		pt       orb.Point
+		expected bool
+	}{
+		{name: "inside", g: square(0, 0, 1, 1), pt: orb.Point{0.5, 0.5}, expected: true},
+		{name: "outside", g: square(0, 0, 1, 1), pt: orb.Point{1.5, 0.5}, expected: false},
+		{name: "hole", g: with_hole, pt: orb.Point{2, 2}, expected: false},
+		{name: "around hole", g: with_hole, pt: orb.Point{0.5, 2}, expected: true},
+		{name: "multi polygon", g: orb.MultiPolygon{square(0, 0, 1, 1), square(2, 2, 3, 3)}, pt: orb.Point{2.5, 2.5}, expected: true},
+		{name: "bound", g: orb.Bound{Min: orb.Point{0, 0}, Max: orb.Point{1, 1}}, pt: orb.Point{0.5, 0.5}, expected: true},
+		{name: "line", g: orb.LineString{{0, 0}, {1, 1}}, pt: orb.Point{0.5, 0.5}, expected: false},
+	}
+
+	for _, tt := range tests {
+
+		t.Run(tt.name, func(t *testing.T) {
+
+			if geometryContainsPoint(tt.g, tt.pt) != tt.expected {
+				t.Fatalf("Expected %t", tt.expected)
+			}
+		})
+	}
+}
+
+func TestDistanceToGeometry(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		pt       orb.Point
+		g        orb.Geometry
+		expected float64
+	}{
+		{name: "inside", pt: orb.Point{0.5, 0.5}, g: square(0, 0, 1, 1), expected: 0},
+		{name: "below", pt: orb.Point{0.5, -1}, g: square(0, 0, 1, 1), expected: metresPerDegree},
+		{name: "vertex", pt: orb.Point{0, -1}, g: orb.Point{0, 0}, expected: metresPerDegree},
+		{name: "line", pt: orb.Point{1, -0.5}, g: orb.LineString{{0, 0}, {2, 0}}, expected: metresPerDegree / 2},
+	}
+
+	for _, tt := range tests {
+
+		t.Run(tt.name, func(t *testing.T) {
+
+			d := distanceToGeometry(tt.pt, tt.g)
+
+			if math.Abs(d-tt.expected) > 1.0 {
+				t.Fatalf("Expected distance of %f, got %f", tt.expected, d)
+			}
+		})
+	}
+}
+
+func TestBoundWithRadius(t *testing.T) {
+
+	pt := orb.Point{0, 0}
+
+	b := boundWithRadius(pt, metresPerDegree)
+
+	if math.Abs(b.Min.X()+1) > 1e-9 || math.Abs(b.Min.Y()+1) > 1e-9 || math.Abs(b.Max.X()-1) > 1e-9 || math.Abs(b.Max.Y()-1) > 1e-9 {
+		t.Fatalf("Expected bounds of -1,-1,1,1 got %v", b)
+	}
+
+	// Bounds are clamped to valid coordinates
+
+	b = boundWithRadius(orb.Point{179.5, 89.5}, metresPerDegree)
+
+	if b.Max.X() != 180 || b.Max.Y() != 90 {
+		t.Fatalf("Expected bounds to be clamped, got %v", b)
+	}
+}
@@ -0,0 +1,233 @@
+// package hierarchy provides methods for resolving Who's On First style parent IDs and hierarchies
+// using point-in-polygon queries.
+package hierarchy
+
+import (
+	"context"
+	"fmt"
+	"github.com/whosonfirst/go-reader"
+	"github.com/whosonfirst/go-whosonfirst-feature/properties"
+	"github.com/whosonfirst/go-whosonfirst-placetypes"
+	"github.com/whosonfirst/go-whosonfirst-spatial-pip"
+	spatial_app "github.com/whosonfirst/go-whosonfirst-spatial/app"
+	"github.com/whosonfirst/go-whosonfirst-spr/v2"
+	"github.com/whosonfirst/go-whosonfirst-uri"
+	"io"
+	"sort"
+	"strconv"
+)
+
+// UNKNOWN_PARENT_ID is the value assigned to `wof:parent_id` when a parent can not be determined.
+const UNKNOWN_PARENT_ID int64 = -1
+
+// HierarchyResponse is a resolved Who's On First hierarchy for a coordinate.
+type HierarchyResponse struct {
+	// The ID of the most specific place containing the coordinate.
+	ParentId int64 `json:"wof:parent_id"`
+	// The hierarchies of the parent place.
+	Hierarchy []map[string]int64 `json:"wof:hierarchy"`
+	// The most specific place for each placetype containing the coordinate, ordered from most to least specific.
+	Places []spr.StandardPlacesResult `json:"places"`
+}
+
+// ResolveHierarchy performs a point-in-polygon query for 'req' and returns the most specific result for each placetype,
+// ordered by the placetype graph, along with a parent ID and hierarchy derived from the most specific result. Any
+// pagination or limit criteria in 'req' are ignored since the hierarchy depends on every matching result.
+func ResolveHierarchy(ctx context.Context, app *spatial_app.SpatialApplication, req *pip.PointInPolygonRequest) (*HierarchyResponse, error) {
+
+	h_req := new(pip.PointInPolygonRequest)
+	*h_req = *req
+
+	h_req.Page = 0
+	h_req.PerPage = 0
+	h_req.Cursor = ""
+	h_req.Limit = 0
+
+	pip_rsp, err := pip.QueryPointInPolygon(ctx, app, h_req)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to perform point in polygon query, %w", err)
+	}
+
+	places := MostSpecificResults(pip_rsp.Results())
+
+	h_rsp := &HierarchyResponse{
+		ParentId:  UNKNOWN_PARENT_ID,
+		Hierarchy: make([]map[string]int64, 0),
+		Places:    places,
+	}
+
+	if len(places) == 0 {
+		return h_rsp, nil
+	}
+
+	parent := places[0]
+
+	parent_id, err := strconv.ParseInt(parent.Id(), 10, 64)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse ID for parent (%s), %w", parent.Id(), err)
+	}
+
+	hierarchies, err := ParentHierarchies(ctx, app.PropertiesReader, parent)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(hierarchies) == 0 {
+		hierarchies = []map[string]int64{
+			HierarchyWithResults(AncestorResults(parent, places)),
+		}
+	}
+
+	h_rsp.ParentId = parent_id
+	h_rsp.Hierarchy = hierarchies
+
+	return h_rsp, nil
+}
+
+// MostSpecificResults returns the most specific result for each distinct placetype in 'results' ordered by their
+// position in the placetype graph, from most to least specific. When there are multiple results for the same
+// placetype the one with the smallest bounding box is chosen.
+func MostSpecificResults(results []spr.StandardPlacesResult) []spr.StandardPlacesResult {
+
+	by_placetype := make(map[string]spr.StandardPlacesResult)
+
+	for _, r := range results {
+
+		pt := r.Placetype()
+		other, ok := by_placetype[pt]
+
+		if !ok || boundsArea(r) < boundsArea(other) {
+			by_placetype[pt] = r
+		}
+	}
+
+	places := make([]spr.StandardPlacesResult, 0)
+
+	for _, r := range by_placetype {
+		places = append(places, r)
+	}
+
+	sort.Slice(places, func(i int, j int) bool {
+
+		i_depth := placetypeDepth(places[i].Placetype())
+		j_depth := placetypeDepth(places[j].Placetype())
+
+		if i_depth != j_depth {
+			return i_depth > j_depth
+		}
+
+		return places[i].Placetype() < places[j].Placetype()
+	})
+
+	return places
+}
+
+// ParentHierarchies returns the `wof:hierarchy` property for 'parent' read from 'r'. If 'r' is nil or the parent record
+// does not define any hierarchies an empty list is returned. An error is returned if the parent record can not be read.
+func ParentHierarchies(ctx context.Context, r reader.Reader, parent spr.StandardPlacesResult) ([]map[string]int64, error) {
+
+	if r == nil {
+		return nil, nil
+	}
+
+	parent_id, err := strconv.ParseInt(parent.Id(), 10, 64)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse ID for parent (%s), %w", parent.Id(), err)
+	}
+
+	rel_path, err := uri.Id2RelPath(parent_id)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to derive path for parent (%d), %w", parent_id, err)
+	}
+
+	fh, err := r.Read(ctx, rel_path)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read parent record (%d), %w", parent_id, err)
+	}
+
+	defer fh.Close()
+
+	body, err := io.ReadAll(fh)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read parent record (%d), %w", parent_id, err)
+	}
+
+	return properties.Hierarchies(body), nil
+}
+
+// AncestorResults returns 'parent' followed by the results in 'results' whose placetypes are ancestors of the placetype
+// of 'parent'. If the placetype of 'parent' is not known only 'parent' is returned.
+func AncestorResults(parent spr.StandardPlacesResult, results []spr.StandardPlacesResult) []spr.StandardPlacesResult {
+
+	ancestors := []spr.StandardPlacesResult{
+		parent,
+	}
+
+	pt, err := placetypes.GetPlacetypeByName(parent.Placetype())
+
+	if err != nil {
+		return ancestors
+	}
+
+	names := make(map[string]bool)
+
+	for _, a := range placetypes.Ancestors(pt) {
+		names[a.Name] = true
+	}
+
+	for _, r := range results {
+
+		if r.Id() != parent.Id() && names[r.Placetype()] {
+			ancestors = append(ancestors, r)
+		}
+	}
+
+	return ancestors
+}
+
+// HierarchyWithResults returns a single Who's On First hierarchy ({PLACETYPE}_id: {ID}) derived from 'results'.
+func HierarchyWithResults(results []spr.StandardPlacesResult) map[string]int64 {
+
+	h := make(map[string]int64)
+
+	for _, r := range results {
+
+		id, err := strconv.ParseInt(r.Id(), 10, 64)
+
+		if err != nil {
+			continue
+		}
+
+		k := fmt.Sprintf("%s_id", r.Placetype())
+
+		_, exists := h[k]
+
+		if !exists {
+			h[k] = id
+		}
+	}
+
+	return h
+}
+
+func placetypeDepth(name string) int {
+
+	pt, err := placetypes.GetPlacetypeByName(name)
+
+	if err != nil {
+		return -1
+	}
+
+	return len(placetypes.Ancestors(pt))
+}
+
+func boundsArea(r spr.StandardPlacesResult) float64 {
+	return (r.MaxLatitude() - r.MinLatitude()) * (r.MaxLongitude() - r.MinLongitude())
+}
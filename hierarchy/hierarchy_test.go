@@ -0,0 +1,260 @@
+package hierarchy
+
+import (
+	"context"
+	"fmt"
+	"github.com/whosonfirst/go-whosonfirst-spatial-pip"
+	"github.com/whosonfirst/go-whosonfirst-spr/v2"
+	"io"
+	"reflect"
+	"slices"
+	"testing"
+)
+
+// failingReader is a reader.Reader instance that fails to read every URI.
+type failingReader struct{}
+
+func (r *failingReader) Read(ctx context.Context, uri string) (io.ReadSeekCloser, error) {
+	return nil, fmt.Errorf("Failed to read %s", uri)
+}
+
+func (r *failingReader) ReaderURI(ctx context.Context, uri string) string {
+	return uri
+}
+
+func TestResolveHierarchy(t *testing.T) {
+
+	ctx := context.Background()
+	app := newTestApplication(t, nil)
+
+	tests := []struct {
+		name      string
+		req       *pip.PointInPolygonRequest
+		parent_id int64
+		places    []string
+		hierarchy []map[string]int64
+	}{
+		{
+			name:      "neighbourhood",
+			req:       &pip.PointInPolygonRequest{Latitude: 0.25, Longitude: 0.25, IsCurrent: []int64{1}},
+			parent_id: 102000001,
+			places:    []string{"102000001", "101000001", "85000002", "85000001"},
+			hierarchy: []map[string]int64{
+				{"country_id": 85000001, "region_id": 85000002, "locality_id": 101000001, "neighbourhood_id": 102000001},
+			},
+		},
+		{
+			name:      "locality",
+			req:       &pip.PointInPolygonRequest{Latitude: 0.75, Longitude: 0.75, IsCurrent: []int64{1}},
+			parent_id: 101000001,
+			places:    []string{"101000001", "85000002", "85000001"},
+			hierarchy: []map[string]int64{
+				{"country_id": 85000001, "region_id": 85000002, "locality_id": 101000001},
+			},
+		},
+		{
+			name:      "placetypes",
+			req:       &pip.PointInPolygonRequest{Latitude: 0.25, Longitude: 0.25, Placetypes: []string{"region", "country"}},
+			parent_id: 85000002,
+			places:    []string{"85000002", "85000001"},
+			hierarchy: []map[string]int64{
+				{"country_id": 85000001, "region_id": 85000002},
+			},
+		},
+		{
+			name:      "pagination",
+			req:       &pip.PointInPolygonRequest{Latitude: 0.25, Longitude: 0.25, IsCurrent: []int64{1}, Page: 2, PerPage: 1, Limit: 2},
+			parent_id: 102000001,
+			places:    []string{"102000001", "101000001", "85000002", "85000001"},
+			hierarchy: []map[string]int64{
+				{"country_id": 85000001, "region_id": 85000002, "locality_id": 101000001, "neighbourhood_id": 102000001},
+			},
+		},
+		{
+			name:      "island",
+			req:       &pip.PointInPolygonRequest{Latitude: 5.5, Longitude: 5.5},
+			parent_id: 101000003,
+			places:    []string{"101000003"},
+			hierarchy: []map[string]int64{
+				{"locality_id": 101000003},
+			},
+		},
+		{
+			name:      "nothing",
+			req:       &pip.PointInPolygonRequest{Latitude: -50, Longitude: -50},
+			parent_id: UNKNOWN_PARENT_ID,
+			places:    []string{},
+			hierarchy: []map[string]int64{},
+		},
+	}
+
+	for _, tt := range tests {
+
+		t.Run(tt.name, func(t *testing.T) {
+
+			h_rsp, err := ResolveHierarchy(ctx, app, tt.req)
+
+			if err != nil {
+				t.Fatalf("Failed to resolve hierarchy, %v", err)
+			}
+
+			if h_rsp.ParentId != tt.parent_id {
+				t.Fatalf("Expected parent %d, got %d", tt.parent_id, h_rsp.ParentId)
+			}
+
+			ids := resultIds(h_rsp.Places)
+
+			if !slices.Equal(ids, tt.places) {
+				t.Fatalf("Expected places %v, got %v", tt.places, ids)
+			}
+
+			if !reflect.DeepEqual(h_rsp.Hierarchy, tt.hierarchy) {
+				t.Fatalf("Expected hierarchy %v, got %v", tt.hierarchy, h_rsp.Hierarchy)
+			}
+		})
+	}
+}
+
+func TestResolveHierarchyFallback(t *testing.T) {
+
+	ctx := context.Background()
+	app := newTestApplication(t, nil)
+
+	// Without a properties reader the parent's hierarchy can not be read so it is derived
+	// from the results instead
+
+	app.PropertiesReader = nil
+
+	req := &pip.PointInPolygonRequest{
+		Latitude:  0.25,
+		Longitude: 0.25,
+		IsCurrent: []int64{1},
+	}
+
+	h_rsp, err := ResolveHierarchy(ctx, app, req)
+
+	if err != nil {
+		t.Fatalf("Failed to resolve hierarchy, %v", err)
+	}
+
+	expected := []map[string]int64{
+		{"country_id": 85000001, "region_id": 85000002, "locality_id": 101000001, "neighbourhood_id": 102000001},
+	}
+
+	if !reflect.DeepEqual(h_rsp.Hierarchy, expected) {
+		t.Fatalf("Expected hierarchy %v, got %v", expected, h_rsp.Hierarchy)
+	}
+
+	app.PropertiesReader = &failingReader{}
+
+	_, err = ResolveHierarchy(ctx, app, req)
+
+	if err == nil {
+		t.Fatalf("Expected an error when the parent record can not be read")
+	}
+}
+
+func TestAncestorResults(t *testing.T) {
+
+	ctx := context.Background()
+	app := newTestApplication(t, nil)
+
+	req := &pip.PointInPolygonRequest{
+		Latitude:  0.25,
+		Longitude: 0.25,
+		IsCurrent: []int64{1},
+	}
+
+	pip_rsp, err := pip.QueryPointInPolygon(ctx, app, req)
+
+	if err != nil {
+		t.Fatalf("Failed to query point in polygon, %v", err)
+	}
+
+	results := pip_rsp.Results()
+
+	tests := []struct {
+		parent    string
+		ancestors []string
+		hierarchy map[string]int64
+	}{
+		{
+			parent:    "102000001",
+			ancestors: []string{"102000001", "101000001", "85000001", "85000002"},
+			hierarchy: map[string]int64{"country_id": 85000001, "region_id": 85000002, "locality_id": 101000001, "neighbourhood_id": 102000001},
+		},
+		{
+			parent:    "101000001",
+			ancestors: []string{"101000001", "85000001", "85000002"},
+			hierarchy: map[string]int64{"country_id": 85000001, "region_id": 85000002, "locality_id": 101000001},
+		},
+		{
+			parent:    "85000001",
+			ancestors: []string{"85000001"},
+			hierarchy: map[string]int64{"country_id": 85000001},
+		},
+	}
+
+	for _, tt := range tests {
+
+		t.Run(tt.parent, func(t *testing.T) {
+
+			idx := slices.IndexFunc(results, func(r spr.StandardPlacesResult) bool {
+				return r.Id() == tt.parent
+			})
+
+			if idx == -1 {
+				t.Fatalf("Missing result for %s", tt.parent)
+			}
+
+			ancestors := AncestorResults(results[idx], results)
+			ids := resultIds(ancestors)
+
+			if ids[0] != tt.parent {
+				t.Fatalf("Expected parent to be first, got %v", ids)
+			}
+
+			slices.Sort(ids[1:])
+
+			if !slices.Equal(ids, tt.ancestors) {
+				t.Fatalf("Expected ancestors %v, got %v", tt.ancestors, ids)
+			}
+
+			h := HierarchyWithResults(ancestors)
+
+			if !reflect.DeepEqual(h, tt.hierarchy) {
+				t.Fatalf("Expected hierarchy %v, got %v", tt.hierarchy, h)
+			}
+		})
+	}
+}
+
+func TestMostSpecificResults(t *testing.T) {
+
+	ctx := context.Background()
+	app := newTestApplication(t, nil)
+
+	// Both localities share the same bounding box so only the other placetypes are
+	// tested for the order of their results
+
+	req := &pip.PointInPolygonRequest{
+		Latitude:   0.25,
+		Longitude:  0.25,
+		Placetypes: []string{"country", "neighbourhood", "region"},
+	}
+
+	pip_rsp, err := pip.QueryPointInPolygon(ctx, app, req)
+
+	if err != nil {
+		t.Fatalf("Failed to query point in polygon, %v", err)
+	}
+
+	places := MostSpecificResults(pip_rsp.Results())
+	ids := resultIds(places)
+
+	expected := []string{"102000001", "85000002", "85000001"}
+
+	if !slices.Equal(ids, expected) {
+		t.Fatalf("Expected %v, got %v", expected, ids)
+	}
+}
@@ -0,0 +1,190 @@
+package hierarchy
+
+import (
+	"context"
+	"fmt"
+	"github.com/tidwall/sjson"
+	"github.com/whosonfirst/go-whosonfirst-feature/properties"
+	"github.com/whosonfirst/go-whosonfirst-placetypes"
+	"github.com/whosonfirst/go-whosonfirst-spatial-pip"
+	spatial_app "github.com/whosonfirst/go-whosonfirst-spatial/app"
+	"reflect"
+	"sort"
+	"time"
+)
+
+// FeatureHierarchy is the set of hierarchy-related properties derived for a Who's On First record.
+type FeatureHierarchy struct {
+	ParentId  int64              `json:"wof:parent_id"`
+	Hierarchy []map[string]int64 `json:"wof:hierarchy"`
+	BelongsTo []int64            `json:"wof:belongsto"`
+}
+
+// ResolveFeatureHierarchy derives the parent ID, hierarchies and "belongs to" properties for the Who's On First record
+// 'body' by performing a point-in-polygon query for its centroid limited to the ancestors of its placetype. The parent
+// is the most specific ancestor containing the centroid and the hierarchies are those of the parent with the record
+// itself appended. Any existential or other criteria defined in 'filter_req' are applied to the query; its coordinates
+// and placetypes are ignored.
+func ResolveFeatureHierarchy(ctx context.Context, app *spatial_app.SpatialApplication, body []byte, filter_req *pip.PointInPolygonRequest) (*FeatureHierarchy, error) {
+
+	id, err := properties.Id(body)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to derive ID, %w", err)
+	}
+
+	pt_name, err := properties.Placetype(body)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to derive placetype for %d, %w", id, err)
+	}
+
+	pt, err := placetypes.GetPlacetypeByName(pt_name)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to load placetype '%s' for %d, %w", pt_name, id, err)
+	}
+
+	centroid, source, err := properties.Centroid(body)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to derive centroid for %d, %w", id, err)
+	}
+
+	if source == "nullisland" {
+		return nil, fmt.Errorf("Unable to determine centroid for %d", id)
+	}
+
+	self_key := fmt.Sprintf("%s_id", pt_name)
+
+	f_h := &FeatureHierarchy{
+		ParentId: UNKNOWN_PARENT_ID,
+		Hierarchy: []map[string]int64{
+			map[string]int64{self_key: id},
+		},
+		BelongsTo: make([]int64, 0),
+	}
+
+	ancestors := placetypes.Ancestors(pt)
+
+	if len(ancestors) == 0 {
+		return f_h, nil
+	}
+
+	req := &pip.PointInPolygonRequest{}
+
+	if filter_req != nil {
+		*req = *filter_req
+	}
+
+	req.Latitude = centroid.Y()
+	req.Longitude = centroid.X()
+	req.Properties = nil
+	req.Sort = nil
+
+	req.Placetypes = make([]string, len(ancestors))
+
+	for idx, a := range ancestors {
+		req.Placetypes[idx] = a.Name
+	}
+
+	h_rsp, err := ResolveHierarchy(ctx, app, req)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to resolve hierarchy for %d, %w", id, err)
+	}
+
+	if h_rsp.ParentId == UNKNOWN_PARENT_ID {
+		return f_h, nil
+	}
+
+	hierarchies := make([]map[string]int64, len(h_rsp.Hierarchy))
+	belongs_to := make(map[int64]bool)
+
+	for idx, parent_h := range h_rsp.Hierarchy {
+
+		h := make(map[string]int64)
+
+		for k, v := range parent_h {
+
+			h[k] = v
+
+			if v > 0 && v != id {
+				belongs_to[v] = true
+			}
+		}
+
+		h[self_key] = id
+		hierarchies[idx] = h
+	}
+
+	f_h.ParentId = h_rsp.ParentId
+	f_h.Hierarchy = hierarchies
+
+	for v, _ := range belongs_to {
+		f_h.BelongsTo = append(f_h.BelongsTo, v)
+	}
+
+	sort.Slice(f_h.BelongsTo, func(i int, j int) bool {
+		return f_h.BelongsTo[i] < f_h.BelongsTo[j]
+	})
+
+	return f_h, nil
+}
+
+// UpdateFeature resolves the hierarchy for the Who's On First record 'body' and, if it differs from the record's
+// current `wof:parent_id`, `wof:hierarchy` or `wof:belongsto` properties, returns an updated copy of the record with
+// a new `wof:lastmodified` property. The boolean return value indicates whether the record was changed.
+func UpdateFeature(ctx context.Context, app *spatial_app.SpatialApplication, body []byte, filter_req *pip.PointInPolygonRequest) (bool, []byte, error) {
+
+	f_h, err := ResolveFeatureHierarchy(ctx, app, body, filter_req)
+
+	if err != nil {
+		return false, nil, err
+	}
+
+	if !hasChanged(body, f_h) {
+		return false, body, nil
+	}
+
+	updates := map[string]interface{}{
+		"properties.wof:parent_id":    f_h.ParentId,
+		"properties.wof:hierarchy":    f_h.Hierarchy,
+		"properties.wof:belongsto":    f_h.BelongsTo,
+		"properties.wof:lastmodified": time.Now().Unix(),
+	}
+
+	for path, v := range updates {
+
+		body, err = sjson.SetBytes(body, path, v)
+
+		if err != nil {
+			return false, nil, fmt.Errorf("Failed to assign %s, %w", path, err)
+		}
+	}
+
+	return true, body, nil
+}
+
+func hasChanged(body []byte, f_h *FeatureHierarchy) bool {
+
+	parent_id, err := properties.ParentId(body)
+
+	if err != nil || parent_id != f_h.ParentId {
+		return true
+	}
+
+	hierarchies := properties.Hierarchies(body)
+
+	if !reflect.DeepEqual(hierarchies, f_h.Hierarchy) {
+		return true
+	}
+
+	belongs_to := properties.BelongsTo(body)
+
+	sort.Slice(belongs_to, func(i int, j int) bool {
+		return belongs_to[i] < belongs_to[j]
+	})
+
+	return !reflect.DeepEqual(belongs_to, f_h.BelongsTo)
+}
@@ -0,0 +1,136 @@
+package hierarchy
+
+import (
+	"context"
+	"fmt"
+	"github.com/whosonfirst/go-whosonfirst-feature/properties"
+	"github.com/whosonfirst/go-whosonfirst-spatial-pip"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"testing"
+)
+
+// venueFeature returns a WOF-flavoured GeoJSON Feature for a venue whose centroid is 'lat', 'lon' and whose hierarchy
+// properties are 'parent_id', 'hierarchy' and 'belongs_to'.
+func venueFeature(lat float64, lon float64, parent_id int64, hierarchy string, belongs_to string) []byte {
+
+	return []byte(fmt.Sprintf(`{"type":"Feature","id":1,"properties":{"wof:id":1,"wof:name":"Venue","wof:placetype":"venue","wof:parent_id":%d,"wof:hierarchy":%s,"wof:belongsto":%s,"wof:repo":"whosonfirst-data-test","wof:lastmodified":1,"geom:latitude":%f,"geom:longitude":%f},"geometry":{"type":"Point","coordinates":[%f,%f]}}`,
+		parent_id, hierarchy, belongs_to, lat, lon, lon, lat))
+}
+
+func TestUpdateFeature(t *testing.T) {
+
+	ctx := context.Background()
+	app := newTestApplication(t, nil)
+
+	neighbourhood, err := os.ReadFile(filepath.Join(fixturesPath, "102/000/001/102000001.geojson"))
+
+	if err != nil {
+		t.Fatalf("Failed to read fixture, %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		body       []byte
+		changed    bool
+		parent_id  int64
+		hierarchy  []map[string]int64
+		belongs_to []int64
+	}{
+		{
+			name:       "unchanged neighbourhood",
+			body:       neighbourhood,
+			changed:    false,
+			parent_id:  101000001,
+			hierarchy:  []map[string]int64{{"country_id": 85000001, "region_id": 85000002, "locality_id": 101000001, "neighbourhood_id": 102000001}},
+			belongs_to: []int64{85000001, 85000002, 101000001},
+		},
+		{
+			name:       "new venue",
+			body:       venueFeature(0.1, 0.1, -1, `[]`, `[]`),
+			changed:    true,
+			parent_id:  102000001,
+			hierarchy:  []map[string]int64{{"country_id": 85000001, "region_id": 85000002, "locality_id": 101000001, "neighbourhood_id": 102000001, "venue_id": 1}},
+			belongs_to: []int64{85000001, 85000002, 101000001, 102000001},
+		},
+		{
+			name:       "moved venue",
+			body:       venueFeature(5.5, 5.5, 102000001, `[{"country_id":85000001,"region_id":85000002,"locality_id":101000001,"neighbourhood_id":102000001,"venue_id":1}]`, `[85000001,85000002,101000001,102000001]`),
+			changed:    true,
+			parent_id:  101000003,
+			hierarchy:  []map[string]int64{{"locality_id": 101000003, "venue_id": 1}},
+			belongs_to: []int64{101000003},
+		},
+		{
+			name:       "unchanged venue",
+			body:       venueFeature(0.75, 0.75, 101000001, `[{"country_id":85000001,"region_id":85000002,"locality_id":101000001,"venue_id":1}]`, `[101000001,85000001,85000002]`),
+			changed:    false,
+			parent_id:  101000001,
+			hierarchy:  []map[string]int64{{"country_id": 85000001, "region_id": 85000002, "locality_id": 101000001, "venue_id": 1}},
+			belongs_to: []int64{85000001, 85000002, 101000001},
+		},
+		{
+			name:       "nowhere",
+			body:       venueFeature(-50, -50, 101000001, `[]`, `[]`),
+			changed:    true,
+			parent_id:  UNKNOWN_PARENT_ID,
+			hierarchy:  []map[string]int64{{"venue_id": 1}},
+			belongs_to: []int64{},
+		},
+	}
+
+	filter_req := &pip.PointInPolygonRequest{
+		IsCurrent: []int64{1},
+	}
+
+	for _, tt := range tests {
+
+		t.Run(tt.name, func(t *testing.T) {
+
+			changed, body, err := UpdateFeature(ctx, app, tt.body, filter_req)
+
+			if err != nil {
+				t.Fatalf("Failed to update feature, %v", err)
+			}
+
+			if changed != tt.changed {
+				t.Fatalf("Expected changed to be %t, got %t", tt.changed, changed)
+			}
+
+			parent_id, err := properties.ParentId(body)
+
+			if err != nil {
+				t.Fatalf("Failed to derive parent ID, %v", err)
+			}
+
+			if parent_id != tt.parent_id {
+				t.Fatalf("Expected parent %d, got %d", tt.parent_id, parent_id)
+			}
+
+			hierarchy := properties.Hierarchies(body)
+
+			if !reflect.DeepEqual(hierarchy, tt.hierarchy) {
+				t.Fatalf("Expected hierarchy %v, got %v", tt.hierarchy, hierarchy)
+			}
+
+			belongs_to := properties.BelongsTo(body)
+			slices.Sort(belongs_to)
+
+			if !slices.Equal(belongs_to, tt.belongs_to) {
+				t.Fatalf("Expected belongs to %v, got %v", tt.belongs_to, belongs_to)
+			}
+
+			lastmod := properties.LastModified(body)
+
+			if changed && lastmod == 1 {
+				t.Fatalf("Expected wof:lastmodified to be updated")
+			}
+
+			if !changed && !slices.Equal(body, tt.body) {
+				t.Fatalf("Expected unchanged record to be returned as is")
+			}
+		})
+	}
+}
@@ -0,0 +1,304 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/whosonfirst/go-whosonfirst-spatial-pip"
+	spatial_app "github.com/whosonfirst/go-whosonfirst-spatial/app"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// DEFAULT_BATCH_MAX_BODY_SIZE is the default maximum size, in bytes, of a body POST-ed to the batch handler.
+const DEFAULT_BATCH_MAX_BODY_SIZE int64 = 8 * 1024 * 1024
+
+type BatchPointInPolygonHandlerOptions struct {
+	// The maximum number of point-in-polygon queries to perform concurrently.
+	MaxWorkers int
+	// The maximum number of point-in-polygon queries allowed in a single request. If 0 there is no limit.
+	MaxRequests int
+	// The maximum size, in bytes, of a POST-ed body. If 0 then DEFAULT_BATCH_MAX_BODY_SIZE is used.
+	MaxBodySize int64
+	Logger      *log.Logger
+	// An optional Metrics instance used to record stage timings, result counts and errors.
+	Metrics *Metrics
+	// If true reject JSON-encoded requests containing unknown fields.
+	StrictDecoding bool
+	IndexingOptions
+}
+
+// BatchPointInPolygonResponse is the response body returned by the batch point-in-polygon handler.
+type BatchPointInPolygonResponse struct {
+	Results []*BatchPointInPolygonResponseItem `json:"results"`
+	// The progress of indexing, if the queries were performed before all the records were indexed.
+	IndexProgress *pip.IndexProgress `json:"index_progress,omitempty"`
+}
+
+// BatchPointInPolygonResponseItem is the outcome of a single point-in-polygon query in a batch. Results will be
+// either a SPR or a properties response depending on whether the request specified any properties.
+type BatchPointInPolygonResponseItem struct {
+	Results interface{}   `json:"results,omitempty"`
+	Error   string        `json:"error,omitempty"`
+	Code    pip.ErrorCode `json:"code,omitempty"`
+}
+
+// BatchPointInPolygonHandler returns a http.Handler that accepts a POST-ed body containing either a JSON-encoded list of
+// `pip.PointInPolygonRequest` instances or a JSON-encoded `pip.BatchPointInPolygonRequest` (a shared filter and a list
+// of coordinates) and returns one result set for each input, in order.
+func BatchPointInPolygonHandler(app *spatial_app.SpatialApplication, opts *BatchPointInPolygonHandlerOptions) (http.Handler, error) {
+
+	max_body_size := opts.MaxBodySize
+
+	if max_body_size <= 0 {
+		max_body_size = DEFAULT_BATCH_MAX_BODY_SIZE
+	}
+
+	var observer pip.StageObserver
+
+	if opts.Metrics != nil {
+		observer = opts.Metrics
+	}
+
+	fn := func(rsp http.ResponseWriter, req *http.Request) {
+
+		ctx := req.Context()
+
+		if req.Method != "POST" {
+			WriteProblem(rsp, req, METHOD_NOT_ALLOWED, "Unsupported method")
+			return
+		}
+
+		indexing, ok := checkIndexing(rsp, req, app, opts.IndexingOptions)
+
+		if !ok {
+			return
+		}
+
+		body := http.MaxBytesReader(rsp, req.Body, max_body_size)
+
+		pip_reqs, err := batchRequestsWithReader(body, opts.StrictDecoding, opts.MaxRequests)
+
+		if err != nil {
+
+			var max_err *http.MaxBytesError
+
+			if errors.As(err, &max_err) {
+				WriteProblem(rsp, req, REQUEST_TOO_LARGE, fmt.Sprintf("Request body exceeds the maximum size of %d bytes", max_body_size))
+				return
+			}
+
+			WriteError(rsp, req, err, pip.INVALID_REQUEST)
+			return
+		}
+
+		// Each lookup uses its own request application so that timings are not accumulated by 'app' or
+		// mixed with those of the other lookups in the batch
+
+		pip_results := pip.QueryPointInPolygonBatchWithObserver(ctx, app, pip_reqs, opts.MaxWorkers, observer)
+
+		batch_rsp := &BatchPointInPolygonResponse{
+			Results: make([]*BatchPointInPolygonResponseItem, len(pip_results)),
+		}
+
+		for idx, r := range pip_results {
+
+			item := &BatchPointInPolygonResponseItem{}
+			batch_rsp.Results[idx] = item
+
+			if r.Error != nil {
+				item.Error = r.Error.Error()
+				item.Code = errorCode(r.Error, INTERNAL_ERROR)
+				opts.Metrics.ObserveError(METRICS_BATCH, string(item.Code))
+				continue
+			}
+
+			opts.Metrics.ObserveResults(METRICS_BATCH, len(r.Results.Results()))
+
+			if len(r.Request.Properties) == 0 {
+				item.Results = r.Results
+				continue
+			}
+
+			props_rsp, err := propertiesResponse(ctx, r.Application, r.Request.Properties, r.Results)
+
+			if err != nil {
+				item.Error = err.Error()
+				item.Code = errorCode(err, INTERNAL_ERROR)
+				opts.Metrics.ObserveError(METRICS_BATCH, string(item.Code))
+				continue
+			}
+
+			item.Results = props_rsp
+		}
+
+		batch_rsp.IndexProgress = indexing.indexProgress(rsp.Header())
+
+		rsp.Header().Set("Content-Type", "application/json")
+
+		enc := json.NewEncoder(rsp)
+		err = enc.Encode(batch_rsp)
+
+		if err != nil {
+			opts.Logger.Printf("Failed to encode batch response, %v", err)
+			return
+		}
+
+		return
+	}
+
+	batch_handler := http.HandlerFunc(fn)
+	return batch_handler, nil
+}
+
+// batchRequestsWithReader decodes the batch of point-in-polygon requests read from 'r', failing as soon as more than
+// 'max_requests' requests have been read if 'max_requests' is greater than 0.
+func batchRequestsWithReader(r io.Reader, strict bool, max_requests int) ([]*pip.PointInPolygonRequest, error) {
+
+	dec := pip.NewRequestDecoder(r, strict)
+
+	tok, err := dec.Token()
+
+	if err == io.EOF {
+		return nil, fmt.Errorf("Empty request body")
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to decode batch request, %w", err)
+	}
+
+	switch tok {
+	case json.Delim('['):
+
+		pip_reqs := make([]*pip.PointInPolygonRequest, 0)
+
+		decode_func := func() error {
+
+			var pip_req *pip.PointInPolygonRequest
+
+			err := dec.Decode(&pip_req)
+
+			if err != nil {
+				return err
+			}
+
+			pip_reqs = append(pip_reqs, pip_req)
+			return nil
+		}
+
+		err := decodeBatchElements(dec, max_requests, decode_func)
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to decode batch requests, %w", err)
+		}
+
+		return pip_reqs, nil
+
+	case json.Delim('{'):
+
+		batch_req := &pip.BatchPointInPolygonRequest{}
+
+		decode_func := func() error {
+
+			var c *pip.BatchCoordinate
+
+			err := dec.Decode(&c)
+
+			if err != nil {
+				return err
+			}
+
+			batch_req.Coordinates = append(batch_req.Coordinates, c)
+			return nil
+		}
+
+		for dec.More() {
+
+			tok, err := dec.Token()
+
+			if err != nil {
+				return nil, fmt.Errorf("Failed to decode batch request, %w", err)
+			}
+
+			key, _ := tok.(string)
+
+			switch {
+			case strings.EqualFold(key, "filter"):
+				err = dec.Decode(&batch_req.Filter)
+			case strings.EqualFold(key, "coordinates"):
+				err = decodeBatchCoordinates(dec, max_requests, decode_func)
+			case strict:
+				err = fmt.Errorf("json: unknown field %q", key)
+			default:
+				var raw json.RawMessage
+				err = dec.Decode(&raw)
+			}
+
+			if err != nil {
+				return nil, fmt.Errorf("Failed to decode batch request, %w", err)
+			}
+		}
+
+		_, err = dec.Token()
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to decode batch request, %w", err)
+		}
+
+		return batch_req.Requests(), nil
+
+	case nil:
+		return nil, fmt.Errorf("Empty batch request")
+	default:
+		return nil, fmt.Errorf("Invalid batch request")
+	}
+}
+
+// decodeBatchCoordinates invokes 'decode_func' for each element of the JSON array, or null, at the current position
+// of 'dec' in the same way as decodeBatchElements.
+func decodeBatchCoordinates(dec *json.Decoder, max_requests int, decode_func func() error) error {
+
+	tok, err := dec.Token()
+
+	if err != nil {
+		return err
+	}
+
+	if tok == nil {
+		return nil
+	}
+
+	if tok != json.Delim('[') {
+		return fmt.Errorf("Invalid coordinates, expected a list")
+	}
+
+	return decodeBatchElements(dec, max_requests, decode_func)
+}
+
+// decodeBatchElements invokes 'decode_func' for each remaining element of a JSON array whose opening delimiter has
+// already been read from 'dec', returning a REQUEST_TOO_LARGE error as soon as there are more than 'max_requests'
+// elements if 'max_requests' is greater than 0.
+func decodeBatchElements(dec *json.Decoder, max_requests int, decode_func func() error) error {
+
+	count := 0
+
+	for dec.More() {
+
+		count += 1
+
+		if max_requests > 0 && count > max_requests {
+			return pip.NewError(REQUEST_TOO_LARGE, fmt.Errorf("Batch exceeds maximum number of requests (%d)", max_requests))
+		}
+
+		err := decode_func()
+
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err := dec.Token()
+	return err
+}
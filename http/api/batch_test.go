@@ -0,0 +1,203 @@
+package api
+
+import (
+	"encoding/json"
+	"github.com/whosonfirst/go-whosonfirst-spatial-pip"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestBatchPointInPolygonHandler(t *testing.T) {
+
+	app := newTestApplication(t, nil)
+
+	tests := []struct {
+		name         string
+		method       string
+		body         string
+		max_requests int
+		max_body     int64
+		strict       bool
+		status       int
+		code         pip.ErrorCode
+		expected     [][]string
+		item_codes   []pip.ErrorCode
+	}{
+		{
+			name:     "list",
+			body:     `[{"latitude":0.25,"longitude":0.25,"placetypes":["neighbourhood"]},{"latitude":5.5,"longitude":5.5}]`,
+			status:   http.StatusOK,
+			expected: [][]string{{"102000001"}, {"101000003"}},
+		},
+		{
+			name:       "list with invalid item",
+			body:       `[{"latitude":95,"longitude":0.25},{"latitude":0.75,"longitude":0.75,"placetypes":["locality"],"is_current":[1]}]`,
+			status:     http.StatusOK,
+			expected:   [][]string{nil, {"101000001"}},
+			item_codes: []pip.ErrorCode{pip.INVALID_COORDINATE, ""},
+		},
+		{
+			name:     "filter and coordinates",
+			body:     `{"filter":{"placetypes":["locality"]},"coordinates":[{"latitude":0.5,"longitude":0.5},{"latitude":5.5,"longitude":5.5},{"latitude":-50,"longitude":-50}]}`,
+			status:   http.StatusOK,
+			expected: [][]string{{"101000001", "101000002"}, {"101000003"}, {}},
+		},
+		{
+			name:       "huge page",
+			body:       `[{"latitude":0.25,"longitude":0.25,"page":1000000000000000000,"per_page":10},{"latitude":5.5,"longitude":5.5,"page":1,"per_page":10}]`,
+			status:     http.StatusOK,
+			expected:   [][]string{nil, {"101000003"}},
+			item_codes: []pip.ErrorCode{pip.INVALID_PARAMETER, ""},
+		},
+		{
+			name:       "huge page filter",
+			body:       `{"filter":{"page":1000000000000000000,"per_page":10},"coordinates":[{"latitude":0.25,"longitude":0.25}]}`,
+			status:     http.StatusOK,
+			expected:   [][]string{nil},
+			item_codes: []pip.ErrorCode{pip.INVALID_PARAMETER},
+		},
+		{
+			name:     "properties",
+			body:     `[{"latitude":5.5,"longitude":5.5,"properties":["wof:country"]}]`,
+			status:   http.StatusOK,
+			expected: [][]string{{"101000003"}},
+		},
+		{
+			name:     "unknown key",
+			body:     `{"coordinates":[{"latitude":5.5,"longitude":5.5}],"debug":true}`,
+			status:   http.StatusOK,
+			expected: [][]string{{"101000003"}},
+		},
+		{
+			name:   "strict unknown key",
+			body:   `{"coordinates":[{"latitude":5.5,"longitude":5.5}],"debug":true}`,
+			strict: true,
+			status: http.StatusBadRequest,
+			code:   pip.INVALID_REQUEST,
+		},
+		{
+			name:   "empty",
+			body:   ``,
+			status: http.StatusBadRequest,
+			code:   pip.INVALID_REQUEST,
+		},
+		{
+			name:   "malformed",
+			body:   `[{"latitude":0.25,`,
+			status: http.StatusBadRequest,
+			code:   pip.INVALID_REQUEST,
+		},
+		{
+			name:         "too many requests",
+			body:         `[{"latitude":0.25,"longitude":0.25},{"latitude":0.25,"longitude":0.25},{"latitude":0.25,"longitude":0.25}]`,
+			max_requests: 2,
+			status:       http.StatusRequestEntityTooLarge,
+			code:         REQUEST_TOO_LARGE,
+		},
+		{
+			name:         "too many coordinates",
+			body:         `{"coordinates":[{"latitude":0.25,"longitude":0.25},{"latitude":0.25,"longitude":0.25},{"latitude":0.25,"longitude":0.25}]}`,
+			max_requests: 2,
+			status:       http.StatusRequestEntityTooLarge,
+			code:         REQUEST_TOO_LARGE,
+		},
+		{
+			name:     "body too large",
+			body:     `[{"latitude":0.25,"longitude":0.25},{"latitude":0.25,"longitude":0.25}]`,
+			max_body: 32,
+			status:   http.StatusRequestEntityTooLarge,
+			code:     REQUEST_TOO_LARGE,
+		},
+		{
+			name:   "method",
+			method: http.MethodGet,
+			status: http.StatusMethodNotAllowed,
+			code:   METHOD_NOT_ALLOWED,
+		},
+	}
+
+	for _, tt := range tests {
+
+		t.Run(tt.name, func(t *testing.T) {
+
+			opts := &BatchPointInPolygonHandlerOptions{
+				MaxWorkers:     2,
+				MaxRequests:    tt.max_requests,
+				MaxBodySize:    tt.max_body,
+				Logger:         log.New(io.Discard, "", 0),
+				StrictDecoding: tt.strict,
+			}
+
+			handler, err := BatchPointInPolygonHandler(app, opts)
+
+			if err != nil {
+				t.Fatalf("Failed to create handler, %v", err)
+			}
+
+			method := tt.method
+
+			if method == "" {
+				method = http.MethodPost
+			}
+
+			req := httptest.NewRequest(method, "/batch", strings.NewReader(tt.body))
+			rsp := httptest.NewRecorder()
+
+			handler.ServeHTTP(rsp, req)
+
+			if tt.code != "" {
+				decodeProblem(t, rsp, tt.status, tt.code)
+				return
+			}
+
+			if rsp.Code != tt.status {
+				t.Fatalf("Expected status %d, got %d: %s", tt.status, rsp.Code, rsp.Body.String())
+			}
+
+			var batch_rsp struct {
+				Results []struct {
+					Results json.RawMessage `json:"results"`
+					Code    pip.ErrorCode   `json:"code"`
+				} `json:"results"`
+			}
+
+			err = json.Unmarshal(rsp.Body.Bytes(), &batch_rsp)
+
+			if err != nil {
+				t.Fatalf("Failed to decode response, %v", err)
+			}
+
+			if len(batch_rsp.Results) != len(tt.expected) {
+				t.Fatalf("Expected %d results, got %d", len(tt.expected), len(batch_rsp.Results))
+			}
+
+			for idx, expected := range tt.expected {
+
+				item := batch_rsp.Results[idx]
+
+				if tt.item_codes != nil && item.Code != tt.item_codes[idx] {
+					t.Fatalf("Expected code '%s' for item %d, got '%s'", tt.item_codes[idx], idx, item.Code)
+				}
+
+				if expected == nil {
+					continue
+				}
+
+				if item.Results == nil {
+					t.Fatalf("Expected results for item %d, got code '%s'", idx, item.Code)
+				}
+
+				ids := placeIds(t, item.Results)
+
+				if !slices.Equal(ids, expected) {
+					t.Fatalf("Expected %v for item %d, got %v", expected, idx, ids)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,81 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/whosonfirst/go-whosonfirst-spatial-pip"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// INDEX_GENERATION_HEADER is the response header containing the generation of the spatial database.
+const INDEX_GENERATION_HEADER string = "X-Index-Generation"
+
+// INDEX_COMPLETE_HEADER is the response header set to "false" when results are returned before indexing is complete.
+const INDEX_COMPLETE_HEADER string = "X-Index-Complete"
+
+// INDEX_RELEASE_HEADER is the response header containing the label of the data release being indexed.
+const INDEX_RELEASE_HEADER string = "X-Index-Release"
+
+// ETagWithRequest returns a weak entity tag for the response to 'pip_req', encoded using 'format', when the spatial
+// database is in the state described by 'v'. The tag changes whenever the request, the format or the index does.
+// It is weak because responses which include timings are not byte-for-byte identical.
+func ETagWithRequest(v *pip.IndexVersion, pip_req *pip.PointInPolygonRequest, format ResponseFormat) (string, error) {
+
+	tag := struct {
+		Version *pip.IndexVersion          `json:"version"`
+		Format  ResponseFormat             `json:"format"`
+		Request *pip.PointInPolygonRequest `json:"request"`
+	}{
+		Version: v,
+		Format:  format,
+		Request: pip_req,
+	}
+
+	enc_tag, err := json.Marshal(tag)
+
+	if err != nil {
+		return "", fmt.Errorf("Failed to encode entity tag, %w", err)
+	}
+
+	hash := sha256.Sum256(enc_tag)
+	return fmt.Sprintf(`W/"%s"`, hex.EncodeToString(hash[:16])), nil
+}
+
+// MatchesETag returns true if the If-None-Match header in 'req' matches 'etag' using the weak comparison function
+// defined by RFC 9110.
+func MatchesETag(req *http.Request, etag string) bool {
+
+	if_none_match := req.Header.Get("If-None-Match")
+
+	if if_none_match == "" {
+		return false
+	}
+
+	for _, candidate := range strings.Split(if_none_match, ",") {
+
+		candidate = strings.TrimSpace(candidate)
+
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// writeIndexVersionHeaders writes the generation, release and last modified time in 'v', along with 'etag', to 'h'.
+func writeIndexVersionHeaders(h http.Header, v *pip.IndexVersion, etag string) {
+
+	h.Set(INDEX_GENERATION_HEADER, strconv.FormatUint(v.Generation, 10))
+
+	if v.Release != "" {
+		h.Set(INDEX_RELEASE_HEADER, v.Release)
+	}
+
+	h.Set("ETag", etag)
+	h.Set("Last-Modified", v.LastModified.UTC().Format(http.TimeFormat))
+}
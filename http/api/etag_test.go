@@ -0,0 +1,289 @@
+package api
+
+import (
+	"context"
+	"github.com/whosonfirst/go-whosonfirst-spatial-pip"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestETagWithRequest(t *testing.T) {
+
+	v := &pip.IndexVersion{Generation: 1, Release: "2024-01-01", LastModified: time.Unix(0, 0)}
+	req := &pip.PointInPolygonRequest{Latitude: 1, Longitude: 2}
+
+	base, err := ETagWithRequest(v, req, SPR_FORMAT)
+
+	if err != nil {
+		t.Fatalf("Failed to derive entity tag, %v", err)
+	}
+
+	if !strings.HasPrefix(base, `W/"`) || !strings.HasSuffix(base, `"`) {
+		t.Fatalf("Expected a weak entity tag, got %s", base)
+	}
+
+	tests := []struct {
+		name    string
+		version *pip.IndexVersion
+		req     *pip.PointInPolygonRequest
+		format  ResponseFormat
+		equal   bool
+	}{
+		{name: "same", version: &pip.IndexVersion{Generation: 1, Release: "2024-01-01", LastModified: time.Unix(0, 0)}, req: &pip.PointInPolygonRequest{Latitude: 1, Longitude: 2}, format: SPR_FORMAT, equal: true},
+		{name: "generation", version: &pip.IndexVersion{Generation: 2, Release: "2024-01-01", LastModified: time.Unix(0, 0)}, req: req, format: SPR_FORMAT},
+		{name: "release", version: &pip.IndexVersion{Generation: 1, Release: "2024-02-01", LastModified: time.Unix(0, 0)}, req: req, format: SPR_FORMAT},
+		{name: "request", version: v, req: &pip.PointInPolygonRequest{Latitude: 1, Longitude: 2, IsCurrent: []int64{1}}, format: SPR_FORMAT},
+		{name: "format", version: v, req: req, format: GEOJSON_FORMAT},
+	}
+
+	for _, tt := range tests {
+
+		t.Run(tt.name, func(t *testing.T) {
+
+			etag, err := ETagWithRequest(tt.version, tt.req, tt.format)
+
+			if err != nil {
+				t.Fatalf("Failed to derive entity tag, %v", err)
+			}
+
+			if (etag == base) != tt.equal {
+				t.Fatalf("Expected entity tags to be equal to be %t, got %s and %s", tt.equal, base, etag)
+			}
+		})
+	}
+}
+
+func TestMatchesETag(t *testing.T) {
+
+	etag := `W/"abc"`
+
+	tests := []struct {
+		if_none_match string
+		expected      bool
+	}{
+		{if_none_match: "", expected: false},
+		{if_none_match: `W/"abc"`, expected: true},
+		{if_none_match: `"abc"`, expected: true},
+		{if_none_match: `W/"def", W/"abc"`, expected: true},
+		{if_none_match: `*`, expected: true},
+		{if_none_match: `W/"def"`, expected: false},
+		{if_none_match: `abc`, expected: false},
+	}
+
+	for _, tt := range tests {
+
+		t.Run(tt.if_none_match, func(t *testing.T) {
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+
+			if tt.if_none_match != "" {
+				req.Header.Set("If-None-Match", tt.if_none_match)
+			}
+
+			if MatchesETag(req, etag) != tt.expected {
+				t.Fatalf("Expected match to be %t", tt.expected)
+			}
+		})
+	}
+}
+
+func TestPointInPolygonHandlerETag(t *testing.T) {
+
+	ctx := context.Background()
+
+	app := newTestApplication(t, nil)
+
+	db := app.SpatialDatabase.(*pip.SpatialDatabase)
+	db.SetRelease("test")
+
+	opts := &PointInPolygonHandlerOptions{
+		EnableGeoJSON: true,
+		Logger:        log.New(io.Discard, "", 0),
+	}
+
+	handler, err := PointInPolygonHandler(app, opts)
+
+	if err != nil {
+		t.Fatalf("Failed to create handler, %v", err)
+	}
+
+	serve := func(method string, query string, body string, headers map[string]string) *httptest.ResponseRecorder {
+
+		req := httptest.NewRequest(method, "/?"+query, strings.NewReader(body))
+
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+
+		rsp := httptest.NewRecorder()
+		handler.ServeHTTP(rsp, req)
+
+		return rsp
+	}
+
+	rsp := serve(http.MethodGet, "latitude=0.75&longitude=0.75", "", nil)
+
+	if rsp.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", rsp.Code)
+	}
+
+	etag := rsp.Header().Get("ETag")
+
+	if etag == "" {
+		t.Fatalf("Expected an entity tag")
+	}
+
+	if rsp.Header().Get(INDEX_GENERATION_HEADER) == "" || rsp.Header().Get(INDEX_RELEASE_HEADER) != "test" {
+		t.Fatalf("Expected index version headers, %v", rsp.Header())
+	}
+
+	_, err = http.ParseTime(rsp.Header().Get("Last-Modified"))
+
+	if err != nil {
+		t.Fatalf("Failed to parse Last-Modified header, %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		method  string
+		query   string
+		body    string
+		headers map[string]string
+		status  int
+		etag    bool
+	}{
+		{
+			name:    "not modified",
+			method:  http.MethodGet,
+			query:   "latitude=0.75&longitude=0.75",
+			headers: map[string]string{"If-None-Match": etag},
+			status:  http.StatusNotModified,
+			etag:    true,
+		},
+		{
+			name:    "not modified list",
+			method:  http.MethodGet,
+			query:   "latitude=0.75&longitude=0.75",
+			headers: map[string]string{"If-None-Match": `W/"other", ` + etag},
+			status:  http.StatusNotModified,
+			etag:    true,
+		},
+		{
+			name:    "different request",
+			method:  http.MethodGet,
+			query:   "latitude=0.75&longitude=0.75&is_current=1",
+			headers: map[string]string{"If-None-Match": etag},
+			status:  http.StatusOK,
+		},
+		{
+			name:    "different format",
+			method:  http.MethodGet,
+			query:   "latitude=0.75&longitude=0.75",
+			headers: map[string]string{"If-None-Match": etag, "Accept": GEOJSON},
+			status:  http.StatusOK,
+		},
+		{
+			name:    "post",
+			method:  http.MethodPost,
+			body:    `{"latitude":0.75,"longitude":0.75}`,
+			headers: map[string]string{"If-None-Match": etag},
+			status:  http.StatusOK,
+			etag:    true,
+		},
+		{
+			name:    "invalid request",
+			method:  http.MethodGet,
+			query:   "latitude=91&longitude=0.75",
+			headers: map[string]string{"If-None-Match": etag},
+			status:  http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+
+		t.Run(tt.name, func(t *testing.T) {
+
+			rsp := serve(tt.method, tt.query, tt.body, tt.headers)
+
+			if rsp.Code != tt.status {
+				t.Fatalf("Expected status %d, got %d", tt.status, rsp.Code)
+			}
+
+			if tt.status == http.StatusNotModified && rsp.Body.Len() != 0 {
+				t.Fatalf("Expected an empty response body, got %s", rsp.Body.String())
+			}
+
+			if tt.status != http.StatusOK && tt.status != http.StatusNotModified {
+
+				if rsp.Header().Get("ETag") != "" {
+					t.Fatalf("Expected no entity tag for an error response")
+				}
+
+				return
+			}
+
+			if (rsp.Header().Get("ETag") == etag) != tt.etag {
+				t.Fatalf("Expected entity tag %s to be the same to be %t, got %s", etag, tt.etag, rsp.Header().Get("ETag"))
+			}
+		})
+	}
+
+	// Once the index changes the client's copy is stale
+
+	err = db.RemoveFeature(ctx, "101000001")
+
+	if err != nil {
+		t.Fatalf("Failed to remove feature, %v", err)
+	}
+
+	rsp = serve(http.MethodGet, "latitude=0.75&longitude=0.75", "", map[string]string{"If-None-Match": etag})
+
+	if rsp.Code != http.StatusOK {
+		t.Fatalf("Expected status 200 after the index changed, got %d", rsp.Code)
+	}
+
+	if rsp.Header().Get("ETag") == "" || rsp.Header().Get("ETag") == etag {
+		t.Fatalf("Expected a new entity tag after the index changed, got %s", rsp.Header().Get("ETag"))
+	}
+}
+
+func TestPointInPolygonHandlerETagIndexing(t *testing.T) {
+
+	app := newTestApplication(t, nil)
+
+	opts := &PointInPolygonHandlerOptions{
+		IndexingOptions: IndexingOptions{ServeWhileIndexing: true},
+		Logger:          log.New(io.Discard, "", 0),
+	}
+
+	handler, err := PointInPolygonHandler(app, opts)
+
+	if err != nil {
+		t.Fatalf("Failed to create handler, %v", err)
+	}
+
+	// While indexing the generation is not incremented for every record so there is no entity tag
+
+	stop := startIndexing(t, app)
+	defer stop()
+
+	req := httptest.NewRequest(http.MethodGet, "/?latitude=0.75&longitude=0.75", nil)
+	req.Header.Set("If-None-Match", "*")
+
+	rsp := httptest.NewRecorder()
+
+	handler.ServeHTTP(rsp, req)
+
+	if rsp.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", rsp.Code)
+	}
+
+	if rsp.Header().Get("ETag") != "" || rsp.Header().Get(INDEX_GENERATION_HEADER) != "" {
+		t.Fatalf("Expected no index version headers while indexing, %v", rsp.Header())
+	}
+}
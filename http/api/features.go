@@ -0,0 +1,301 @@
+package api
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/tidwall/gjson"
+	"github.com/whosonfirst/go-whosonfirst-feature/alt"
+	"github.com/whosonfirst/go-whosonfirst-feature/geometry"
+	"github.com/whosonfirst/go-whosonfirst-feature/properties"
+	"github.com/whosonfirst/go-whosonfirst-spatial-pip"
+	spatial_app "github.com/whosonfirst/go-whosonfirst-spatial/app"
+	"github.com/whosonfirst/go-whosonfirst-spr/v2"
+	"github.com/whosonfirst/go-whosonfirst-uri"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// DEFAULT_FEATURES_MAX_BODY_SIZE is the default maximum size, in bytes, of a body POST-ed to the features handler.
+const DEFAULT_FEATURES_MAX_BODY_SIZE int64 = 32 * 1024 * 1024
+
+type FeaturesHandlerOptions struct {
+	// The bearer token that requests must present in their Authorization header. Required.
+	Token string
+	// The maximum size, in bytes, of a POST-ed body. If 0 then DEFAULT_FEATURES_MAX_BODY_SIZE is used.
+	MaxBodySize int64
+	Logger      *log.Logger
+}
+
+// FeaturesResponse is the JSON-encoded response returned by the features handler after the index has been changed.
+type FeaturesResponse struct {
+	// The generation of the spatial database after the change, if it tracks changes.
+	Generation uint64 `json:"generation"`
+	// The IDs of the records that were indexed.
+	Indexed []int64 `json:"indexed,omitempty"`
+	// The IDs of the records that were removed.
+	Removed []int64 `json:"removed,omitempty"`
+	// The features that could not be indexed.
+	Failed []*FeaturesResponseError `json:"failed,omitempty"`
+}
+
+// FeaturesResponseError describes a feature in a POST request to the features handler that could not be indexed.
+type FeaturesResponseError struct {
+	Id    int64         `json:"id"`
+	Error string        `json:"error"`
+	Code  pip.ErrorCode `json:"code"`
+}
+
+// FeaturesHandler returns a http.Handler for changing the records in the spatial database of 'app' while the server
+// is running. A POST request with a Who's On First GeoJSON Feature, or FeatureCollection, body indexes each feature,
+// replacing any existing record with the same ID. If any feature would not be indexed, because it does not have a
+// Polygon or MultiPolygon geometry or because it is an alternate geometry file and the spatial database does not index
+// them, the entire request is rejected. If indexing a feature fails the remaining features are still indexed and the
+// response, whose status is 500 Internal Server Error, lists the features that were indexed and those that failed. A
+// DELETE request for a path whose "id" wildcard is a record ID, for example "/features/{id}", removes that record.
+// Every request must present opts.Token as a bearer token. In both cases the response is a JSON-encoded
+// FeaturesResponse containing the new index generation. Changes are not carried over if the spatial database is
+// rebuilt from its source URIs, for example when the server receives a SIGHUP signal: removed records whose files
+// are still present are indexed again and indexed records which are not in the source URIs are lost.
+func FeaturesHandler(app *spatial_app.SpatialApplication, opts *FeaturesHandlerOptions) (http.Handler, error) {
+
+	if opts.Token == "" {
+		return nil, fmt.Errorf("Missing token")
+	}
+
+	max_body_size := opts.MaxBodySize
+
+	if max_body_size <= 0 {
+		max_body_size = DEFAULT_FEATURES_MAX_BODY_SIZE
+	}
+
+	// Alternate geometry files are only accepted if the spatial database is known to index them
+
+	index_alt_files := false
+
+	spatial_db, ok := app.SpatialDatabase.(*pip.SpatialDatabase)
+
+	if ok {
+		index_alt_files = spatial_db.IndexAltFiles()
+	}
+
+	fn := func(rsp http.ResponseWriter, req *http.Request) {
+
+		ctx := req.Context()
+
+		status := http.StatusOK
+
+		if !isAuthorized(req, opts.Token) {
+			rsp.Header().Set("WWW-Authenticate", `Bearer realm="pip"`)
+			WriteProblem(rsp, req, UNAUTHORIZED, "Missing or invalid bearer token")
+			return
+		}
+
+		features_rsp := &FeaturesResponse{}
+		str_id := req.PathValue("id")
+
+		switch {
+		case req.Method == "POST" && str_id == "":
+
+			body, err := io.ReadAll(http.MaxBytesReader(rsp, req.Body, max_body_size))
+
+			if err != nil {
+
+				var max_err *http.MaxBytesError
+
+				if errors.As(err, &max_err) {
+					WriteProblem(rsp, req, REQUEST_TOO_LARGE, fmt.Sprintf("Request body exceeds the maximum size of %d bytes", max_body_size))
+					return
+				}
+
+				WriteError(rsp, req, err, pip.INVALID_REQUEST)
+				return
+			}
+
+			features, err := featuresWithBody(body, index_alt_files)
+
+			if err != nil {
+				WriteError(rsp, req, err, pip.INVALID_REQUEST)
+				return
+			}
+
+			features_rsp.Indexed = make([]int64, 0)
+
+			for _, f := range features {
+
+				id, _ := properties.Id(f)
+
+				err := app.SpatialDatabase.IndexFeature(ctx, f)
+
+				if err != nil {
+
+					opts.Logger.Printf("Failed to index feature %d, %v", id, err)
+
+					features_rsp.Failed = append(features_rsp.Failed, &FeaturesResponseError{
+						Id:    id,
+						Error: fmt.Sprintf("Failed to index %d, %v", id, err),
+						Code:  pip.BACKEND_ERROR,
+					})
+
+					continue
+				}
+
+				features_rsp.Indexed = append(features_rsp.Indexed, id)
+			}
+
+			if len(features_rsp.Failed) > 0 {
+				status = http.StatusInternalServerError
+			}
+
+			opts.Logger.Printf("Indexed %d features, %d failed", len(features_rsp.Indexed), len(features_rsp.Failed))
+
+		case req.Method == "DELETE" && str_id != "":
+
+			id, err := strconv.ParseInt(str_id, 10, 64)
+
+			if err != nil {
+				WriteProblem(rsp, req, pip.INVALID_PARAMETER, "Invalid ID")
+				return
+			}
+
+			rel_path, err := uri.Id2RelPath(id)
+
+			if err != nil {
+				WriteProblem(rsp, req, pip.INVALID_PARAMETER, "Invalid ID")
+				return
+			}
+
+			fh, err := app.SpatialDatabase.Read(ctx, rel_path)
+
+			if err != nil {
+				WriteProblem(rsp, req, NOT_FOUND, fmt.Sprintf("Record %d is not indexed", id))
+				return
+			}
+
+			fh.Close()
+
+			err = app.SpatialDatabase.RemoveFeature(ctx, str_id)
+
+			if err != nil {
+				WriteError(rsp, req, pip.NewError(pip.BACKEND_ERROR, fmt.Errorf("Failed to remove %d, %w", id, err)), INTERNAL_ERROR)
+				return
+			}
+
+			features_rsp.Removed = []int64{id}
+
+			opts.Logger.Printf("Removed feature %d", id)
+
+		default:
+			WriteProblem(rsp, req, METHOD_NOT_ALLOWED, "Unsupported method")
+			return
+		}
+
+		version, has_version := pip.IndexVersionWithApplication(app)
+
+		if has_version {
+			features_rsp.Generation = version.Generation
+		}
+
+		rsp.Header().Set("Content-Type", JSON)
+		rsp.Header().Set("Cache-Control", "no-store")
+		rsp.WriteHeader(status)
+
+		err := json.NewEncoder(rsp).Encode(features_rsp)
+
+		if err != nil {
+			opts.Logger.Printf("Failed to encode features response, %v", err)
+		}
+
+		return
+	}
+
+	features_handler := http.HandlerFunc(fn)
+	return features_handler, nil
+}
+
+// featuresWithBody returns the features in 'body' which must be either a GeoJSON Feature or FeatureCollection. Every
+// feature must have a Polygon or MultiPolygon geometry and the properties required to derive a standard places
+// response, and must not be an alternate geometry file unless 'index_alt_files' is true, otherwise an error is returned
+// and none are.
+func featuresWithBody(body []byte, index_alt_files bool) ([][]byte, error) {
+
+	if !gjson.ValidBytes(body) {
+		return nil, pip.NewError(pip.INVALID_REQUEST, fmt.Errorf("Invalid JSON"))
+	}
+
+	features := make([][]byte, 0)
+
+	switch gjson.GetBytes(body, "type").String() {
+	case "Feature":
+		features = append(features, body)
+	case "FeatureCollection":
+
+		for _, f := range gjson.GetBytes(body, "features").Array() {
+			features = append(features, []byte(f.Raw))
+		}
+
+	default:
+		return nil, pip.NewError(pip.INVALID_REQUEST, fmt.Errorf("Body must be a GeoJSON Feature or FeatureCollection"))
+	}
+
+	if len(features) == 0 {
+		return nil, pip.NewError(pip.INVALID_REQUEST, fmt.Errorf("No features to index"))
+	}
+
+	for idx, f := range features {
+
+		id, err := properties.Id(f)
+
+		if err != nil {
+			return nil, pip.NewError(pip.INVALID_REQUEST, fmt.Errorf("Failed to derive ID for feature %d, %w", idx, err))
+		}
+
+		if alt.IsAlt(f) && !index_alt_files {
+			return nil, pip.NewError(pip.INVALID_REQUEST, fmt.Errorf("Alternate geometry file for %d is not indexed by the spatial database", id))
+		}
+
+		geom, err := geometry.Geometry(f)
+
+		if err != nil {
+			return nil, pip.NewError(pip.INVALID_REQUEST, fmt.Errorf("Failed to derive geometry for %d, %w", id, err))
+		}
+
+		switch geom.Geometry().GeoJSONType() {
+		case "Polygon", "MultiPolygon":
+			// pass
+		default:
+			return nil, pip.NewError(pip.INVALID_REQUEST, fmt.Errorf("Geometry for %d must be a Polygon or MultiPolygon", id))
+		}
+
+		_, err = spr.WhosOnFirstSPR(f)
+
+		if err != nil {
+			return nil, pip.NewError(pip.INVALID_REQUEST, fmt.Errorf("Failed to derive standard places response for %d, %w", id, err))
+		}
+	}
+
+	return features, nil
+}
+
+// isAuthorized returns true if 'req' has an Authorization header containing the bearer token 'token'.
+func isAuthorized(req *http.Request, token string) bool {
+
+	auth := req.Header.Get("Authorization")
+	scheme, credentials, ok := strings.Cut(auth, " ")
+
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return false
+	}
+
+	// Compare hashes so that the comparison does not leak the length of the token
+
+	expected := sha256.Sum256([]byte(token))
+	actual := sha256.Sum256([]byte(strings.TrimSpace(credentials)))
+
+	return subtle.ConstantTimeCompare(expected[:], actual[:]) == 1
+}
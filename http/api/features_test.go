@@ -0,0 +1,412 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/whosonfirst/go-whosonfirst-feature/properties"
+	"github.com/whosonfirst/go-whosonfirst-spatial-pip"
+	"github.com/whosonfirst/go-whosonfirst-spatial/database"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// featureBody returns a Who's On First GeoJSON feature for the locality 'id' covering the box between 7,7 and 8,8.
+func featureBody(id int64) string {
+	return fmt.Sprintf(`{"type":"Feature","id":%d,"properties":{"wof:id":%d,"wof:name":"Test","wof:placetype":"locality","wof:parent_id":-1,"wof:repo":"whosonfirst-data-test","wof:lastmodified":1,"geom:latitude":7.5,"geom:longitude":7.5},"bbox":[7,7,8,8],"geometry":{"type":"Polygon","coordinates":[[[7,7],[8,7],[8,8],[7,8],[7,7]]]}}`, id, id)
+}
+
+func TestFeaturesHandler(t *testing.T) {
+
+	app := newTestApplication(t, nil)
+
+	opts := &FeaturesHandlerOptions{
+		Token:       "s33kret",
+		MaxBodySize: 4096,
+		Logger:      log.New(io.Discard, "", 0),
+	}
+
+	features_handler, err := FeaturesHandler(app, opts)
+
+	if err != nil {
+		t.Fatalf("Failed to create handler, %v", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/features", features_handler)
+	mux.Handle("/features/{id}", features_handler)
+
+	pip_handler, err := PointInPolygonHandler(app, &PointInPolygonHandlerOptions{Logger: log.New(io.Discard, "", 0)})
+
+	if err != nil {
+		t.Fatalf("Failed to create point in polygon handler, %v", err)
+	}
+
+	// Each step changes the index, in order, and is followed by a point-in-polygon query at 'latitude', 'latitude'
+
+	tests := []struct {
+		name     string
+		method   string
+		path     string
+		body     string
+		token    string
+		status   int
+		code     pip.ErrorCode
+		indexed  []int64
+		removed  []int64
+		latitude float64
+		expected []string
+	}{
+		{
+			name:   "missing token",
+			method: http.MethodPost,
+			path:   "/features",
+			body:   featureBody(1),
+			status: http.StatusUnauthorized,
+			code:   UNAUTHORIZED,
+		},
+		{
+			name:   "invalid token",
+			method: http.MethodPost,
+			path:   "/features",
+			body:   featureBody(1),
+			token:  "Bearer s33krets",
+			status: http.StatusUnauthorized,
+			code:   UNAUTHORIZED,
+		},
+		{
+			name:     "index feature",
+			method:   http.MethodPost,
+			path:     "/features",
+			body:     featureBody(1),
+			token:    "Bearer s33kret",
+			status:   http.StatusOK,
+			indexed:  []int64{1},
+			latitude: 7.5,
+			expected: []string{"1"},
+		},
+		{
+			name:     "index feature collection",
+			method:   http.MethodPost,
+			path:     "/features",
+			body:     fmt.Sprintf(`{"type":"FeatureCollection","features":[%s,%s]}`, featureBody(2), featureBody(1)),
+			token:    "bearer s33kret",
+			status:   http.StatusOK,
+			indexed:  []int64{2, 1},
+			latitude: 7.5,
+			expected: []string{"1", "2"},
+		},
+		{
+			name:     "remove feature",
+			method:   http.MethodDelete,
+			path:     "/features/1",
+			token:    "Bearer s33kret",
+			status:   http.StatusOK,
+			removed:  []int64{1},
+			latitude: 7.5,
+			expected: []string{"2"},
+		},
+		{
+			name:   "remove removed feature",
+			method: http.MethodDelete,
+			path:   "/features/1",
+			token:  "Bearer s33kret",
+			status: http.StatusNotFound,
+			code:   NOT_FOUND,
+		},
+		{
+			name:   "remove unknown feature",
+			method: http.MethodDelete,
+			path:   "/features/999999",
+			token:  "Bearer s33kret",
+			status: http.StatusNotFound,
+			code:   NOT_FOUND,
+		},
+		{
+			name:   "invalid id",
+			method: http.MethodDelete,
+			path:   "/features/abc",
+			token:  "Bearer s33kret",
+			status: http.StatusBadRequest,
+			code:   pip.INVALID_PARAMETER,
+		},
+		{
+			name:     "remove fixture",
+			method:   http.MethodDelete,
+			path:     "/features/101000003",
+			token:    "Bearer s33kret",
+			status:   http.StatusOK,
+			removed:  []int64{101000003},
+			latitude: 5.5,
+			expected: []string{},
+		},
+		{
+			name:     "reindex removed feature",
+			method:   http.MethodPost,
+			path:     "/features",
+			body:     featureBody(1),
+			token:    "Bearer s33kret",
+			status:   http.StatusOK,
+			indexed:  []int64{1},
+			latitude: 7.5,
+			expected: []string{"1", "2"},
+		},
+		{
+			name:   "invalid json",
+			method: http.MethodPost,
+			path:   "/features",
+			body:   `{"type":`,
+			token:  "Bearer s33kret",
+			status: http.StatusBadRequest,
+			code:   pip.INVALID_REQUEST,
+		},
+		{
+			name:   "not a feature",
+			method: http.MethodPost,
+			path:   "/features",
+			body:   `{"type":"Point","coordinates":[0,0]}`,
+			token:  "Bearer s33kret",
+			status: http.StatusBadRequest,
+			code:   pip.INVALID_REQUEST,
+		},
+		{
+			name:   "empty feature collection",
+			method: http.MethodPost,
+			path:   "/features",
+			body:   `{"type":"FeatureCollection","features":[]}`,
+			token:  "Bearer s33kret",
+			status: http.StatusBadRequest,
+			code:   pip.INVALID_REQUEST,
+		},
+		{
+			name:   "invalid feature in collection",
+			method: http.MethodPost,
+			path:   "/features",
+			body:   fmt.Sprintf(`{"type":"FeatureCollection","features":[%s,{"type":"Feature","properties":{}}]}`, featureBody(3)),
+			token:  "Bearer s33kret",
+			status: http.StatusBadRequest,
+			code:   pip.INVALID_REQUEST,
+		},
+		{
+			name:   "point geometry",
+			method: http.MethodPost,
+			path:   "/features",
+			body:   fmt.Sprintf(`{"type":"FeatureCollection","features":[%s,%s]}`, featureBody(3), strings.Replace(featureBody(4), `"type":"Polygon","coordinates":[[[7,7],[8,7],[8,8],[7,8],[7,7]]]`, `"type":"Point","coordinates":[7.5,7.5]`, 1)),
+			token:  "Bearer s33kret",
+			status: http.StatusBadRequest,
+			code:   pip.INVALID_REQUEST,
+		},
+		{
+			name:   "alternate geometry",
+			method: http.MethodPost,
+			path:   "/features",
+			body:   strings.Replace(featureBody(3), `"wof:name":"Test"`, `"wof:name":"Test","src:alt_label":"example"`, 1),
+			token:  "Bearer s33kret",
+			status: http.StatusBadRequest,
+			code:   pip.INVALID_REQUEST,
+		},
+		{
+			name:   "too large",
+			method: http.MethodPost,
+			path:   "/features",
+			body:   fmt.Sprintf(`{"type":"Feature","properties":{"padding":"%s"}}`, strings.Repeat("x", 4096)),
+			token:  "Bearer s33kret",
+			status: http.StatusRequestEntityTooLarge,
+			code:   REQUEST_TOO_LARGE,
+		},
+		{
+			name:   "method",
+			method: http.MethodGet,
+			path:   "/features/1",
+			token:  "Bearer s33kret",
+			status: http.StatusMethodNotAllowed,
+			code:   METHOD_NOT_ALLOWED,
+		},
+		{
+			name:     "unchanged",
+			latitude: 7.5,
+			expected: []string{"1", "2"},
+		},
+	}
+
+	generation := app.SpatialDatabase.(*pip.SpatialDatabase).Generation()
+
+	for _, tt := range tests {
+
+		t.Run(tt.name, func(t *testing.T) {
+
+			if tt.method != "" {
+
+				req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+
+				if tt.token != "" {
+					req.Header.Set("Authorization", tt.token)
+				}
+
+				rsp := httptest.NewRecorder()
+
+				mux.ServeHTTP(rsp, req)
+
+				if tt.code != "" {
+
+					decodeProblem(t, rsp, tt.status, tt.code)
+
+					if tt.status == http.StatusUnauthorized && rsp.Header().Get("WWW-Authenticate") == "" {
+						t.Fatalf("Expected WWW-Authenticate header")
+					}
+
+					return
+				}
+
+				if rsp.Code != tt.status {
+					t.Fatalf("Expected status %d, got %d: %s", tt.status, rsp.Code, rsp.Body.String())
+				}
+
+				var features_rsp *FeaturesResponse
+
+				err := json.Unmarshal(rsp.Body.Bytes(), &features_rsp)
+
+				if err != nil {
+					t.Fatalf("Failed to decode response, %v", err)
+				}
+
+				if !reflect.DeepEqual(features_rsp.Indexed, tt.indexed) || !reflect.DeepEqual(features_rsp.Removed, tt.removed) {
+					t.Fatalf("Expected indexed %v and removed %v, got %+v", tt.indexed, tt.removed, features_rsp)
+				}
+
+				changes := uint64(len(tt.indexed) + len(tt.removed))
+
+				if features_rsp.Generation != generation+changes {
+					t.Fatalf("Expected generation %d, got %d", generation+changes, features_rsp.Generation)
+				}
+
+				generation = features_rsp.Generation
+			}
+
+			if tt.expected == nil {
+				return
+			}
+
+			req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/?latitude=%v&longitude=%v", tt.latitude, tt.latitude), nil)
+			rsp := httptest.NewRecorder()
+
+			pip_handler.ServeHTTP(rsp, req)
+
+			if rsp.Code != http.StatusOK {
+				t.Fatalf("Expected status 200, got %d", rsp.Code)
+			}
+
+			ids := placeIds(t, rsp.Body.Bytes())
+
+			if !reflect.DeepEqual(ids, tt.expected) {
+				t.Fatalf("Expected %v, got %v", tt.expected, ids)
+			}
+		})
+	}
+}
+
+func TestFeaturesHandlerToken(t *testing.T) {
+
+	app := newTestApplication(t, nil)
+
+	_, err := FeaturesHandler(app, &FeaturesHandlerOptions{Logger: log.New(io.Discard, "", 0)})
+
+	if err == nil {
+		t.Fatalf("Expected an error creating a features handler without a token")
+	}
+}
+
+// failingDatabase is a database.SpatialDatabase instance that fails to index the record 'fail_id'.
+type failingDatabase struct {
+	database.SpatialDatabase
+	fail_id int64
+}
+
+func (db *failingDatabase) IndexFeature(ctx context.Context, body []byte) error {
+
+	id, err := properties.Id(body)
+
+	if err != nil {
+		return err
+	}
+
+	if id == db.fail_id {
+		return fmt.Errorf("Failed to index %d", id)
+	}
+
+	return db.SpatialDatabase.IndexFeature(ctx, body)
+}
+
+func TestFeaturesHandlerPartialFailure(t *testing.T) {
+
+	app := newTestApplication(t, nil)
+
+	failing_app := *app
+	failing_app.SpatialDatabase = &failingDatabase{SpatialDatabase: app.SpatialDatabase, fail_id: 2}
+
+	opts := &FeaturesHandlerOptions{
+		Token:  "s33kret",
+		Logger: log.New(io.Discard, "", 0),
+	}
+
+	handler, err := FeaturesHandler(&failing_app, opts)
+
+	if err != nil {
+		t.Fatalf("Failed to create handler, %v", err)
+	}
+
+	body := fmt.Sprintf(`{"type":"FeatureCollection","features":[%s,%s,%s]}`, featureBody(1), featureBody(2), featureBody(3))
+
+	req := httptest.NewRequest(http.MethodPost, "/features", strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer s33kret")
+
+	rsp := httptest.NewRecorder()
+
+	handler.ServeHTTP(rsp, req)
+
+	if rsp.Code != http.StatusInternalServerError {
+		t.Fatalf("Expected status 500, got %d", rsp.Code)
+	}
+
+	var features_rsp *FeaturesResponse
+
+	err = json.Unmarshal(rsp.Body.Bytes(), &features_rsp)
+
+	if err != nil {
+		t.Fatalf("Failed to decode response, %v", err)
+	}
+
+	if !reflect.DeepEqual(features_rsp.Indexed, []int64{1, 3}) {
+		t.Fatalf("Expected 1 and 3 to be indexed, got %v", features_rsp.Indexed)
+	}
+
+	if len(features_rsp.Failed) != 1 || features_rsp.Failed[0].Id != 2 || features_rsp.Failed[0].Code != pip.BACKEND_ERROR {
+		t.Fatalf("Expected 2 to have failed, got %+v", features_rsp.Failed)
+	}
+
+	// The features which were reported as indexed can be queried
+
+	pip_rsp, err := pip.QueryPointInPolygon(context.Background(), app, &pip.PointInPolygonRequest{Latitude: 7.5, Longitude: 7.5})
+
+	if err != nil {
+		t.Fatalf("Failed to query point in polygon, %v", err)
+	}
+
+	ids := make([]string, 0)
+
+	for _, r := range pip_rsp.Results() {
+		ids = append(ids, r.Id())
+	}
+
+	slices.Sort(ids)
+
+	if !reflect.DeepEqual(ids, []string{"1", "3"}) {
+		t.Fatalf("Expected 1 and 3, got %v", ids)
+	}
+}
@@ -0,0 +1,205 @@
+package api
+
+import (
+	_ "github.com/whosonfirst/go-whosonfirst-spatial-rtree"
+)
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/whosonfirst/go-whosonfirst-iterate/v2/iterator"
+	"github.com/whosonfirst/go-whosonfirst-spatial-pip"
+	spatial_app "github.com/whosonfirst/go-whosonfirst-spatial/app"
+	"github.com/whosonfirst/go-whosonfirst-spatial/database"
+	"io"
+	"log"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fixturesPath is the directory containing the test records. It contains a country (85000001), a region (85000002),
+// two localities (101000001 and the ceased 101000002) and a neighbourhood (102000001) nested inside one another around
+// 0,0 as well as an unrelated locality (101000003) between 5,5 and 6,6 and an alternate geometry for 101000001.
+const fixturesPath string = "../../fixtures/data"
+
+// newTestApplication returns a new spatial_app.SpatialApplication instance whose spatial database is a pip.SpatialDatabase
+// instance, configured by 'opts', wrapping a rtree:// database in which the records in fixturesPath have been indexed.
+func newTestApplication(t *testing.T, opts *pip.SpatialDatabaseOptions) *spatial_app.SpatialApplication {
+
+	t.Helper()
+
+	ctx := context.Background()
+
+	rtree_db, err := database.NewSpatialDatabase(ctx, "rtree://")
+
+	if err != nil {
+		t.Fatalf("Failed to create rtree database, %v", err)
+	}
+
+	db, err := pip.NewSpatialDatabase(ctx, rtree_db, opts)
+
+	if err != nil {
+		t.Fatalf("Failed to create spatial database, %v", err)
+	}
+
+	iter_cb := func(ctx context.Context, path string, r io.ReadSeeker, args ...interface{}) error {
+
+		body, err := io.ReadAll(r)
+
+		if err != nil {
+			return err
+		}
+
+		return db.IndexFeature(ctx, body)
+	}
+
+	iter, err := iterator.NewIterator(ctx, "directory://", iter_cb)
+
+	if err != nil {
+		t.Fatalf("Failed to create iterator, %v", err)
+	}
+
+	iter.Logger = log.New(io.Discard, "", 0)
+
+	err = iter.IterateURIs(ctx, fixturesPath)
+
+	if err != nil {
+		t.Fatalf("Failed to index fixtures, %v", err)
+	}
+
+	app := &spatial_app.SpatialApplication{
+		SpatialDatabase:  db,
+		PropertiesReader: db,
+		Iterator:         iter,
+		Logger:           log.New(io.Discard, "", 0),
+		Monitor:          pip.NewRequestMonitor(),
+	}
+
+	return app
+}
+
+// decodeProblem decodes the problem details response in 'rsp', failing if it is not a problem details response with the
+// status 'status' and the code 'code'.
+func decodeProblem(t *testing.T, rsp *httptest.ResponseRecorder, status int, code pip.ErrorCode) *Problem {
+
+	t.Helper()
+
+	if rsp.Code != status {
+		t.Fatalf("Expected status %d, got %d: %s", status, rsp.Code, rsp.Body.String())
+	}
+
+	if rsp.Header().Get("Content-Type") != PROBLEM_JSON {
+		t.Fatalf("Expected %s response, got %s", PROBLEM_JSON, rsp.Header().Get("Content-Type"))
+	}
+
+	var problem *Problem
+
+	err := json.Unmarshal(rsp.Body.Bytes(), &problem)
+
+	if err != nil {
+		t.Fatalf("Failed to decode problem, %v", err)
+	}
+
+	if problem.Status != status || problem.Code != code {
+		t.Fatalf("Expected %d %s problem, got %d %s", status, code, problem.Status, problem.Code)
+	}
+
+	return problem
+}
+
+// placeIds returns the sorted IDs of the places in the JSON-encoded SPR response 'body'.
+func placeIds(t *testing.T, body []byte) []string {
+
+	t.Helper()
+
+	var spr_rsp struct {
+		Places []struct {
+			Id int64 `json:"wof:id"`
+		} `json:"places"`
+	}
+
+	err := json.Unmarshal(body, &spr_rsp)
+
+	if err != nil {
+		t.Fatalf("Failed to decode SPR response, %v", err)
+	}
+
+	ids := make([]string, len(spr_rsp.Places))
+
+	for idx, p := range spr_rsp.Places {
+		ids[idx] = strconv.FormatInt(p.Id, 10)
+	}
+
+	slices.Sort(ids)
+	return ids
+}
+
+// startIndexing replaces the iterator for 'app' with one that re-indexes the records in fixturesPath but blocks before
+// indexing 85000002, the last record it walks, so that handlers can be tested while records are still being indexed.
+// It returns once every other record has been seen, along with a function that unblocks the iterator and waits for it
+// to complete.
+func startIndexing(t *testing.T, app *spatial_app.SpatialApplication) func() {
+
+	t.Helper()
+
+	ctx := context.Background()
+
+	db := app.SpatialDatabase.(*pip.SpatialDatabase)
+	release := make(chan bool)
+
+	iter_cb := func(ctx context.Context, path string, r io.ReadSeeker, args ...interface{}) error {
+
+		if strings.HasSuffix(path, "85000002.geojson") {
+			<-release
+		}
+
+		body, err := io.ReadAll(r)
+
+		if err != nil {
+			return err
+		}
+
+		return db.IndexFeature(ctx, body)
+	}
+
+	iter, err := iterator.NewIterator(ctx, "directory://", iter_cb)
+
+	if err != nil {
+		t.Fatalf("Failed to create iterator, %v", err)
+	}
+
+	iter.Logger = log.New(io.Discard, "", 0)
+	app.Iterator = iter
+
+	done_ch := make(chan error)
+
+	go func() {
+		done_ch <- iter.IterateURIs(ctx, fixturesPath)
+	}()
+
+	for atomic.LoadInt64(&iter.Seen) < 6 {
+		time.Sleep(time.Millisecond)
+	}
+
+	if !iter.IsIndexing() {
+		t.Fatalf("Expected iterator to be indexing")
+	}
+
+	stop := func() {
+
+		close(release)
+
+		err := <-done_ch
+
+		if err != nil {
+			t.Fatalf("Failed to index fixtures, %v", err)
+		}
+	}
+
+	return stop
+}
@@ -0,0 +1,376 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/sfomuseum/go-timings"
+	"github.com/tidwall/sjson"
+	"github.com/whosonfirst/go-ioutil"
+	"github.com/whosonfirst/go-reader"
+	"github.com/whosonfirst/go-whosonfirst-spatial"
+	"github.com/whosonfirst/go-whosonfirst-spatial-pip"
+	spatial_app "github.com/whosonfirst/go-whosonfirst-spatial/app"
+	"github.com/whosonfirst/go-whosonfirst-spr-geojson"
+	"github.com/whosonfirst/go-whosonfirst-spr/v2"
+	"io"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const JSON string = "application/json"
+
+// ResponseFormat is the format used to encode point-in-polygon results.
+type ResponseFormat string
+
+// SPR_FORMAT encodes results as a JSON-encoded list of standard places responses.
+const SPR_FORMAT ResponseFormat = "spr"
+
+// PROPERTIES_FORMAT encodes results as a JSON-encoded list of standard places responses with additional properties appended.
+const PROPERTIES_FORMAT ResponseFormat = "properties"
+
+// GEOJSON_FORMAT encodes results as a GeoJSON FeatureCollection with any additional properties merged in.
+const GEOJSON_FORMAT ResponseFormat = "geojson"
+
+// ContentType returns the media type for 'f'.
+func (f ResponseFormat) ContentType() string {
+
+	switch f {
+	case GEOJSON_FORMAT:
+		return GEOJSON
+	default:
+		return JSON
+	}
+}
+
+// NegotiateResponseFormat returns the response format for 'pip_req' derived from the Accept header in 'req'. If the
+// client does not accept any of the supported media types then an error is returned. GeoJSON output is only considered
+// if 'enable_geojson' is true. Handlers that call NegotiateResponseFormat should add "Accept" to the Vary header of
+// their responses.
+func NegotiateResponseFormat(req *http.Request, pip_req *pip.PointInPolygonRequest, enable_geojson bool) (ResponseFormat, error) {
+
+	offers := []string{
+		JSON,
+	}
+
+	if enable_geojson {
+		offers = append(offers, GEOJSON)
+	}
+
+	media_type, ok := negotiateMediaType(req.Header.Get("Accept"), offers)
+
+	if !ok {
+		return "", fmt.Errorf("None of the requested media types are supported. Supported media types are: %s", strings.Join(offers, ", "))
+	}
+
+	if media_type == GEOJSON {
+		return GEOJSON_FORMAT, nil
+	}
+
+	if len(pip_req.Properties) > 0 {
+		return PROPERTIES_FORMAT, nil
+	}
+
+	return SPR_FORMAT, nil
+}
+
+// negotiateResponseFormat adds "Accept" to the Vary header of 'rsp', since the response format depends on it, and
+// returns the response format for 'pip_req' as described by NegotiateResponseFormat.
+func negotiateResponseFormat(rsp http.ResponseWriter, req *http.Request, pip_req *pip.PointInPolygonRequest, enable_geojson bool) (ResponseFormat, error) {
+
+	rsp.Header().Add("Vary", "Accept")
+	return NegotiateResponseFormat(req, pip_req, enable_geojson)
+}
+
+// WriteResponse encodes 'results' using 'format' and writes them to 'rsp'. The response is buffered so that encoding
+// errors can still be reported to the client. If the application's Monitor is a `pip.RequestMonitor` instance then the
+// timings it has collected, including those for encoding the response, are written as a `Server-Timing` header and, if
+// requested by 'pip_req', included in the response body.
+func WriteResponse(ctx context.Context, rsp http.ResponseWriter, app *spatial_app.SpatialApplication, pip_req *pip.PointInPolygonRequest, format ResponseFormat, results spr.StandardPlacesResults) error {
+	return WriteResponseWithHeaders(ctx, rsp, app, pip_req, format, results, nil)
+}
+
+// WriteResponseWithHeaders is identical to WriteResponse except that 'headers' are also written to 'rsp' once 'results'
+// have been encoded successfully, so that they are not included in error responses.
+func WriteResponseWithHeaders(ctx context.Context, rsp http.ResponseWriter, app *spatial_app.SpatialApplication, pip_req *pip.PointInPolygonRequest, format ResponseFormat, results spr.StandardPlacesResults, headers http.Header) error {
+
+	var buf bytes.Buffer
+
+	m, has_monitor := app.Monitor.(*pip.RequestMonitor)
+	include_timings := has_monitor && pip_req.Timings
+
+	switch format {
+	case GEOJSON_FORMAT:
+
+		app.Monitor.Signal(ctx, timings.SinceStart, timingsPIPFeatureCollection)
+
+		var r reader.Reader = app.SpatialDatabase
+
+		if len(pip_req.Properties) > 0 {
+
+			r = &propertiesMergeReader{
+				reader:            app.SpatialDatabase,
+				properties_reader: app.PropertiesReader,
+				keys:              pip_req.Properties,
+			}
+		}
+
+		opts := &geojson.AsFeatureCollectionOptions{
+			Reader: r,
+			Writer: &buf,
+		}
+
+		err := geojson.AsFeatureCollection(ctx, results, opts)
+
+		app.Monitor.Signal(ctx, timings.SinceStop, timingsPIPFeatureCollection)
+
+		if err != nil {
+			return fmt.Errorf("Failed to create feature collection, %w", err)
+		}
+
+		// Pagination details, explanations, index progress and timings are assigned as foreign members of the feature collection
+
+		members := make(map[string]interface{})
+
+		pg := pip.PaginationWithResults(results)
+
+		if pg != nil {
+			members["pagination"] = pg
+		}
+
+		explain := pip.ExplanationWithResults(results)
+
+		if explain != nil {
+			members["explain"] = explain
+		}
+
+		progress := pip.IndexProgressWithResults(results)
+
+		if progress != nil {
+			members["index_progress"] = progress
+		}
+
+		results_timings := pip.TimingsWithResults(results)
+
+		if include_timings {
+			results_timings = m.Timings()
+		}
+
+		if results_timings != nil {
+			members["timings"] = results_timings
+		}
+
+		for k, v := range members {
+
+			fc, err := sjson.SetBytes(buf.Bytes(), k, v)
+
+			if err != nil {
+				return fmt.Errorf("Failed to assign %s to feature collection, %w", k, err)
+			}
+
+			buf.Reset()
+			buf.Write(fc)
+		}
+
+	case PROPERTIES_FORMAT:
+
+		app.Monitor.Signal(ctx, timings.SinceStart, timingsPIPProperties)
+
+		props_rsp, err := propertiesResponse(ctx, app, pip_req.Properties, results)
+
+		app.Monitor.Signal(ctx, timings.SinceStop, timingsPIPProperties)
+
+		if err != nil {
+			return fmt.Errorf("Failed to derive properties response, %w", err)
+		}
+
+		if include_timings {
+			props_rsp.Timings = m.Timings()
+		}
+
+		err = json.NewEncoder(&buf).Encode(props_rsp)
+
+		if err != nil {
+			return fmt.Errorf("Failed to encode properties response, %w", err)
+		}
+
+	case SPR_FORMAT:
+
+		if include_timings {
+			results = pip.AppendTimingsToResults(results, m.Timings())
+		}
+
+		err := json.NewEncoder(&buf).Encode(results)
+
+		if err != nil {
+			return fmt.Errorf("Failed to encode results, %w", err)
+		}
+
+	default:
+		return fmt.Errorf("Unsupported response format '%s'", format)
+	}
+
+	for k, v := range headers {
+		rsp.Header()[k] = v
+	}
+
+	if has_monitor {
+		rsp.Header().Set("Server-Timing", m.ServerTiming())
+	}
+
+	rsp.Header().Set("Content-Type", format.ContentType())
+
+	_, err := io.Copy(rsp, &buf)
+
+	if err != nil {
+		return fmt.Errorf("Failed to write response, %w", err)
+	}
+
+	return nil
+}
+
+// propertiesMergeReader implements the reader.Reader interface returning records from 'reader' with the
+// properties defined in 'keys' read from 'properties_reader' merged in to them.
+type propertiesMergeReader struct {
+	reader            reader.Reader
+	properties_reader reader.Reader
+	keys              []string
+}
+
+func (r *propertiesMergeReader) Read(ctx context.Context, path string) (io.ReadSeekCloser, error) {
+
+	target, err := readAll(ctx, r.reader, path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	source, err := readAll(ctx, r.properties_reader, path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	opts := &spatial.PropertiesResponseOptions{
+		Keys:         r.keys,
+		SourcePrefix: "properties",
+		TargetPrefix: "properties",
+	}
+
+	target, err = spatial.AppendPropertiesWithJSON(ctx, opts, source, target)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to append properties for %s, %w", path, err)
+	}
+
+	return ioutil.NewReadSeekCloser(bytes.NewReader(target))
+}
+
+func (r *propertiesMergeReader) ReaderURI(ctx context.Context, path string) string {
+	return r.reader.ReaderURI(ctx, path)
+}
+
+func readAll(ctx context.Context, r reader.Reader, path string) ([]byte, error) {
+
+	fh, err := r.Read(ctx, path)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to open %s for reading, %w", path, err)
+	}
+
+	defer fh.Close()
+
+	body, err := io.ReadAll(fh)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read %s, %w", path, err)
+	}
+
+	return body, nil
+}
+
+type mediaRange struct {
+	media_type string
+	q          float64
+}
+
+// negotiateMediaType returns the first element of 'offers' that is acceptable according to 'accept' taking
+// quality values and wildcards in to account. An empty Accept header accepts the first offer.
+func negotiateMediaType(accept string, offers []string) (string, bool) {
+
+	if len(offers) == 0 {
+		return "", false
+	}
+
+	accept = strings.TrimSpace(accept)
+
+	if accept == "" {
+		return offers[0], true
+	}
+
+	ranges := make([]*mediaRange, 0)
+
+	for _, part := range strings.Split(accept, ",") {
+
+		media_type, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+
+		if err != nil {
+			continue
+		}
+
+		q := 1.0
+
+		str_q, ok := params["q"]
+
+		if ok {
+
+			v, err := strconv.ParseFloat(str_q, 64)
+
+			if err != nil {
+				continue
+			}
+
+			q = v
+		}
+
+		if q <= 0 {
+			continue
+		}
+
+		ranges = append(ranges, &mediaRange{media_type: media_type, q: q})
+	}
+
+	sort.SliceStable(ranges, func(i int, j int) bool {
+		return ranges[i].q > ranges[j].q
+	})
+
+	for _, r := range ranges {
+
+		for _, o := range offers {
+
+			if matchesMediaRange(r.media_type, o) {
+				return o, true
+			}
+		}
+	}
+
+	return "", false
+}
+
+func matchesMediaRange(media_range string, media_type string) bool {
+
+	if media_range == "*/*" || media_range == media_type {
+		return true
+	}
+
+	if strings.HasSuffix(media_range, "/*") {
+		prefix := strings.TrimSuffix(media_range, "*")
+		return strings.HasPrefix(media_type, prefix)
+	}
+
+	return false
+}
@@ -0,0 +1,287 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/tidwall/sjson"
+	"github.com/whosonfirst/go-ioutil"
+	"github.com/whosonfirst/go-reader"
+	"github.com/whosonfirst/go-whosonfirst-spatial-pip"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"testing"
+)
+
+// extraPropertiesReader is a reader.Reader instance which appends a "test:extra" property to the records read from
+// 'reader' so that tests can distinguish between properties read from the properties reader and the spatial database.
+type extraPropertiesReader struct {
+	reader reader.Reader
+}
+
+func (r *extraPropertiesReader) Read(ctx context.Context, path string) (io.ReadSeekCloser, error) {
+
+	body, err := readAll(ctx, r.reader, path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	body, err = sjson.SetBytes(body, "properties.test:extra", "extra")
+
+	if err != nil {
+		return nil, err
+	}
+
+	return ioutil.NewReadSeekCloser(bytes.NewReader(body))
+}
+
+func (r *extraPropertiesReader) ReaderURI(ctx context.Context, path string) string {
+	return r.reader.ReaderURI(ctx, path)
+}
+
+func TestNegotiateResponseFormat(t *testing.T) {
+
+	tests := []struct {
+		name           string
+		accept         string
+		properties     []string
+		enable_geojson bool
+		expected       ResponseFormat
+		ok             bool
+	}{
+		{name: "default", accept: "", expected: SPR_FORMAT, ok: true},
+		{name: "json", accept: "application/json", expected: SPR_FORMAT, ok: true},
+		{name: "wildcard", accept: "*/*", expected: SPR_FORMAT, ok: true},
+		{name: "properties", accept: "application/json", properties: []string{"wof:name"}, expected: PROPERTIES_FORMAT, ok: true},
+		{name: "geojson", accept: "application/geo+json", enable_geojson: true, expected: GEOJSON_FORMAT, ok: true},
+		{name: "geojson with properties", accept: "application/geo+json", properties: []string{"wof:name"}, enable_geojson: true, expected: GEOJSON_FORMAT, ok: true},
+		{name: "geojson disabled", accept: "application/geo+json", ok: false},
+		{name: "quality", accept: "application/json;q=0.5, application/geo+json", enable_geojson: true, expected: GEOJSON_FORMAT, ok: true},
+		{name: "quality fallback", accept: "application/geo+json;q=1, application/json;q=0.1", expected: SPR_FORMAT, ok: true},
+		{name: "application wildcard", accept: "application/*", enable_geojson: true, expected: SPR_FORMAT, ok: true},
+		{name: "refused", accept: "application/json;q=0", ok: false},
+		{name: "unsupported", accept: "text/html", enable_geojson: true, ok: false},
+	}
+
+	for _, tt := range tests {
+
+		t.Run(tt.name, func(t *testing.T) {
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+
+			if tt.accept != "" {
+				req.Header.Set("Accept", tt.accept)
+			}
+
+			pip_req := &pip.PointInPolygonRequest{
+				Properties: tt.properties,
+			}
+
+			format, err := NegotiateResponseFormat(req, pip_req, tt.enable_geojson)
+
+			if !tt.ok {
+
+				if err == nil {
+					t.Fatalf("Expected an error, got %s", format)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Failed to negotiate response format, %v", err)
+			}
+
+			if format != tt.expected {
+				t.Fatalf("Expected %s, got %s", tt.expected, format)
+			}
+		})
+	}
+}
+
+func TestPointInPolygonHandlerFormats(t *testing.T) {
+
+	app := newTestApplication(t, nil)
+	app.PropertiesReader = &extraPropertiesReader{reader: app.SpatialDatabase}
+
+	opts := &PointInPolygonHandlerOptions{
+		EnableGeoJSON: true,
+		Logger:        log.New(io.Discard, "", 0),
+	}
+
+	handler, err := PointInPolygonHandler(app, opts)
+
+	if err != nil {
+		t.Fatalf("Failed to create handler, %v", err)
+	}
+
+	tests := []struct {
+		name         string
+		accept       string
+		query        string
+		content_type string
+		extra        bool
+	}{
+		{
+			name:         "spr",
+			accept:       "application/json",
+			query:        "latitude=0.75&longitude=0.75&is_current=1",
+			content_type: JSON,
+		},
+		{
+			name:         "properties",
+			accept:       "application/json",
+			query:        "latitude=0.75&longitude=0.75&is_current=1&property=test:extra",
+			content_type: JSON,
+			extra:        true,
+		},
+		{
+			name:         "geojson",
+			accept:       "application/geo+json",
+			query:        "latitude=0.75&longitude=0.75&is_current=1",
+			content_type: GEOJSON,
+		},
+		{
+			name:         "geojson with properties",
+			accept:       "application/geo+json",
+			query:        "latitude=0.75&longitude=0.75&is_current=1&property=test:extra",
+			content_type: GEOJSON,
+			extra:        true,
+		},
+	}
+
+	expected := []string{"101000001", "85000001", "85000002"}
+
+	for _, tt := range tests {
+
+		t.Run(tt.name, func(t *testing.T) {
+
+			req := httptest.NewRequest(http.MethodGet, "/?"+tt.query, nil)
+			req.Header.Set("Accept", tt.accept)
+
+			rsp := httptest.NewRecorder()
+
+			handler.ServeHTTP(rsp, req)
+
+			if rsp.Code != http.StatusOK {
+				t.Fatalf("Expected status 200, got %d: %s", rsp.Code, rsp.Body.String())
+			}
+
+			if rsp.Header().Get("Content-Type") != tt.content_type {
+				t.Fatalf("Expected %s response, got %s", tt.content_type, rsp.Header().Get("Content-Type"))
+			}
+
+			if !slices.Contains(rsp.Header().Values("Vary"), "Accept") {
+				t.Fatalf("Expected response to vary by Accept header")
+			}
+
+			// The body must be a single JSON document
+
+			dec := json.NewDecoder(bytes.NewReader(rsp.Body.Bytes()))
+
+			var doc map[string]interface{}
+
+			err := dec.Decode(&doc)
+
+			if err != nil {
+				t.Fatalf("Failed to decode response, %v", err)
+			}
+
+			if dec.More() {
+				t.Fatalf("Response contains more than one JSON document")
+			}
+
+			var places []map[string]interface{}
+
+			if tt.content_type == GEOJSON {
+
+				var fc struct {
+					Type     string `json:"type"`
+					Features []struct {
+						Properties map[string]interface{} `json:"properties"`
+					} `json:"features"`
+				}
+
+				err = json.Unmarshal(rsp.Body.Bytes(), &fc)
+
+				if err != nil || fc.Type != "FeatureCollection" {
+					t.Fatalf("Expected a FeatureCollection, got %s", rsp.Body.String())
+				}
+
+				for _, f := range fc.Features {
+					places = append(places, f.Properties)
+				}
+
+			} else {
+
+				var spr_rsp struct {
+					Places []map[string]interface{} `json:"places"`
+				}
+
+				err = json.Unmarshal(rsp.Body.Bytes(), &spr_rsp)
+
+				if err != nil {
+					t.Fatalf("Failed to decode places, %v", err)
+				}
+
+				places = spr_rsp.Places
+			}
+
+			ids := make([]string, 0)
+
+			for _, p := range places {
+
+				id, _ := p["wof:id"].(float64)
+				ids = append(ids, strconv.FormatInt(int64(id), 10))
+
+				_, has_extra := p["test:extra"]
+
+				if has_extra != tt.extra {
+					t.Fatalf("Expected test:extra property to be present: %t, got %t", tt.extra, has_extra)
+				}
+			}
+
+			slices.Sort(ids)
+
+			if !slices.Equal(ids, expected) {
+				t.Fatalf("Expected %v, got %v", expected, ids)
+			}
+		})
+	}
+}
+
+func TestPointInPolygonHandlerNotAcceptable(t *testing.T) {
+
+	app := newTestApplication(t, nil)
+
+	opts := &PointInPolygonHandlerOptions{
+		Logger: log.New(io.Discard, "", 0),
+	}
+
+	handler, err := PointInPolygonHandler(app, opts)
+
+	if err != nil {
+		t.Fatalf("Failed to create handler, %v", err)
+	}
+
+	for _, accept := range []string{"text/html", "application/geo+json"} {
+
+		req := httptest.NewRequest(http.MethodGet, "/?latitude=0.75&longitude=0.75", nil)
+		req.Header.Set("Accept", accept)
+
+		rsp := httptest.NewRecorder()
+
+		handler.ServeHTTP(rsp, req)
+
+		decodeProblem(t, rsp, http.StatusNotAcceptable, NOT_ACCEPTABLE)
+
+		if !slices.Contains(rsp.Header().Values("Vary"), "Accept") {
+			t.Fatalf("Expected response to vary by Accept header")
+		}
+	}
+}
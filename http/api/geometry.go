@@ -0,0 +1,144 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"github.com/sfomuseum/go-timings"
+	"github.com/whosonfirst/go-whosonfirst-spatial-pip"
+	spatial_app "github.com/whosonfirst/go-whosonfirst-spatial/app"
+	"log"
+	"net/http"
+)
+
+const timingsGeometryHandler string = "Geometry handler"
+
+const timingsGeometryQuery string = "Geometry handler query"
+
+// DEFAULT_GEOMETRY_MAX_BODY_SIZE is the default maximum size, in bytes, of a body POST-ed to the geometry handler.
+const DEFAULT_GEOMETRY_MAX_BODY_SIZE int64 = 8 * 1024 * 1024
+
+type GeometryHandlerOptions struct {
+	EnableGeoJSON bool
+	Logger        *log.Logger
+	LogTimings    bool
+	// An optional Metrics instance used to record stage timings and result counts.
+	Metrics *Metrics
+	// If true reject JSON-encoded requests containing unknown fields.
+	StrictDecoding bool
+	// The maximum size, in bytes, of a POST-ed body. If 0 then DEFAULT_GEOMETRY_MAX_BODY_SIZE is used.
+	MaxBodySize int64
+	IndexingOptions
+}
+
+// GeometryHandler returns a http.Handler that accepts a POST-ed JSON-encoded `pip.GeometryRequest` body and returns
+// the records whose geometries contain, are within or intersect the request's GeoJSON geometry. Responses are encoded
+// in the same formats as the point-in-polygon handler.
+func GeometryHandler(app *spatial_app.SpatialApplication, opts *GeometryHandlerOptions) (http.Handler, error) {
+
+	max_body_size := opts.MaxBodySize
+
+	if max_body_size <= 0 {
+		max_body_size = DEFAULT_GEOMETRY_MAX_BODY_SIZE
+	}
+
+	fn := func(rsp http.ResponseWriter, req *http.Request) {
+
+		ctx := req.Context()
+
+		if req.Method != "POST" {
+			WriteProblem(rsp, req, METHOD_NOT_ALLOWED, "Unsupported method")
+			return
+		}
+
+		q, ok := newQueryRequest(rsp, req, app, opts.IndexingOptions, opts.Metrics)
+
+		if !ok {
+			return
+		}
+
+		req_app := q.app
+
+		defer q.timeHandler(ctx, timingsGeometryHandler, opts.LogTimings, opts.Logger)()
+
+		var geom_req *pip.GeometryRequest
+
+		body := http.MaxBytesReader(rsp, req.Body, max_body_size)
+
+		dec := pip.NewRequestDecoder(body, opts.StrictDecoding)
+		err := dec.Decode(&geom_req)
+
+		if err != nil {
+
+			var max_err *http.MaxBytesError
+
+			if errors.As(err, &max_err) {
+				WriteProblem(rsp, req, REQUEST_TOO_LARGE, fmt.Sprintf("Request body exceeds the maximum size of %d bytes", max_body_size))
+				return
+			}
+
+			WriteError(rsp, req, err, pip.INVALID_REQUEST)
+			return
+		}
+
+		if geom_req == nil {
+			WriteProblem(rsp, req, pip.INVALID_REQUEST, "Empty request")
+			return
+		}
+
+		switch geom_req.Relation {
+		case "", pip.CONTAINS, pip.WITHIN, pip.INTERSECTS:
+			// pass
+		default:
+			WriteProblem(rsp, req, pip.INVALID_PARAMETER, "Invalid relation")
+			return
+		}
+
+		if geom_req.Geometry == nil {
+			WriteProblem(rsp, req, pip.INVALID_PARAMETER, "Missing geometry")
+			return
+		}
+
+		err = geom_req.Validate()
+
+		if err != nil {
+			WriteError(rsp, req, err, pip.INVALID_REQUEST)
+			return
+		}
+
+		format, err := negotiateResponseFormat(rsp, req, &geom_req.PointInPolygonRequest, opts.EnableGeoJSON)
+
+		if err != nil {
+			WriteProblem(rsp, req, NOT_ACCEPTABLE, err.Error())
+			return
+		}
+
+		req_app.Monitor.Signal(ctx, timings.SinceStart, timingsGeometryQuery)
+
+		geom_rsp, err := pip.QueryGeometry(ctx, req_app, geom_req)
+
+		req_app.Monitor.Signal(ctx, timings.SinceStop, timingsGeometryQuery)
+
+		if err != nil {
+			WriteError(rsp, req, err, INTERNAL_ERROR)
+			return
+		}
+
+		opts.Metrics.ObserveResults(METRICS_GEOMETRY, len(geom_rsp.Results()))
+
+		headers := make(http.Header)
+
+		geom_rsp = q.appendIndexProgress(geom_rsp, headers)
+
+		err = WriteResponseWithHeaders(ctx, rsp, req_app, &geom_req.PointInPolygonRequest, format, geom_rsp, headers)
+
+		if err != nil {
+			WriteError(rsp, req, err, INTERNAL_ERROR)
+			return
+		}
+
+		return
+	}
+
+	geom_handler := http.HandlerFunc(fn)
+	return geom_handler, nil
+}
@@ -0,0 +1,119 @@
+package api
+
+import (
+	"github.com/whosonfirst/go-whosonfirst-spatial-pip"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestGeometryHandler(t *testing.T) {
+
+	app := newTestApplication(t, &pip.SpatialDatabaseOptions{IndexGeometries: true})
+
+	opts := &GeometryHandlerOptions{
+		MaxBodySize: 1024,
+		Logger:      log.New(io.Discard, "", 0),
+	}
+
+	handler, err := GeometryHandler(app, opts)
+
+	if err != nil {
+		t.Fatalf("Failed to create handler, %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		method   string
+		body     string
+		status   int
+		code     pip.ErrorCode
+		expected []string
+	}{
+		{
+			name:     "contains",
+			method:   http.MethodPost,
+			body:     `{"geometry":{"type":"Polygon","coordinates":[[[0.6,0.6],[0.7,0.6],[0.7,0.7],[0.6,0.7],[0.6,0.6]]]},"relation":"contains","is_current":[1]}`,
+			status:   http.StatusOK,
+			expected: []string{"101000001", "85000001", "85000002"},
+		},
+		{
+			name:     "within",
+			method:   http.MethodPost,
+			body:     `{"geometry":{"type":"Polygon","coordinates":[[[-1.5,-1.5],[2.5,-1.5],[2.5,2.5],[-1.5,2.5],[-1.5,-1.5]]]},"relation":"within","placetypes":["region"]}`,
+			status:   http.StatusOK,
+			expected: []string{"85000002"},
+		},
+		{
+			name:     "intersects",
+			method:   http.MethodPost,
+			body:     `{"geometry":{"type":"LineString","coordinates":[[4,4],[5.5,5.5]]}}`,
+			status:   http.StatusOK,
+			expected: []string{"101000003"},
+		},
+		{
+			name:   "invalid relation",
+			method: http.MethodPost,
+			body:   `{"geometry":{"type":"Point","coordinates":[0.5,0.5]},"relation":"touches"}`,
+			status: http.StatusBadRequest,
+			code:   pip.INVALID_PARAMETER,
+		},
+		{
+			name:   "missing geometry",
+			method: http.MethodPost,
+			body:   `{"relation":"contains"}`,
+			status: http.StatusBadRequest,
+			code:   pip.INVALID_PARAMETER,
+		},
+		{
+			name:   "malformed",
+			method: http.MethodPost,
+			body:   `{"geometry":`,
+			status: http.StatusBadRequest,
+			code:   pip.INVALID_REQUEST,
+		},
+		{
+			name:   "too large",
+			method: http.MethodPost,
+			body:   `{"geometry":{"type":"LineString","coordinates":[` + strings.Repeat("[4,4],", 200) + `[5.5,5.5]]}}`,
+			status: http.StatusRequestEntityTooLarge,
+			code:   REQUEST_TOO_LARGE,
+		},
+		{
+			name:   "method",
+			method: http.MethodGet,
+			status: http.StatusMethodNotAllowed,
+			code:   METHOD_NOT_ALLOWED,
+		},
+	}
+
+	for _, tt := range tests {
+
+		t.Run(tt.name, func(t *testing.T) {
+
+			req := httptest.NewRequest(tt.method, "/", strings.NewReader(tt.body))
+			rsp := httptest.NewRecorder()
+
+			handler.ServeHTTP(rsp, req)
+
+			if tt.code != "" {
+				decodeProblem(t, rsp, tt.status, tt.code)
+				return
+			}
+
+			if rsp.Code != tt.status {
+				t.Fatalf("Expected status %d, got %d: %s", tt.status, rsp.Code, rsp.Body.String())
+			}
+
+			ids := placeIds(t, rsp.Body.Bytes())
+
+			if !slices.Equal(ids, tt.expected) {
+				t.Fatalf("Expected %v, got %v", tt.expected, ids)
+			}
+		})
+	}
+}
@@ -0,0 +1,161 @@
+package api
+
+import (
+	"encoding/json"
+	"github.com/whosonfirst/go-whosonfirst-spatial-pip"
+	spatial_app "github.com/whosonfirst/go-whosonfirst-spatial/app"
+	"log"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// HEALTH_OK is the status reported when the application is live or ready.
+const HEALTH_OK string = "ok"
+
+// HEALTH_INDEXING is the status reported while records are still being indexed. Unless queries are served while
+// indexing the application is not ready.
+const HEALTH_INDEXING string = "indexing"
+
+// HEALTH_INSUFFICIENT_RECORDS is the status reported when the application is not ready because fewer than the minimum
+// number of records have been indexed.
+const HEALTH_INSUFFICIENT_RECORDS string = "insufficient_records"
+
+// HealthResponse is the JSON-encoded response returned by the readiness handler.
+type HealthResponse struct {
+	// The status of the application, one of HEALTH_OK, HEALTH_INDEXING or HEALTH_INSUFFICIENT_RECORDS.
+	Status string `json:"status"`
+	// Whether the application is ready to serve requests.
+	Ready bool `json:"ready"`
+	// Whether records are currently being indexed.
+	Indexing bool `json:"indexing"`
+	// The number of records seen by the indexing iterator so far.
+	Records int64 `json:"records"`
+	// The minimum number of records that must be indexed before the application is ready.
+	MinimumRecords int64 `json:"minimum_records"`
+	// The number of records expected to be indexed, if known.
+	ExpectedRecords int64 `json:"expected_records,omitempty"`
+	// The time that indexing began, if known.
+	IndexingStarted *time.Time `json:"indexing_started,omitempty"`
+	// The number of seconds since indexing began, if known.
+	IndexingSeconds float64 `json:"indexing_seconds,omitempty"`
+	// The generation, release and last modified time of the spatial database, if it tracks changes.
+	Index *pip.IndexVersion `json:"index,omitempty"`
+}
+
+// HealthHandlerOptions defines options for the liveness and readiness handlers.
+type HealthHandlerOptions struct {
+	// The minimum number of records that must have been seen by the indexing iterator before the application is ready.
+	MinimumRecords int64
+	// The number of records expected to be indexed, if known.
+	ExpectedRecords int64
+	// If true the application is ready while records are still being indexed, once MinimumRecords have been seen.
+	ServeWhileIndexing bool
+	// The time that indexing began. If zero the time since indexing began is not reported.
+	IndexingStarted time.Time
+	// An optional flag set to true once the initial indexing of records has finished. The iterator only reports that
+	// it is indexing once it has started so, if not nil, records are considered to be indexing until the flag is set.
+	Indexed *atomic.Bool
+	Logger  *log.Logger
+}
+
+// LiveHandler returns a http.Handler that reports whether the application is running. It always returns a 200 OK
+// response with a JSON-encoded `{"status":"ok"}` body, regardless of whether records are still being indexed.
+func LiveHandler(app *spatial_app.SpatialApplication, opts *HealthHandlerOptions) (http.Handler, error) {
+
+	fn := func(rsp http.ResponseWriter, req *http.Request) {
+
+		if req.Method != "GET" && req.Method != "HEAD" {
+			WriteProblem(rsp, req, METHOD_NOT_ALLOWED, "Unsupported method")
+			return
+		}
+
+		health_rsp := map[string]string{
+			"status": HEALTH_OK,
+		}
+
+		writeHealthResponse(rsp, opts, health_rsp, http.StatusOK)
+		return
+	}
+
+	live_handler := http.HandlerFunc(fn)
+	return live_handler, nil
+}
+
+// ReadyHandler returns a http.Handler that reports whether the application is ready to serve requests, which is to say
+// that it has finished indexing records, unless opts.ServeWhileIndexing is true, and has seen at least
+// opts.MinimumRecords records. If opts.Indexed is not nil the application has not finished indexing records until it
+// is set, even if the iterator has not started yet. If the application is not
+// ready a 503 Service Unavailable response is returned. In both cases the response body is a JSON-encoded HealthResponse.
+func ReadyHandler(app *spatial_app.SpatialApplication, opts *HealthHandlerOptions) (http.Handler, error) {
+
+	fn := func(rsp http.ResponseWriter, req *http.Request) {
+
+		if req.Method != "GET" && req.Method != "HEAD" {
+			WriteProblem(rsp, req, METHOD_NOT_ALLOWED, "Unsupported method")
+			return
+		}
+
+		indexing := app.Iterator.IsIndexing()
+
+		if opts.Indexed != nil && !opts.Indexed.Load() {
+			indexing = true
+		}
+
+		health_rsp := &HealthResponse{
+			Status:          HEALTH_OK,
+			Ready:           true,
+			Indexing:        indexing,
+			Records:         atomic.LoadInt64(&app.Iterator.Seen),
+			MinimumRecords:  opts.MinimumRecords,
+			ExpectedRecords: opts.ExpectedRecords,
+		}
+
+		version, has_version := pip.IndexVersionWithApplication(app)
+
+		if has_version {
+			health_rsp.Index = version
+		}
+
+		if !opts.IndexingStarted.IsZero() {
+			health_rsp.IndexingStarted = &opts.IndexingStarted
+			health_rsp.IndexingSeconds = time.Since(opts.IndexingStarted).Seconds()
+		}
+
+		switch {
+		case health_rsp.Indexing && !opts.ServeWhileIndexing:
+			health_rsp.Status = HEALTH_INDEXING
+			health_rsp.Ready = false
+		case health_rsp.Records < opts.MinimumRecords:
+			health_rsp.Status = HEALTH_INSUFFICIENT_RECORDS
+			health_rsp.Ready = false
+		case health_rsp.Indexing:
+			health_rsp.Status = HEALTH_INDEXING
+		}
+
+		status := http.StatusOK
+
+		if !health_rsp.Ready {
+			status = http.StatusServiceUnavailable
+		}
+
+		writeHealthResponse(rsp, opts, health_rsp, status)
+		return
+	}
+
+	ready_handler := http.HandlerFunc(fn)
+	return ready_handler, nil
+}
+
+func writeHealthResponse(rsp http.ResponseWriter, opts *HealthHandlerOptions, health_rsp interface{}, status int) {
+
+	rsp.Header().Set("Content-Type", JSON)
+	rsp.Header().Set("Cache-Control", "no-store")
+	rsp.WriteHeader(status)
+
+	err := json.NewEncoder(rsp).Encode(health_rsp)
+
+	if err != nil && opts.Logger != nil {
+		opts.Logger.Printf("Failed to encode health response, %v", err)
+	}
+}
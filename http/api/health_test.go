@@ -0,0 +1,201 @@
+package api
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestLiveHandler(t *testing.T) {
+
+	app := newTestApplication(t, nil)
+
+	opts := &HealthHandlerOptions{
+		Logger: log.New(io.Discard, "", 0),
+	}
+
+	handler, err := LiveHandler(app, opts)
+
+	if err != nil {
+		t.Fatalf("Failed to create handler, %v", err)
+	}
+
+	// The application is live regardless of whether records are being indexed
+
+	stop := startIndexing(t, app)
+	defer stop()
+
+	req := httptest.NewRequest(http.MethodGet, "/health/live", nil)
+	rsp := httptest.NewRecorder()
+
+	handler.ServeHTTP(rsp, req)
+
+	if rsp.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", rsp.Code)
+	}
+
+	if rsp.Body.String() != "{\"status\":\"ok\"}\n" {
+		t.Fatalf("Unexpected response %s", rsp.Body.String())
+	}
+
+	if rsp.Header().Get("Cache-Control") != "no-store" {
+		t.Fatalf("Expected response not to be cached")
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/health/live", nil)
+	rsp = httptest.NewRecorder()
+
+	handler.ServeHTTP(rsp, req)
+
+	decodeProblem(t, rsp, http.StatusMethodNotAllowed, METHOD_NOT_ALLOWED)
+}
+
+func TestReadyHandler(t *testing.T) {
+
+	// Records which have not been indexed yet are reported as indexing even though the iterator has not started
+
+	indexed := new(atomic.Bool)
+	indexed.Store(true)
+
+	not_indexed := new(atomic.Bool)
+
+	tests := []struct {
+		name                 string
+		indexing             bool
+		indexed              *atomic.Bool
+		minimum_records      int64
+		serve_while_indexing bool
+		status               int
+		health_status        string
+	}{
+		{
+			name:          "ready",
+			status:        http.StatusOK,
+			health_status: HEALTH_OK,
+		},
+		{
+			name:            "enough records",
+			minimum_records: 7,
+			status:          http.StatusOK,
+			health_status:   HEALTH_OK,
+		},
+		{
+			name:            "insufficient records",
+			minimum_records: 100,
+			status:          http.StatusServiceUnavailable,
+			health_status:   HEALTH_INSUFFICIENT_RECORDS,
+		},
+		{
+			name:          "not indexed",
+			indexed:       not_indexed,
+			status:        http.StatusServiceUnavailable,
+			health_status: HEALTH_INDEXING,
+		},
+		{
+			name:          "indexed",
+			indexed:       indexed,
+			status:        http.StatusOK,
+			health_status: HEALTH_OK,
+		},
+		{
+			name:          "indexing",
+			indexing:      true,
+			status:        http.StatusServiceUnavailable,
+			health_status: HEALTH_INDEXING,
+		},
+		{
+			name:                 "serving while indexing",
+			indexing:             true,
+			serve_while_indexing: true,
+			minimum_records:      5,
+			status:               http.StatusOK,
+			health_status:        HEALTH_INDEXING,
+		},
+		{
+			name:                 "serving while indexing insufficient records",
+			indexing:             true,
+			serve_while_indexing: true,
+			minimum_records:      100,
+			status:               http.StatusServiceUnavailable,
+			health_status:        HEALTH_INSUFFICIENT_RECORDS,
+		},
+	}
+
+	for _, tt := range tests {
+
+		t.Run(tt.name, func(t *testing.T) {
+
+			app := newTestApplication(t, nil)
+
+			if tt.indexing {
+				stop := startIndexing(t, app)
+				defer stop()
+			}
+
+			started := time.Now().Add(-1 * time.Minute)
+
+			opts := &HealthHandlerOptions{
+				MinimumRecords:     tt.minimum_records,
+				ExpectedRecords:    7,
+				ServeWhileIndexing: tt.serve_while_indexing,
+				IndexingStarted:    started,
+				Indexed:            tt.indexed,
+				Logger:             log.New(io.Discard, "", 0),
+			}
+
+			handler, err := ReadyHandler(app, opts)
+
+			if err != nil {
+				t.Fatalf("Failed to create handler, %v", err)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, "/health/ready", nil)
+			rsp := httptest.NewRecorder()
+
+			handler.ServeHTTP(rsp, req)
+
+			if rsp.Code != tt.status {
+				t.Fatalf("Expected status %d, got %d", tt.status, rsp.Code)
+			}
+
+			var health_rsp *HealthResponse
+
+			err = json.Unmarshal(rsp.Body.Bytes(), &health_rsp)
+
+			if err != nil {
+				t.Fatalf("Failed to decode response, %v", err)
+			}
+
+			if health_rsp.Status != tt.health_status {
+				t.Fatalf("Expected status %s, got %s", tt.health_status, health_rsp.Status)
+			}
+
+			if health_rsp.Ready != (tt.status == http.StatusOK) {
+				t.Fatalf("Expected ready to be %t", tt.status == http.StatusOK)
+			}
+
+			expected_indexing := tt.indexing || (tt.indexed != nil && !tt.indexed.Load())
+
+			if health_rsp.Indexing != expected_indexing {
+				t.Fatalf("Expected indexing to be %t", expected_indexing)
+			}
+
+			if health_rsp.MinimumRecords != tt.minimum_records || health_rsp.ExpectedRecords != 7 {
+				t.Fatalf("Unexpected minimum or expected records, %+v", health_rsp)
+			}
+
+			if health_rsp.IndexingStarted == nil || health_rsp.IndexingSeconds < 60 {
+				t.Fatalf("Expected time since indexing started, %+v", health_rsp)
+			}
+
+			if health_rsp.Index == nil || health_rsp.Index.Generation == 0 {
+				t.Fatalf("Expected index version, %+v", health_rsp)
+			}
+		})
+	}
+}
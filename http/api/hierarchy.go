@@ -0,0 +1,74 @@
+package api
+
+import (
+	"encoding/json"
+	"github.com/whosonfirst/go-whosonfirst-spatial-pip"
+	"github.com/whosonfirst/go-whosonfirst-spatial-pip/hierarchy"
+	spatial_app "github.com/whosonfirst/go-whosonfirst-spatial/app"
+	"log"
+	"net/http"
+)
+
+type HierarchyHandlerOptions struct {
+	Logger *log.Logger
+	// An optional Metrics instance used to record stage timings.
+	Metrics *Metrics
+	// If true reject JSON-encoded requests containing unknown fields.
+	StrictDecoding bool
+	IndexingOptions
+}
+
+// HierarchyHandler returns a http.Handler that resolves a Who's On First hierarchy (parent ID, hierarchies and the most
+// specific place for each placetype) for a point-in-polygon request. Requests may be issued as either a GET request with
+// query parameters or a POST request with a JSON-encoded body, as with the point-in-polygon handler.
+func HierarchyHandler(app *spatial_app.SpatialApplication, opts *HierarchyHandlerOptions) (http.Handler, error) {
+
+	fn := func(rsp http.ResponseWriter, req *http.Request) {
+
+		ctx := req.Context()
+
+		if req.Method != "GET" && req.Method != "POST" {
+			WriteProblem(rsp, req, METHOD_NOT_ALLOWED, "Unsupported method")
+			return
+		}
+
+		q, ok := newQueryRequest(rsp, req, app, opts.IndexingOptions, opts.Metrics)
+
+		if !ok {
+			return
+		}
+
+		req_app := q.app
+
+		pip_req, err := pointInPolygonRequestWithHTTPRequest(req, opts.StrictDecoding)
+
+		if err != nil {
+			WriteError(rsp, req, err, pip.INVALID_REQUEST)
+			return
+		}
+
+		h_rsp, err := hierarchy.ResolveHierarchy(ctx, req_app, pip_req)
+
+		if err != nil {
+			WriteError(rsp, req, err, INTERNAL_ERROR)
+			return
+		}
+
+		q.markIncomplete(rsp.Header())
+
+		rsp.Header().Set("Content-Type", "application/json")
+
+		enc := json.NewEncoder(rsp)
+		err = enc.Encode(h_rsp)
+
+		if err != nil {
+			opts.Logger.Printf("Failed to encode hierarchy response, %v", err)
+			return
+		}
+
+		return
+	}
+
+	h_handler := http.HandlerFunc(fn)
+	return h_handler, nil
+}
@@ -0,0 +1,135 @@
+package api
+
+import (
+	"context"
+	"github.com/sfomuseum/go-timings"
+	"github.com/whosonfirst/go-whosonfirst-spatial-pip"
+	spatial_app "github.com/whosonfirst/go-whosonfirst-spatial/app"
+	"github.com/whosonfirst/go-whosonfirst-spr/v2"
+	"log"
+	"net/http"
+	"sync/atomic"
+)
+
+// IndexingOptions defines how the query handlers respond to requests received while records are still being indexed.
+type IndexingOptions struct {
+	// If true perform queries while records are still being indexed rather than returning a 503 Service Unavailable
+	// response. Responses are marked as incomplete using the INDEX_COMPLETE_HEADER header and, except for the
+	// streaming and hierarchy handlers, an `index_progress` property.
+	ServeWhileIndexing bool
+	// The number of records expected to be indexed, if known. Only used when ServeWhileIndexing is true.
+	ExpectedRecords int64
+}
+
+// indexingState records whether records were still being indexed when a request was received.
+type indexingState struct {
+	indexing bool
+	// The handler's application, whose iterator reports indexing progress.
+	parent *spatial_app.SpatialApplication
+	opts   IndexingOptions
+}
+
+// checkIndexing returns the indexing state of 'app' for 'req'. If records are still being indexed and
+// opts.ServeWhileIndexing is false then an INDEX_NOT_READY problem response is written to 'rsp' and false is returned.
+func checkIndexing(rsp http.ResponseWriter, req *http.Request, app *spatial_app.SpatialApplication, opts IndexingOptions) (*indexingState, bool) {
+
+	indexing := app.Iterator.IsIndexing()
+
+	if indexing && !opts.ServeWhileIndexing {
+		WriteProblem(rsp, req, pip.INDEX_NOT_READY, "Indexing records")
+		return nil, false
+	}
+
+	s := &indexingState{
+		indexing: indexing,
+		parent:   app,
+		opts:     opts,
+	}
+
+	return s, true
+}
+
+// markIncomplete sets the INDEX_COMPLETE_HEADER header in 'headers' to "false", and returns true, if records were still
+// being indexed when the request was received.
+func (s *indexingState) markIncomplete(headers http.Header) bool {
+
+	if !s.indexing {
+		return false
+	}
+
+	headers.Set(INDEX_COMPLETE_HEADER, "false")
+	return true
+}
+
+// indexProgress returns nil if records had been indexed when the request was received. Otherwise it marks the
+// response as incomplete, as described by markIncomplete, and returns the progress of indexing.
+func (s *indexingState) indexProgress(headers http.Header) *pip.IndexProgress {
+
+	if !s.markIncomplete(headers) {
+		return nil
+	}
+
+	return pip.NewIndexProgress(atomic.LoadInt64(&s.parent.Iterator.Seen), s.opts.ExpectedRecords)
+}
+
+// appendIndexProgress returns 'results' with the progress of indexing appended, as described by indexProgress, or
+// 'results' unchanged if records had been indexed when the request was received.
+func (s *indexingState) appendIndexProgress(results spr.StandardPlacesResults, headers http.Header) spr.StandardPlacesResults {
+
+	progress := s.indexProgress(headers)
+
+	if progress == nil {
+		return results
+	}
+
+	return pip.AppendIndexProgressToResults(results, progress)
+}
+
+// queryRequest is the state shared by the query handlers for a single HTTP request.
+type queryRequest struct {
+	*indexingState
+	// A per-request application, derived from the handler's application, so that timings are not accumulated by the
+	// handler's application or mixed with those of other requests.
+	app     *spatial_app.SpatialApplication
+	monitor *pip.RequestMonitor
+}
+
+// newQueryRequest returns a new queryRequest for 'req', as described by checkIndexing, whose per-request application
+// is derived from 'app' and reports stage timings to 'm', if not nil.
+func newQueryRequest(rsp http.ResponseWriter, req *http.Request, app *spatial_app.SpatialApplication, opts IndexingOptions, m *Metrics) (*queryRequest, bool) {
+
+	s, ok := checkIndexing(rsp, req, app, opts)
+
+	if !ok {
+		return nil, false
+	}
+
+	req_app, req_monitor := newRequestApplication(app, m)
+
+	q := &queryRequest{
+		indexingState: s,
+		app:           req_app,
+		monitor:       req_monitor,
+	}
+
+	return q, true
+}
+
+// timeHandler signals the start of the stage 'label' and returns a function which signals that it has stopped and,
+// if 'log_timings' is true, logs every timing recorded for the request to 'logger'. It should be deferred.
+func (q *queryRequest) timeHandler(ctx context.Context, label string, log_timings bool, logger *log.Logger) func() {
+
+	q.app.Monitor.Signal(ctx, timings.SinceStart, label)
+
+	return func() {
+
+		q.app.Monitor.Signal(ctx, timings.SinceStop, label)
+
+		if log_timings {
+
+			for _, t := range q.monitor.Timings() {
+				logger.Println(t)
+			}
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package api
+
+import (
+	"github.com/sfomuseum/go-timings"
+	"github.com/whosonfirst/go-whosonfirst-spatial-pip"
+	spatial_app "github.com/whosonfirst/go-whosonfirst-spatial/app"
+	"log"
+	"net/http"
+)
+
+const timingsIntersectsHandler string = "Intersects handler"
+
+const timingsIntersectsQuery string = "Intersects handler query"
+
+type IntersectsHandlerOptions struct {
+	EnableGeoJSON bool
+	Logger        *log.Logger
+	LogTimings    bool
+	// An optional Metrics instance used to record stage timings and result counts.
+	Metrics *Metrics
+	// If true reject JSON-encoded requests containing unknown fields.
+	StrictDecoding bool
+	IndexingOptions
+}
+
+// IntersectsHandler returns a http.Handler that performs intersects queries for a bounding box or a point and radius.
+// Requests may be issued as GET requests with query parameters or as POST requests with a JSON-encoded
+// `pip.IntersectsRequest` body. Responses are encoded in the same formats as the point-in-polygon handler.
+func IntersectsHandler(app *spatial_app.SpatialApplication, opts *IntersectsHandlerOptions) (http.Handler, error) {
+
+	fn := func(rsp http.ResponseWriter, req *http.Request) {
+
+		ctx := req.Context()
+
+		if req.Method != "GET" && req.Method != "POST" {
+			WriteProblem(rsp, req, METHOD_NOT_ALLOWED, "Unsupported method")
+			return
+		}
+
+		q, ok := newQueryRequest(rsp, req, app, opts.IndexingOptions, opts.Metrics)
+
+		if !ok {
+			return
+		}
+
+		req_app := q.app
+
+		defer q.timeHandler(ctx, timingsIntersectsHandler, opts.LogTimings, opts.Logger)()
+
+		intersects_req, err := intersectsRequestWithHTTPRequest(req, opts.StrictDecoding)
+
+		if err != nil {
+			WriteError(rsp, req, err, pip.INVALID_REQUEST)
+			return
+		}
+
+		format, err := negotiateResponseFormat(rsp, req, &intersects_req.PointInPolygonRequest, opts.EnableGeoJSON)
+
+		if err != nil {
+			WriteProblem(rsp, req, NOT_ACCEPTABLE, err.Error())
+			return
+		}
+
+		req_app.Monitor.Signal(ctx, timings.SinceStart, timingsIntersectsQuery)
+
+		intersects_rsp, err := pip.QueryIntersects(ctx, req_app, intersects_req)
+
+		req_app.Monitor.Signal(ctx, timings.SinceStop, timingsIntersectsQuery)
+
+		if err != nil {
+			WriteError(rsp, req, err, INTERNAL_ERROR)
+			return
+		}
+
+		opts.Metrics.ObserveResults(METRICS_INTERSECTS, len(intersects_rsp.Results()))
+
+		headers := make(http.Header)
+
+		intersects_rsp = q.appendIndexProgress(intersects_rsp, headers)
+
+		err = WriteResponseWithHeaders(ctx, rsp, req_app, &intersects_req.PointInPolygonRequest, format, intersects_rsp, headers)
+
+		if err != nil {
+			WriteError(rsp, req, err, INTERNAL_ERROR)
+			return
+		}
+
+		return
+	}
+
+	intersects_handler := http.HandlerFunc(fn)
+	return intersects_handler, nil
+}
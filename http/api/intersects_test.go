@@ -0,0 +1,128 @@
+package api
+
+import (
+	"github.com/whosonfirst/go-whosonfirst-spatial-pip"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestIntersectsHandler(t *testing.T) {
+
+	app := newTestApplication(t, &pip.SpatialDatabaseOptions{IndexGeometries: true})
+
+	opts := &IntersectsHandlerOptions{
+		Logger: log.New(io.Discard, "", 0),
+	}
+
+	handler, err := IntersectsHandler(app, opts)
+
+	if err != nil {
+		t.Fatalf("Failed to create handler, %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		method   string
+		query    string
+		body     string
+		status   int
+		code     pip.ErrorCode
+		expected []string
+	}{
+		{
+			name:     "bbox",
+			method:   http.MethodGet,
+			query:    "bbox=0.1,0.1,0.2,0.2&is_current=1",
+			status:   http.StatusOK,
+			expected: []string{"101000001", "102000001", "85000001", "85000002"},
+		},
+		{
+			name:     "radius",
+			method:   http.MethodGet,
+			query:    "latitude=3.5&longitude=3.5&radius=100000",
+			status:   http.StatusOK,
+			expected: []string{"85000001"},
+		},
+		{
+			name:     "POST",
+			method:   http.MethodPost,
+			body:     `{"bbox":[2.5,2.5,5.5,5.5],"placetypes":["locality"]}`,
+			status:   http.StatusOK,
+			expected: []string{"101000003"},
+		},
+		{
+			name:   "invalid bbox",
+			method: http.MethodGet,
+			query:  "bbox=0,0,1",
+			status: http.StatusBadRequest,
+			code:   pip.INVALID_PARAMETER,
+		},
+		{
+			name:   "invalid radius",
+			method: http.MethodPost,
+			body:   `{"latitude":1,"longitude":1,"radius":-1}`,
+			status: http.StatusBadRequest,
+			code:   pip.INVALID_PARAMETER,
+		},
+		{
+			name:   "method",
+			method: http.MethodDelete,
+			query:  "bbox=0,0,1,1",
+			status: http.StatusMethodNotAllowed,
+			code:   METHOD_NOT_ALLOWED,
+		},
+	}
+
+	for _, tt := range tests {
+
+		t.Run(tt.name, func(t *testing.T) {
+
+			req := httptest.NewRequest(tt.method, "/?"+tt.query, strings.NewReader(tt.body))
+			rsp := httptest.NewRecorder()
+
+			handler.ServeHTTP(rsp, req)
+
+			if tt.code != "" {
+				decodeProblem(t, rsp, tt.status, tt.code)
+				return
+			}
+
+			if rsp.Code != tt.status {
+				t.Fatalf("Expected status %d, got %d: %s", tt.status, rsp.Code, rsp.Body.String())
+			}
+
+			ids := placeIds(t, rsp.Body.Bytes())
+
+			if !slices.Equal(ids, tt.expected) {
+				t.Fatalf("Expected %v, got %v", tt.expected, ids)
+			}
+		})
+	}
+}
+
+func TestIntersectsHandlerUnsupported(t *testing.T) {
+
+	app := newTestApplication(t, nil)
+
+	opts := &IntersectsHandlerOptions{
+		Logger: log.New(io.Discard, "", 0),
+	}
+
+	handler, err := IntersectsHandler(app, opts)
+
+	if err != nil {
+		t.Fatalf("Failed to create handler, %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/?bbox=0,0,1,1", nil)
+	rsp := httptest.NewRecorder()
+
+	handler.ServeHTTP(rsp, req)
+
+	decodeProblem(t, rsp, http.StatusNotImplemented, pip.UNSUPPORTED_QUERY)
+}
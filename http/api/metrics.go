@@ -0,0 +1,401 @@
+package api
+
+import (
+	"fmt"
+	"github.com/whosonfirst/go-whosonfirst-spatial-pip"
+	spatial_app "github.com/whosonfirst/go-whosonfirst-spatial/app"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// PROMETHEUS_TEXT is the media type for the Prometheus text exposition format.
+const PROMETHEUS_TEXT string = "text/plain; version=0.0.4; charset=utf-8"
+
+// The names of the handlers used to label metrics.
+
+const METRICS_POINT_IN_POLYGON string = "point_in_polygon"
+
+const METRICS_BATCH string = "batch"
+
+const METRICS_STREAM string = "stream"
+
+const METRICS_HIERARCHY string = "hierarchy"
+
+const METRICS_INTERSECTS string = "intersects"
+
+const METRICS_GEOMETRY string = "geometry"
+
+const METRICS_FEATURES string = "features"
+
+// durationBuckets are the upper bounds, in seconds, of the buckets used for stage latency histograms.
+var durationBuckets = []float64{0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// resultsBuckets are the upper bounds of the buckets used for result count histograms.
+var resultsBuckets = []float64{0, 1, 2, 5, 10, 25, 50, 100, 250, 500, 1000}
+
+// Metrics aggregates request counts, stage latencies, result counts and errors across requests so they can be
+// exported in the Prometheus text exposition format by MetricsHandler. Metrics implements the `pip.StageObserver`
+// interface. All methods are safe to call on a nil instance, in which case they do nothing.
+type Metrics struct {
+	mu       *sync.Mutex
+	requests map[string]map[string]int64
+	errors   map[string]map[string]int64
+	stages   map[string]*histogram
+	results  map[string]*histogram
+}
+
+// NewMetrics returns a new Metrics instance.
+func NewMetrics() *Metrics {
+
+	m := &Metrics{
+		mu:       new(sync.Mutex),
+		requests: make(map[string]map[string]int64),
+		errors:   make(map[string]map[string]int64),
+		stages:   make(map[string]*histogram),
+		results:  make(map[string]*histogram),
+	}
+
+	return m
+}
+
+// ObserveStage records the duration of the stage labeled 'label'.
+func (m *Metrics) ObserveStage(label string, d time.Duration) {
+
+	if m == nil {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	h, ok := m.stages[label]
+
+	if !ok {
+		h = newHistogram(durationBuckets)
+		m.stages[label] = h
+	}
+
+	h.observe(d.Seconds())
+}
+
+// ObserveResults records the number of results returned by the handler named 'handler'.
+func (m *Metrics) ObserveResults(handler string, count int) {
+
+	if m == nil {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	h, ok := m.results[handler]
+
+	if !ok {
+		h = newHistogram(resultsBuckets)
+		m.results[handler] = h
+	}
+
+	h.observe(float64(count))
+}
+
+// ObserveRequest records a request to the handler named 'handler' that completed with the HTTP status code 'status'.
+// Status codes of 400 or more are also recorded as errors of type 'error_type' or, if empty, a type derived from 'status'.
+func (m *Metrics) ObserveRequest(handler string, status int, error_type string) {
+
+	if m == nil {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	increment(m.requests, handler, strconv.Itoa(status))
+
+	if status >= http.StatusBadRequest {
+
+		if error_type == "" {
+			error_type = errorType(status)
+		}
+
+		increment(m.errors, handler, error_type)
+	}
+}
+
+// ObserveError records an error of type 'error_type' for the handler named 'handler' that did not end the request,
+// for example a single failed lookup in a batch.
+func (m *Metrics) ObserveError(handler string, error_type string) {
+
+	if m == nil {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	increment(m.errors, handler, error_type)
+}
+
+// WithMetrics returns a http.Handler that records the status code, and the error code for problem details responses,
+// of every request to 'next' in 'm' using the handler name 'handler'. If 'm' is nil then 'next' is returned unchanged.
+func WithMetrics(handler string, next http.Handler, m *Metrics) http.Handler {
+
+	if m == nil {
+		return next
+	}
+
+	fn := func(rsp http.ResponseWriter, req *http.Request) {
+
+		status_rsp := &statusResponseWriter{
+			ResponseWriter: rsp,
+			status:         http.StatusOK,
+		}
+
+		next.ServeHTTP(status_rsp, req)
+
+		m.ObserveRequest(handler, status_rsp.status, status_rsp.code)
+	}
+
+	return http.HandlerFunc(fn)
+}
+
+// MetricsHandlerOptions defines options for the MetricsHandler.
+type MetricsHandlerOptions struct {
+	// The Metrics instance whose values are exported.
+	Metrics *Metrics
+}
+
+// MetricsHandler returns a http.Handler that exports the values in opts.Metrics, along with the indexing status of
+// 'app' and the activity of its query cache if it has one, in the Prometheus text exposition format.
+func MetricsHandler(app *spatial_app.SpatialApplication, opts *MetricsHandlerOptions) (http.Handler, error) {
+
+	if opts.Metrics == nil {
+		return nil, fmt.Errorf("Missing metrics")
+	}
+
+	fn := func(rsp http.ResponseWriter, req *http.Request) {
+
+		if req.Method != "GET" {
+			WriteProblem(rsp, req, METHOD_NOT_ALLOWED, "Unsupported method")
+			return
+		}
+
+		rsp.Header().Set("Content-Type", PROMETHEUS_TEXT)
+
+		err := opts.Metrics.write(rsp, app)
+
+		if err != nil {
+			WriteError(rsp, req, err, INTERNAL_ERROR)
+			return
+		}
+
+		return
+	}
+
+	metrics_handler := http.HandlerFunc(fn)
+	return metrics_handler, nil
+}
+
+// write writes the values in 'm' and the indexing status of 'app' to 'wr' in the Prometheus text exposition format.
+func (m *Metrics) write(wr io.Writer, app *spatial_app.SpatialApplication) error {
+
+	var b strings.Builder
+
+	m.mu.Lock()
+
+	writeHeader(&b, "pip_http_requests_total", "counter", "Total number of HTTP requests by handler and status code.")
+
+	for _, handler := range sortedKeys(m.requests) {
+
+		for _, code := range sortedKeys(m.requests[handler]) {
+			fmt.Fprintf(&b, "pip_http_requests_total{handler=%s,code=%s} %d\n", quoteLabel(handler), quoteLabel(code), m.requests[handler][code])
+		}
+	}
+
+	writeHeader(&b, "pip_errors_total", "counter", "Total number of errors by handler and type.")
+
+	for _, handler := range sortedKeys(m.errors) {
+
+		for _, error_type := range sortedKeys(m.errors[handler]) {
+			fmt.Fprintf(&b, "pip_errors_total{handler=%s,type=%s} %d\n", quoteLabel(handler), quoteLabel(error_type), m.errors[handler][error_type])
+		}
+	}
+
+	writeHeader(&b, "pip_stage_duration_seconds", "histogram", "Duration of each stage of a request, in seconds.")
+
+	for _, stage := range sortedKeys(m.stages) {
+		m.stages[stage].write(&b, "pip_stage_duration_seconds", "stage", stage)
+	}
+
+	writeHeader(&b, "pip_results", "histogram", "Number of results returned by each request.")
+
+	for _, handler := range sortedKeys(m.results) {
+		m.results[handler].write(&b, "pip_results", "handler", handler)
+	}
+
+	m.mu.Unlock()
+
+	indexing := 0
+
+	if app.Iterator.IsIndexing() {
+		indexing = 1
+	}
+
+	writeHeader(&b, "pip_indexing", "gauge", "Whether records are currently being indexed.")
+	fmt.Fprintf(&b, "pip_indexing %d\n", indexing)
+
+	writeHeader(&b, "pip_indexed_records_total", "counter", "Total number of records seen by the indexing iterator.")
+	fmt.Fprintf(&b, "pip_indexed_records_total %d\n", atomic.LoadInt64(&app.Iterator.Seen))
+
+	cache, has_cache := pip.QueryCacheWithApplication(app)
+
+	if has_cache {
+
+		stats := cache.Stats()
+
+		writeHeader(&b, "pip_cache_hits_total", "counter", "Total number of point-in-polygon queries answered from the cache.")
+		fmt.Fprintf(&b, "pip_cache_hits_total %d\n", stats.Hits)
+
+		writeHeader(&b, "pip_cache_misses_total", "counter", "Total number of point-in-polygon queries not answered from the cache.")
+		fmt.Fprintf(&b, "pip_cache_misses_total %d\n", stats.Misses)
+
+		writeHeader(&b, "pip_cache_evictions_total", "counter", "Total number of cached results removed because the cache was full or they had expired.")
+		fmt.Fprintf(&b, "pip_cache_evictions_total %d\n", stats.Evictions)
+
+		writeHeader(&b, "pip_cache_invalidations_total", "counter", "Total number of times the cache was emptied because a record was indexed or removed.")
+		fmt.Fprintf(&b, "pip_cache_invalidations_total %d\n", stats.Invalidations)
+
+		writeHeader(&b, "pip_cache_entries", "gauge", "Number of results currently cached.")
+		fmt.Fprintf(&b, "pip_cache_entries %d\n", stats.Entries)
+	}
+
+	_, err := io.WriteString(wr, b.String())
+
+	if err != nil {
+		return fmt.Errorf("Failed to write metrics, %w", err)
+	}
+
+	return nil
+}
+
+// histogram is a cumulative histogram with fixed bucket upper bounds.
+type histogram struct {
+	bounds []float64
+	counts []int64
+	count  int64
+	sum    float64
+}
+
+func newHistogram(bounds []float64) *histogram {
+
+	h := &histogram{
+		bounds: bounds,
+		counts: make([]int64, len(bounds)),
+	}
+
+	return h
+}
+
+func (h *histogram) observe(v float64) {
+
+	for i, le := range h.bounds {
+
+		if v <= le {
+			h.counts[i] += 1
+		}
+	}
+
+	h.count += 1
+	h.sum += v
+}
+
+func (h *histogram) write(b *strings.Builder, name string, label string, value string) {
+
+	for i, le := range h.bounds {
+		fmt.Fprintf(b, "%s_bucket{%s=%s,le=\"%s\"} %d\n", name, label, quoteLabel(value), strconv.FormatFloat(le, 'g', -1, 64), h.counts[i])
+	}
+
+	fmt.Fprintf(b, "%s_bucket{%s=%s,le=\"+Inf\"} %d\n", name, label, quoteLabel(value), h.count)
+	fmt.Fprintf(b, "%s_sum{%s=%s} %s\n", name, label, quoteLabel(value), strconv.FormatFloat(h.sum, 'g', -1, 64))
+	fmt.Fprintf(b, "%s_count{%s=%s} %d\n", name, label, quoteLabel(value), h.count)
+}
+
+// statusResponseWriter is a http.ResponseWriter that records the status code, and any problem details error code,
+// written to it.
+type statusResponseWriter struct {
+	http.ResponseWriter
+	status int
+	code   string
+}
+
+func (w *statusResponseWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// Unwrap returns the underlying http.ResponseWriter so that http.ResponseController can flush responses.
+func (w *statusResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// newRequestApplication returns a per-request application, and its monitor, derived from 'app' whose stage timings
+// are also recorded by 'm' if it is not nil.
+func newRequestApplication(app *spatial_app.SpatialApplication, m *Metrics) (*spatial_app.SpatialApplication, *pip.RequestMonitor) {
+
+	if m == nil {
+		return pip.NewRequestApplication(app)
+	}
+
+	return pip.NewRequestApplicationWithObserver(app, m)
+}
+
+// errorType returns the error type for the HTTP status code 'status', for example "bad_request".
+func errorType(status int) string {
+
+	text := http.StatusText(status)
+
+	if text == "" {
+		return strconv.Itoa(status)
+	}
+
+	return strings.ReplaceAll(strings.ToLower(text), " ", "_")
+}
+
+func increment(counts map[string]map[string]int64, key string, sub_key string) {
+
+	_, ok := counts[key]
+
+	if !ok {
+		counts[key] = make(map[string]int64)
+	}
+
+	counts[key][sub_key] += 1
+}
+
+func writeHeader(b *strings.Builder, name string, metric_type string, help string) {
+	fmt.Fprintf(b, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(b, "# TYPE %s %s\n", name, metric_type)
+}
+
+func quoteLabel(v string) string {
+
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	return `"` + r.Replace(v) + `"`
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+
+	keys := make([]string, 0, len(m))
+
+	for k := range m {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+	return keys
+}
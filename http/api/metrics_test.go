@@ -0,0 +1,272 @@
+package api
+
+import (
+	"github.com/whosonfirst/go-whosonfirst-spatial-pip"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetricsHandler(t *testing.T) {
+
+	app := newTestApplication(t, nil)
+	m := NewMetrics()
+
+	pip_opts := &PointInPolygonHandlerOptions{
+		Logger:  log.New(io.Discard, "", 0),
+		Metrics: m,
+	}
+
+	pip_handler, err := PointInPolygonHandler(app, pip_opts)
+
+	if err != nil {
+		t.Fatalf("Failed to create point in polygon handler, %v", err)
+	}
+
+	pip_handler = WithMetrics(METRICS_POINT_IN_POLYGON, pip_handler, m)
+
+	metrics_opts := &MetricsHandlerOptions{
+		Metrics: m,
+	}
+
+	metrics_handler, err := MetricsHandler(app, metrics_opts)
+
+	if err != nil {
+		t.Fatalf("Failed to create metrics handler, %v", err)
+	}
+
+	for _, q := range []string{"latitude=0.25&longitude=0.25", "latitude=5.5&longitude=5.5", "latitude=0.25"} {
+
+		req := httptest.NewRequest(http.MethodGet, "/?"+q, nil)
+		rsp := httptest.NewRecorder()
+
+		pip_handler.ServeHTTP(rsp, req)
+	}
+
+	m.ObserveError(METRICS_BATCH, string(pip.INVALID_PLACETYPE))
+
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	rsp := httptest.NewRecorder()
+
+	metrics_handler.ServeHTTP(rsp, req)
+
+	if rsp.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rsp.Code, rsp.Body.String())
+	}
+
+	if rsp.Header().Get("Content-Type") != PROMETHEUS_TEXT {
+		t.Fatalf("Expected %s response, got %s", PROMETHEUS_TEXT, rsp.Header().Get("Content-Type"))
+	}
+
+	body := rsp.Body.String()
+
+	expected := []string{
+		"# TYPE pip_http_requests_total counter",
+		`pip_http_requests_total{handler="point_in_polygon",code="200"} 2`,
+		`pip_http_requests_total{handler="point_in_polygon",code="400"} 1`,
+		"# TYPE pip_errors_total counter",
+		`pip_errors_total{handler="batch",type="invalid_placetype"} 1`,
+		`pip_errors_total{handler="point_in_polygon",type="invalid_coordinate"} 1`,
+		"# TYPE pip_stage_duration_seconds histogram",
+		`pip_stage_duration_seconds_count{stage="PIP query"} 2`,
+		"# TYPE pip_results histogram",
+		`pip_results_bucket{handler="point_in_polygon",le="0"} 0`,
+		`pip_results_bucket{handler="point_in_polygon",le="1"} 1`,
+		`pip_results_bucket{handler="point_in_polygon",le="5"} 2`,
+		`pip_results_bucket{handler="point_in_polygon",le="+Inf"} 2`,
+		`pip_results_sum{handler="point_in_polygon"} 6`,
+		`pip_results_count{handler="point_in_polygon"} 2`,
+		"pip_indexing 0",
+		"pip_indexed_records_total 7",
+	}
+
+	lines := strings.Split(body, "\n")
+
+	for _, e := range expected {
+
+		found := false
+
+		for _, ln := range lines {
+
+			if ln == e {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			t.Fatalf("Expected metrics to include '%s', got %s", e, body)
+		}
+	}
+
+	// Cache metrics are only included if the spatial database has a query cache
+
+	if strings.Contains(body, "pip_cache_hits_total") {
+		t.Fatalf("Unexpected cache metrics")
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/metrics", nil)
+	rsp = httptest.NewRecorder()
+
+	metrics_handler.ServeHTTP(rsp, req)
+
+	decodeProblem(t, rsp, http.StatusMethodNotAllowed, METHOD_NOT_ALLOWED)
+
+	_, err = MetricsHandler(app, &MetricsHandlerOptions{})
+
+	if err == nil {
+		t.Fatalf("Expected an error creating a metrics handler without metrics")
+	}
+}
+
+func TestMetricsHandlerCache(t *testing.T) {
+
+	app := newTestApplication(t, nil)
+	m := NewMetrics()
+
+	c, err := pip.NewQueryCache(&pip.QueryCacheOptions{Size: 1})
+
+	if err != nil {
+		t.Fatalf("Failed to create query cache, %v", err)
+	}
+
+	app.SpatialDatabase.(*pip.SpatialDatabase).SetQueryCache(c)
+
+	pip_opts := &PointInPolygonHandlerOptions{
+		Logger:  log.New(io.Discard, "", 0),
+		Metrics: m,
+	}
+
+	pip_handler, err := PointInPolygonHandler(app, pip_opts)
+
+	if err != nil {
+		t.Fatalf("Failed to create point in polygon handler, %v", err)
+	}
+
+	metrics_handler, err := MetricsHandler(app, &MetricsHandlerOptions{Metrics: m})
+
+	if err != nil {
+		t.Fatalf("Failed to create metrics handler, %v", err)
+	}
+
+	// The second query is a hit and the third evicts its result from the single entry cache
+
+	for _, q := range []string{"latitude=0.25&longitude=0.25", "latitude=0.25&longitude=0.25", "latitude=5.5&longitude=5.5"} {
+
+		req := httptest.NewRequest(http.MethodGet, "/?"+q, nil)
+		rsp := httptest.NewRecorder()
+
+		pip_handler.ServeHTTP(rsp, req)
+
+		if rsp.Code != http.StatusOK {
+			t.Fatalf("Expected status 200 for %s, got %d", q, rsp.Code)
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	rsp := httptest.NewRecorder()
+
+	metrics_handler.ServeHTTP(rsp, req)
+
+	lines := strings.Split(rsp.Body.String(), "\n")
+
+	expected := []string{
+		"# TYPE pip_cache_hits_total counter",
+		"pip_cache_hits_total 1",
+		"pip_cache_misses_total 2",
+		"pip_cache_evictions_total 1",
+		"pip_cache_invalidations_total 0",
+		"# TYPE pip_cache_entries gauge",
+		"pip_cache_entries 1",
+	}
+
+	for _, e := range expected {
+
+		if !slices.Contains(lines, e) {
+			t.Fatalf("Expected metrics to include '%s', got %s", e, rsp.Body.String())
+		}
+	}
+}
+
+func TestMetricsObserveRequest(t *testing.T) {
+
+	tests := []struct {
+		status     int
+		error_type string
+		expected   string
+	}{
+		{status: http.StatusOK},
+		{status: http.StatusNotFound, expected: "not_found"},
+		{status: http.StatusServiceUnavailable, expected: "service_unavailable"},
+		{status: http.StatusBadRequest, error_type: "invalid_coordinate", expected: "invalid_coordinate"},
+		{status: 599, expected: "599"},
+	}
+
+	for _, tt := range tests {
+
+		m := NewMetrics()
+		m.ObserveRequest(METRICS_POINT_IN_POLYGON, tt.status, tt.error_type)
+
+		if tt.expected == "" {
+
+			if len(m.errors) != 0 {
+				t.Fatalf("Expected no errors for status %d, got %v", tt.status, m.errors)
+			}
+
+			continue
+		}
+
+		if m.errors[METRICS_POINT_IN_POLYGON][tt.expected] != 1 {
+			t.Fatalf("Expected %s error for status %d, got %v", tt.expected, tt.status, m.errors)
+		}
+	}
+}
+
+func TestMetricsNil(t *testing.T) {
+
+	var m *Metrics
+
+	// None of these should panic
+
+	m.ObserveStage("PIP query", time.Millisecond)
+	m.ObserveResults(METRICS_POINT_IN_POLYGON, 1)
+	m.ObserveRequest(METRICS_POINT_IN_POLYGON, http.StatusOK, "")
+	m.ObserveError(METRICS_POINT_IN_POLYGON, "invalid_coordinate")
+
+	next := http.NotFoundHandler()
+
+	if WithMetrics(METRICS_POINT_IN_POLYGON, next, m) == nil {
+		t.Fatalf("Expected handler to be returned")
+	}
+}
+
+func TestHistogram(t *testing.T) {
+
+	h := newHistogram([]float64{1, 5, 10})
+
+	for _, v := range []float64{0, 1, 3, 7, 20} {
+		h.observe(v)
+	}
+
+	var b strings.Builder
+	h.write(&b, "test", "label", `a "quoted"\value`)
+
+	expected := strings.Join([]string{
+		`test_bucket{label="a \"quoted\"\\value",le="1"} 2`,
+		`test_bucket{label="a \"quoted\"\\value",le="5"} 3`,
+		`test_bucket{label="a \"quoted\"\\value",le="10"} 4`,
+		`test_bucket{label="a \"quoted\"\\value",le="+Inf"} 5`,
+		`test_sum{label="a \"quoted\"\\value"} 31`,
+		`test_count{label="a \"quoted\"\\value"} 5`,
+		``,
+	}, "\n")
+
+	if b.String() != expected {
+		t.Fatalf("Expected %s, got %s", expected, b.String())
+	}
+}
@@ -1,13 +1,9 @@
 package api
 
 import (
-	"encoding/json"
-	"github.com/aaronland/go-http-sanitize"
-	"github.com/whosonfirst/go-whosonfirst-spatial"
+	"github.com/sfomuseum/go-timings"
 	"github.com/whosonfirst/go-whosonfirst-spatial-pip"
 	spatial_app "github.com/whosonfirst/go-whosonfirst-spatial/app"
-	"github.com/whosonfirst/go-whosonfirst-spr-geojson"
-	"github.com/sfomuseum/go-timings"
 	"log"
 	"net/http"
 )
@@ -24,129 +20,114 @@ const GEOJSON string = "application/geo+json"
 
 type PointInPolygonHandlerOptions struct {
 	EnableGeoJSON bool
-	Logger *log.Logger
-	LogTimings bool
+	Logger        *log.Logger
+	LogTimings    bool
+	// An optional Metrics instance used to record stage timings and result counts.
+	Metrics *Metrics
+	// If true reject JSON-encoded requests containing unknown fields.
+	StrictDecoding bool
+	IndexingOptions
 }
 
+// PointInPolygonHandler returns a http.Handler that performs a point-in-polygon query for a GET or POST request. If
+// the application's spatial database implements the `pip.VersionedIndex` interface then, once records have been
+// indexed, responses include the index generation and release, as well as `ETag` and `Last-Modified` headers, and a
+// GET request whose If-None-Match header matches the current entity tag receives a 304 Not Modified response. Unless
+// opts.ServeWhileIndexing is true a 503 Service Unavailable response is returned while records are still being indexed.
 func PointInPolygonHandler(app *spatial_app.SpatialApplication, opts *PointInPolygonHandlerOptions) (http.Handler, error) {
 
 	fn := func(rsp http.ResponseWriter, req *http.Request) {
 
 		ctx := req.Context()
 
-		if req.Method != "POST" {
-			http.Error(rsp, "Unsupported method", http.StatusMethodNotAllowed)
+		if req.Method != "GET" && req.Method != "POST" {
+			WriteProblem(rsp, req, METHOD_NOT_ALLOWED, "Unsupported method")
 			return
 		}
 
-		if app.Iterator.IsIndexing() {
-			http.Error(rsp, "Indexing records", http.StatusServiceUnavailable)
+		q, ok := newQueryRequest(rsp, req, app, opts.IndexingOptions, opts.Metrics)
+
+		if !ok {
 			return
 		}
 
-		app.Monitor.Signal(ctx, timings.SinceStart, timingsPIPHandler)
-		
-		defer func(){
-
-			app.Monitor.Signal(ctx, timings.SinceStop, timingsPIPHandler)
+		req_app := q.app
 
-			if opts.LogTimings {
-				
-				for _, t := range app.Timings {
-					opts.Logger.Println(t)
-				}
-			}
-		}()
-		
-		var pip_req *pip.PointInPolygonRequest
+		defer q.timeHandler(ctx, timingsPIPHandler, opts.LogTimings, opts.Logger)()
 
-		dec := json.NewDecoder(req.Body)
-		err := dec.Decode(&pip_req)
+		pip_req, err := pointInPolygonRequestWithHTTPRequest(req, opts.StrictDecoding)
 
 		if err != nil {
-			http.Error(rsp, err.Error(), http.StatusBadRequest)
+			WriteError(rsp, req, err, pip.INVALID_REQUEST)
 			return
 		}
 
-		accept, err := sanitize.HeaderString(req, "Accept")
+		format, err := negotiateResponseFormat(rsp, req, pip_req, opts.EnableGeoJSON)
 
 		if err != nil {
-			http.Error(rsp, err.Error(), http.StatusBadRequest)
-			return
-		}
-
-		if accept == GEOJSON && !opts.EnableGeoJSON {
-			http.Error(rsp, "GeoJSON output is not supported", http.StatusBadRequest)
+			WriteProblem(rsp, req, NOT_ACCEPTABLE, err.Error())
 			return
 		}
 
-		app.Monitor.Signal(ctx, timings.SinceStart, timingsPIPQuery)
-		
-		pip_rsp, err := pip.QueryPointInPolygon(ctx, app, pip_req)
+		// If the spatial database tracks changes then GET requests for which neither the
+		// request nor the index has changed since the client's copy are not performed. While
+		// indexing the generation is not incremented for every record so there is no version.
 
-		app.Monitor.Signal(ctx, timings.SinceStop, timingsPIPQuery)
-		
-		if err != nil {
-			http.Error(rsp, err.Error(), http.StatusInternalServerError)
-			return
-		}
+		version, has_version := pip.IndexVersionWithApplication(app)
+		has_version = has_version && !q.indexing
 
-		if opts.EnableGeoJSON && accept == GEOJSON {
+		var etag string
 
-			app.Monitor.Signal(ctx, "Start PIP handler feature collection")
-			
-			opts := &geojson.AsFeatureCollectionOptions{
-				Reader: app.SpatialDatabase,
-				Writer: rsp,
-			}
+		if has_version {
 
-			app.Monitor.Signal(ctx, timings.SinceStart, timingsPIPFeatureCollection)
-			
-			err := geojson.AsFeatureCollection(ctx, pip_rsp, opts)
+			etag, err = ETagWithRequest(version, pip_req, format)
 
-			app.Monitor.Signal(ctx, timings.SinceStop, timingsPIPFeatureCollection)
-			
 			if err != nil {
-				http.Error(rsp, err.Error(), http.StatusInternalServerError)
+				WriteError(rsp, req, err, INTERNAL_ERROR)
 				return
 			}
-		}
-
-		if len(pip_req.Properties) > 0 {
-			
-			props_opts := &spatial.PropertiesResponseOptions{
-				Reader:       app.PropertiesReader,
-				Keys:         pip_req.Properties,
-				SourcePrefix: "properties",
-			}
-
-			app.Monitor.Signal(ctx, timings.SinceStart, timingsPIPProperties)
-			
-			props_rsp, err := spatial.PropertiesResponseResultsWithStandardPlacesResults(ctx, props_opts, pip_rsp)
 
-			app.Monitor.Signal(ctx, timings.SinceStop, timingsPIPProperties)
-			
-			if err != nil {
-				http.Error(rsp, err.Error(), http.StatusInternalServerError)
+			if req.Method == "GET" && MatchesETag(req, etag) {
+				writeIndexVersionHeaders(rsp.Header(), version, etag)
+				rsp.WriteHeader(http.StatusNotModified)
 				return
 			}
+		}
 
-			enc := json.NewEncoder(rsp)
-			err = enc.Encode(props_rsp)
+		req_app.Monitor.Signal(ctx, timings.SinceStart, timingsPIPQuery)
 
-			if err != nil {
-				http.Error(rsp, err.Error(), http.StatusInternalServerError)
-				return
-			}
+		pip_rsp, err := pip.QueryPointInPolygon(ctx, req_app, pip_req)
+
+		req_app.Monitor.Signal(ctx, timings.SinceStop, timingsPIPQuery)
 
+		if err != nil {
+			WriteError(rsp, req, err, INTERNAL_ERROR)
 			return
 		}
 
-		enc := json.NewEncoder(rsp)
-		err = enc.Encode(pip_rsp)
+		opts.Metrics.ObserveResults(METRICS_POINT_IN_POLYGON, len(pip_rsp.Results()))
+
+		// The entity tag was derived before the query so it is only used if the index did not
+		// change while the query was being performed. Headers are only written if the results
+		// are encoded successfully.
+
+		headers := make(http.Header)
+
+		if has_version {
+
+			current, _ := pip.IndexVersionWithApplication(app)
+
+			if current.Generation == version.Generation {
+				writeIndexVersionHeaders(headers, version, etag)
+			}
+		}
+
+		pip_rsp = q.appendIndexProgress(pip_rsp, headers)
+
+		err = WriteResponseWithHeaders(ctx, rsp, req_app, pip_req, format, pip_rsp, headers)
 
 		if err != nil {
-			http.Error(rsp, err.Error(), http.StatusInternalServerError)
+			WriteError(rsp, req, err, INTERNAL_ERROR)
 			return
 		}
 
@@ -0,0 +1,417 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/whosonfirst/go-whosonfirst-spatial-pip"
+	spatial_app "github.com/whosonfirst/go-whosonfirst-spatial/app"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestPointInPolygonHandlerGET(t *testing.T) {
+
+	app := newTestApplication(t, nil)
+
+	opts := &PointInPolygonHandlerOptions{
+		Logger: log.New(io.Discard, "", 0),
+	}
+
+	handler, err := PointInPolygonHandler(app, opts)
+
+	if err != nil {
+		t.Fatalf("Failed to create handler, %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		query    string
+		body     string
+		expected []string
+	}{
+		{
+			name:     "coordinate",
+			query:    "latitude=0.25&longitude=0.25",
+			body:     `{"latitude":0.25,"longitude":0.25}`,
+			expected: []string{"101000001", "101000002", "102000001", "85000001", "85000002"},
+		},
+		{
+			name:     "filters",
+			query:    "latitude=0.75&longitude=0.75&placetype=locality&placetype=region&is_current=1",
+			body:     `{"latitude":0.75,"longitude":0.75,"placetypes":["locality","region"],"is_current":[1]}`,
+			expected: []string{"101000001", "85000002"},
+		},
+		{
+			name:     "sort",
+			query:    "latitude=0.25&longitude=0.25&sort=placetype://&is_current=1",
+			body:     `{"latitude":0.25,"longitude":0.25,"sort":["placetype://"],"is_current":[1]}`,
+			expected: []string{"101000001", "102000001", "85000001", "85000002"},
+		},
+		{
+			name:     "properties",
+			query:    "latitude=5.5&longitude=5.5&property=wof:country",
+			body:     `{"latitude":5.5,"longitude":5.5,"properties":["wof:country"]}`,
+			expected: []string{"101000003"},
+		},
+	}
+
+	for _, tt := range tests {
+
+		t.Run(tt.name, func(t *testing.T) {
+
+			get_req := httptest.NewRequest(http.MethodGet, "/?"+tt.query, nil)
+			get_rsp := httptest.NewRecorder()
+
+			handler.ServeHTTP(get_rsp, get_req)
+
+			if get_rsp.Code != http.StatusOK {
+				t.Fatalf("Expected status 200 for GET request, got %d: %s", get_rsp.Code, get_rsp.Body.String())
+			}
+
+			post_req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tt.body))
+			post_rsp := httptest.NewRecorder()
+
+			handler.ServeHTTP(post_rsp, post_req)
+
+			if post_rsp.Code != http.StatusOK {
+				t.Fatalf("Expected status 200 for POST request, got %d: %s", post_rsp.Code, post_rsp.Body.String())
+			}
+
+			// The order of results is not guaranteed so compare their (sorted) IDs
+
+			for label, body := range map[string][]byte{"GET": get_rsp.Body.Bytes(), "POST": post_rsp.Body.Bytes()} {
+
+				ids := placeIds(t, body)
+
+				if !slices.Equal(ids, tt.expected) {
+					t.Fatalf("Expected %v for %s request, got %v", tt.expected, label, ids)
+				}
+			}
+		})
+	}
+}
+
+func TestPointInPolygonHandlerGETInvalid(t *testing.T) {
+
+	app := newTestApplication(t, nil)
+
+	opts := &PointInPolygonHandlerOptions{
+		Logger: log.New(io.Discard, "", 0),
+	}
+
+	handler, err := PointInPolygonHandler(app, opts)
+
+	if err != nil {
+		t.Fatalf("Failed to create handler, %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		method string
+		query  string
+		status int
+		code   pip.ErrorCode
+	}{
+		{
+			name:   "missing longitude",
+			method: http.MethodGet,
+			query:  "latitude=0.25",
+			status: http.StatusBadRequest,
+			code:   pip.INVALID_COORDINATE,
+		},
+		{
+			name:   "invalid placetype",
+			method: http.MethodGet,
+			query:  "latitude=0.25&longitude=0.25&placetype=planet-x",
+			status: http.StatusBadRequest,
+			code:   pip.INVALID_PLACETYPE,
+		},
+		{
+			name:   "huge page",
+			method: http.MethodGet,
+			query:  "latitude=0.25&longitude=0.25&page=1000000000000000000&per_page=10",
+			status: http.StatusBadRequest,
+			code:   pip.INVALID_PARAMETER,
+		},
+		{
+			name:   "method",
+			method: http.MethodDelete,
+			query:  "latitude=0.25&longitude=0.25",
+			status: http.StatusMethodNotAllowed,
+			code:   METHOD_NOT_ALLOWED,
+		},
+	}
+
+	for _, tt := range tests {
+
+		t.Run(tt.name, func(t *testing.T) {
+
+			req := httptest.NewRequest(tt.method, "/?"+tt.query, nil)
+			rsp := httptest.NewRecorder()
+
+			handler.ServeHTTP(rsp, req)
+
+			decodeProblem(t, rsp, tt.status, tt.code)
+		})
+	}
+}
+
+func TestPointInPolygonHandlerTimings(t *testing.T) {
+
+	app := newTestApplication(t, nil)
+
+	opts := &PointInPolygonHandlerOptions{
+		Logger: log.New(io.Discard, "", 0),
+	}
+
+	handler, err := PointInPolygonHandler(app, opts)
+
+	if err != nil {
+		t.Fatalf("Failed to create handler, %v", err)
+	}
+
+	// Timings are recorded for each request so the number of stages should not grow between requests
+
+	stages := -1
+
+	for i := 0; i < 2; i++ {
+
+		req := httptest.NewRequest(http.MethodGet, "/?latitude=0.25&longitude=0.25&timings=1", nil)
+		rsp := httptest.NewRecorder()
+
+		handler.ServeHTTP(rsp, req)
+
+		if rsp.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d: %s", rsp.Code, rsp.Body.String())
+		}
+
+		server_timing := rsp.Header().Get("Server-Timing")
+
+		if !strings.Contains(server_timing, `desc="PIP query"`) {
+			t.Fatalf("Expected Server-Timing header to include PIP query, got '%s'", server_timing)
+		}
+
+		var pip_rsp struct {
+			Timings []struct {
+				Label string `json:"label"`
+			} `json:"timings"`
+		}
+
+		err := json.Unmarshal(rsp.Body.Bytes(), &pip_rsp)
+
+		if err != nil {
+			t.Fatalf("Failed to decode response, %v", err)
+		}
+
+		if len(pip_rsp.Timings) == 0 {
+			t.Fatalf("Expected response to include timings")
+		}
+
+		if stages != -1 && len(pip_rsp.Timings) != stages {
+			t.Fatalf("Expected %d timings, got %d", stages, len(pip_rsp.Timings))
+		}
+
+		stages = len(pip_rsp.Timings)
+	}
+}
+
+func TestPointInPolygonHandlerValidation(t *testing.T) {
+
+	app := newTestApplication(t, nil)
+
+	opts := &PointInPolygonHandlerOptions{
+		Logger:         log.New(io.Discard, "", 0),
+		StrictDecoding: true,
+	}
+
+	handler, err := PointInPolygonHandler(app, opts)
+
+	if err != nil {
+		t.Fatalf("Failed to create handler, %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		body   string
+		code   pip.ErrorCode
+		fields []string
+	}{
+		{
+			name:   "every invalid field",
+			body:   `{"latitude":91,"longitude":0,"placetypes":["planet-x"],"is_current":[2]}`,
+			code:   pip.INVALID_REQUEST,
+			fields: []string{"latitude", "placetypes", "is_current"},
+		},
+		{
+			name:   "shared code",
+			body:   `{"latitude":0,"longitude":0,"inception_date":"yesterday","cessation_date":"tomorrow"}`,
+			code:   pip.INVALID_EDTF,
+			fields: []string{"inception_date", "cessation_date"},
+		},
+		{
+			name:   "unknown field",
+			body:   `{"latitude":0,"longitude":0,"placetype":"locality"}`,
+			code:   pip.INVALID_REQUEST,
+			fields: []string{},
+		},
+	}
+
+	for _, tt := range tests {
+
+		t.Run(tt.name, func(t *testing.T) {
+
+			req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tt.body))
+			rsp := httptest.NewRecorder()
+
+			handler.ServeHTTP(rsp, req)
+
+			problem := decodeProblem(t, rsp, http.StatusBadRequest, tt.code)
+
+			fields := make([]string, len(problem.Errors))
+
+			for idx, fe := range problem.Errors {
+				fields[idx] = fe.Field
+			}
+
+			if !slices.Equal(fields, tt.fields) {
+				t.Fatalf("Expected invalid fields %v, got %v", tt.fields, fields)
+			}
+		})
+	}
+}
+
+func TestHandlersServeWhileIndexing(t *testing.T) {
+
+	logger := log.New(io.Discard, "", 0)
+
+	tests := []struct {
+		name    string
+		handler func(app *spatial_app.SpatialApplication, serve bool) (http.Handler, error)
+		method  string
+		target  string
+		body    string
+		// Whether the JSON-encoded response includes an `index_progress` member
+		progress bool
+	}{
+		{
+			name: "point in polygon",
+			handler: func(app *spatial_app.SpatialApplication, serve bool) (http.Handler, error) {
+				return PointInPolygonHandler(app, &PointInPolygonHandlerOptions{IndexingOptions: IndexingOptions{ServeWhileIndexing: serve, ExpectedRecords: 7}, Logger: logger})
+			},
+			method:   http.MethodGet,
+			target:   "/?latitude=0.75&longitude=0.75",
+			progress: true,
+		},
+		{
+			name: "intersects",
+			handler: func(app *spatial_app.SpatialApplication, serve bool) (http.Handler, error) {
+				return IntersectsHandler(app, &IntersectsHandlerOptions{IndexingOptions: IndexingOptions{ServeWhileIndexing: serve, ExpectedRecords: 7}, Logger: logger})
+			},
+			method:   http.MethodGet,
+			target:   "/?bbox=0.6,0.6,0.7,0.7",
+			progress: true,
+		},
+		{
+			name: "geometry",
+			handler: func(app *spatial_app.SpatialApplication, serve bool) (http.Handler, error) {
+				return GeometryHandler(app, &GeometryHandlerOptions{IndexingOptions: IndexingOptions{ServeWhileIndexing: serve, ExpectedRecords: 7}, Logger: logger})
+			},
+			method:   http.MethodPost,
+			target:   "/",
+			body:     `{"geometry":{"type":"Polygon","coordinates":[[[0.6,0.6],[0.7,0.6],[0.7,0.7],[0.6,0.7],[0.6,0.6]]]},"relation":"contains"}`,
+			progress: true,
+		},
+		{
+			name: "batch",
+			handler: func(app *spatial_app.SpatialApplication, serve bool) (http.Handler, error) {
+				return BatchPointInPolygonHandler(app, &BatchPointInPolygonHandlerOptions{IndexingOptions: IndexingOptions{ServeWhileIndexing: serve, ExpectedRecords: 7}, Logger: logger})
+			},
+			method:   http.MethodPost,
+			target:   "/batch",
+			body:     `[{"latitude":0.75,"longitude":0.75}]`,
+			progress: true,
+		},
+		{
+			name: "hierarchy",
+			handler: func(app *spatial_app.SpatialApplication, serve bool) (http.Handler, error) {
+				return HierarchyHandler(app, &HierarchyHandlerOptions{IndexingOptions: IndexingOptions{ServeWhileIndexing: serve}, Logger: logger})
+			},
+			method: http.MethodGet,
+			target: "/?latitude=0.75&longitude=0.75",
+		},
+		{
+			name: "stream",
+			handler: func(app *spatial_app.SpatialApplication, serve bool) (http.Handler, error) {
+				return StreamPointInPolygonHandler(app, &StreamPointInPolygonHandlerOptions{IndexingOptions: IndexingOptions{ServeWhileIndexing: serve}, Logger: logger})
+			},
+			method: http.MethodPost,
+			target: "/stream",
+			body:   `{"id":"a","latitude":0.75,"longitude":0.75}`,
+		},
+	}
+
+	for _, tt := range tests {
+
+		for _, serve := range []bool{false, true} {
+
+			t.Run(fmt.Sprintf("%s serve %t", tt.name, serve), func(t *testing.T) {
+
+				app := newTestApplication(t, &pip.SpatialDatabaseOptions{IndexGeometries: true})
+
+				handler, err := tt.handler(app, serve)
+
+				if err != nil {
+					t.Fatalf("Failed to create handler, %v", err)
+				}
+
+				stop := startIndexing(t, app)
+				defer stop()
+
+				req := httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.body))
+				rsp := httptest.NewRecorder()
+
+				handler.ServeHTTP(rsp, req)
+
+				if !serve {
+					decodeProblem(t, rsp, http.StatusServiceUnavailable, pip.INDEX_NOT_READY)
+					return
+				}
+
+				if rsp.Code != http.StatusOK {
+					t.Fatalf("Expected status 200, got %d: %s", rsp.Code, rsp.Body.String())
+				}
+
+				if rsp.Header().Get(INDEX_COMPLETE_HEADER) != "false" {
+					t.Fatalf("Expected %s header to be false", INDEX_COMPLETE_HEADER)
+				}
+
+				if !tt.progress {
+					return
+				}
+
+				var progress_rsp struct {
+					IndexProgress *pip.IndexProgress `json:"index_progress"`
+				}
+
+				err = json.Unmarshal(rsp.Body.Bytes(), &progress_rsp)
+
+				if err != nil {
+					t.Fatalf("Failed to decode response, %v", err)
+				}
+
+				p := progress_rsp.IndexProgress
+
+				// Indexing is blocked before the last of the 7 records has been seen
+
+				if p == nil || p.Records != 6 || p.Expected != 7 || p.Ratio <= 0.85 || p.Ratio >= 0.86 {
+					t.Fatalf("Unexpected index progress, %+v", p)
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/whosonfirst/go-whosonfirst-spatial-pip"
+	"net/http"
+)
+
+// PROBLEM_JSON is the media type for RFC 7807 problem details.
+const PROBLEM_JSON string = "application/problem+json"
+
+// UNAUTHORIZED is the code for errors caused by a request that is missing valid credentials.
+const UNAUTHORIZED pip.ErrorCode = "unauthorized"
+
+// NOT_FOUND is the code for errors caused by a request for a record that does not exist.
+const NOT_FOUND pip.ErrorCode = "not_found"
+
+// METHOD_NOT_ALLOWED is the code for errors caused by a request using an unsupported HTTP method.
+const METHOD_NOT_ALLOWED pip.ErrorCode = "method_not_allowed"
+
+// NOT_ACCEPTABLE is the code for errors caused by a request that does not accept any supported media type.
+const NOT_ACCEPTABLE pip.ErrorCode = "not_acceptable"
+
+// REQUEST_TOO_LARGE is the code for errors caused by a request that exceeds a configured limit.
+const REQUEST_TOO_LARGE pip.ErrorCode = "request_too_large"
+
+// INTERNAL_ERROR is the code for any error that does not have a more specific code.
+const INTERNAL_ERROR pip.ErrorCode = "internal_error"
+
+// Problem is a RFC 7807 problem details object with an additional "code" member containing a stable, machine-readable
+// error code. The "type" member is always "about:blank" so clients should use "code" to distinguish between errors.
+type Problem struct {
+	Type     string        `json:"type"`
+	Title    string        `json:"title"`
+	Status   int           `json:"status"`
+	Detail   string        `json:"detail,omitempty"`
+	Instance string        `json:"instance,omitempty"`
+	Code     pip.ErrorCode `json:"code"`
+	// The list of invalid fields if the problem was caused by a request that failed validation.
+	Errors pip.ValidationErrors `json:"errors,omitempty"`
+}
+
+// StatusWithErrorCode returns the HTTP status code for 'code'.
+func StatusWithErrorCode(code pip.ErrorCode) int {
+
+	switch code {
+	case pip.INVALID_COORDINATE, pip.INVALID_PLACETYPE, pip.INVALID_EDTF, pip.INVALID_PARAMETER, pip.INVALID_REQUEST:
+		return http.StatusBadRequest
+	case UNAUTHORIZED:
+		return http.StatusUnauthorized
+	case NOT_FOUND:
+		return http.StatusNotFound
+	case METHOD_NOT_ALLOWED:
+		return http.StatusMethodNotAllowed
+	case NOT_ACCEPTABLE:
+		return http.StatusNotAcceptable
+	case REQUEST_TOO_LARGE:
+		return http.StatusRequestEntityTooLarge
+	case pip.INDEX_NOT_READY:
+		return http.StatusServiceUnavailable
+	case pip.UNSUPPORTED_QUERY:
+		return http.StatusNotImplemented
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// WriteError writes 'err' to 'rsp' as a problem details response. The error code is derived from 'err' if it wraps a
+// `pip.Error` instance, otherwise 'default_code' is used. If 'err' wraps a `pip.ValidationErrors` instance every invalid
+// field is listed in the response.
+func WriteError(rsp http.ResponseWriter, req *http.Request, err error, default_code pip.ErrorCode) {
+
+	problem := newProblem(req, errorCode(err, default_code), err.Error())
+
+	var validation_errs pip.ValidationErrors
+
+	if errors.As(err, &validation_errs) {
+		problem.Errors = validation_errs
+	}
+
+	writeProblem(rsp, problem)
+}
+
+// WriteProblem writes a problem details response for 'code', with the human-readable explanation 'detail', to 'rsp'.
+func WriteProblem(rsp http.ResponseWriter, req *http.Request, code pip.ErrorCode, detail string) {
+
+	problem := newProblem(req, code, detail)
+	writeProblem(rsp, problem)
+}
+
+func newProblem(req *http.Request, code pip.ErrorCode, detail string) *Problem {
+
+	status := StatusWithErrorCode(code)
+
+	problem := &Problem{
+		Type:     "about:blank",
+		Title:    http.StatusText(status),
+		Status:   status,
+		Detail:   detail,
+		Instance: req.URL.Path,
+		Code:     code,
+	}
+
+	return problem
+}
+
+func writeProblem(rsp http.ResponseWriter, problem *Problem) {
+
+	// Record the code so that metrics can report errors by code rather than status
+
+	status_rsp, ok := rsp.(*statusResponseWriter)
+
+	if ok {
+		status_rsp.code = string(problem.Code)
+	}
+
+	rsp.Header().Set("Content-Type", PROBLEM_JSON)
+	rsp.Header().Set("X-Content-Type-Options", "nosniff")
+	rsp.WriteHeader(problem.Status)
+
+	json.NewEncoder(rsp).Encode(problem)
+}
+
+// errorCode returns the code for 'err' if it wraps a `pip.Error` instance, otherwise 'default_code'.
+func errorCode(err error, default_code pip.ErrorCode) pip.ErrorCode {
+
+	code, ok := pip.ErrorCodeWithError(err)
+
+	if !ok {
+		return default_code
+	}
+
+	return code
+}
@@ -0,0 +1,150 @@
+package api
+
+import (
+	"fmt"
+	"github.com/whosonfirst/go-whosonfirst-spatial-pip"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestStatusWithErrorCode(t *testing.T) {
+
+	tests := []struct {
+		code   pip.ErrorCode
+		status int
+	}{
+		{code: pip.INVALID_COORDINATE, status: http.StatusBadRequest},
+		{code: pip.INVALID_PLACETYPE, status: http.StatusBadRequest},
+		{code: pip.INVALID_EDTF, status: http.StatusBadRequest},
+		{code: pip.INVALID_PARAMETER, status: http.StatusBadRequest},
+		{code: pip.INVALID_REQUEST, status: http.StatusBadRequest},
+		{code: UNAUTHORIZED, status: http.StatusUnauthorized},
+		{code: NOT_FOUND, status: http.StatusNotFound},
+		{code: METHOD_NOT_ALLOWED, status: http.StatusMethodNotAllowed},
+		{code: NOT_ACCEPTABLE, status: http.StatusNotAcceptable},
+		{code: REQUEST_TOO_LARGE, status: http.StatusRequestEntityTooLarge},
+		{code: pip.INDEX_NOT_READY, status: http.StatusServiceUnavailable},
+		{code: pip.UNSUPPORTED_QUERY, status: http.StatusNotImplemented},
+		{code: pip.BACKEND_ERROR, status: http.StatusInternalServerError},
+		{code: INTERNAL_ERROR, status: http.StatusInternalServerError},
+		{code: "unknown", status: http.StatusInternalServerError},
+	}
+
+	for _, tt := range tests {
+
+		t.Run(string(tt.code), func(t *testing.T) {
+
+			status := StatusWithErrorCode(tt.code)
+
+			if status != tt.status {
+				t.Fatalf("Expected %d, got %d", tt.status, status)
+			}
+		})
+	}
+}
+
+func TestWriteError(t *testing.T) {
+
+	validation_errs := pip.ValidationErrors{
+		{Field: "latitude", Code: pip.INVALID_COORDINATE, Message: "Latitude must be between -90 and 90"},
+		{Field: "placetypes", Code: pip.INVALID_PLACETYPE, Message: "Unknown placetype 'planet-x'"},
+	}
+
+	tests := []struct {
+		name         string
+		err          error
+		default_code pip.ErrorCode
+		status       int
+		code         pip.ErrorCode
+		errors       int
+	}{
+		{
+			name:         "plain error",
+			err:          fmt.Errorf("Something went wrong"),
+			default_code: INTERNAL_ERROR,
+			status:       http.StatusInternalServerError,
+			code:         INTERNAL_ERROR,
+		},
+		{
+			name:         "plain error with default code",
+			err:          fmt.Errorf("Failed to decode request"),
+			default_code: pip.INVALID_REQUEST,
+			status:       http.StatusBadRequest,
+			code:         pip.INVALID_REQUEST,
+		},
+		{
+			name:         "wrapped error",
+			err:          fmt.Errorf("Failed to query, %w", pip.NewError(pip.INVALID_PLACETYPE, fmt.Errorf("Unknown placetype"))),
+			default_code: INTERNAL_ERROR,
+			status:       http.StatusBadRequest,
+			code:         pip.INVALID_PLACETYPE,
+		},
+		{
+			name:         "backend error",
+			err:          pip.NewError(pip.BACKEND_ERROR, fmt.Errorf("Database is unavailable")),
+			default_code: pip.INVALID_REQUEST,
+			status:       http.StatusInternalServerError,
+			code:         pip.BACKEND_ERROR,
+		},
+		{
+			name:         "validation errors",
+			err:          pip.NewError(validation_errs.Code(), validation_errs),
+			default_code: INTERNAL_ERROR,
+			status:       http.StatusBadRequest,
+			code:         pip.INVALID_REQUEST,
+			errors:       2,
+		},
+	}
+
+	for _, tt := range tests {
+
+		t.Run(tt.name, func(t *testing.T) {
+
+			req := httptest.NewRequest(http.MethodGet, "/test?latitude=1", nil)
+			rsp := httptest.NewRecorder()
+
+			WriteError(rsp, req, tt.err, tt.default_code)
+
+			problem := decodeProblem(t, rsp, tt.status, tt.code)
+
+			if problem.Type != "about:blank" || problem.Title != http.StatusText(tt.status) {
+				t.Fatalf("Unexpected type or title, %+v", problem)
+			}
+
+			if problem.Detail != tt.err.Error() {
+				t.Fatalf("Expected detail '%s', got '%s'", tt.err.Error(), problem.Detail)
+			}
+
+			if problem.Instance != "/test" {
+				t.Fatalf("Expected instance /test, got %s", problem.Instance)
+			}
+
+			if len(problem.Errors) != tt.errors {
+				t.Fatalf("Expected %d field errors, got %d", tt.errors, len(problem.Errors))
+			}
+
+			if rsp.Header().Get("X-Content-Type-Options") != "nosniff" {
+				t.Fatalf("Expected nosniff header")
+			}
+		})
+	}
+}
+
+func TestWriteProblemMetrics(t *testing.T) {
+
+	// The problem code is recorded so that metrics can report errors by code
+
+	status_rsp := &statusResponseWriter{
+		ResponseWriter: httptest.NewRecorder(),
+		status:         http.StatusOK,
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+
+	WriteProblem(status_rsp, req, pip.INDEX_NOT_READY, "Indexing records")
+
+	if status_rsp.status != http.StatusServiceUnavailable || status_rsp.code != string(pip.INDEX_NOT_READY) {
+		t.Fatalf("Expected 503 %s, got %d %s", pip.INDEX_NOT_READY, status_rsp.status, status_rsp.code)
+	}
+}
@@ -0,0 +1,13 @@
+package api
+
+import (
+	"context"
+	"github.com/whosonfirst/go-whosonfirst-spatial-pip"
+	spatial_app "github.com/whosonfirst/go-whosonfirst-spatial/app"
+	"github.com/whosonfirst/go-whosonfirst-spr/v2"
+)
+
+// propertiesResponse returns a properties response for 'keys' derived from 'results' using the application's properties reader.
+func propertiesResponse(ctx context.Context, app *spatial_app.SpatialApplication, keys []string, results spr.StandardPlacesResults) (*pip.PropertiesResponseResults, error) {
+	return pip.PropertiesResponseResultsWithStandardPlacesResults(ctx, app.PropertiesReader, keys, results)
+}
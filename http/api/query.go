@@ -0,0 +1,127 @@
+package api
+
+import (
+	"fmt"
+	"github.com/whosonfirst/go-sanitize"
+	"github.com/whosonfirst/go-whosonfirst-spatial-pip"
+	"net/http"
+	"net/url"
+)
+
+// sanitize_opts are the options used to sanitize query parameters, the same options used by go-http-sanitize.
+var sanitize_opts = sanitize.DefaultOptions()
+
+// sanitizedQuery returns a copy of the query parameters in 'req' with each value sanitized. Empty values are omitted.
+func sanitizedQuery(req *http.Request) (url.Values, error) {
+
+	raw_q := req.URL.Query()
+	q := url.Values{}
+
+	for k, raw_values := range raw_q {
+
+		for _, raw_v := range raw_values {
+
+			v, err := sanitize.SanitizeString(raw_v, sanitize_opts)
+
+			if err != nil {
+				return nil, pip.NewError(pip.INVALID_REQUEST, fmt.Errorf("Failed to sanitize %s parameter, %w", k, err))
+			}
+
+			if v == "" {
+				continue
+			}
+
+			q.Add(k, v)
+		}
+	}
+
+	return q, nil
+}
+
+// pointInPolygonRequestWithHTTPRequest returns a new, validated, pip.PointInPolygonRequest derived from the query
+// parameters of a GET request or the JSON-encoded body of any other request. If 'strict' is true JSON-encoded
+// requests containing unknown fields are rejected.
+func pointInPolygonRequestWithHTTPRequest(req *http.Request, strict bool) (*pip.PointInPolygonRequest, error) {
+
+	var pip_req *pip.PointInPolygonRequest
+
+	if req.Method == "GET" {
+
+		q, err := sanitizedQuery(req)
+
+		if err != nil {
+			return nil, err
+		}
+
+		pip_req, err = pip.NewPointInPolygonRequestFromQuery(q)
+
+		if err != nil {
+			return nil, err
+		}
+
+	} else {
+
+		dec := pip.NewRequestDecoder(req.Body, strict)
+		err := dec.Decode(&pip_req)
+
+		if err != nil {
+			return nil, pip.NewError(pip.INVALID_REQUEST, fmt.Errorf("Failed to decode request, %w", err))
+		}
+
+		if pip_req == nil {
+			return nil, pip.NewError(pip.INVALID_REQUEST, fmt.Errorf("Empty request"))
+		}
+	}
+
+	err := pip_req.Validate()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return pip_req, nil
+}
+
+// intersectsRequestWithHTTPRequest returns a new, validated, pip.IntersectsRequest derived from the query parameters
+// of a GET request or the JSON-encoded body of any other request. If 'strict' is true JSON-encoded requests containing
+// unknown fields are rejected.
+func intersectsRequestWithHTTPRequest(req *http.Request, strict bool) (*pip.IntersectsRequest, error) {
+
+	var intersects_req *pip.IntersectsRequest
+
+	if req.Method == "GET" {
+
+		q, err := sanitizedQuery(req)
+
+		if err != nil {
+			return nil, err
+		}
+
+		intersects_req, err = pip.NewIntersectsRequestFromQuery(q)
+
+		if err != nil {
+			return nil, err
+		}
+
+	} else {
+
+		dec := pip.NewRequestDecoder(req.Body, strict)
+		err := dec.Decode(&intersects_req)
+
+		if err != nil {
+			return nil, pip.NewError(pip.INVALID_REQUEST, fmt.Errorf("Failed to decode request, %w", err))
+		}
+
+		if intersects_req == nil {
+			return nil, pip.NewError(pip.INVALID_REQUEST, fmt.Errorf("Empty request"))
+		}
+	}
+
+	err := intersects_req.Validate()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return intersects_req, nil
+}
@@ -0,0 +1,80 @@
+package api
+
+import (
+	"github.com/whosonfirst/go-whosonfirst-spatial-pip"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+func TestSanitizedQuery(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		query    string
+		expected url.Values
+		code     pip.ErrorCode
+	}{
+		{
+			name:     "repeated",
+			query:    "placetype=locality&placetype=region&latitude=1",
+			expected: url.Values{"placetype": {"locality", "region"}, "latitude": {"1"}},
+		},
+		{
+			name:     "empty",
+			query:    "property=&placetype=locality&property=wof:name",
+			expected: url.Values{"placetype": {"locality"}, "property": {"wof:name"}},
+		},
+		{
+			name:     "newline",
+			query:    "inception_date=2000%0A",
+			expected: url.Values{"inception_date": {"2000 "}},
+		},
+		{
+			name:  "invalid utf8",
+			query: "placetype=%ff",
+			code:  pip.INVALID_REQUEST,
+		},
+		{
+			name:  "repeated invalid utf8",
+			query: "placetype=locality&placetype=%ff",
+			code:  pip.INVALID_REQUEST,
+		},
+		{
+			name:     "repeated newline",
+			query:    "placetype=locality&placetype=region%0A",
+			expected: url.Values{"placetype": {"locality", "region "}},
+		},
+	}
+
+	for _, tt := range tests {
+
+		t.Run(tt.name, func(t *testing.T) {
+
+			req := httptest.NewRequest(http.MethodGet, "/?"+tt.query, nil)
+
+			q, err := sanitizedQuery(req)
+
+			if tt.code != "" {
+
+				code, ok := pip.ErrorCodeWithError(err)
+
+				if !ok || code != tt.code {
+					t.Fatalf("Expected %s error, got %v", tt.code, err)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Failed to sanitize query, %v", err)
+			}
+
+			if !reflect.DeepEqual(q, tt.expected) {
+				t.Fatalf("Expected %v, got %v", tt.expected, q)
+			}
+		})
+	}
+}
@@ -0,0 +1,250 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/whosonfirst/go-whosonfirst-spatial-pip"
+	spatial_app "github.com/whosonfirst/go-whosonfirst-spatial/app"
+	"github.com/whosonfirst/go-whosonfirst-spr/v2"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+)
+
+const NDJSON string = "application/x-ndjson"
+
+type StreamPointInPolygonHandlerOptions struct {
+	// The maximum number of point-in-polygon queries to perform concurrently.
+	MaxWorkers int
+	Logger     *log.Logger
+	// An optional Metrics instance used to record stage timings, result counts and errors.
+	Metrics *Metrics
+	// If true reject JSON-encoded requests containing unknown fields.
+	StrictDecoding bool
+	IndexingOptions
+}
+
+// StreamPointInPolygonResponse is a single line in the response body returned by the streaming point-in-polygon handler.
+// Results will be either a SPR or a properties response depending on whether the request specified any properties.
+type StreamPointInPolygonResponse struct {
+	Id      string        `json:"id,omitempty"`
+	Results interface{}   `json:"results,omitempty"`
+	Error   string        `json:"error,omitempty"`
+	Code    pip.ErrorCode `json:"code,omitempty"`
+}
+
+// StreamPointInPolygonHandler returns a http.Handler that accepts a POST-ed body containing a newline-delimited stream of
+// JSON-encoded `pip.PointInPolygonRequest` instances and writes a newline-delimited stream of JSON-encoded
+// `StreamPointInPolygonResponse` instances, flushed as each query completes. Because queries are performed concurrently
+// responses are not guaranteed to be in the same order as their requests; clients should use the optional `id` property
+// to correlate them.
+func StreamPointInPolygonHandler(app *spatial_app.SpatialApplication, opts *StreamPointInPolygonHandlerOptions) (http.Handler, error) {
+
+	max_workers := opts.MaxWorkers
+
+	if max_workers < 1 {
+		max_workers = pip.DEFAULT_BATCH_WORKERS
+	}
+
+	fn := func(rsp http.ResponseWriter, req *http.Request) {
+
+		ctx := req.Context()
+
+		if req.Method != "POST" {
+			WriteProblem(rsp, req, METHOD_NOT_ALLOWED, "Unsupported method")
+			return
+		}
+
+		indexing, ok := checkIndexing(rsp, req, app, opts.IndexingOptions)
+
+		if !ok {
+			return
+		}
+
+		// Allow the request body to continue to be read after we start writing responses.
+		// Not all http.ResponseWriter implementations support this so errors are ignored.
+
+		rc := http.NewResponseController(rsp)
+		rc.EnableFullDuplex()
+
+		indexing.markIncomplete(rsp.Header())
+
+		rsp.Header().Set("Content-Type", NDJSON)
+		rsp.WriteHeader(http.StatusOK)
+
+		mu := new(sync.Mutex)
+		enc := json.NewEncoder(rsp)
+
+		write := func(line *StreamPointInPolygonResponse) {
+
+			mu.Lock()
+			defer mu.Unlock()
+
+			err := enc.Encode(line)
+
+			if err != nil {
+				opts.Logger.Printf("Failed to encode stream response, %v", err)
+				return
+			}
+
+			rc.Flush()
+		}
+
+		throttle := make(chan bool, max_workers)
+		wg := new(sync.WaitGroup)
+
+		dec := pip.NewRequestDecoder(req.Body, opts.StrictDecoding)
+
+		for {
+
+			var pip_req *pip.PointInPolygonRequest
+			err := dec.Decode(&pip_req)
+
+			if errors.Is(err, io.EOF) {
+				break
+			}
+
+			if err != nil {
+
+				// It is not possible to resynchronize the decoder after a
+				// syntax error so report it and stop reading
+
+				opts.Metrics.ObserveError(METRICS_STREAM, string(pip.INVALID_REQUEST))
+
+				write(&StreamPointInPolygonResponse{
+					Error: fmt.Sprintf("Failed to decode request, %v", err),
+					Code:  pip.INVALID_REQUEST,
+				})
+
+				break
+			}
+
+			if pip_req == nil {
+				continue
+			}
+
+			select {
+			case <-ctx.Done():
+				// pass, handled below
+			case throttle <- true:
+				// pass
+			}
+
+			if ctx.Err() != nil {
+				break
+			}
+
+			wg.Add(1)
+
+			go func(pip_req *pip.PointInPolygonRequest) {
+
+				defer func() {
+					<-throttle
+					wg.Done()
+				}()
+
+				line := &StreamPointInPolygonResponse{
+					Id: pip_req.Id,
+				}
+
+				// Each line uses its own request application so that timings are not accumulated by 'app' or
+				// mixed with those of the other lines being queried concurrently
+
+				line_app, _ := newRequestApplication(app, opts.Metrics)
+
+				results, err := streamResults(ctx, line_app, pip_req)
+
+				if err != nil {
+					line.Error = err.Error()
+					line.Code = errorCode(err, INTERNAL_ERROR)
+					opts.Metrics.ObserveError(METRICS_STREAM, string(line.Code))
+				} else {
+					line.Results = results
+				}
+
+				write(line)
+
+			}(pip_req)
+		}
+
+		wg.Wait()
+		return
+	}
+
+	stream_handler := http.HandlerFunc(fn)
+	return stream_handler, nil
+}
+
+func streamResults(ctx context.Context, app *spatial_app.SpatialApplication, pip_req *pip.PointInPolygonRequest) (interface{}, error) {
+
+	err := pip_req.Validate()
+
+	if err != nil {
+		return nil, err
+	}
+
+	rsp_ch := make(chan spr.StandardPlacesResult)
+	err_ch := make(chan error)
+	done_ch := make(chan bool)
+
+	go pip.QueryPointInPolygonWithChannels(ctx, app, pip_req, rsp_ch, err_ch, done_ch)
+
+	places := make([]spr.StandardPlacesResult, 0)
+	var query_err error
+
+	working := true
+
+	// Keep reading until the query signals that it is done so that
+	// no producer is left blocked on a channel write
+
+	for working {
+		select {
+		case <-done_ch:
+			working = false
+		case r := <-rsp_ch:
+			places = append(places, r)
+		case err := <-err_ch:
+			if query_err == nil {
+				query_err = err
+			}
+		}
+	}
+
+	if query_err != nil {
+		return nil, query_err
+	}
+
+	var results spr.StandardPlacesResults = pip.NewPointInPolygonResults(places)
+
+	if len(places) == 0 && pip_req.FallbackMaxDistance > 0 {
+
+		nearest, err := pip.NearestPointInPolygonResults(ctx, app, pip_req)
+
+		if err != nil {
+			return nil, err
+		}
+
+		results = nearest
+	}
+
+	results, err = pip.SortPointInPolygonResults(ctx, pip_req, results)
+
+	if err != nil {
+		return nil, err
+	}
+
+	results, err = pip.PaginatePointInPolygonResults(pip_req, results)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(pip_req.Properties) == 0 {
+		return results, nil
+	}
+
+	return propertiesResponse(ctx, app, pip_req.Properties, results)
+}
@@ -0,0 +1,248 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/paulmach/orb"
+	"github.com/whosonfirst/go-whosonfirst-spatial"
+	"github.com/whosonfirst/go-whosonfirst-spatial-pip"
+	"github.com/whosonfirst/go-whosonfirst-spatial/database"
+	"github.com/whosonfirst/go-whosonfirst-spr/v2"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestStreamPointInPolygonHandler(t *testing.T) {
+
+	app := newTestApplication(t, nil)
+
+	opts := &StreamPointInPolygonHandlerOptions{
+		MaxWorkers: 2,
+		Logger:     log.New(io.Discard, "", 0),
+	}
+
+	handler, err := StreamPointInPolygonHandler(app, opts)
+
+	if err != nil {
+		t.Fatalf("Failed to create handler, %v", err)
+	}
+
+	type expectedLine struct {
+		ids  []string
+		code pip.ErrorCode
+	}
+
+	tests := []struct {
+		name     string
+		body     string
+		expected map[string]*expectedLine
+	}{
+		{
+			name: "requests",
+			body: strings.Join([]string{
+				`{"id":"a","latitude":0.25,"longitude":0.25,"placetypes":["neighbourhood"]}`,
+				``,
+				`{"id":"b","latitude":5.5,"longitude":5.5}`,
+				`{"id":"c","latitude":95,"longitude":5.5}`,
+				`{"id":"d","latitude":-50,"longitude":-50}`,
+				`{"id":"e","latitude":0.75,"longitude":0.75,"placetypes":["locality"],"properties":["wof:country"]}`,
+			}, "\n"),
+			expected: map[string]*expectedLine{
+				"a": {ids: []string{"102000001"}},
+				"b": {ids: []string{"101000003"}},
+				"c": {code: pip.INVALID_COORDINATE},
+				"d": {ids: []string{}},
+				"e": {ids: []string{"101000001", "101000002"}},
+			},
+		},
+		{
+			name: "malformed",
+			body: strings.Join([]string{
+				`{"id":"a","latitude":5.5,"longitude":5.5}`,
+				`{"id":"b","latitude":`,
+				`{"id":"c","latitude":5.5,"longitude":5.5}`,
+			}, "\n"),
+			expected: map[string]*expectedLine{
+				"a": {ids: []string{"101000003"}},
+				"":  {code: pip.INVALID_REQUEST},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+
+		t.Run(tt.name, func(t *testing.T) {
+
+			req := httptest.NewRequest(http.MethodPost, "/stream", strings.NewReader(tt.body))
+			rsp := httptest.NewRecorder()
+
+			handler.ServeHTTP(rsp, req)
+
+			if rsp.Code != http.StatusOK {
+				t.Fatalf("Expected status 200, got %d", rsp.Code)
+			}
+
+			if rsp.Header().Get("Content-Type") != NDJSON {
+				t.Fatalf("Expected %s response, got %s", NDJSON, rsp.Header().Get("Content-Type"))
+			}
+
+			seen := make(map[string]bool)
+
+			scanner := bufio.NewScanner(rsp.Body)
+
+			for scanner.Scan() {
+
+				var line struct {
+					Id      string          `json:"id"`
+					Results json.RawMessage `json:"results"`
+					Code    pip.ErrorCode   `json:"code"`
+				}
+
+				err := json.Unmarshal(scanner.Bytes(), &line)
+
+				if err != nil {
+					t.Fatalf("Failed to decode line, %v", err)
+				}
+
+				expected, ok := tt.expected[line.Id]
+
+				if !ok {
+					t.Fatalf("Unexpected line for '%s'", line.Id)
+				}
+
+				if seen[line.Id] {
+					t.Fatalf("Duplicate line for '%s'", line.Id)
+				}
+
+				seen[line.Id] = true
+
+				if line.Code != expected.code {
+					t.Fatalf("Expected code '%s' for '%s', got '%s'", expected.code, line.Id, line.Code)
+				}
+
+				if expected.code != "" {
+					continue
+				}
+
+				ids := placeIds(t, line.Results)
+
+				if !slices.Equal(ids, expected.ids) {
+					t.Fatalf("Expected %v for '%s', got %v", expected.ids, line.Id, ids)
+				}
+			}
+
+			if len(seen) != len(tt.expected) {
+				t.Fatalf("Expected %d lines, got %d", len(tt.expected), len(seen))
+			}
+		})
+	}
+}
+
+func TestStreamPointInPolygonHandlerMethod(t *testing.T) {
+
+	app := newTestApplication(t, nil)
+
+	opts := &StreamPointInPolygonHandlerOptions{
+		Logger: log.New(io.Discard, "", 0),
+	}
+
+	handler, err := StreamPointInPolygonHandler(app, opts)
+
+	if err != nil {
+		t.Fatalf("Failed to create handler, %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/stream", nil)
+	rsp := httptest.NewRecorder()
+
+	handler.ServeHTTP(rsp, req)
+
+	decodeProblem(t, rsp, http.StatusMethodNotAllowed, METHOD_NOT_ALLOWED)
+}
+
+// barrierDatabase is a database.SpatialDatabase instance whose nearest queries wait until 'wg' is done and then
+// return no results.
+type barrierDatabase struct {
+	database.SpatialDatabase
+	wg *sync.WaitGroup
+}
+
+func (db *barrierDatabase) Nearest(ctx context.Context, c *orb.Point, distance float64, filters ...spatial.Filter) (spr.StandardPlacesResults, error) {
+
+	db.wg.Done()
+	db.wg.Wait()
+
+	return pip.NewPointInPolygonResults(nil), nil
+}
+
+func TestStreamPointInPolygonHandlerTimings(t *testing.T) {
+
+	app := newTestApplication(t, nil)
+
+	count := 4
+
+	// Every line falls back to a nearest query and none of them are performed until all the lines
+	// are being queried, so if the lines shared a monitor the stage would only be recorded once
+
+	wg := new(sync.WaitGroup)
+	wg.Add(count)
+
+	barrier_app := *app
+	barrier_app.SpatialDatabase = &barrierDatabase{SpatialDatabase: app.SpatialDatabase, wg: wg}
+
+	m := NewMetrics()
+
+	opts := &StreamPointInPolygonHandlerOptions{
+		MaxWorkers: count,
+		Logger:     log.New(io.Discard, "", 0),
+		Metrics:    m,
+	}
+
+	handler, err := StreamPointInPolygonHandler(&barrier_app, opts)
+
+	if err != nil {
+		t.Fatalf("Failed to create handler, %v", err)
+	}
+
+	lines := make([]string, count)
+
+	for idx := range lines {
+		lines[idx] = fmt.Sprintf(`{"id":"%d","latitude":7,"longitude":7,"fallback_max_distance":500000}`, idx)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/stream", strings.NewReader(strings.Join(lines, "\n")))
+	rsp := httptest.NewRecorder()
+
+	handler.ServeHTTP(rsp, req)
+
+	if rsp.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", rsp.Code)
+	}
+
+	if strings.Contains(rsp.Body.String(), `"error"`) {
+		t.Fatalf("Unexpected error, %s", rsp.Body.String())
+	}
+
+	label := "PIP query nearest"
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	h, ok := m.stages[label]
+
+	if !ok {
+		t.Fatalf("Expected timings for '%s'", label)
+	}
+
+	if h.count != int64(count) {
+		t.Fatalf("Expected %d timings for '%s', got %d", count, label, h.count)
+	}
+}
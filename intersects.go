@@ -0,0 +1,193 @@
+package pip
+
+import (
+	"context"
+	"fmt"
+	"github.com/paulmach/orb"
+	"github.com/sfomuseum/go-timings"
+	spatial_app "github.com/whosonfirst/go-whosonfirst-spatial/app"
+	"github.com/whosonfirst/go-whosonfirst-spatial/geo"
+	"github.com/whosonfirst/go-whosonfirst-spr/v2"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+const timingsIntersectsQuery string = "Intersects query"
+
+const timingsIntersectsQueryIntersects string = "Intersects query intersects"
+
+// IntersectsRequest is a request for the records that intersect a bounding box or that are within a radius of a point.
+// Filtering, sorting and pagination criteria are defined by the embedded PointInPolygonRequest whose latitude and
+// longitude are used as the center of radius queries.
+type IntersectsRequest struct {
+	PointInPolygonRequest
+	// A bounding box defined as [ min_longitude, min_latitude, max_longitude, max_latitude ].
+	BoundingBox []float64 `json:"bbox,omitempty"`
+	// A radius, in metres, around the request's latitude and longitude.
+	Radius float64 `json:"radius,omitempty"`
+}
+
+// NewIntersectsRequestFromQuery returns a new IntersectsRequest derived from 'q'. Bounding boxes are defined using a
+// comma-separated "bbox" parameter (min_longitude,min_latitude,max_longitude,max_latitude) and point-radius queries
+// are defined using "latitude", "longitude" and "radius" (in metres) parameters. All other parameters are the same as
+// those used by NewPointInPolygonRequestFromQuery.
+func NewIntersectsRequestFromQuery(q url.Values) (*IntersectsRequest, error) {
+
+	req := &IntersectsRequest{}
+
+	str_bbox := q.Get("bbox")
+
+	if str_bbox != "" {
+
+		parts := strings.Split(str_bbox, ",")
+
+		if len(parts) != 4 {
+			return nil, NewError(INVALID_PARAMETER, fmt.Errorf("Invalid bbox parameter, expected min_longitude,min_latitude,max_longitude,max_latitude"))
+		}
+
+		bbox := make([]float64, 4)
+
+		for idx, str_v := range parts {
+
+			v, err := strconv.ParseFloat(strings.TrimSpace(str_v), 64)
+
+			if err != nil {
+				return nil, NewError(INVALID_PARAMETER, fmt.Errorf("Invalid bbox parameter, %w", err))
+			}
+
+			bbox[idx] = v
+		}
+
+		req.BoundingBox = bbox
+
+	} else {
+
+		latitude, err := strconv.ParseFloat(q.Get("latitude"), 64)
+
+		if err != nil {
+			return nil, NewError(INVALID_COORDINATE, fmt.Errorf("Invalid latitude parameter, %w", err))
+		}
+
+		longitude, err := strconv.ParseFloat(q.Get("longitude"), 64)
+
+		if err != nil {
+			return nil, NewError(INVALID_COORDINATE, fmt.Errorf("Invalid longitude parameter, %w", err))
+		}
+
+		radius, err := strconv.ParseFloat(q.Get("radius"), 64)
+
+		if err != nil {
+			return nil, NewError(INVALID_PARAMETER, fmt.Errorf("Invalid radius parameter, %w", err))
+		}
+
+		req.Latitude = latitude
+		req.Longitude = longitude
+		req.Radius = radius
+	}
+
+	err := appendQueryCriteria(&req.PointInPolygonRequest, q)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return req, nil
+}
+
+// Bound returns the bounding box defined by 'req' or an error if it does not define a valid bounding box.
+func (req *IntersectsRequest) Bound() (*orb.Bound, error) {
+
+	if len(req.BoundingBox) != 4 {
+		return nil, NewError(INVALID_PARAMETER, fmt.Errorf("Invalid bounding box, expected 4 values but got %d", len(req.BoundingBox)))
+	}
+
+	min_x := req.BoundingBox[0]
+	min_y := req.BoundingBox[1]
+	max_x := req.BoundingBox[2]
+	max_y := req.BoundingBox[3]
+
+	if min_y > max_y {
+		return nil, NewError(INVALID_PARAMETER, fmt.Errorf("Invalid bounding box, min latitude is greater than max latitude"))
+	}
+
+	return geo.NewBoundingBox(min_x, min_y, max_x, max_y)
+}
+
+// QueryIntersects returns the records that intersect the bounding box, or are within the radius of the point, defined
+// by 'req'. Results are filtered, sorted and paginated in the same way as QueryPointInPolygon. The application's
+// spatial database must implement the IntersectsIndex interface.
+func QueryIntersects(ctx context.Context, app *spatial_app.SpatialApplication, req *IntersectsRequest) (spr.StandardPlacesResults, error) {
+
+	app.Monitor.Signal(ctx, timings.SinceStart, timingsIntersectsQuery)
+	defer app.Monitor.Signal(ctx, timings.SinceStop, timingsIntersectsQuery)
+
+	idx, ok := app.SpatialDatabase.(IntersectsIndex)
+
+	if !ok {
+		return nil, NewError(UNSUPPORTED_QUERY, fmt.Errorf("Spatial database does not support intersects queries"))
+	}
+
+	f, err := NewSPRFilterFromPointInPolygonRequest(&req.PointInPolygonRequest)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create intersects filter from request, %w", err)
+	}
+
+	var rsp spr.StandardPlacesResults
+
+	app.Monitor.Signal(ctx, timings.SinceStart, timingsIntersectsQueryIntersects)
+
+	if len(req.BoundingBox) > 0 {
+
+		bounds, err := req.Bound()
+
+		if err != nil {
+			return nil, err
+		}
+
+		rsp, err = idx.Intersects(ctx, *bounds, f)
+
+		if err != nil {
+			return nil, backendError("Failed to perform intersects query", err)
+		}
+
+	} else {
+
+		if req.Radius <= 0 {
+			return nil, NewError(INVALID_PARAMETER, fmt.Errorf("Invalid radius, must be greater than 0"))
+		}
+
+		c, err := geo.NewCoordinate(req.Longitude, req.Latitude)
+
+		if err != nil {
+			return nil, NewError(INVALID_COORDINATE, fmt.Errorf("Failed to create new coordinate, %w", err))
+		}
+
+		rsp, err = idx.WithinDistance(ctx, c, req.Radius, f)
+
+		if err != nil {
+			return nil, backendError("Failed to perform radius query", err)
+		}
+	}
+
+	app.Monitor.Signal(ctx, timings.SinceStop, timingsIntersectsQueryIntersects)
+
+	if rsp == nil {
+		return nil, noResultsError(ctx)
+	}
+
+	rsp, err = SortPointInPolygonResults(ctx, &req.PointInPolygonRequest, rsp)
+
+	if err != nil {
+		return nil, err
+	}
+
+	rsp, err = PaginatePointInPolygonResults(&req.PointInPolygonRequest, rsp)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to paginate results, %w", err)
+	}
+
+	return rsp, nil
+}
@@ -0,0 +1,260 @@
+package pip
+
+import (
+	"context"
+	"net/url"
+	"reflect"
+	"slices"
+	"testing"
+)
+
+func TestNewIntersectsRequestFromQuery(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		query    string
+		expected *IntersectsRequest
+		code     ErrorCode
+	}{
+		{
+			name:     "bbox",
+			query:    "bbox=-1,-2,3,4",
+			expected: &IntersectsRequest{BoundingBox: []float64{-1, -2, 3, 4}},
+		},
+		{
+			name:  "bbox with criteria",
+			query: "bbox=0,0,1,1&placetype=locality&is_current=1&per_page=5",
+			expected: &IntersectsRequest{
+				PointInPolygonRequest: PointInPolygonRequest{
+					Placetypes: []string{"locality"},
+					IsCurrent:  []int64{1},
+					PerPage:    5,
+				},
+				BoundingBox: []float64{0, 0, 1, 1},
+			},
+		},
+		{
+			name:  "radius",
+			query: "latitude=1&longitude=2&radius=500",
+			expected: &IntersectsRequest{
+				PointInPolygonRequest: PointInPolygonRequest{Latitude: 1, Longitude: 2},
+				Radius:                500,
+			},
+		},
+		{
+			name:  "bbox too short",
+			query: "bbox=0,0,1",
+			code:  INVALID_PARAMETER,
+		},
+		{
+			name:  "bbox not a number",
+			query: "bbox=0,0,1,north",
+			code:  INVALID_PARAMETER,
+		},
+		{
+			name:  "missing latitude",
+			query: "longitude=2&radius=500",
+			code:  INVALID_COORDINATE,
+		},
+		{
+			name:  "missing radius",
+			query: "latitude=1&longitude=2",
+			code:  INVALID_PARAMETER,
+		},
+	}
+
+	for _, tt := range tests {
+
+		t.Run(tt.name, func(t *testing.T) {
+
+			q, err := url.ParseQuery(tt.query)
+
+			if err != nil {
+				t.Fatalf("Failed to parse query, %v", err)
+			}
+
+			req, err := NewIntersectsRequestFromQuery(q)
+
+			if tt.code != "" {
+
+				code, _ := ErrorCodeWithError(err)
+
+				if code != tt.code {
+					t.Fatalf("Expected %s, got %v", tt.code, err)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Failed to create request, %v", err)
+			}
+
+			if !reflect.DeepEqual(req, tt.expected) {
+				t.Fatalf("Expected %+v, got %+v", tt.expected, req)
+			}
+		})
+	}
+}
+
+func TestIntersectsRequestBound(t *testing.T) {
+
+	tests := []struct {
+		name string
+		bbox []float64
+		ok   bool
+	}{
+		{name: "valid", bbox: []float64{-1, -2, 3, 4}, ok: true},
+		{name: "point", bbox: []float64{1, 1, 1, 1}, ok: true},
+		{name: "empty", bbox: []float64{}},
+		{name: "too long", bbox: []float64{0, 0, 1, 1, 2}},
+		{name: "inverted latitudes", bbox: []float64{0, 4, 1, 2}},
+	}
+
+	for _, tt := range tests {
+
+		t.Run(tt.name, func(t *testing.T) {
+
+			req := &IntersectsRequest{
+				BoundingBox: tt.bbox,
+			}
+
+			bounds, err := req.Bound()
+
+			if !tt.ok {
+
+				code, _ := ErrorCodeWithError(err)
+
+				if code != INVALID_PARAMETER {
+					t.Fatalf("Expected %s, got %v", INVALID_PARAMETER, err)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Failed to derive bounds, %v", err)
+			}
+
+			if bounds.Min.X() != tt.bbox[0] || bounds.Min.Y() != tt.bbox[1] || bounds.Max.X() != tt.bbox[2] || bounds.Max.Y() != tt.bbox[3] {
+				t.Fatalf("Expected bounds %v, got %v", tt.bbox, bounds)
+			}
+		})
+	}
+}
+
+func TestQueryIntersects(t *testing.T) {
+
+	ctx := context.Background()
+
+	app := newTestApplication(t, &SpatialDatabaseOptions{IndexGeometries: true})
+
+	tests := []struct {
+		name     string
+		req      *IntersectsRequest
+		expected []string
+		code     ErrorCode
+	}{
+		{
+			name:     "bbox",
+			req:      &IntersectsRequest{BoundingBox: []float64{0.1, 0.1, 0.2, 0.2}},
+			expected: []string{"101000001", "101000002", "102000001", "85000001", "85000002"},
+		},
+		{
+			name: "bbox with filters",
+			req: &IntersectsRequest{
+				PointInPolygonRequest: PointInPolygonRequest{IsCurrent: []int64{1}, Placetypes: []string{"locality"}},
+				BoundingBox:           []float64{0.1, 0.1, 0.2, 0.2},
+			},
+			expected: []string{"101000001"},
+		},
+		{
+			name:     "bbox spanning records",
+			req:      &IntersectsRequest{BoundingBox: []float64{2.5, 2.5, 5.5, 5.5}},
+			expected: []string{"101000003", "85000001"},
+		},
+		{
+			name:     "bbox between records",
+			req:      &IntersectsRequest{BoundingBox: []float64{3.5, 3.5, 4.5, 4.5}},
+			expected: []string{},
+		},
+		{
+			name: "radius",
+			req: &IntersectsRequest{
+				PointInPolygonRequest: PointInPolygonRequest{Latitude: 3.5, Longitude: 3.5},
+				Radius:                100000,
+			},
+			expected: []string{"85000001"},
+		},
+		{
+			name: "larger radius",
+			req: &IntersectsRequest{
+				PointInPolygonRequest: PointInPolygonRequest{Latitude: 4, Longitude: 4},
+				Radius:                200000,
+			},
+			expected: []string{"101000003", "85000001"},
+		},
+		{
+			name: "invalid radius",
+			req: &IntersectsRequest{
+				PointInPolygonRequest: PointInPolygonRequest{Latitude: 4, Longitude: 4},
+			},
+			code: INVALID_PARAMETER,
+		},
+		{
+			name: "invalid bbox",
+			req:  &IntersectsRequest{BoundingBox: []float64{0, 4, 1, 2}},
+			code: INVALID_PARAMETER,
+		},
+	}
+
+	for _, tt := range tests {
+
+		t.Run(tt.name, func(t *testing.T) {
+
+			rsp, err := QueryIntersects(ctx, app, tt.req)
+
+			if tt.code != "" {
+
+				code, _ := ErrorCodeWithError(err)
+
+				if code != tt.code {
+					t.Fatalf("Expected %s, got %v", tt.code, err)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Failed to query intersects, %v", err)
+			}
+
+			ids := resultIds(rsp)
+
+			if !slices.Equal(ids, tt.expected) {
+				t.Fatalf("Expected %v, got %v", tt.expected, ids)
+			}
+		})
+	}
+}
+
+func TestQueryIntersectsUnsupported(t *testing.T) {
+
+	ctx := context.Background()
+
+	// Intersects queries require the secondary index
+
+	app := newTestApplication(t, nil)
+
+	req := &IntersectsRequest{
+		BoundingBox: []float64{0.1, 0.1, 0.2, 0.2},
+	}
+
+	_, err := QueryIntersects(ctx, app, req)
+
+	code, _ := ErrorCodeWithError(err)
+
+	if code != UNSUPPORTED_QUERY {
+		t.Fatalf("Expected %s, got %v", UNSUPPORTED_QUERY, err)
+	}
+}
@@ -0,0 +1,102 @@
+package pip
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/sfomuseum/go-timings"
+	spatial_app "github.com/whosonfirst/go-whosonfirst-spatial/app"
+	"github.com/whosonfirst/go-whosonfirst-spatial/geo"
+	"github.com/whosonfirst/go-whosonfirst-spr/v2"
+)
+
+const timingsPIPQueryNearest string = "PIP query nearest"
+
+// NEAREST_MATCH is the match type assigned to results returned by a nearest-polygon fallback query.
+const NEAREST_MATCH string = "nearest"
+
+const FallbackMaxDistanceFlag string = "fallback-max-distance"
+
+// NearestPlacesResult is a standard places result for a record that does not contain a coordinate but is near it.
+// When encoded as JSON the "distance" and "match_type" properties are appended to the standard places result.
+type NearestPlacesResult struct {
+	spr.StandardPlacesResult
+	// The distance, in metres, between the coordinate and the nearest boundary of the record.
+	Distance float64
+	// The type of match, which is always NEAREST_MATCH.
+	MatchType string
+}
+
+// MarshalJSON encodes 'r' as its underlying standard places result with additional "distance" and "match_type" properties.
+func (r *NearestPlacesResult) MarshalJSON() ([]byte, error) {
+
+	enc_spr, err := json.Marshal(r.StandardPlacesResult)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var props map[string]interface{}
+
+	err = json.Unmarshal(enc_spr, &props)
+
+	if err != nil {
+		return nil, err
+	}
+
+	props["distance"] = r.Distance
+	props["match_type"] = r.MatchType
+
+	return json.Marshal(props)
+}
+
+// AppendFallbackFlags appends flags for configuring nearest-polygon fallback queries to 'fs'.
+func AppendFallbackFlags(fs *flag.FlagSet) error {
+
+	fs.Float64(FallbackMaxDistanceFlag, 0.0, "If greater than 0 and no polygons contain the coordinate then return the polygons whose boundaries are within this distance, in metres, of the coordinate instead.")
+	return nil
+}
+
+// NearestPointInPolygonResults returns the records matching the criteria in 'req' whose boundaries are within
+// req.FallbackMaxDistance metres of its coordinate, ordered by distance. Each result is a NearestPlacesResult
+// instance. The application's spatial database must implement the NearestIndex interface.
+func NearestPointInPolygonResults(ctx context.Context, app *spatial_app.SpatialApplication, req *PointInPolygonRequest) (spr.StandardPlacesResults, error) {
+
+	app.Monitor.Signal(ctx, timings.SinceStart, timingsPIPQueryNearest)
+	defer app.Monitor.Signal(ctx, timings.SinceStop, timingsPIPQueryNearest)
+
+	if req.FallbackMaxDistance <= 0 {
+		return nil, NewError(INVALID_PARAMETER, fmt.Errorf("Invalid fallback max distance, must be greater than 0"))
+	}
+
+	idx, ok := app.SpatialDatabase.(NearestIndex)
+
+	if !ok {
+		return nil, NewError(UNSUPPORTED_QUERY, fmt.Errorf("Spatial database does not support nearest queries"))
+	}
+
+	c, err := geo.NewCoordinate(req.Longitude, req.Latitude)
+
+	if err != nil {
+		return nil, NewError(INVALID_COORDINATE, fmt.Errorf("Failed to create new coordinate, %w", err))
+	}
+
+	f, err := NewSPRFilterFromPointInPolygonRequest(req)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create nearest filter from request, %w", err)
+	}
+
+	rsp, err := idx.Nearest(ctx, c, req.FallbackMaxDistance, f)
+
+	if err != nil {
+		return nil, backendError("Failed to perform nearest query", err)
+	}
+
+	if rsp == nil {
+		return nil, noResultsError(ctx)
+	}
+
+	return rsp, nil
+}
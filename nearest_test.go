@@ -0,0 +1,261 @@
+package pip
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/paulmach/orb"
+	"github.com/whosonfirst/go-whosonfirst-spatial"
+	"github.com/whosonfirst/go-whosonfirst-spr/v2"
+	"slices"
+	"testing"
+)
+
+// emptyNearestDatabase is a SpatialDatabase instance whose Nearest method returns neither results nor an error, as
+// some databases do when a query is cancelled.
+type emptyNearestDatabase struct {
+	*SpatialDatabase
+}
+
+func (db *emptyNearestDatabase) Nearest(ctx context.Context, pt *orb.Point, max_distance float64, filters ...spatial.Filter) (spr.StandardPlacesResults, error) {
+	return nil, nil
+}
+
+func TestQueryPointInPolygonFallback(t *testing.T) {
+
+	ctx := context.Background()
+
+	app := newTestApplication(t, &SpatialDatabaseOptions{IndexGeometries: true})
+
+	tests := []struct {
+		name     string
+		req      *PointInPolygonRequest
+		expected []string
+		nearest  bool
+	}{
+		{
+			name:     "contained",
+			req:      &PointInPolygonRequest{Latitude: 0.75, Longitude: 0.75, IsCurrent: []int64{1}, FallbackMaxDistance: 100000},
+			expected: []string{"101000001", "85000001", "85000002"},
+		},
+		{
+			name:     "nearest",
+			req:      &PointInPolygonRequest{Latitude: 3.5, Longitude: 3.5, FallbackMaxDistance: 100000},
+			expected: []string{"85000001"},
+			nearest:  true,
+		},
+		{
+			name:     "nearest ordered by distance",
+			req:      &PointInPolygonRequest{Latitude: 3.8, Longitude: 3.8, FallbackMaxDistance: 200000},
+			expected: []string{"85000001", "101000003"},
+			nearest:  true,
+		},
+		{
+			name:     "nearest with filters",
+			req:      &PointInPolygonRequest{Latitude: 3.8, Longitude: 3.8, Placetypes: []string{"locality"}, FallbackMaxDistance: 200000},
+			expected: []string{"101000003"},
+			nearest:  true,
+		},
+		{
+			name:     "too far",
+			req:      &PointInPolygonRequest{Latitude: 3.5, Longitude: 3.5, FallbackMaxDistance: 1000},
+			expected: []string{},
+		},
+		{
+			name:     "no fallback",
+			req:      &PointInPolygonRequest{Latitude: 3.5, Longitude: 3.5},
+			expected: []string{},
+		},
+	}
+
+	for _, tt := range tests {
+
+		t.Run(tt.name, func(t *testing.T) {
+
+			rsp, err := QueryPointInPolygon(ctx, app, tt.req)
+
+			if err != nil {
+				t.Fatalf("Failed to query point in polygon, %v", err)
+			}
+
+			// Nearest results are ordered by distance, other results are compared by ID
+
+			ids := make([]string, 0)
+
+			for _, r := range rsp.Results() {
+				ids = append(ids, r.Id())
+			}
+
+			if !tt.nearest {
+				slices.Sort(ids)
+			}
+
+			if !slices.Equal(ids, tt.expected) {
+				t.Fatalf("Expected %v, got %v", tt.expected, ids)
+			}
+
+			last_distance := 0.0
+
+			for _, r := range rsp.Results() {
+
+				nearest_r, is_nearest := r.(*NearestPlacesResult)
+
+				if is_nearest != tt.nearest {
+					t.Fatalf("Expected nearest result to be %t, got %t", tt.nearest, is_nearest)
+				}
+
+				if !is_nearest {
+					continue
+				}
+
+				if nearest_r.MatchType != NEAREST_MATCH {
+					t.Fatalf("Expected %s match type, got %s", NEAREST_MATCH, nearest_r.MatchType)
+				}
+
+				if nearest_r.Distance <= 0 || nearest_r.Distance > tt.req.FallbackMaxDistance {
+					t.Fatalf("Unexpected distance %f", nearest_r.Distance)
+				}
+
+				if nearest_r.Distance < last_distance {
+					t.Fatalf("Expected results to be ordered by distance")
+				}
+
+				last_distance = nearest_r.Distance
+			}
+		})
+	}
+}
+
+func TestNearestPlacesResultMarshalJSON(t *testing.T) {
+
+	ctx := context.Background()
+
+	app := newTestApplication(t, &SpatialDatabaseOptions{IndexGeometries: true})
+
+	req := &PointInPolygonRequest{
+		Latitude:            3.5,
+		Longitude:           3.5,
+		FallbackMaxDistance: 100000,
+	}
+
+	rsp, err := NearestPointInPolygonResults(ctx, app, req)
+
+	if err != nil {
+		t.Fatalf("Failed to query nearest results, %v", err)
+	}
+
+	enc, err := json.Marshal(rsp.Results()[0])
+
+	if err != nil {
+		t.Fatalf("Failed to marshal result, %v", err)
+	}
+
+	var props map[string]interface{}
+
+	err = json.Unmarshal(enc, &props)
+
+	if err != nil {
+		t.Fatalf("Failed to unmarshal result, %v", err)
+	}
+
+	if props["wof:id"] != float64(85000001) {
+		t.Fatalf("Expected wof:id 85000001, got %v", props["wof:id"])
+	}
+
+	if props["match_type"] != NEAREST_MATCH {
+		t.Fatalf("Expected %s match type, got %v", NEAREST_MATCH, props["match_type"])
+	}
+
+	distance, _ := props["distance"].(float64)
+
+	if distance <= 0 {
+		t.Fatalf("Expected a distance, got %v", props["distance"])
+	}
+}
+
+func TestNearestPointInPolygonResultsErrors(t *testing.T) {
+
+	ctx := context.Background()
+
+	indexed_app := newTestApplication(t, &SpatialDatabaseOptions{IndexGeometries: true})
+	unindexed_app := newTestApplication(t, nil)
+
+	tests := []struct {
+		name string
+		req  *PointInPolygonRequest
+		code ErrorCode
+	}{
+		{
+			name: "no distance",
+			req:  &PointInPolygonRequest{Latitude: 3.5, Longitude: 3.5},
+			code: INVALID_PARAMETER,
+		},
+		{
+			name: "invalid coordinate",
+			req:  &PointInPolygonRequest{Latitude: 100, Longitude: 3.5, FallbackMaxDistance: 1000},
+			code: INVALID_COORDINATE,
+		},
+	}
+
+	for _, tt := range tests {
+
+		t.Run(tt.name, func(t *testing.T) {
+
+			_, err := NearestPointInPolygonResults(ctx, indexed_app, tt.req)
+
+			code, _ := ErrorCodeWithError(err)
+
+			if code != tt.code {
+				t.Fatalf("Expected %s, got %v", tt.code, err)
+			}
+		})
+	}
+
+	// Fallback queries require the secondary index
+
+	req := &PointInPolygonRequest{
+		Latitude:            3.5,
+		Longitude:           3.5,
+		FallbackMaxDistance: 100000,
+	}
+
+	_, err := QueryPointInPolygon(ctx, unindexed_app, req)
+
+	code, _ := ErrorCodeWithError(err)
+
+	if code != UNSUPPORTED_QUERY {
+		t.Fatalf("Expected %s, got %v", UNSUPPORTED_QUERY, err)
+	}
+}
+
+func TestNearestPointInPolygonResultsEmpty(t *testing.T) {
+
+	app := newTestApplication(t, &SpatialDatabaseOptions{IndexGeometries: true})
+
+	app.SpatialDatabase = &emptyNearestDatabase{
+		SpatialDatabase: app.SpatialDatabase.(*SpatialDatabase),
+	}
+
+	req := &PointInPolygonRequest{
+		Latitude:            3.5,
+		Longitude:           3.5,
+		FallbackMaxDistance: 100000,
+	}
+
+	_, err := NearestPointInPolygonResults(context.Background(), app, req)
+
+	code, _ := ErrorCodeWithError(err)
+
+	if code != BACKEND_ERROR {
+		t.Fatalf("Expected %s, got %v", BACKEND_ERROR, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err = NearestPointInPolygonResults(ctx, app, req)
+
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected context.Canceled, got %v", err)
+	}
+}
@@ -0,0 +1,207 @@
+package pip
+
+import (
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"github.com/whosonfirst/go-whosonfirst-spr/v2"
+	"strconv"
+	"strings"
+)
+
+// DEFAULT_PER_PAGE is the number of results per page used when a cursor is provided without a per-page value.
+const DEFAULT_PER_PAGE int = 10
+
+// MAX_PAGE is the largest page of results that may be requested.
+const MAX_PAGE int = 100000
+
+// MAX_PER_PAGE is the largest number of results per page that may be requested.
+const MAX_PER_PAGE int = 1000
+
+// MAX_LIMIT is the largest maximum number of results that may be requested.
+const MAX_LIMIT int = 100000
+
+const PageFlag string = "page"
+
+const PerPageFlag string = "per-page"
+
+const CursorFlag string = "cursor"
+
+const LimitFlag string = "limit"
+
+// Pagination reports the pagination details for a point-in-polygon response.
+type Pagination struct {
+	// The total number of results, after any limit has been applied.
+	Total int `json:"total"`
+	// The current page of results.
+	Page int `json:"page"`
+	// The number of results per page.
+	PerPage int `json:"per_page"`
+	// The total number of pages.
+	Pages int `json:"pages"`
+	// An opaque cursor for the next page of results. Empty if there are no more results.
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// AppendPaginationFlags appends flags for paginating and limiting point-in-polygon results to 'fs'.
+func AppendPaginationFlags(fs *flag.FlagSet) error {
+
+	fs.Int(PageFlag, 0, "The page of results to return. Pages start at 1.")
+	fs.Int(PerPageFlag, 0, "The number of results per page. If 0 results are not paginated.")
+	fs.String(CursorFlag, "", "A cursor, returned by a previous paginated query, identifying the page of results to return.")
+	fs.Int(LimitFlag, 0, "The maximum number of results to return. If 0 there is no limit.")
+
+	return nil
+}
+
+// IsPaginated returns true if 'req' specifies any pagination or limit criteria.
+func (req *PointInPolygonRequest) IsPaginated() bool {
+	return req.Page != 0 || req.PerPage != 0 || req.Cursor != "" || req.Limit != 0
+}
+
+// PaginatePointInPolygonResults applies the limit and pagination criteria in 'req' to 'rsp', which is assumed to have
+// already been sorted. If 'req' does not specify any pagination or limit criteria then 'rsp' is returned unchanged,
+// otherwise a *PointInPolygonResults instance with pagination details is returned.
+func PaginatePointInPolygonResults(req *PointInPolygonRequest, rsp spr.StandardPlacesResults) (spr.StandardPlacesResults, error) {
+
+	if !req.IsPaginated() {
+		return rsp, nil
+	}
+
+	if req.Page < 0 || req.PerPage < 0 || req.Limit < 0 {
+		return nil, NewError(INVALID_PARAMETER, fmt.Errorf("Invalid pagination criteria, values must not be negative"))
+	}
+
+	if req.Page > MAX_PAGE || req.PerPage > MAX_PER_PAGE || req.Limit > MAX_LIMIT {
+		return nil, NewError(INVALID_PARAMETER, fmt.Errorf("Invalid pagination criteria, values exceed the maximum allowed"))
+	}
+
+	places := rsp.Results()
+
+	if req.Limit > 0 && len(places) > req.Limit {
+		places = places[0:req.Limit]
+	}
+
+	total := len(places)
+
+	per_page := req.PerPage
+
+	if per_page == 0 {
+
+		if req.Page > 0 || req.Cursor != "" {
+			per_page = DEFAULT_PER_PAGE
+		} else {
+			per_page = total
+		}
+	}
+
+	offset := 0
+	page := 1
+
+	if req.Cursor != "" {
+
+		cursor_offset, err := decodeCursor(req.Cursor)
+
+		if err != nil {
+			return nil, err
+		}
+
+		offset = cursor_offset
+
+		if per_page > 0 {
+			page = (offset / per_page) + 1
+		}
+
+	} else if req.Page > 1 {
+
+		page = req.Page
+
+		// Pages past the last page are empty. This is checked before the offset is calculated so that it can
+		// never overflow.
+
+		if per_page == 0 || req.Page-1 > total/per_page {
+			offset = total
+		} else {
+			offset = (req.Page - 1) * per_page
+		}
+	}
+
+	pg := &Pagination{
+		Total:   total,
+		PerPage: per_page,
+		Page:    page,
+		Pages:   1,
+	}
+
+	if per_page > 0 {
+		pg.Pages = (total + per_page - 1) / per_page
+	}
+
+	if pg.Pages == 0 {
+		pg.Pages = 1
+	}
+
+	page_places := make([]spr.StandardPlacesResult, 0)
+
+	if offset < total {
+
+		end := total
+
+		if per_page < total-offset {
+			end = offset + per_page
+		}
+
+		page_places = places[offset:end]
+
+		if end < total {
+			pg.NextCursor = encodeCursor(end)
+		}
+	}
+
+	paginated := &PointInPolygonResults{
+		Places:     page_places,
+		Pagination: pg,
+	}
+
+	return paginated, nil
+}
+
+// PaginationWithResults returns the pagination details for 'rsp' or nil if it has not been paginated.
+func PaginationWithResults(rsp spr.StandardPlacesResults) *Pagination {
+
+	pip_rsp, ok := rsp.(*PointInPolygonResults)
+
+	if !ok {
+		return nil
+	}
+
+	return pip_rsp.Pagination
+}
+
+func encodeCursor(offset int) string {
+	str_offset := fmt.Sprintf("offset:%d", offset)
+	return base64.RawURLEncoding.EncodeToString([]byte(str_offset))
+}
+
+func decodeCursor(cursor string) (int, error) {
+
+	body, err := base64.RawURLEncoding.DecodeString(cursor)
+
+	if err != nil {
+		return 0, NewError(INVALID_PARAMETER, fmt.Errorf("Invalid cursor, %w", err))
+	}
+
+	str_offset, ok := strings.CutPrefix(string(body), "offset:")
+
+	if !ok {
+		return 0, NewError(INVALID_PARAMETER, fmt.Errorf("Invalid cursor"))
+	}
+
+	offset, err := strconv.Atoi(str_offset)
+
+	if err != nil || offset < 0 {
+		return 0, NewError(INVALID_PARAMETER, fmt.Errorf("Invalid cursor"))
+	}
+
+	return offset, nil
+}
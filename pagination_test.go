@@ -0,0 +1,235 @@
+package pip
+
+import (
+	"context"
+	"encoding/base64"
+	"github.com/whosonfirst/go-whosonfirst-spr/v2"
+	"math"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestCursor(t *testing.T) {
+
+	for _, offset := range []int{0, 1, 10, 12345} {
+
+		cursor := encodeCursor(offset)
+
+		decoded, err := decodeCursor(cursor)
+
+		if err != nil {
+			t.Fatalf("Failed to decode cursor for %d, %v", offset, err)
+		}
+
+		if decoded != offset {
+			t.Fatalf("Expected offset %d, got %d", offset, decoded)
+		}
+	}
+
+	invalid := []string{
+		"!!!",
+		base64.RawURLEncoding.EncodeToString([]byte("10")),
+		base64.RawURLEncoding.EncodeToString([]byte("offset:ten")),
+		base64.RawURLEncoding.EncodeToString([]byte("offset:-1")),
+	}
+
+	for _, cursor := range invalid {
+
+		_, err := decodeCursor(cursor)
+
+		code, _ := ErrorCodeWithError(err)
+
+		if code != INVALID_PARAMETER {
+			t.Fatalf("Expected %s for cursor '%s', got %v", INVALID_PARAMETER, cursor, err)
+		}
+	}
+}
+
+func TestPaginatePointInPolygonResults(t *testing.T) {
+
+	ctx := context.Background()
+	app := newTestApplication(t, nil)
+
+	pip_req := &PointInPolygonRequest{
+		Latitude:  0.25,
+		Longitude: 0.25,
+	}
+
+	pip_rsp, err := QueryPointInPolygon(ctx, app, pip_req)
+
+	if err != nil {
+		t.Fatalf("Failed to query point in polygon, %v", err)
+	}
+
+	// Sort the results by ID so that the contents of each page are predictable
+
+	places := slices.Clone(pip_rsp.Results())
+
+	slices.SortFunc(places, func(a spr.StandardPlacesResult, b spr.StandardPlacesResult) int {
+		return strings.Compare(a.Id(), b.Id())
+	})
+
+	rsp := NewPointInPolygonResults(places)
+
+	all := []string{"101000001", "101000002", "102000001", "85000001", "85000002"}
+
+	if !slices.Equal(resultIds(rsp), all) {
+		t.Fatalf("Expected %v, got %v", all, resultIds(rsp))
+	}
+
+	tests := []struct {
+		name       string
+		req        *PointInPolygonRequest
+		expected   []string
+		pagination *Pagination
+		code       ErrorCode
+	}{
+		{
+			name:       "first page",
+			req:        &PointInPolygonRequest{Page: 1, PerPage: 2},
+			expected:   all[0:2],
+			pagination: &Pagination{Total: 5, Page: 1, PerPage: 2, Pages: 3, NextCursor: encodeCursor(2)},
+		},
+		{
+			name:       "per page",
+			req:        &PointInPolygonRequest{PerPage: 2},
+			expected:   all[0:2],
+			pagination: &Pagination{Total: 5, Page: 1, PerPage: 2, Pages: 3, NextCursor: encodeCursor(2)},
+		},
+		{
+			name:       "last page",
+			req:        &PointInPolygonRequest{Page: 3, PerPage: 2},
+			expected:   all[4:5],
+			pagination: &Pagination{Total: 5, Page: 3, PerPage: 2, Pages: 3},
+		},
+		{
+			name:       "cursor",
+			req:        &PointInPolygonRequest{Cursor: encodeCursor(2), PerPage: 2},
+			expected:   all[2:4],
+			pagination: &Pagination{Total: 5, Page: 2, PerPage: 2, Pages: 3, NextCursor: encodeCursor(4)},
+		},
+		{
+			name:       "cursor default per page",
+			req:        &PointInPolygonRequest{Cursor: encodeCursor(1)},
+			expected:   all[1:5],
+			pagination: &Pagination{Total: 5, Page: 1, PerPage: DEFAULT_PER_PAGE, Pages: 1},
+		},
+		{
+			name:       "page default per page",
+			req:        &PointInPolygonRequest{Page: 1},
+			expected:   all,
+			pagination: &Pagination{Total: 5, Page: 1, PerPage: DEFAULT_PER_PAGE, Pages: 1},
+		},
+		{
+			name:       "out of range",
+			req:        &PointInPolygonRequest{Page: 10, PerPage: 2},
+			expected:   []string{},
+			pagination: &Pagination{Total: 5, Page: 10, PerPage: 2, Pages: 3},
+		},
+		{
+			name:       "limit",
+			req:        &PointInPolygonRequest{Limit: 3},
+			expected:   all[0:3],
+			pagination: &Pagination{Total: 3, Page: 1, PerPage: 3, Pages: 1},
+		},
+		{
+			name:       "limit and per page",
+			req:        &PointInPolygonRequest{Limit: 3, PerPage: 2},
+			expected:   all[0:2],
+			pagination: &Pagination{Total: 3, Page: 1, PerPage: 2, Pages: 2, NextCursor: encodeCursor(2)},
+		},
+		{
+			name:       "limit larger than results",
+			req:        &PointInPolygonRequest{Limit: 50},
+			expected:   all,
+			pagination: &Pagination{Total: 5, Page: 1, PerPage: 5, Pages: 1},
+		},
+		{
+			name: "negative page",
+			req:  &PointInPolygonRequest{Page: -1},
+			code: INVALID_PARAMETER,
+		},
+		{
+			name:       "last allowed page",
+			req:        &PointInPolygonRequest{Page: MAX_PAGE, PerPage: MAX_PER_PAGE},
+			expected:   []string{},
+			pagination: &Pagination{Total: 5, Page: MAX_PAGE, PerPage: MAX_PER_PAGE, Pages: 1},
+		},
+		{
+			name: "huge page",
+			req:  &PointInPolygonRequest{Page: 1000000000000000000, PerPage: 10},
+			code: INVALID_PARAMETER,
+		},
+		{
+			name: "huge per page",
+			req:  &PointInPolygonRequest{Page: 2, PerPage: math.MaxInt},
+			code: INVALID_PARAMETER,
+		},
+		{
+			name: "negative limit",
+			req:  &PointInPolygonRequest{Limit: -1},
+			code: INVALID_PARAMETER,
+		},
+		{
+			name: "invalid cursor",
+			req:  &PointInPolygonRequest{Cursor: "!!!"},
+			code: INVALID_PARAMETER,
+		},
+	}
+
+	for _, tt := range tests {
+
+		t.Run(tt.name, func(t *testing.T) {
+
+			paginated, err := PaginatePointInPolygonResults(tt.req, rsp)
+
+			if tt.code != "" {
+
+				code, _ := ErrorCodeWithError(err)
+
+				if code != tt.code {
+					t.Fatalf("Expected %s, got %v", tt.code, err)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Failed to paginate results, %v", err)
+			}
+
+			ids := make([]string, 0)
+
+			for _, r := range paginated.Results() {
+				ids = append(ids, r.Id())
+			}
+
+			if !slices.Equal(ids, tt.expected) {
+				t.Fatalf("Expected %v, got %v", tt.expected, ids)
+			}
+
+			pg := PaginationWithResults(paginated)
+
+			if pg == nil {
+				t.Fatalf("Expected pagination details")
+			}
+
+			if *pg != *tt.pagination {
+				t.Fatalf("Expected pagination %+v, got %+v", tt.pagination, pg)
+			}
+		})
+	}
+
+	// Requests without pagination criteria are returned as is
+
+	unpaginated, err := PaginatePointInPolygonResults(&PointInPolygonRequest{}, rsp)
+
+	if err != nil {
+		t.Fatalf("Failed to paginate results, %v", err)
+	}
+
+	if unpaginated != rsp || PaginationWithResults(unpaginated) != nil {
+		t.Fatalf("Expected results without pagination criteria to be unchanged")
+	}
+}
@@ -2,6 +2,7 @@ package pip
 
 import (
 	"flag"
+	"fmt"
 	"github.com/sfomuseum/go-flags/lookup"
 	"github.com/whosonfirst/go-whosonfirst-spatial"
 	"github.com/whosonfirst/go-whosonfirst-spatial/filter"
@@ -11,6 +12,7 @@ import (
 )
 
 type PointInPolygonRequest struct {
+	Id                  string   `json:"id,omitempty"`
 	Latitude            float64  `json:"latitude"`
 	Longitude           float64  `json:"longitude"`
 	Placetypes          []string `json:"placetypes,omitempty"`
@@ -25,11 +27,26 @@ type PointInPolygonRequest struct {
 	CessationDate       string   `json:"cessation_date,omitempty"`
 	Properties          []string `json:"properties,omitempty"`
 	Sort                []string `json:"sort,omitempty"`
+	Page                int      `json:"page,omitempty"`
+	PerPage             int      `json:"per_page,omitempty"`
+	Cursor              string   `json:"cursor,omitempty"`
+	Limit               int      `json:"limit,omitempty"`
+	FallbackMaxDistance float64  `json:"fallback_max_distance,omitempty"`
+	Explain             bool     `json:"explain,omitempty"`
+	Timings             bool     `json:"timings,omitempty"`
 }
 
+// NewPointInPolygonRequestFromFlagSet returns a new PointInPolygonRequest derived from the flags in 'fs'. The -latitude
+// and -longitude flags must be set explicitly; since they default to 0.0 an error is returned if either of them is
+// missing rather than querying Null Island.
 func NewPointInPolygonRequestFromFlagSet(fs *flag.FlagSet) (*PointInPolygonRequest, error) {
 
-	req := &PointInPolygonRequest{}
+	for _, name := range []string{flags.LATITUDE, flags.LONGITUDE} {
+
+		if !IsFlagSet(fs, name) {
+			return nil, NewError(INVALID_COORDINATE, fmt.Errorf("Missing -%s flag", name))
+		}
+	}
 
 	latitude, err := lookup.Float64Var(fs, flags.LATITUDE)
 
@@ -37,15 +54,24 @@ func NewPointInPolygonRequestFromFlagSet(fs *flag.FlagSet) (*PointInPolygonReque
 		return nil, err
 	}
 
-	req.Latitude = latitude
-
 	longitude, err := lookup.Float64Var(fs, flags.LONGITUDE)
 
 	if err != nil {
 		return nil, err
 	}
 
-	req.Longitude = longitude
+	return NewPointInPolygonRequestFromFlagSetWithCoordinate(fs, latitude, longitude)
+}
+
+// NewPointInPolygonRequestFromFlagSetWithCoordinate returns a new PointInPolygonRequest for 'latitude' and 'longitude'
+// using the filtering, sorting, properties and pagination criteria defined by the flags in 'fs'. The -latitude and
+// -longitude flags are ignored.
+func NewPointInPolygonRequestFromFlagSetWithCoordinate(fs *flag.FlagSet, latitude float64, longitude float64) (*PointInPolygonRequest, error) {
+
+	req := &PointInPolygonRequest{
+		Latitude:  latitude,
+		Longitude: longitude,
+	}
 
 	placetypes, err := lookup.MultiStringVar(fs, flags.PLACETYPES)
 
@@ -126,6 +152,14 @@ func NewPointInPolygonRequestFromFlagSet(fs *flag.FlagSet) (*PointInPolygonReque
 
 	req.IsSuperseding = is_superseding
 
+	properties, err := lookup.MultiStringVar(fs, flags.PROPERTIES)
+
+	if err != nil {
+		return nil, err
+	}
+
+	req.Properties = properties
+
 	sort_uris, err := lookup.MultiStringVar(fs, "sort-uri")
 
 	if err != nil {
@@ -134,9 +168,235 @@ func NewPointInPolygonRequestFromFlagSet(fs *flag.FlagSet) (*PointInPolygonReque
 
 	req.Sort = sort_uris
 
+	// Pagination, fallback and explain flags are specific to this package and
+	// may not have been appended to 'fs' so only look them up if they are present
+
+	if fs.Lookup(PageFlag) != nil {
+
+		page, err := lookup.IntVar(fs, PageFlag)
+
+		if err != nil {
+			return nil, err
+		}
+
+		req.Page = page
+	}
+
+	if fs.Lookup(PerPageFlag) != nil {
+
+		per_page, err := lookup.IntVar(fs, PerPageFlag)
+
+		if err != nil {
+			return nil, err
+		}
+
+		req.PerPage = per_page
+	}
+
+	if fs.Lookup(CursorFlag) != nil {
+
+		cursor, err := lookup.StringVar(fs, CursorFlag)
+
+		if err != nil {
+			return nil, err
+		}
+
+		req.Cursor = cursor
+	}
+
+	if fs.Lookup(LimitFlag) != nil {
+
+		limit, err := lookup.IntVar(fs, LimitFlag)
+
+		if err != nil {
+			return nil, err
+		}
+
+		req.Limit = limit
+	}
+
+	if fs.Lookup(FallbackMaxDistanceFlag) != nil {
+
+		fallback_max_distance, err := lookup.Float64Var(fs, FallbackMaxDistanceFlag)
+
+		if err != nil {
+			return nil, err
+		}
+
+		req.FallbackMaxDistance = fallback_max_distance
+	}
+
+	if fs.Lookup(ExplainFlag) != nil {
+
+		explain, err := lookup.BoolVar(fs, ExplainFlag)
+
+		if err != nil {
+			return nil, err
+		}
+
+		req.Explain = explain
+	}
+
+	if fs.Lookup(TimingsFlag) != nil {
+
+		include_timings, err := lookup.BoolVar(fs, TimingsFlag)
+
+		if err != nil {
+			return nil, err
+		}
+
+		req.Timings = include_timings
+	}
+
+	return req, nil
+}
+
+// IsFlagSet returns true if the flag named 'name' was explicitly set in 'fs', either on the command line or by
+// `flag.FlagSet.Set`, as opposed to using its default value.
+func IsFlagSet(fs *flag.FlagSet, name string) bool {
+
+	is_set := false
+
+	fs.Visit(func(f *flag.Flag) {
+
+		if f.Name == name {
+			is_set = true
+		}
+	})
+
+	return is_set
+}
+
+// NewPointInPolygonRequestFromQuery returns a new PointInPolygonRequest derived from 'q' using the same parameter
+// names as the `filter.NewSPRFilterFromQuery` method as well as "latitude", "longitude", "sort", "property",
+// "page", "per_page", "cursor", "limit", "fallback_max_distance", "explain" and "timings".
+func NewPointInPolygonRequestFromQuery(q url.Values) (*PointInPolygonRequest, error) {
+
+	req := &PointInPolygonRequest{}
+
+	latitude, err := strconv.ParseFloat(q.Get("latitude"), 64)
+
+	if err != nil {
+		return nil, NewError(INVALID_COORDINATE, fmt.Errorf("Invalid latitude parameter, %w", err))
+	}
+
+	req.Latitude = latitude
+
+	longitude, err := strconv.ParseFloat(q.Get("longitude"), 64)
+
+	if err != nil {
+		return nil, NewError(INVALID_COORDINATE, fmt.Errorf("Invalid longitude parameter, %w", err))
+	}
+
+	req.Longitude = longitude
+
+	err = appendQueryCriteria(req, q)
+
+	if err != nil {
+		return nil, err
+	}
+
 	return req, nil
 }
 
+// appendQueryCriteria assigns the filtering, sorting, properties and pagination criteria defined in 'q' to 'req'.
+func appendQueryCriteria(req *PointInPolygonRequest, q url.Values) error {
+
+	req.Placetypes = q["placetype"]
+	req.Geometries = q.Get("geometries")
+	req.AlternateGeometries = q["alternate_geometry"]
+
+	req.InceptionDate = q.Get("inception_date")
+	req.CessationDate = q.Get("cessation_date")
+
+	req.Properties = q["property"]
+	req.Sort = q["sort"]
+
+	req.Cursor = q.Get("cursor")
+
+	pagination := map[string]*int{
+		"page":     &req.Page,
+		"per_page": &req.PerPage,
+		"limit":    &req.Limit,
+	}
+
+	for k, target := range pagination {
+
+		str_v := q.Get(k)
+
+		if str_v == "" {
+			continue
+		}
+
+		v, err := strconv.Atoi(str_v)
+
+		if err != nil {
+			return NewError(INVALID_PARAMETER, fmt.Errorf("Invalid %s parameter, %w", k, err))
+		}
+
+		*target = v
+	}
+
+	str_fallback := q.Get("fallback_max_distance")
+
+	if str_fallback != "" {
+
+		v, err := strconv.ParseFloat(str_fallback, 64)
+
+		if err != nil {
+			return NewError(INVALID_PARAMETER, fmt.Errorf("Invalid fallback_max_distance parameter, %w", err))
+		}
+
+		req.FallbackMaxDistance = v
+	}
+
+	options := map[string]*bool{
+		"explain": &req.Explain,
+		"timings": &req.Timings,
+	}
+
+	for k, target := range options {
+
+		str_v := q.Get(k)
+
+		if str_v == "" {
+			continue
+		}
+
+		v, err := strconv.ParseBool(str_v)
+
+		if err != nil {
+			return NewError(INVALID_PARAMETER, fmt.Errorf("Invalid %s parameter, %w", k, err))
+		}
+
+		*target = v
+	}
+
+	existential := map[string]*[]int64{
+		"is_current":     &req.IsCurrent,
+		"is_ceased":      &req.IsCeased,
+		"is_deprecated":  &req.IsDeprecated,
+		"is_superseded":  &req.IsSuperseded,
+		"is_superseding": &req.IsSuperseding,
+	}
+
+	for k, target := range existential {
+
+		for _, str_v := range q[k] {
+
+			v, err := strconv.ParseInt(str_v, 10, 64)
+
+			if err != nil {
+				return NewError(INVALID_PARAMETER, fmt.Errorf("Invalid %s parameter, %w", k, err))
+			}
+
+			*target = append(*target, v)
+		}
+	}
+
+	return nil
+}
+
 func NewSPRFilterFromPointInPolygonRequest(req *PointInPolygonRequest) (spatial.Filter, error) {
 
 	q := url.Values{}
@@ -173,5 +433,11 @@ func NewSPRFilterFromPointInPolygonRequest(req *PointInPolygonRequest) (spatial.
 		q.Add("is_superseding", strconv.FormatInt(v, 10))
 	}
 
-	return filter.NewSPRFilterFromQuery(q)
+	f, err := filter.NewSPRFilterFromQuery(q)
+
+	if err != nil {
+		return nil, NewError(filterErrorCode(req), err)
+	}
+
+	return f, nil
 }
@@ -0,0 +1,113 @@
+package pip
+
+import (
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+func TestNewPointInPolygonRequestFromQuery(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		query    string
+		expected *PointInPolygonRequest
+		code     ErrorCode
+	}{
+		{
+			name:     "coordinate",
+			query:    "latitude=37.616951&longitude=-122.383747",
+			expected: &PointInPolygonRequest{Latitude: 37.616951, Longitude: -122.383747},
+		},
+		{
+			name:  "criteria",
+			query: "latitude=1&longitude=2&placetype=locality&placetype=neighbourhood&is_current=1&is_current=-1&is_deprecated=0&geometries=alt&alternate_geometry=quattroshapes&inception_date=2000&cessation_date=..&property=wof:name&property=wof:country&sort=name://",
+			expected: &PointInPolygonRequest{
+				Latitude:            1,
+				Longitude:           2,
+				Placetypes:          []string{"locality", "neighbourhood"},
+				IsCurrent:           []int64{1, -1},
+				IsDeprecated:        []int64{0},
+				Geometries:          "alt",
+				AlternateGeometries: []string{"quattroshapes"},
+				InceptionDate:       "2000",
+				CessationDate:       "..",
+				Properties:          []string{"wof:name", "wof:country"},
+				Sort:                []string{"name://"},
+			},
+		},
+		{
+			name:  "options",
+			query: "latitude=1&longitude=2&page=2&per_page=10&limit=50&cursor=abc&fallback_max_distance=500&explain=true&timings=1",
+			expected: &PointInPolygonRequest{
+				Latitude:            1,
+				Longitude:           2,
+				Page:                2,
+				PerPage:             10,
+				Limit:               50,
+				Cursor:              "abc",
+				FallbackMaxDistance: 500,
+				Explain:             true,
+				Timings:             true,
+			},
+		},
+		{
+			name:  "missing latitude",
+			query: "longitude=2",
+			code:  INVALID_COORDINATE,
+		},
+		{
+			name:  "invalid longitude",
+			query: "latitude=1&longitude=east",
+			code:  INVALID_COORDINATE,
+		},
+		{
+			name:  "invalid page",
+			query: "latitude=1&longitude=2&page=two",
+			code:  INVALID_PARAMETER,
+		},
+		{
+			name:  "invalid is_current",
+			query: "latitude=1&longitude=2&is_current=yes",
+			code:  INVALID_PARAMETER,
+		},
+		{
+			name:  "invalid explain",
+			query: "latitude=1&longitude=2&explain=please",
+			code:  INVALID_PARAMETER,
+		},
+	}
+
+	for _, tt := range tests {
+
+		t.Run(tt.name, func(t *testing.T) {
+
+			q, err := url.ParseQuery(tt.query)
+
+			if err != nil {
+				t.Fatalf("Failed to parse query, %v", err)
+			}
+
+			req, err := NewPointInPolygonRequestFromQuery(q)
+
+			if tt.code != "" {
+
+				code, ok := ErrorCodeWithError(err)
+
+				if !ok || code != tt.code {
+					t.Fatalf("Expected %s error, got %v", tt.code, err)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Failed to create request, %v", err)
+			}
+
+			if !reflect.DeepEqual(req, tt.expected) {
+				t.Fatalf("Expected %+v, got %+v", tt.expected, req)
+			}
+		})
+	}
+}
@@ -0,0 +1,55 @@
+package pip
+
+import (
+	"github.com/whosonfirst/go-whosonfirst-spr/v2"
+)
+
+// IndexProgress reports how far indexing has progressed for results returned before it is complete.
+type IndexProgress struct {
+	// The number of records seen by the indexing iterator so far.
+	Records int64 `json:"records"`
+	// The number of records expected to be indexed, if known.
+	Expected int64 `json:"expected,omitempty"`
+	// The ratio of Records to Expected, if Expected is known.
+	Ratio float64 `json:"ratio,omitempty"`
+}
+
+// NewIndexProgress returns a new IndexProgress instance for 'records' out of 'expected' records. If 'expected' is
+// zero, or less, the number of expected records is not known.
+func NewIndexProgress(records int64, expected int64) *IndexProgress {
+
+	p := &IndexProgress{
+		Records: records,
+	}
+
+	if expected > 0 {
+		p.Expected = expected
+		p.Ratio = min(float64(records)/float64(expected), 1.0)
+	}
+
+	return p
+}
+
+// IndexProgressWithResults returns the IndexProgress instance associated with 'rsp', or nil if there is none.
+func IndexProgressWithResults(rsp spr.StandardPlacesResults) *IndexProgress {
+
+	pip_rsp, ok := rsp.(*PointInPolygonResults)
+
+	if !ok {
+		return nil
+	}
+
+	return pip_rsp.IndexProgress
+}
+
+// AppendIndexProgressToResults returns a copy of 'rsp' whose index progress is 'p'.
+func AppendIndexProgressToResults(rsp spr.StandardPlacesResults, p *IndexProgress) spr.StandardPlacesResults {
+
+	return &PointInPolygonResults{
+		Places:        rsp.Results(),
+		Pagination:    PaginationWithResults(rsp),
+		Explain:       ExplanationWithResults(rsp),
+		Timings:       TimingsWithResults(rsp),
+		IndexProgress: p,
+	}
+}
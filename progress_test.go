@@ -0,0 +1,62 @@
+package pip
+
+import (
+	"context"
+	"testing"
+)
+
+func TestNewIndexProgress(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		records  int64
+		expected int64
+		progress IndexProgress
+	}{
+		{name: "expected", records: 25, expected: 100, progress: IndexProgress{Records: 25, Expected: 100, Ratio: 0.25}},
+		{name: "unknown", records: 25, expected: 0, progress: IndexProgress{Records: 25}},
+		{name: "negative", records: 25, expected: -1, progress: IndexProgress{Records: 25}},
+		{name: "more than expected", records: 150, expected: 100, progress: IndexProgress{Records: 150, Expected: 100, Ratio: 1.0}},
+	}
+
+	for _, tt := range tests {
+
+		t.Run(tt.name, func(t *testing.T) {
+
+			p := NewIndexProgress(tt.records, tt.expected)
+
+			if *p != tt.progress {
+				t.Fatalf("Expected %+v, got %+v", tt.progress, p)
+			}
+		})
+	}
+}
+
+func TestAppendIndexProgressToResults(t *testing.T) {
+
+	ctx := context.Background()
+	app := newTestApplication(t, nil)
+
+	rsp, err := QueryPointInPolygon(ctx, app, &PointInPolygonRequest{Latitude: 0.25, Longitude: 0.25, PerPage: 2})
+
+	if err != nil {
+		t.Fatalf("Failed to query point in polygon, %v", err)
+	}
+
+	if IndexProgressWithResults(rsp) != nil {
+		t.Fatalf("Expected no index progress")
+	}
+
+	p := NewIndexProgress(6, 7)
+	progress_rsp := AppendIndexProgressToResults(rsp, p)
+
+	if IndexProgressWithResults(progress_rsp) != p {
+		t.Fatalf("Expected index progress to be appended")
+	}
+
+	// The results and their pagination are preserved
+
+	if len(progress_rsp.Results()) != 2 || PaginationWithResults(progress_rsp) == nil {
+		t.Fatalf("Expected results and pagination to be preserved, %+v", progress_rsp)
+	}
+}
@@ -0,0 +1,46 @@
+package pip
+
+import (
+	"context"
+	"github.com/sfomuseum/go-timings"
+	"github.com/whosonfirst/go-reader"
+	"github.com/whosonfirst/go-whosonfirst-spatial"
+	"github.com/whosonfirst/go-whosonfirst-spr/v2"
+)
+
+// PropertiesResponseResults is a list of properties responses derived from point-in-polygon results
+// along with any pagination details, explanation or timings for those results.
+type PropertiesResponseResults struct {
+	Properties    []*spatial.PropertiesResponse `json:"places"`
+	Pagination    *Pagination                   `json:"pagination,omitempty"`
+	Explain       *Explanation                  `json:"explain,omitempty"`
+	Timings       []*timings.SinceResponse      `json:"timings,omitempty"`
+	IndexProgress *IndexProgress                `json:"index_progress,omitempty"`
+}
+
+// PropertiesResponseResultsWithStandardPlacesResults returns a properties response for 'keys' derived from
+// 'results' whose properties are read from 'r'.
+func PropertiesResponseResultsWithStandardPlacesResults(ctx context.Context, r reader.Reader, keys []string, results spr.StandardPlacesResults) (*PropertiesResponseResults, error) {
+
+	props_opts := &spatial.PropertiesResponseOptions{
+		Reader:       r,
+		Keys:         keys,
+		SourcePrefix: "properties",
+	}
+
+	props_rsp, err := spatial.PropertiesResponseResultsWithStandardPlacesResults(ctx, props_opts, results)
+
+	if err != nil {
+		return nil, NewError(BACKEND_ERROR, err)
+	}
+
+	pip_props_rsp := &PropertiesResponseResults{
+		Properties:    props_rsp.Properties,
+		Pagination:    PaginationWithResults(results),
+		Explain:       ExplanationWithResults(results),
+		Timings:       TimingsWithResults(results),
+		IndexProgress: IndexProgressWithResults(results),
+	}
+
+	return pip_props_rsp, nil
+}
@@ -3,11 +3,11 @@ package pip
 import (
 	"context"
 	"fmt"
+	"github.com/sfomuseum/go-timings"
 	spatial_app "github.com/whosonfirst/go-whosonfirst-spatial/app"
 	"github.com/whosonfirst/go-whosonfirst-spatial/geo"
 	"github.com/whosonfirst/go-whosonfirst-spr/v2"
 	"github.com/whosonfirst/go-whosonfirst-spr/v2/sort"
-	"github.com/sfomuseum/go-timings"
 )
 
 const timingsPIPQuery string = "PIP query"
@@ -18,13 +18,46 @@ const timingsPIPQuerySort string = "PIP query sort"
 
 func QueryPointInPolygon(ctx context.Context, app *spatial_app.SpatialApplication, req *PointInPolygonRequest) (spr.StandardPlacesResults, error) {
 
+	if req.Explain {
+		return explainPointInPolygon(ctx, app, req)
+	}
+
 	app.Monitor.Signal(ctx, timings.SinceStart, timingsPIPQuery)
-	defer app.Monitor.Signal(ctx, timings.SinceStop, timingsPIPQuery)	
-	
+	defer app.Monitor.Signal(ctx, timings.SinceStop, timingsPIPQuery)
+
+	// If the database has a cache then the query is performed using the normalised
+	// request so that the results are the same for every request sharing its key.
+
+	cache, has_cache := QueryCacheWithApplication(app)
+
+	var cache_key string
+	var cache_generation uint64
+
+	if has_cache {
+
+		req = cache.NormaliseRequest(req)
+
+		key, err := cache.Key(req)
+
+		if err != nil {
+			return nil, err
+		}
+
+		cached, generation, ok := cache.Get(key)
+
+		if ok {
+			app.Monitor.Signal(ctx, "complete point in polygon")
+			return cached, nil
+		}
+
+		cache_key = key
+		cache_generation = generation
+	}
+
 	c, err := geo.NewCoordinate(req.Longitude, req.Latitude)
 
 	if err != nil {
-		return nil, fmt.Errorf("Failed to create new coordinate, %v", err)
+		return nil, NewError(INVALID_COORDINATE, fmt.Errorf("Failed to create new coordinate, %w", err))
 	}
 
 	f, err := NewSPRFilterFromPointInPolygonRequest(req)
@@ -33,43 +66,44 @@ func QueryPointInPolygon(ctx context.Context, app *spatial_app.SpatialApplicatio
 		return nil, fmt.Errorf("Failed to create point in polygon filter from request, %w", err)
 	}
 
-	var principal_sorter sort.Sorter
-	var follow_on_sorters []sort.Sorter
-
-	for idx, uri := range req.Sort {
-
-		s, err := sort.NewSorter(ctx, uri)
-
-		if err != nil {
-			return nil, fmt.Errorf("Failed to create sorter for '%s', %w", uri, err)
-		}
+	principal_sorter, follow_on_sorters, err := newSorters(ctx, req.Sort)
 
-		if idx == 0 {
-			principal_sorter = s
-		} else {
-			follow_on_sorters = append(follow_on_sorters, s)
-		}
+	if err != nil {
+		return nil, err
 	}
 
 	app.Monitor.Signal(ctx, timings.SinceStart, timingsPIPQueryPointInPolygon)
-	
+
 	db := app.SpatialDatabase
 	rsp, err := db.PointInPolygon(ctx, c, f)
 
 	app.Monitor.Signal(ctx, timings.SinceStop, timingsPIPQueryPointInPolygon)
-	
+
 	if err != nil {
-		return nil, fmt.Errorf("Failed to perform point in polygon query, %w", err)
+		return nil, NewError(BACKEND_ERROR, fmt.Errorf("Failed to perform point in polygon query, %w", err))
+	}
+
+	if rsp == nil {
+		return nil, noResultsError(ctx)
+	}
+
+	if len(rsp.Results()) == 0 && req.FallbackMaxDistance > 0 {
+
+		rsp, err = NearestPointInPolygonResults(ctx, app, req)
+
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	if principal_sorter != nil {
 
-		app.Monitor.Signal(ctx, timings.SinceStart, timingsPIPQuerySort)		
-		
+		app.Monitor.Signal(ctx, timings.SinceStart, timingsPIPQuerySort)
+
 		sorted, err := principal_sorter.Sort(ctx, rsp, follow_on_sorters...)
 
 		app.Monitor.Signal(ctx, timings.SinceStop, timingsPIPQuerySort)
-		
+
 		if err != nil {
 			return nil, fmt.Errorf("Failed to sort results, %w", err)
 		}
@@ -77,6 +111,86 @@ func QueryPointInPolygon(ctx context.Context, app *spatial_app.SpatialApplicatio
 		rsp = sorted
 	}
 
-	app.Monitor.Signal(ctx, "complete point in polygon")	
+	rsp, err = PaginatePointInPolygonResults(req, rsp)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to paginate results, %w", err)
+	}
+
+	if has_cache {
+		cache.Set(cache_key, rsp, cache_generation)
+	}
+
+	app.Monitor.Signal(ctx, "complete point in polygon")
 	return rsp, nil
 }
+
+// QueryPointInPolygonWithChannels performs a point-in-polygon query for 'req' dispatching each matching result
+// to 'rsp_ch' as it is found. Errors are dispatched to 'err_ch' and 'done_ch' is signaled when the query is complete.
+// Unlike QueryPointInPolygon results are not sorted or paginated and no nearest-polygon fallback is performed.
+func QueryPointInPolygonWithChannels(ctx context.Context, app *spatial_app.SpatialApplication, req *PointInPolygonRequest, rsp_ch chan spr.StandardPlacesResult, err_ch chan error, done_ch chan bool) {
+
+	c, err := geo.NewCoordinate(req.Longitude, req.Latitude)
+
+	if err != nil {
+		err_ch <- NewError(INVALID_COORDINATE, fmt.Errorf("Failed to create new coordinate, %w", err))
+		done_ch <- true
+		return
+	}
+
+	f, err := NewSPRFilterFromPointInPolygonRequest(req)
+
+	if err != nil {
+		err_ch <- fmt.Errorf("Failed to create point in polygon filter from request, %w", err)
+		done_ch <- true
+		return
+	}
+
+	app.SpatialDatabase.PointInPolygonWithChannels(ctx, rsp_ch, err_ch, done_ch, c, f)
+}
+
+// SortPointInPolygonResults sorts 'rsp' using the sorter URIs defined in 'req'. If 'req' does not define
+// any sorters then 'rsp' is returned unchanged.
+func SortPointInPolygonResults(ctx context.Context, req *PointInPolygonRequest, rsp spr.StandardPlacesResults) (spr.StandardPlacesResults, error) {
+
+	principal_sorter, follow_on_sorters, err := newSorters(ctx, req.Sort)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if principal_sorter == nil {
+		return rsp, nil
+	}
+
+	sorted, err := principal_sorter.Sort(ctx, rsp, follow_on_sorters...)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to sort results, %w", err)
+	}
+
+	return sorted, nil
+}
+
+func newSorters(ctx context.Context, uris []string) (sort.Sorter, []sort.Sorter, error) {
+
+	var principal_sorter sort.Sorter
+	var follow_on_sorters []sort.Sorter
+
+	for idx, uri := range uris {
+
+		s, err := sort.NewSorter(ctx, uri)
+
+		if err != nil {
+			return nil, nil, NewError(INVALID_PARAMETER, fmt.Errorf("Failed to create sorter for '%s', %w", uri, err))
+		}
+
+		if idx == 0 {
+			principal_sorter = s
+		} else {
+			follow_on_sorters = append(follow_on_sorters, s)
+		}
+	}
+
+	return principal_sorter, follow_on_sorters, nil
+}
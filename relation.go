@@ -0,0 +1,97 @@
+package pip
+
+import (
+	"context"
+	"fmt"
+	"github.com/paulmach/orb/geojson"
+	"github.com/sfomuseum/go-timings"
+	spatial_app "github.com/whosonfirst/go-whosonfirst-spatial/app"
+	"github.com/whosonfirst/go-whosonfirst-spr/v2"
+)
+
+const timingsGeometryQuery string = "Geometry query"
+
+const timingsGeometryQueryRelate string = "Geometry query relate"
+
+// Relation is a spatial relationship between an indexed record and the geometry in a GeometryRequest.
+type Relation string
+
+// CONTAINS matches records whose geometries contain the requested geometry.
+const CONTAINS Relation = "contains"
+
+// WITHIN matches records whose geometries are within the requested geometry.
+const WITHIN Relation = "within"
+
+// INTERSECTS matches records whose geometries intersect the requested geometry.
+const INTERSECTS Relation = "intersects"
+
+// GeometryRequest is a request for the records that have a spatial relationship with a GeoJSON geometry. Filtering,
+// sorting and pagination criteria are defined by the embedded PointInPolygonRequest whose latitude and longitude
+// are ignored.
+type GeometryRequest struct {
+	PointInPolygonRequest
+	// The GeoJSON geometry to compare records against.
+	Geometry *geojson.Geometry `json:"geometry"`
+	// The relationship between records and the geometry. If empty INTERSECTS is assumed.
+	Relation Relation `json:"relation,omitempty"`
+}
+
+// QueryGeometry returns the records whose geometries have the relationship defined by 'req' with its geometry. For
+// example the places that contain a building footprint (CONTAINS) or the places that a route crosses (INTERSECTS).
+// Results are filtered, sorted and paginated in the same way as QueryPointInPolygon. The application's spatial
+// database must implement the GeometryIndex interface.
+func QueryGeometry(ctx context.Context, app *spatial_app.SpatialApplication, req *GeometryRequest) (spr.StandardPlacesResults, error) {
+
+	app.Monitor.Signal(ctx, timings.SinceStart, timingsGeometryQuery)
+	defer app.Monitor.Signal(ctx, timings.SinceStop, timingsGeometryQuery)
+
+	idx, ok := app.SpatialDatabase.(GeometryIndex)
+
+	if !ok {
+		return nil, NewError(UNSUPPORTED_QUERY, fmt.Errorf("Spatial database does not support geometry queries"))
+	}
+
+	if req.Geometry == nil || req.Geometry.Geometry() == nil {
+		return nil, NewError(INVALID_PARAMETER, fmt.Errorf("Missing or invalid geometry"))
+	}
+
+	relation := req.Relation
+
+	if relation == "" {
+		relation = INTERSECTS
+	}
+
+	f, err := NewSPRFilterFromPointInPolygonRequest(&req.PointInPolygonRequest)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create geometry filter from request, %w", err)
+	}
+
+	app.Monitor.Signal(ctx, timings.SinceStart, timingsGeometryQueryRelate)
+
+	rsp, err := idx.Relate(ctx, req.Geometry.Geometry(), relation, f)
+
+	app.Monitor.Signal(ctx, timings.SinceStop, timingsGeometryQueryRelate)
+
+	if err != nil {
+		return nil, backendError("Failed to perform geometry query", err)
+	}
+
+	if rsp == nil {
+		return nil, noResultsError(ctx)
+	}
+
+	rsp, err = SortPointInPolygonResults(ctx, &req.PointInPolygonRequest, rsp)
+
+	if err != nil {
+		return nil, err
+	}
+
+	rsp, err = PaginatePointInPolygonResults(&req.PointInPolygonRequest, rsp)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to paginate results, %w", err)
+	}
+
+	return rsp, nil
+}
@@ -0,0 +1,183 @@
+package pip
+
+import (
+	"context"
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geojson"
+	"slices"
+	"testing"
+)
+
+func TestQueryGeometry(t *testing.T) {
+
+	ctx := context.Background()
+
+	app := newTestApplication(t, &SpatialDatabaseOptions{IndexGeometries: true})
+
+	tests := []struct {
+		name     string
+		geometry orb.Geometry
+		relation Relation
+		filters  PointInPolygonRequest
+		expected []string
+		code     ErrorCode
+	}{
+		{
+			name:     "contains",
+			geometry: square(0.1, 0.1, 0.2, 0.2),
+			relation: CONTAINS,
+			expected: []string{"101000001", "101000002", "102000001", "85000001", "85000002"},
+		},
+		{
+			name:     "contains with filters",
+			geometry: square(0.6, 0.6, 0.7, 0.7),
+			relation: CONTAINS,
+			filters:  PointInPolygonRequest{IsCurrent: []int64{1}},
+			expected: []string{"101000001", "85000001", "85000002"},
+		},
+		{
+			name:     "contains across boundary",
+			geometry: square(0.4, 0.4, 0.6, 0.6),
+			relation: CONTAINS,
+			expected: []string{"101000001", "101000002", "85000001", "85000002"},
+		},
+		{
+			name:     "within",
+			geometry: square(-1.5, -1.5, 2.5, 2.5),
+			relation: WITHIN,
+			expected: []string{"101000001", "101000002", "102000001", "85000002"},
+		},
+		{
+			name:     "intersects",
+			geometry: orb.LineString{{0.75, 0.75}, {5.5, 5.5}},
+			relation: INTERSECTS,
+			expected: []string{"101000001", "101000002", "101000003", "85000001", "85000002"},
+		},
+		{
+			name:     "default relation",
+			geometry: orb.LineString{{0.75, 0.75}, {5.5, 5.5}},
+			expected: []string{"101000001", "101000002", "101000003", "85000001", "85000002"},
+		},
+		{
+			name:     "nothing",
+			geometry: square(10, 10, 11, 11),
+			relation: INTERSECTS,
+			expected: []string{},
+		},
+		{
+			name: "missing geometry",
+			code: INVALID_PARAMETER,
+		},
+	}
+
+	for _, tt := range tests {
+
+		t.Run(tt.name, func(t *testing.T) {
+
+			req := &GeometryRequest{
+				PointInPolygonRequest: tt.filters,
+				Relation:              tt.relation,
+			}
+
+			if tt.geometry != nil {
+				req.Geometry = geojson.NewGeometry(tt.geometry)
+			}
+
+			rsp, err := QueryGeometry(ctx, app, req)
+
+			if tt.code != "" {
+
+				code, _ := ErrorCodeWithError(err)
+
+				if code != tt.code {
+					t.Fatalf("Expected %s, got %v", tt.code, err)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Failed to query geometry, %v", err)
+			}
+
+			ids := resultIds(rsp)
+
+			if !slices.Equal(ids, tt.expected) {
+				t.Fatalf("Expected %v, got %v", tt.expected, ids)
+			}
+		})
+	}
+}
+
+func TestQueryGeometryUnsupported(t *testing.T) {
+
+	ctx := context.Background()
+
+	// Geometry queries require the secondary index
+
+	app := newTestApplication(t, nil)
+
+	req := &GeometryRequest{
+		Geometry: geojson.NewGeometry(square(0, 0, 1, 1)),
+	}
+
+	_, err := QueryGeometry(ctx, app, req)
+
+	code, _ := ErrorCodeWithError(err)
+
+	if code != UNSUPPORTED_QUERY {
+		t.Fatalf("Expected %s, got %v", UNSUPPORTED_QUERY, err)
+	}
+}
+
+func TestRelateWithHole(t *testing.T) {
+
+	ctx := context.Background()
+
+	app := newTestApplication(t, &SpatialDatabaseOptions{IndexGeometries: true})
+	db := app.SpatialDatabase.(*SpatialDatabase)
+
+	// A locality between 20,20 and 30,30 with a hole between 24,24 and 26,26
+
+	body := `{"type":"Feature","id":1,"properties":{"wof:id":1,"wof:name":"Donut","wof:placetype":"locality","wof:parent_id":-1,"wof:repo":"whosonfirst-data-test","wof:lastmodified":1,"geom:latitude":22,"geom:longitude":22},"bbox":[20,20,30,30],"geometry":{"type":"Polygon","coordinates":[[[20,20],[30,20],[30,30],[20,30],[20,20]],[[24,24],[26,24],[26,26],[24,26],[24,24]]]}}`
+
+	err := db.IndexFeature(ctx, []byte(body))
+
+	if err != nil {
+		t.Fatalf("Failed to index feature, %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		geometry orb.Geometry
+		relation Relation
+		expected []string
+	}{
+		{name: "contains", geometry: square(21, 21, 23, 23), relation: CONTAINS, expected: []string{"1"}},
+		{name: "contains around hole", geometry: square(23, 23, 27, 27), relation: CONTAINS, expected: []string{}},
+		{name: "contains in hole", geometry: square(24.5, 24.5, 25.5, 25.5), relation: CONTAINS, expected: []string{}},
+		{name: "intersects in hole", geometry: square(24.5, 24.5, 25.5, 25.5), relation: INTERSECTS, expected: []string{}},
+		{name: "within", geometry: square(19, 19, 31, 31), relation: WITHIN, expected: []string{"1"}},
+		{name: "within with hole in hole", geometry: orb.Polygon{square(19, 19, 31, 31)[0], square(24.5, 24.5, 25.5, 25.5)[0]}, relation: WITHIN, expected: []string{"1"}},
+		{name: "within with hole", geometry: orb.Polygon{square(19, 19, 31, 31)[0], square(21, 21, 22, 22)[0]}, relation: WITHIN, expected: []string{}},
+		{name: "within multi polygon", geometry: orb.MultiPolygon{square(19, 19, 31, 31), square(40, 40, 41, 41)}, relation: WITHIN, expected: []string{"1"}},
+	}
+
+	for _, tt := range tests {
+
+		t.Run(tt.name, func(t *testing.T) {
+
+			rsp, err := db.Relate(ctx, tt.geometry, tt.relation)
+
+			if err != nil {
+				t.Fatalf("Failed to relate geometry, %v", err)
+			}
+
+			ids := resultIds(rsp)
+
+			if !slices.Equal(ids, tt.expected) {
+				t.Fatalf("Expected %v, got %v", tt.expected, ids)
+			}
+		})
+	}
+}
@@ -0,0 +1,29 @@
+package pip
+
+import (
+	"github.com/sfomuseum/go-timings"
+	"github.com/whosonfirst/go-whosonfirst-spr/v2"
+)
+
+// PointInPolygonResults implements the spr.StandardPlacesResults interface for a list of results
+// that have been collected outside of a spatial database, for example from a channel.
+type PointInPolygonResults struct {
+	Places        []spr.StandardPlacesResult `json:"places"`
+	Pagination    *Pagination                `json:"pagination,omitempty"`
+	Explain       *Explanation               `json:"explain,omitempty"`
+	Timings       []*timings.SinceResponse   `json:"timings,omitempty"`
+	IndexProgress *IndexProgress             `json:"index_progress,omitempty"`
+}
+
+// Results returns the list of spr.StandardPlacesResult instances in 'r'.
+func (r *PointInPolygonResults) Results() []spr.StandardPlacesResult {
+	return r.Places
+}
+
+// NewPointInPolygonResults returns a new spr.StandardPlacesResults instance for 'places'.
+func NewPointInPolygonResults(places []spr.StandardPlacesResult) spr.StandardPlacesResults {
+
+	return &PointInPolygonResults{
+		Places: places,
+	}
+}
@@ -0,0 +1,238 @@
+package pip
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/sfomuseum/go-timings"
+	spatial_app "github.com/whosonfirst/go-whosonfirst-spatial/app"
+	"github.com/whosonfirst/go-whosonfirst-spr/v2"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+const TimingsFlag string = "timings"
+
+// RequestMonitor implements the timings.Monitor interface recording the time elapsed between `timings.SinceStart`
+// and `timings.SinceStop` signals for a single request. Unlike the "since://" monitor timings are recorded synchronously,
+// without a background goroutine, so they can be read at any time and the monitor does not need to be started or stopped.
+type RequestMonitor struct {
+	timings.Monitor
+	mu       *sync.Mutex
+	started  map[string]time.Time
+	stages   []*requestStage
+	observer StageObserver
+}
+
+// StageObserver is an interface for being notified of the duration of each stage recorded by a RequestMonitor, for
+// example to aggregate timings across requests.
+type StageObserver interface {
+	// ObserveStage is called with the label and duration of a stage when it is completed.
+	ObserveStage(string, time.Duration)
+}
+
+// requestStage is the duration of a single named stage of a request.
+type requestStage struct {
+	label     string
+	duration  time.Duration
+	timestamp time.Time
+}
+
+// NewRequestMonitor returns a new RequestMonitor instance.
+func NewRequestMonitor() *RequestMonitor {
+	return NewRequestMonitorWithObserver(nil)
+}
+
+// NewRequestMonitorWithObserver returns a new RequestMonitor instance that notifies 'o', if not nil, as each stage is completed.
+func NewRequestMonitorWithObserver(o StageObserver) *RequestMonitor {
+
+	m := &RequestMonitor{
+		mu:       new(sync.Mutex),
+		started:  make(map[string]time.Time),
+		stages:   make([]*requestStage, 0),
+		observer: o,
+	}
+
+	return m
+}
+
+// NewRequestApplication returns a shallow copy of 'app' whose Monitor is a new RequestMonitor instance, so that
+// timings for a single request are not mixed with those of any other request or accumulated by 'app', along with
+// that RequestMonitor instance.
+func NewRequestApplication(app *spatial_app.SpatialApplication) (*spatial_app.SpatialApplication, *RequestMonitor) {
+	return NewRequestApplicationWithObserver(app, nil)
+}
+
+// NewRequestApplicationWithObserver is identical to NewRequestApplication except that the RequestMonitor instance notifies
+// 'o', if not nil, as each stage is completed.
+func NewRequestApplicationWithObserver(app *spatial_app.SpatialApplication, o StageObserver) (*spatial_app.SpatialApplication, *RequestMonitor) {
+
+	m := NewRequestMonitorWithObserver(o)
+
+	req_app := *app
+	req_app.Monitor = m
+
+	return &req_app, m
+}
+
+// AppendTimingsFlags appends flags for including per-request timings in point-in-polygon responses to 'fs'.
+func AppendTimingsFlags(fs *flag.FlagSet) error {
+
+	fs.Bool(TimingsFlag, false, "If true include the timings for each stage of the query in the response.")
+	return nil
+}
+
+// Start is a no-op. Timings are read using the Timings method.
+func (m *RequestMonitor) Start(ctx context.Context, wr io.Writer) error {
+	return nil
+}
+
+// Stop discards any stages that have been started but not stopped.
+func (m *RequestMonitor) Stop(ctx context.Context) error {
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.started = make(map[string]time.Time)
+	return nil
+}
+
+// Signal records the start or the end of a stage. It expects a `timings.SinceEvent` and a string label, the same
+// arguments as the "since://" monitor.
+func (m *RequestMonitor) Signal(ctx context.Context, args ...interface{}) error {
+
+	if len(args) < 2 {
+		return fmt.Errorf("Signal requires valid status event and label")
+	}
+
+	ev, ok := args[0].(timings.SinceEvent)
+
+	if !ok {
+		return fmt.Errorf("Signal requires first argument to be a SinceEvent")
+	}
+
+	label, ok := args[1].(string)
+
+	if !ok {
+		return fmt.Errorf("Signal requires second argument to a string")
+	}
+
+	now := time.Now()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	switch ev {
+	case timings.SinceStart:
+
+		_, exists := m.started[label]
+
+		if exists {
+			return fmt.Errorf("Label %s has already been stored", label)
+		}
+
+		m.started[label] = now
+
+	case timings.SinceStop:
+
+		t, exists := m.started[label]
+
+		if !exists {
+			return fmt.Errorf("Failed to find any record with label %s", label)
+		}
+
+		delete(m.started, label)
+
+		st := &requestStage{
+			label:     label,
+			duration:  now.Sub(t),
+			timestamp: now,
+		}
+
+		m.stages = append(m.stages, st)
+
+		if m.observer != nil {
+			m.observer.ObserveStage(st.label, st.duration)
+		}
+
+	default:
+		return fmt.Errorf("Unsupported event")
+	}
+
+	return nil
+}
+
+// Timings returns the stages that have been completed, in the order they were completed.
+func (m *RequestMonitor) Timings() []*timings.SinceResponse {
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	responses := make([]*timings.SinceResponse, len(m.stages))
+
+	for idx, st := range m.stages {
+
+		responses[idx] = &timings.SinceResponse{
+			Label:     st.label,
+			Duration:  st.duration.String(),
+			Timestamp: st.timestamp.Unix(),
+		}
+	}
+
+	return responses
+}
+
+// ServerTiming returns the stages that have been completed formatted as the value of a `Server-Timing` HTTP header.
+func (m *RequestMonitor) ServerTiming() string {
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	metrics := make([]string, len(m.stages))
+
+	for idx, st := range m.stages {
+
+		name := strings.Map(func(r rune) rune {
+
+			if unicode.IsLetter(r) || unicode.IsDigit(r) {
+				return unicode.ToLower(r)
+			}
+
+			return '-'
+		}, st.label)
+
+		dur := float64(st.duration.Microseconds()) / 1000.0
+
+		metrics[idx] = fmt.Sprintf("%s;desc=%s;dur=%s", name, strconv.Quote(st.label), strconv.FormatFloat(dur, 'f', -1, 64))
+	}
+
+	return strings.Join(metrics, ", ")
+}
+
+// TimingsWithResults returns the timings for 'rsp' or nil if they were not included.
+func TimingsWithResults(rsp spr.StandardPlacesResults) []*timings.SinceResponse {
+
+	pip_rsp, ok := rsp.(*PointInPolygonResults)
+
+	if !ok {
+		return nil
+	}
+
+	return pip_rsp.Timings
+}
+
+// AppendTimingsToResults returns a copy of 'rsp' whose timings are 't'.
+func AppendTimingsToResults(rsp spr.StandardPlacesResults, t []*timings.SinceResponse) spr.StandardPlacesResults {
+
+	return &PointInPolygonResults{
+		Places:        rsp.Results(),
+		Pagination:    PaginationWithResults(rsp),
+		Explain:       ExplanationWithResults(rsp),
+		Timings:       t,
+		IndexProgress: IndexProgressWithResults(rsp),
+	}
+}
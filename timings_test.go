@@ -0,0 +1,178 @@
+package pip
+
+import (
+	"context"
+	"github.com/sfomuseum/go-timings"
+	"regexp"
+	"testing"
+	"time"
+)
+
+// stageCounter is a StageObserver instance which counts the number of times each stage is observed.
+type stageCounter map[string]int
+
+func (c stageCounter) ObserveStage(label string, d time.Duration) {
+	c[label] += 1
+}
+
+func TestRequestMonitorSignal(t *testing.T) {
+
+	ctx := context.Background()
+
+	tests := []struct {
+		name string
+		args []interface{}
+		ok   bool
+	}{
+		{name: "start", args: []interface{}{timings.SinceStart, "Test stage"}, ok: true},
+		{name: "duplicate start", args: []interface{}{timings.SinceStart, "Test stage"}, ok: false},
+		{name: "stop", args: []interface{}{timings.SinceStop, "Test stage"}, ok: true},
+		{name: "stop without start", args: []interface{}{timings.SinceStop, "Test stage"}, ok: false},
+		{name: "missing label", args: []interface{}{timings.SinceStart}, ok: false},
+		{name: "invalid event", args: []interface{}{"start", "Test stage"}, ok: false},
+		{name: "invalid label", args: []interface{}{timings.SinceStart, 1}, ok: false},
+	}
+
+	counter := make(stageCounter)
+	m := NewRequestMonitorWithObserver(counter)
+
+	// Each test depends on the state left by the tests before it
+
+	for _, tt := range tests {
+
+		err := m.Signal(ctx, tt.args...)
+
+		if tt.ok && err != nil {
+			t.Fatalf("Expected %s signal to succeed, %v", tt.name, err)
+		}
+
+		if !tt.ok && err == nil {
+			t.Fatalf("Expected %s signal to fail", tt.name)
+		}
+	}
+
+	stages := m.Timings()
+
+	if len(stages) != 1 || stages[0].Label != "Test stage" {
+		t.Fatalf("Expected a single 'Test stage' timing, got %v", stages)
+	}
+
+	if counter["Test stage"] != 1 {
+		t.Fatalf("Expected observer to be notified once, got %d", counter["Test stage"])
+	}
+
+	// Stages which are started but not stopped are discarded by Stop
+
+	m.Signal(ctx, timings.SinceStart, "Unfinished stage")
+
+	err := m.Stop(ctx)
+
+	if err != nil {
+		t.Fatalf("Failed to stop monitor, %v", err)
+	}
+
+	err = m.Signal(ctx, timings.SinceStop, "Unfinished stage")
+
+	if err == nil {
+		t.Fatalf("Expected unfinished stage to be discarded")
+	}
+}
+
+func TestRequestMonitorServerTiming(t *testing.T) {
+
+	ctx := context.Background()
+
+	m := NewRequestMonitor()
+
+	if m.ServerTiming() != "" {
+		t.Fatalf("Expected empty Server-Timing value, got '%s'", m.ServerTiming())
+	}
+
+	for _, label := range []string{"PIP query", "Point-in-polygon: \"sort\""} {
+		m.Signal(ctx, timings.SinceStart, label)
+		m.Signal(ctx, timings.SinceStop, label)
+	}
+
+	re := regexp.MustCompile(`^pip-query;desc="PIP query";dur=[0-9.]+, point-in-polygon---sort-;desc="Point-in-polygon: \\"sort\\"";dur=[0-9.]+$`)
+
+	if !re.MatchString(m.ServerTiming()) {
+		t.Fatalf("Unexpected Server-Timing value '%s'", m.ServerTiming())
+	}
+}
+
+func TestNewRequestApplication(t *testing.T) {
+
+	ctx := context.Background()
+
+	app := newTestApplication(t, nil)
+	app_monitor := app.Monitor.(*RequestMonitor)
+
+	req := &PointInPolygonRequest{
+		Latitude:  0.75,
+		Longitude: 0.75,
+	}
+
+	// Timings for each request are recorded by their own monitor, and not the application's
+
+	req_app, req_m := NewRequestApplication(app)
+	other_app, other_m := NewRequestApplication(app)
+
+	_, err := QueryPointInPolygon(ctx, req_app, req)
+
+	if err != nil {
+		t.Fatalf("Failed to query point in polygon, %v", err)
+	}
+
+	if req_app.Monitor != req_m || app.Monitor != app_monitor {
+		t.Fatalf("Expected request application to have its own monitor")
+	}
+
+	if len(req_m.Timings()) == 0 {
+		t.Fatalf("Expected request timings to be recorded")
+	}
+
+	if len(other_m.Timings()) != 0 || len(app_monitor.Timings()) != 0 {
+		t.Fatalf("Expected timings to only be recorded for the request")
+	}
+
+	if other_app.SpatialDatabase != app.SpatialDatabase {
+		t.Fatalf("Expected request application to share the spatial database")
+	}
+}
+
+func TestAppendTimingsToResults(t *testing.T) {
+
+	ctx := context.Background()
+
+	app := newTestApplication(t, nil)
+
+	req := &PointInPolygonRequest{
+		Latitude:  0.25,
+		Longitude: 0.25,
+		PerPage:   2,
+	}
+
+	rsp, err := QueryPointInPolygon(ctx, app, req)
+
+	if err != nil {
+		t.Fatalf("Failed to query point in polygon, %v", err)
+	}
+
+	if TimingsWithResults(rsp) != nil {
+		t.Fatalf("Expected results without timings")
+	}
+
+	stages := []*timings.SinceResponse{
+		{Label: "Test stage", Duration: "1ms"},
+	}
+
+	timed := AppendTimingsToResults(rsp, stages)
+
+	if len(TimingsWithResults(timed)) != 1 {
+		t.Fatalf("Expected results with timings")
+	}
+
+	if PaginationWithResults(timed) != PaginationWithResults(rsp) || len(timed.Results()) != len(rsp.Results()) {
+		t.Fatalf("Expected results and pagination to be preserved")
+	}
+}
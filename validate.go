@@ -0,0 +1,210 @@
+package pip
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/whosonfirst/go-whosonfirst-flags/date"
+	"github.com/whosonfirst/go-whosonfirst-flags/placetypes"
+	"github.com/whosonfirst/go-whosonfirst-spatial/geo"
+	"github.com/whosonfirst/go-whosonfirst-spr/v2/sort"
+	"io"
+	"math"
+	"strings"
+)
+
+// FieldError describes a single invalid field in a request.
+type FieldError struct {
+	// The JSON name of the invalid field.
+	Field string `json:"field"`
+	// The code describing why the field is invalid.
+	Code ErrorCode `json:"code"`
+	// A human-readable description of the problem.
+	Message string `json:"message"`
+}
+
+// ValidationErrors is the list of every invalid field in a request, as returned by PointInPolygonRequest.Validate.
+type ValidationErrors []*FieldError
+
+// Error returns the messages for each invalid field joined by semicolons.
+func (e ValidationErrors) Error() string {
+
+	messages := make([]string, len(e))
+
+	for idx, fe := range e {
+		messages[idx] = fmt.Sprintf("%s: %s", fe.Field, fe.Message)
+	}
+
+	return fmt.Sprintf("Invalid request, %s", strings.Join(messages, "; "))
+}
+
+// Code returns the code shared by every invalid field or INVALID_REQUEST if there is more than one code.
+func (e ValidationErrors) Code() ErrorCode {
+
+	if len(e) == 0 {
+		return INVALID_REQUEST
+	}
+
+	code := e[0].Code
+
+	for _, fe := range e[1:] {
+
+		if fe.Code != code {
+			return INVALID_REQUEST
+		}
+	}
+
+	return code
+}
+
+// Validate checks every field in 'req' and returns a *Error instance, whose code is derived from and which wraps a
+// ValidationErrors instance listing every problem, if any of them are invalid. This includes the coordinate ranges,
+// placetypes, EDTF dates, existential values, geometries, sorting and pagination criteria.
+func (req *PointInPolygonRequest) Validate() error {
+
+	errs := make(ValidationErrors, 0)
+
+	invalid := func(field string, code ErrorCode, msg string, args ...interface{}) {
+		errs = append(errs, &FieldError{Field: field, Code: code, Message: fmt.Sprintf(msg, args...)})
+	}
+
+	if math.IsNaN(req.Latitude) || !geo.IsValidLatitude(req.Latitude) {
+		invalid("latitude", INVALID_COORDINATE, "Latitude must be between -90 and 90")
+	}
+
+	if math.IsNaN(req.Longitude) || !geo.IsValidLongitude(req.Longitude) {
+		invalid("longitude", INVALID_COORDINATE, "Longitude must be between -180 and 180")
+	}
+
+	for _, raw := range req.Placetypes {
+
+		for _, pt := range strings.Split(raw, ",") {
+
+			pt = strings.TrimSpace(pt)
+
+			if pt == "" {
+				continue
+			}
+
+			_, err := placetypes.NewPlacetypeFlag(pt)
+
+			if err != nil {
+				invalid("placetypes", INVALID_PLACETYPE, "Unknown placetype '%s'", pt)
+			}
+		}
+	}
+
+	dates := map[string]string{
+		"inception_date": req.InceptionDate,
+		"cessation_date": req.CessationDate,
+	}
+
+	for _, k := range []string{"inception_date", "cessation_date"} {
+
+		if dates[k] == "" {
+			continue
+		}
+
+		_, err := date.NewEDTFDateFlag(dates[k])
+
+		if err != nil {
+			invalid(k, INVALID_EDTF, "Invalid EDTF date '%s'", dates[k])
+		}
+	}
+
+	existential := map[string][]int64{
+		"is_current":     req.IsCurrent,
+		"is_ceased":      req.IsCeased,
+		"is_deprecated":  req.IsDeprecated,
+		"is_superseded":  req.IsSuperseded,
+		"is_superseding": req.IsSuperseding,
+	}
+
+	for _, k := range []string{"is_current", "is_ceased", "is_deprecated", "is_superseded", "is_superseding"} {
+
+		for _, v := range existential[k] {
+
+			if v < -1 || v > 1 {
+				invalid(k, INVALID_PARAMETER, "Invalid value %d, must be -1, 0 or 1", v)
+			}
+		}
+	}
+
+	switch req.Geometries {
+	case "", "all", "alt", "alternate", "default":
+		// pass
+	default:
+		invalid("geometries", INVALID_PARAMETER, "Invalid value '%s', must be one of all, alt, alternate or default", req.Geometries)
+	}
+
+	for _, uri := range req.Sort {
+
+		_, err := sort.NewSorter(context.Background(), uri)
+
+		if err != nil {
+			invalid("sort", INVALID_PARAMETER, "Invalid sorter '%s'", uri)
+		}
+	}
+
+	for _, p := range req.Properties {
+
+		if strings.TrimSpace(p) == "" {
+			invalid("properties", INVALID_PARAMETER, "Property names must not be empty")
+			break
+		}
+	}
+
+	pagination := map[string]int{
+		"page":     req.Page,
+		"per_page": req.PerPage,
+		"limit":    req.Limit,
+	}
+
+	max_pagination := map[string]int{
+		"page":     MAX_PAGE,
+		"per_page": MAX_PER_PAGE,
+		"limit":    MAX_LIMIT,
+	}
+
+	for _, k := range []string{"page", "per_page", "limit"} {
+
+		if pagination[k] < 0 {
+			invalid(k, INVALID_PARAMETER, "Value must not be negative")
+		} else if pagination[k] > max_pagination[k] {
+			invalid(k, INVALID_PARAMETER, "Value must not be greater than %d", max_pagination[k])
+		}
+	}
+
+	if req.Cursor != "" {
+
+		_, err := decodeCursor(req.Cursor)
+
+		if err != nil {
+			invalid("cursor", INVALID_PARAMETER, "Invalid cursor")
+		}
+	}
+
+	if math.IsNaN(req.FallbackMaxDistance) || req.FallbackMaxDistance < 0 {
+		invalid("fallback_max_distance", INVALID_PARAMETER, "Value must not be negative")
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+
+	return NewError(errs.Code(), errs)
+}
+
+// NewRequestDecoder returns a new json.Decoder for reading JSON-encoded requests from 'r'. If 'strict' is true the
+// decoder will return an error if a request contains any fields that are not defined by the type it is decoded in to,
+// rather than silently ignoring them.
+func NewRequestDecoder(r io.Reader, strict bool) *json.Decoder {
+
+	dec := json.NewDecoder(r)
+
+	if strict {
+		dec.DisallowUnknownFields()
+	}
+
+	return dec
+}
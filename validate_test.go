@@ -0,0 +1,192 @@
+package pip
+
+import (
+	"errors"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestPointInPolygonRequestValidate(t *testing.T) {
+
+	tests := []struct {
+		name string
+		req  *PointInPolygonRequest
+		code ErrorCode
+		// The invalid fields, in the order they are reported.
+		fields []string
+	}{
+		{
+			name: "valid",
+			req: &PointInPolygonRequest{
+				Latitude:            37.6,
+				Longitude:           -122.4,
+				Placetypes:          []string{"locality,neighbourhood", "region"},
+				InceptionDate:       "2000",
+				CessationDate:       "..",
+				IsCurrent:           []int64{-1, 0, 1},
+				Geometries:          "alternate",
+				Sort:                []string{"name://"},
+				Properties:          []string{"wof:name"},
+				Page:                1,
+				PerPage:             10,
+				Cursor:              encodeCursor(10),
+				FallbackMaxDistance: 100,
+			},
+		},
+		{
+			name:   "latitude",
+			req:    &PointInPolygonRequest{Latitude: 91},
+			code:   INVALID_COORDINATE,
+			fields: []string{"latitude"},
+		},
+		{
+			name:   "coordinates",
+			req:    &PointInPolygonRequest{Latitude: math.NaN(), Longitude: -181},
+			code:   INVALID_COORDINATE,
+			fields: []string{"latitude", "longitude"},
+		},
+		{
+			name:   "placetypes",
+			req:    &PointInPolygonRequest{Placetypes: []string{"locality,planet-x", "moon"}},
+			code:   INVALID_PLACETYPE,
+			fields: []string{"placetypes", "placetypes"},
+		},
+		{
+			name:   "dates",
+			req:    &PointInPolygonRequest{InceptionDate: "yesterday", CessationDate: "2000-13-45"},
+			code:   INVALID_EDTF,
+			fields: []string{"inception_date", "cessation_date"},
+		},
+		{
+			name:   "existential",
+			req:    &PointInPolygonRequest{IsCurrent: []int64{2}, IsSuperseding: []int64{-2}},
+			code:   INVALID_PARAMETER,
+			fields: []string{"is_current", "is_superseding"},
+		},
+		{
+			name:   "options",
+			req:    &PointInPolygonRequest{Geometries: "some", Sort: []string{"bogus://"}, Properties: []string{" "}, Page: -1, PerPage: -1, Limit: -1, Cursor: "!!!", FallbackMaxDistance: -1},
+			code:   INVALID_PARAMETER,
+			fields: []string{"geometries", "sort", "properties", "page", "per_page", "limit", "cursor", "fallback_max_distance"},
+		},
+		{
+			name:   "maximums",
+			req:    &PointInPolygonRequest{Page: MAX_PAGE + 1, PerPage: MAX_PER_PAGE + 1, Limit: math.MaxInt},
+			code:   INVALID_PARAMETER,
+			fields: []string{"page", "per_page", "limit"},
+		},
+		{
+			name:   "mixed",
+			req:    &PointInPolygonRequest{Latitude: 91, Placetypes: []string{"planet-x"}, Page: -1},
+			code:   INVALID_REQUEST,
+			fields: []string{"latitude", "placetypes", "page"},
+		},
+	}
+
+	for _, tt := range tests {
+
+		t.Run(tt.name, func(t *testing.T) {
+
+			err := tt.req.Validate()
+
+			if tt.code == "" {
+
+				if err != nil {
+					t.Fatalf("Expected request to be valid, %v", err)
+				}
+
+				return
+			}
+
+			if err == nil {
+				t.Fatalf("Expected request to be invalid")
+			}
+
+			code, _ := ErrorCodeWithError(err)
+
+			if code != tt.code {
+				t.Fatalf("Expected %s, got %s", tt.code, code)
+			}
+
+			var validation_errs ValidationErrors
+
+			if !errors.As(err, &validation_errs) {
+				t.Fatalf("Expected error to wrap ValidationErrors, %v", err)
+			}
+
+			fields := make([]string, len(validation_errs))
+
+			for idx, fe := range validation_errs {
+				fields[idx] = fe.Field
+			}
+
+			if !reflect.DeepEqual(fields, tt.fields) {
+				t.Fatalf("Expected invalid fields %v, got %v", tt.fields, fields)
+			}
+		})
+	}
+}
+
+func TestValidationErrors(t *testing.T) {
+
+	errs := ValidationErrors{
+		{Field: "latitude", Code: INVALID_COORDINATE, Message: "Latitude must be between -90 and 90"},
+		{Field: "longitude", Code: INVALID_COORDINATE, Message: "Longitude must be between -180 and 180"},
+	}
+
+	if errs.Code() != INVALID_COORDINATE {
+		t.Fatalf("Expected shared code %s, got %s", INVALID_COORDINATE, errs.Code())
+	}
+
+	expected := "Invalid request, latitude: Latitude must be between -90 and 90; longitude: Longitude must be between -180 and 180"
+
+	if errs.Error() != expected {
+		t.Fatalf("Expected '%s', got '%s'", expected, errs.Error())
+	}
+
+	errs = append(errs, &FieldError{Field: "page", Code: INVALID_PARAMETER, Message: "Value must not be negative"})
+
+	if errs.Code() != INVALID_REQUEST {
+		t.Fatalf("Expected %s for mixed codes, got %s", INVALID_REQUEST, errs.Code())
+	}
+
+	if (ValidationErrors{}).Code() != INVALID_REQUEST {
+		t.Fatalf("Expected %s for empty errors", INVALID_REQUEST)
+	}
+}
+
+func TestNewRequestDecoder(t *testing.T) {
+
+	tests := []struct {
+		name   string
+		body   string
+		strict bool
+		ok     bool
+	}{
+		{name: "known fields", body: `{"latitude":1,"longitude":2}`, ok: true},
+		{name: "known fields strict", body: `{"latitude":1,"longitude":2}`, strict: true, ok: true},
+		{name: "unknown field", body: `{"latitude":1,"longitude":2,"placetype":"locality"}`, ok: true},
+		{name: "unknown field strict", body: `{"latitude":1,"longitude":2,"placetype":"locality"}`, strict: true, ok: false},
+	}
+
+	for _, tt := range tests {
+
+		t.Run(tt.name, func(t *testing.T) {
+
+			var req *PointInPolygonRequest
+
+			dec := NewRequestDecoder(strings.NewReader(tt.body), tt.strict)
+			err := dec.Decode(&req)
+
+			if tt.ok && err != nil {
+				t.Fatalf("Failed to decode request, %v", err)
+			}
+
+			if !tt.ok && err == nil {
+				t.Fatalf("Expected decoding to fail")
+			}
+		})
+	}
+}